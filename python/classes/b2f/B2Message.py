@@ -0,0 +1,553 @@
+#!/usr/bin/env python
+'''Simple Winlink Server that accepts incoming messages'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import subprocess
+import platform
+import os
+import shutil
+import struct
+import logging
+import hashlib
+import tempfile
+import traceback
+from datetime import timedelta
+import json
+from classes.forms.WinlinkForm import WinlinkForm
+from classes.b2f.PositionAttachment import PositionAttachment
+from classes.b2f.WinlinkTime import parse_timestamp, to_local, utc_now
+from classes.b2f import IngestTransforms
+from classes.forms import TemplateVersions
+from classes.b2f import WinlinkPrecedence
+from classes.server import Tracing
+from classes.geo import Geo
+from classes.lzhuf import Lzhuf
+
+SOH = 0x01
+NUL = 0x00
+STX = 0x02
+EOT = 0x04
+
+GO_EXECUTABLE = 'decompress_lzhuf.exe' if platform.system() == 'Windows' else 'decompress_lzhuf'
+BUILT_IN_DECOMPRESSOR = "built-in"
+
+
+def decompressor():
+	"""The path of GO_EXECUTABLE if it is on the PATH, or BUILT_IN_DECOMPRESSOR when messages are
+	decompressed by Lzhuf instead."""
+	return shutil.which(GO_EXECUTABLE) or BUILT_IN_DECOMPRESSOR
+
+# Catalog responses, inquiries, and bulletins are recognized by sender, recipient, or Type: header
+BULLETIN_ADDRESSES = {"SERVICE", "INQUIRY", "SYSTEM"}
+BULLETIN_TYPES = {"service", "inquiry", "bulletin", "system"}
+
+REPORTER_ROLE = "reporter"  # Role of the X-Location position
+SAME_POINT_KM = 0.025  # A form or attached position this close to the reporter's is the same point, given twice
+
+# Limits used when checking a message for plausibility
+MAX_FUTURE_SKEW = timedelta(days=1)  # Messages dated further ahead than this are suspect
+MAX_MESSAGE_AGE = timedelta(days=30)  # Messages dated further back than this are suspect
+
+def transfer_length(raw_data):
+	"""Bytes taken by the B2 transfer at the start of raw_data, found from its framing alone so a batch
+	can be split without decompressing anything.  Raises ValueError if the framing is incomplete."""
+	try:
+		if raw_data[0] != SOH:
+			raise ValueError("Expected SOH at start of message")
+		byte_index = 2 + raw_data[1]  # SOH, the header length, then the subject and offset it covers
+		while raw_data[byte_index] == STX:
+			byte_index += 2 + raw_data[byte_index + 1]
+		if raw_data[byte_index] != EOT:
+			raise ValueError(f"Malformed message block at index {byte_index} -- expected STX or EOT, got 0x{raw_data[byte_index]:02X}")
+		if byte_index + 2 > len(raw_data):
+			raise IndexError
+		return byte_index + 2
+	except IndexError:
+		raise ValueError(f"Transfer is truncated after {len(raw_data)} bytes")
+
+
+class B2Attachment:
+	def __init__(self, filename, size):
+		self.filename = filename  # Name of the attachment file
+		self.data = None
+		self.size = size  # SExpected size in bytes
+		self.location = None  # Where the data is kept, when it is in a blob store rather than loaded
+
+class B2Message:
+	def __init__(self, message_id, raw_data, decompressed_size, compressed_size, enable_debug=False) -> int:
+		self.enable_debug = enable_debug
+		self.raw_data = raw_data
+		self.header_length = None
+		self.subject = None
+		self.offset = None
+		self.transmitted_checksum = None
+		self.block_count = 0  # Number of STX blocks in the transfer
+		self.transfer_size = None  # Bytes on the wire, including B2 framing
+		self.declared_crc = None  # CRC-16 from the start of the compressed image
+		self.declared_decompressed_size = None  # Length field from the compressed image
+		self.compressed_data = bytearray()
+		self.compressed_size = compressed_size
+		self.decompressed_data = None
+		self.decompression_error = None  # Why decompression failed, if it did
+		self.parse_error = None  # {"stage", "error", "traceback"} if taking the decoded message apart crashed
+		self.decompressed_size = decompressed_size
+		self.headers = ""
+		self.body = ""
+		self.attachments = []
+		# Header fields
+		self.message_id = message_id
+		self.mid = None  # Mid: header from the decoded message
+		self.date = utc_now()  # Always an aware datetime in UTC
+		self.reported_date = None  # The Date header as sent, when ClockSkew has corrected date
+		self.clock_offset = None  # How far the sender's clock appears to be off, as a timedelta; see ClockSkew
+		self.body_length = 0
+		self.sender = ""
+		self.recipient = ""
+		self.subject = ""
+		self.type = ""  # Type: header, e.g. Private or Service
+		self.position = {"latitude": 0.0, "longitude": 0.0}
+		self.form = None  # WinlinkForm, if the message carries one
+		self.attached_positions = []  # (role, latitude, longitude) from position attachments such as pos.xml
+		self.warnings = []  # Problems found by _validate()
+		self.transforms = []  # Names of the mappings.json transforms that changed this message before parsing
+		self.duplicate_of = None  # MID of an earlier message with the same content
+		self.precedence = WinlinkPrecedence.ROUTINE  # From the subject prefix
+		self.severity = None  # Severity or priority value from the form, if it has one
+		self.severity_rank = None
+		self.source = None  # Where the message came from; see WinlinkMailMessage
+		self.in_reply_to = None  # MID of the message this one answers; see MessageThreads
+		self.thread = None  # MID of the first message in its conversation
+		self.conversation = []  # Every message in the conversation, in date order, when there is more than one
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _calculate_checksum(self) -> int:
+		"""Checksum as described: ((sum & 0xFF) * -1) & 0xFF"""
+		checksum = sum(self.compressed_data) & 0xFF
+		return ((checksum * -1) & 0xFF)
+
+	# Returns the index of the next unprocessed byte in raw_data
+	def parse(self) -> int:
+		# Position 0: SOH
+		byte_index = 0
+		if self.raw_data[byte_index] != SOH:
+			raise ValueError("Expected SOH at start of message")
+		self._log_debug(f"Found SOH")
+		
+		# Position 1: One byte length field which covers the SUBJECT, a NUL, an ASCII LENGTH field called
+		# the OFFSET, and another NUL
+		byte_index += 1
+		self.header_length = self.raw_data[byte_index]
+		self._log_debug(f"Header length is <{self.header_length}>")
+
+		# Position 2..2+Read subject
+		byte_index += 1
+		end_subject = self.raw_data.index(NUL, byte_index)
+		self.subject = self.raw_data[byte_index:end_subject].decode("ascii")
+		self._log_debug(f"Subject is <{self.subject}>")
+
+		# Another NUL
+		byte_index = end_subject
+		if self.raw_data[byte_index] != NUL:
+			raise ValueError("Expected NUL after subject field")
+
+		# Read offset
+		byte_index += 1
+		end_offset = self.raw_data.index(NUL, byte_index)
+		offset_str = self.raw_data[byte_index:end_offset].decode("ascii")
+		self.offset = int(offset_str)
+		self._log_debug(f"Offset is {self.offset}")
+
+		byte_index = end_offset + 1  # end_offset points to NUL; skip over it
+		# compressed_index = 0
+		if self.offset != 0:
+			if self.raw_data[byte_index] != STX or self.raw_data[byte_index+1] != 0x06:
+				raise ValueError("Expected STX 0x06 before lead-bytes")
+			byte_index += 2
+			self.compressed_data[0:6] = self.raw_data[byte_index:byte_index+6] # these are the "lead bytes" and this probably
+																			   # NOT the right way to handle them
+			byte_index += 6
+
+		while True: 
+			if self.raw_data[byte_index] == STX:
+				self._log_debug(f"Found STX at index {byte_index}")
+				byte_index += 1
+				stx_block_length = self.raw_data[byte_index] # from byte following this one to the next <STX> or <EOT>
+				self._log_debug(f"Found LENGTH of {stx_block_length} at index {byte_index}")
+				byte_index += 1  # pointing to first data byte
+
+				self._log_debug(f"Expecting compressed block of {stx_block_length} bytes at index {byte_index}")
+				self.compressed_data.extend(self.raw_data[byte_index:byte_index+stx_block_length])
+				byte_index += stx_block_length
+				self.block_count += 1
+				self._log_debug(f"Captured block of length {stx_block_length}")
+			elif self.raw_data[byte_index] == EOT:
+				self._log_debug(f"Found EOT at index {byte_index}")
+				byte_index += 1
+				self.transmitted_checksum = self.raw_data[byte_index]
+				calculated_checksum = self._calculate_checksum()
+				byte_index += 1
+				if self.transmitted_checksum != calculated_checksum:
+					raise ValueError(f"Checksum mismatch: expected 0x{calculated_checksum:02X}, got 0x{self.transmitted_checksum:02X}")
+				else:
+					self._log_debug(f"Checksum match")
+					break
+			else:
+				raise ValueError(f"Malformed message block at index {byte_index} -- expected STX or EOT, got 0x{self.raw_data[byte_index]:02X}")
+
+		# CRC-16, LENGTH, and compressed message
+		# A size of None means there was no proposal (e.g., the message was read from a file) and is not checked
+		compressed_data_len = len(self.compressed_data)  # Data begins after the <STX><LEN> and ends before <EOT><CHECKSUM>
+		if self.compressed_size is None:
+			self.compressed_size = compressed_data_len
+		elif compressed_data_len == self.compressed_size:
+			self._log_debug(f"Compressed message plus header matches proposal: {compressed_data_len}")
+		else:
+			raise ValueError(f"Compressed message size {compressed_data_len} does not match proposal {self.compressed_size}")
+
+		self.declared_crc = int.from_bytes(self.compressed_data[0:2], byteorder='little')
+		decompressed_data_len = int.from_bytes(self.compressed_data[2:6], byteorder='little')
+		self.declared_decompressed_size = decompressed_data_len
+		if self.decompressed_size is None:
+			self.decompressed_size = decompressed_data_len
+		elif decompressed_data_len == self.decompressed_size:
+			self._log_debug(f"Decompressed message size matches proposal: {decompressed_data_len}")
+		else:
+			raise ValueError(f"Decompressed message size {decompressed_data_len} does not match proposal {self.decompressed_size}")
+
+		program = decompressor()
+		decompress_span = Tracing.start_span("decompress", compressed_bytes=compressed_data_len, decompressed_bytes=decompressed_data_len, decompressor=os.path.basename(program))
+		try:
+			if program == BUILT_IN_DECOMPRESSOR:
+				self.decompressed_data = Lzhuf.decompress(self.compressed_data)
+			else:
+				self.decompressed_data = self._run_decompressor(program)
+		except Exception as e:
+			self.decompression_error = str(e)
+			self.logger.error(f"Decompression failed: {e}")
+			decompress_span.set_error(self.decompression_error)
+		finally:
+			decompress_span.end()
+		# A bug in any of the parsers below costs only this message its decoded fields
+		if self.decompression_error is None:
+			with Tracing.span("parse") as parse_span:
+				try:
+					self._extract_message_parts()
+				except Exception as e:
+					self._record_parse_error("extract", e)
+				parse_span.set_attribute("form_type", self.form.form_type if self.form is not None else None)
+				if self.parse_error is not None:
+					parse_span.set_error(f"{self.parse_error['stage']}: {self.parse_error['error']}")
+		self.transfer_size = byte_index
+		self._log_debug(f"JSON: {self.json_header()}")
+		return byte_index  # Returns the index of the next unprocessed byte in raw_data
+
+	def _run_decompressor(self, program):
+		"""The compressed data as decompressed by program, GO_EXECUTABLE.  Raises RuntimeError with what
+		it wrote to stderr if it fails."""
+		# Both temporary files are closed before the decompressor runs, since Windows will not let
+		# another process open a file that is still held open here
+		compressed_file_name = None
+		decompressed_file_name = None
+		try:
+			with tempfile.NamedTemporaryFile(delete=False, mode='wb', suffix='.Z') as compressed_file:
+				compressed_file_name = compressed_file.name
+				compressed_file.write(self.compressed_data)
+			with tempfile.NamedTemporaryFile(delete=False, mode='wb') as decompressed_file:
+				decompressed_file_name = decompressed_file.name
+			result = subprocess.run([program, compressed_file_name, decompressed_file_name], capture_output=True, text=True)
+			if result.returncode != 0:
+				detail = " ".join((result.stderr or "").split()) or "no message"
+				raise RuntimeError(f"{os.path.basename(program)} exited with status {result.returncode}: {detail}")
+			with open(decompressed_file_name, 'rb') as decompressed_file:
+				return decompressed_file.read()
+		finally:
+			for name in (compressed_file_name, decompressed_file_name):
+				if name is not None and os.path.exists(name):
+					os.remove(name)
+
+	def _extract_message_parts(self):
+		"""Extract headers and body from the decompressed data."""
+		if self.decompressed_data:
+
+			header_binary, body_and_attachments_binary = self.decompressed_data.split(b"\r\n\r\n", 1)
+			header_binary = self._transform(header_binary, IngestTransforms.HEADERS)
+			self.headers = header_binary.decode('ascii', errors='ignore') 
+			self.parse_headers(self.headers)
+			# The body is Body: bytes long and may hold CRLFs of its own; a CRLF follows it
+			body_binary = self._transform(body_and_attachments_binary[:self.body_length], IngestTransforms.BODY)
+			attachment_binary = body_and_attachments_binary[self.body_length + 2:]
+			if self.body_length == 0:
+				self.body = ""
+			else:
+				self.body = body_binary.decode('ascii', errors='ignore')
+
+			for attachment in self.attachments:
+				self._log_debug(f"Attachment expected size {attachment.size} Available {len(attachment_binary)}")
+				if attachment.size + 2 <= len(attachment_binary):
+					attachment.data = self._transform(attachment_binary[:attachment.size], IngestTransforms.ATTACHMENTS, attachment.filename)
+					self._log_debug(f"Extracted attachment {attachment.filename} of size {attachment.size}")
+					# Remove the extracted data from the binary stream
+					attachment_binary = attachment_binary[attachment.size+2:]
+			if self.is_bulletin():
+				self._log_debug(f"Message {self.message_id} is a bulletin or catalog response")
+			else:
+				self.extract_form()
+				self._validate()
+		else:
+			self.logger.error("Decompressed data is empty, cannot extract headers and body.")
+
+	def _transform(self, data, part, filename=None):
+		"""One part of the decompressed message with the configured transforms applied.  The decompressed
+		data itself is left alone, so what is saved as sent is what was sent."""
+		data, applied = IngestTransforms.apply(data, part, filename)
+		for name in applied:
+			self._log_debug(f"Transform {name} changed the {filename or part} of {self.message_id}")
+			if name not in self.transforms:
+				self.transforms.append(name)
+		return data
+
+	def parse_headers(self, headers):
+		"""Parse the header lines of a decoded message into the header fields."""
+		header_lines = headers.splitlines()  # Lines end in \r\n on the wire
+		self.body_length = 0
+		for line in header_lines:
+			line = line.strip()
+			parts = line.split()
+			part = line.split(" ",1)
+			# Body: 28
+			# Date: 2025/08/08 20:40
+			# From: W6EI-2
+			# Subject: Test
+			# To: BOB
+			# File: 21385 39D0D08F-D670-435E-AEB6-FE2A2936E900.jpg
+			# X-Location: 37.420281N, 122.120632W (GPS)
+			if line.startswith("Mid: "):
+				self.mid = part[1] if len(part) > 1 else None
+			elif line.startswith("Body: "):
+				self.body_length = int(parts[1]) if len(parts) > 1 else 0
+			elif line.startswith("Date: "):  # Date: 2025/08/08 20:40 (always UTC)
+				date = parse_timestamp(part[1], "UTC") if len(part) > 1 else None
+				if date is not None:
+					self.date = date
+				else:
+					self.warnings.append(f"Unrecognized date <{line.strip()}>")
+			elif line.startswith("From: "):
+				self.sender = part[1] if len(part) > 1 else "Unknown"
+			elif line.startswith("Subject: "):
+				self.subject = part[1] if len(part) > 1 else "Unknown"
+				self.precedence = WinlinkPrecedence.subject_precedence(self.subject) or WinlinkPrecedence.ROUTINE
+			elif line.startswith("To: "):
+				self.recipient = part[1] if len(part) > 1 else "Unknown"
+			elif line.startswith("X-Location: "):
+				i = line.replace(",", "").split()
+				if len(i) == 4:
+					lat = float(i[1][:-1])
+					if i[1][-1] == "N":
+						latitude = lat
+					else:
+						latitude = 0 - lat
+					lon = float(i[2][:-1])
+					if i[2][-1] == "E":
+						longitude = lon
+					else:
+						longitude = 0 - lon
+					self.position = { "latitude": latitude, "longitude": longitude}
+				else:
+					self.position = {"latitude": 0.0, "longitude": 0.0}
+				self.to = part[1] if len(part) > 1 else "Unknown"
+			elif line.startswith("Type: "):
+				self.type = part[1] if len(part) > 1 else ""
+			elif line.startswith("File: "):
+				b2attachment = B2Attachment(parts[2], int(parts[1]))
+				self.attachments.append(b2attachment)
+
+	def extract_form(self):
+		"""Parse the first RMS Express form attachment with data, if there is one, and any position attachments."""
+		for attachment in self.attachments:
+			if attachment.data is not None and WinlinkForm.is_form_attachment(attachment.filename):
+				form = WinlinkForm(attachment.filename, attachment.data, enable_debug=self.enable_debug)
+				try:
+					form.parse()
+					self.form = form
+					self.severity, self.severity_rank = WinlinkPrecedence.form_severity(form.variables)
+				except ValueError as e:
+					self.warnings.append(str(e))
+				except Exception as e:
+					# Malformed XML is a ValueError; anything else is a parser bug
+					self.form = None
+					self._record_parse_error(f"form {attachment.filename}", e)
+				break
+		self.extract_positions()
+
+	def extract_positions(self):
+		"""Collect the locations in position attachments, which some templates send instead of putting
+		coordinates in the form's variables."""
+		self.attached_positions = []
+		for attachment in self.attachments:
+			if attachment.data is not None and PositionAttachment.is_position_attachment(attachment.filename):
+				position = PositionAttachment(attachment.filename, attachment.data, enable_debug=self.enable_debug)
+				try:
+					position.parse()
+					self.attached_positions.extend(position.positions())
+				except ValueError as e:
+					self.warnings.append(str(e))
+				except Exception as e:
+					self._record_parse_error(f"position {attachment.filename}", e)
+
+	def _record_parse_error(self, stage, error):
+		self.parse_error = {"stage": stage, "error": f"{error.__class__.__name__}: {error}", "traceback": traceback.format_exc()}
+		self.warnings.append(f"Parser failure in {stage}: {self.parse_error['error']}")
+
+	def problem(self):
+		"""(stage, error, traceback) if the message could not be fully decoded, else None."""
+		if self.decompression_error is not None:
+			return "decompress", self.decompression_error, None
+		if not self.decompressed_data:
+			return "decompress", "Decompressed data is empty", None
+		if self.parse_error is not None:
+			return self.parse_error["stage"], self.parse_error["error"], self.parse_error["traceback"]
+		return None
+
+	def _validate(self):
+		"""Check the message for missing fields and implausible values, recording warnings."""
+		if not self.sender:
+			self.warnings.append("Missing From header")
+		if not self.recipient:
+			self.warnings.append("Missing To header")
+
+		latitude = self.position["latitude"]
+		longitude = self.position["longitude"]
+		if not (-90.0 <= latitude <= 90.0) or not (-180.0 <= longitude <= 180.0):
+			self.warnings.append(f"Position {latitude}, {longitude} is out of range")
+		elif latitude == 0.0 and longitude == 0.0:
+			self.warnings.append("No position reported")
+
+		now = utc_now()
+		if self.date > now + MAX_FUTURE_SKEW:
+			self.warnings.append(f"Date {self.date} is in the future")
+		elif self.date < now - MAX_MESSAGE_AGE:
+			self.warnings.append(f"Date {self.date} is more than {MAX_MESSAGE_AGE.days} days old")
+		if self.form is not None and self.form.submission_time is None:
+			self.warnings.append(f"Form {self.form.form_type}: unrecognized submission_datetime")
+
+		for attachment in self.attachments:
+			if attachment.data is None:
+				self.warnings.append(f"Attachment {attachment.filename} is truncated")
+
+		if self.form is not None:
+			self.warnings.extend(self.form.validate())
+
+		for warning in self.warnings:
+			self.logger.warning(f"Message {self.message_id}: {warning}")
+
+	def positions(self):
+		"""Every location in the message as (role, latitude, longitude): the reporting station's
+		X-Location first, as role "reporter", then any carried in the form, then any from position
+		attachments for roles not already placed.  Forms such as the check-in copy the station's own
+		position into the form, so one within SAME_POINT_KM of the reporter's is left out rather than
+		drawn as a second marker on top of the first."""
+		positions = []
+		reporter = None
+		if self.position["latitude"] != 0.0 or self.position["longitude"] != 0.0:
+			reporter = (REPORTER_ROLE, self.position["latitude"], self.position["longitude"])
+			positions.append(reporter)
+
+		def same_as_reporter(position):
+			return reporter is not None and Geo.distance_km(reporter[1], reporter[2], position[1], position[2]) <= SAME_POINT_KM
+
+		if self.form is not None:
+			positions.extend(p for p in self.form.positions() if not same_as_reporter(p))
+		roles = {role for role, _, _ in positions}
+		for position in self.attached_positions:
+			if position[0] not in roles and not same_as_reporter(position):
+				roles.add(position[0])
+				positions.append(position)
+		return positions
+
+	def is_bulletin(self):
+		"""True for catalog responses, inquiries, and bulletins, which are not station traffic."""
+		sender = (self.sender or "").upper().split("-")[0]
+		recipients = {r.strip().upper().split("-")[0] for r in (self.recipient or "").split(";")}
+		return sender in BULLETIN_ADDRESSES or bool(recipients & BULLETIN_ADDRESSES) or self.type.lower() in BULLETIN_TYPES
+
+	def urgency(self):
+		"""Urgency rank (0 = routine .. 3 = flash): the higher of the precedence and the form's severity."""
+		rank = WinlinkPrecedence.PRECEDENCE_RANK[self.precedence]
+		return max(rank, self.severity_rank) if self.severity_rank is not None else rank
+
+	def compression_stats(self):
+		"""Sizes of the message before and after compression, or None if it hasn't been parsed."""
+		if self.transfer_size is None:
+			return None
+		original = self.declared_decompressed_size or 0
+		return {
+			"original_size": original,
+			"compressed_size": len(self.compressed_data),
+			"transfer_size": self.transfer_size,  # compressed_size plus B2 framing
+			"ratio": len(self.compressed_data) / original if original else 0.0,
+			"bytes_saved": original - self.transfer_size,
+		}
+
+	def content_hash(self):
+		"""Hash of what the message says, ignoring its MID and date, so a re-send hashes the same."""
+		if self.form is not None:
+			# The submission time changes each time the operator presses send, so leave it out
+			parameters = {k: v for k, v in self.form.parameters.items() if k != "submission_datetime"}
+			content = {"sender": self.sender, "form_type": self.form.form_type, "parameters": parameters, "variables": self.form.variables}
+		else:
+			content = {
+				"sender": self.sender,
+				"recipient": self.recipient,
+				"subject": self.subject,
+				"body": self.body,
+				"attachments": [[a.filename, hashlib.sha256(a.data or b"").hexdigest()] for a in self.attachments],
+			}
+		return hashlib.sha256(json.dumps(content, sort_keys=True).encode()).hexdigest()
+
+	def json_header(self):
+		'''Produce JSON string of message header information'''
+		python_dict = {
+			"message_id": self.message_id,
+			"date": self.date.isoformat(),
+			"local_date": to_local(self.date).isoformat(),
+			"reported_date": self.reported_date.isoformat() if self.reported_date is not None else None,
+			"clock_offset_seconds": self.clock_offset.total_seconds() if self.clock_offset is not None else None,
+			"sender": self.sender,
+			"recipient": self.recipient,
+			"subject": self.subject,
+			"position": self.position,
+			"form_type": self.form.form_type if self.form is not None else None,
+			"template_version": TemplateVersions.declared_version(self.form) if self.form is not None else None,
+			"duplicate_of": self.duplicate_of,
+			"in_reply_to": self.in_reply_to,
+			"thread": self.thread,
+			"type": self.type,
+			"bulletin": self.is_bulletin(),
+			"precedence": self.precedence,
+			"severity": self.severity,
+			"urgency": self.urgency(),
+			"source": self.source,
+			"compression": self.compression_stats(),
+			"warnings": self.warnings,
+			"transforms": self.transforms
+		}
+
+		return json.dumps(python_dict, indent = 4, default=str)
\ No newline at end of file
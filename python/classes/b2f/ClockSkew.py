@@ -0,0 +1,97 @@
+#!/usr/bin/env python
+'''Estimates each station's clock error from when its messages were received, and corrects their dates'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+from datetime import timedelta
+from classes.b2f.WinlinkTime import parse_timestamp
+
+CORRECT = "correct"  # Move each message's date by its station's offset, keeping the date it carried
+FLAG = "flag"  # Only warn about stations whose clocks are off
+OFF = "off"
+MODES = [CORRECT, FLAG, OFF]
+
+# An offset smaller than this is ordinary delivery delay; only a clock further off is corrected
+SKEW_THRESHOLD = timedelta(minutes=5)
+# A clock that is behind looks like a slow delivery, so it needs this many messages delayed by about the
+# same amount (within SKEW_THRESHOLD) before it is believed; real delays vary, a wrong clock doesn't
+MIN_MESSAGES_BEHIND = 2
+
+_mode = FLAG  # Correcting rewrites the dates of messages, so it has to be asked for
+
+
+def set_mode(mode):
+	global _mode
+	if mode not in MODES:
+		raise ValueError(f"Unknown clock mode {mode}; expected one of {', '.join(MODES)}")
+	_mode = mode
+
+
+def received_time(message):
+	"""When the server received the message, from its source, or None if that wasn't recorded."""
+	return parse_timestamp((message.source or {}).get("received"))
+
+
+def sent_time(message):
+	"""The date the message carried, before any correction."""
+	return message.reported_date or message.date
+
+
+def _station(callsign):
+	return (callsign or "").upper().split("-")[0]
+
+
+def station_offsets(messages):
+	"""{station: offset} for each station whose clock is off by SKEW_THRESHOLD or more, judged from the
+	messages with a receive time.  A message can't have been sent after it arrived, so the date minus
+	the receive time is the station's clock error less the delivery delay; the largest of those, the
+	message that came through quickest, is the best estimate of the error."""
+	offsets = {}
+	for message in messages:
+		received = received_time(message)
+		if received is not None and message.sender:
+			offsets.setdefault(_station(message.sender), []).append(sent_time(message) - received)
+	skews = {}
+	for station, found in offsets.items():
+		offset = max(found)
+		if offset >= SKEW_THRESHOLD:
+			skews[station] = offset
+		elif offset <= -SKEW_THRESHOLD and len(found) >= MIN_MESSAGES_BEHIND and offset - min(found) < SKEW_THRESHOLD:
+			skews[station] = offset
+	return skews
+
+
+def describe(offset):
+	"""An offset as e.g. "2h 03m ahead" or "11m behind"."""
+	minutes = round(abs(offset).total_seconds() / 60)
+	text = f"{minutes // 60}h {minutes % 60:02d}m" if minutes >= 60 else f"{minutes}m"
+	return f"{text} {'ahead' if offset > timedelta(0) else 'behind'}"
+
+
+def apply(messages, mode=None):
+	"""Set each message's clock_offset from its station's, and with CORRECT move its date (and its form's
+	submission time) back by that much, which never puts it after its receive time.  The date the message
+	carried stays in reported_date.  Returns the {station: offset} found."""
+	mode = mode or _mode
+	if mode == OFF:
+		return {}
+	skews = station_offsets(messages)
+	for message in messages:
+		offset = skews.get(_station(message.sender))
+		if offset is None or message.clock_offset is not None:
+			continue
+		message.clock_offset = offset
+		if mode == FLAG:
+			message.warnings.append(f"Clock of {message.sender} appears to be {describe(offset)}")
+			continue
+		message.reported_date = message.date
+		message.date -= offset
+		if message.form is not None and message.form.submission_time is not None:
+			message.form.submission_time -= offset
+		message.warnings.append(f"Clock of {message.sender} appears to be {describe(offset)}; date corrected from {message.reported_date.isoformat()}")
+	return skews
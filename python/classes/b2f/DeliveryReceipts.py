@@ -0,0 +1,81 @@
+#!/usr/bin/env python
+'''Delivery and read receipts, and which messages they confirm'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+from classes.b2f.MessageThreads import MID_PATTERN, PRECEDENCE_PREFIX
+
+UNCONFIRMED = "unconfirmed"
+DELIVERED = "delivered"
+READ = "read"
+
+# Later statuses outrank earlier ones: a message that has been read was also delivered
+STATUS_ORDER = [UNCONFIRMED, DELIVERED, READ]
+
+# Subjects that mark a message as a receipt, and what it confirms. The first match wins.
+RECEIPT_SUBJECTS = [
+	(re.compile(r"^\s*(?:read\s+(?:receipt|confirmation|notification)|message\s+read)\b", re.IGNORECASE), READ),
+	(re.compile(r"^\s*(?:delivery\s+(?:receipt|confirmation|notification)|message\s+delivered|delivered|ack)\b", re.IGNORECASE), DELIVERED),
+]
+
+
+def receipt_kind(message):
+	"""READ or DELIVERED if the message's subject marks it as a receipt, otherwise None."""
+	subject = PRECEDENCE_PREFIX.sub("", message.subject or "")
+	return next((kind for pattern, kind in RECEIPT_SUBJECTS if pattern.match(subject)), None)
+
+
+def _station(callsign):
+	return (callsign or "").upper().split("-")[0]
+
+
+class Delivery:
+	"""A message and the receipts that confirm it."""
+
+	def __init__(self, message):
+		self.message = message
+		self.receipts = []  # (kind, B2Message) in date order
+
+	@property
+	def status(self):
+		return max((kind for kind, _ in self.receipts), key=STATUS_ORDER.index, default=UNCONFIRMED)
+
+	def as_dict(self):
+		message = self.message
+		return {
+			"mid": message.mid,
+			"date": message.date.isoformat(),
+			"sender": message.sender,
+			"recipient": message.recipient,
+			"subject": message.subject,
+			"status": self.status,
+			"receipts": [{"kind": kind, "mid": r.mid, "sender": r.sender, "date": r.date.isoformat()} for kind, r in self.receipts],
+		}
+
+
+def track_deliveries(messages):
+	"""Deliveries of the messages that aren't receipts, in date order, and the receipts that name no
+	message among them.  A receipt confirms the message whose MID it quotes, provided it comes from a
+	station other than that message's sender."""
+	ordered = sorted(messages, key=lambda m: m.date)
+	deliveries = [Delivery(m) for m in ordered if receipt_kind(m) is None]
+	by_mid = {d.message.mid.upper(): d for d in deliveries if d.message.mid}
+	unmatched = []
+	for message in ordered:
+		kind = receipt_kind(message)
+		if kind is None:
+			continue
+		text = f"{message.subject or ''} {message.body or ''}".upper()
+		confirmed = [by_mid[mid] for mid in dict.fromkeys(MID_PATTERN.findall(text)) if mid in by_mid]
+		confirmed = [d for d in confirmed if _station(d.message.sender) != _station(message.sender)]
+		for delivery in confirmed:
+			delivery.receipts.append((kind, message))
+		if not confirmed:
+			unmatched.append(message)
+	return deliveries, unmatched
@@ -0,0 +1,114 @@
+#!/usr/bin/env python
+'''Synthesizes realistic exercise traffic: form messages from made-up stations around an area'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import random
+import string
+from datetime import timedelta
+from classes.geo import Geo
+from classes.b2f import OutboundMessage
+from classes.b2f import WinlinkPrecedence
+
+CALLSIGN_PREFIXES = ["K", "W", "N", "KA", "KB", "KC", "KD", "KE", "KF", "KG", "KI", "KJ", "KK", "AA", "AB", "AC", "AD", "AE", "AF", "AG", "AI"]
+STATIONS_PER_MESSAGE = 0.25  # Size of the station pool relative to the message count
+WANDER_KM = 0.5  # How far a station moves between reports
+
+# (kind, weight); see _traffic for what each produces
+TRAFFIC_MIX = [("checkin", 5), ("ics213", 3), ("shelter", 1), ("request", 1)]
+PRECEDENCE_MIX = [(WinlinkPrecedence.ROUTINE, 85), (WinlinkPrecedence.PRIORITY, 10), (WinlinkPrecedence.IMMEDIATE, 4), (WinlinkPrecedence.FLASH, 1)]
+PLACES = ["Oak School", "Elm Street Firehouse", "Community Center", "Library", "High School Gym", "Senior Center", "City Hall", "Fairgrounds"]
+ICS213_TRAFFIC = [
+	("Road closed", "Road closed at {place}; downed lines across both lanes. Detour via the frontage road."),
+	("Power out", "Power out in the area around {place} since {time}. No ETA from the utility."),
+	("Water needed", "Request 20 cases of drinking water at {place}. About 60 people on site."),
+	("Medical aid", "One adult with a leg injury at {place}, stable, needs transport when available."),
+	("Status report", "All quiet at {place}. Radio, power, and antenna checks complete."),
+	("Net check", "Station on the air at {place} on battery power. Signals good both ways."),
+]
+PRECEDENCE_CODES = {name: code for code, name in WinlinkPrecedence.SUBJECT_PRECEDENCE_CODES.items()}
+SUPPLIES = [("cases", "Drinking water"), ("each", "Cots"), ("each", "Blankets"), ("each", "Generator 5 kW"), ("gallons", "Gasoline"), ("boxes", "First aid supplies")]
+
+
+def random_callsign(rng):
+	prefix = rng.choice(CALLSIGN_PREFIXES)
+	suffix_length = rng.choice([1, 2, 3]) if len(prefix) == 1 else rng.choice([2, 3])
+	return f"{prefix}{rng.randint(0, 9)}{''.join(rng.choice(string.ascii_uppercase) for _ in range(suffix_length))}"
+
+
+def _weighted(rng, mix):
+	return rng.choices([value for value, _ in mix], weights=[weight for _, weight in mix])[0]
+
+
+class ExerciseTraffic:
+	"""Messages from a pool of stations inside bbox (west, south, east, north), dated between start and
+	end (aware datetimes).  The same seed gives the same traffic."""
+
+	def __init__(self, bbox, start, end, recipients, seed=None):
+		self.bbox = bbox
+		self.start = start
+		self.end = end
+		self.recipients = recipients
+		self.rng = random.Random(seed)
+
+	def _point(self):
+		west, south, east, north = self.bbox
+		return self.rng.uniform(south, north), self.rng.uniform(west, east)
+
+	def _wander(self, lat, lon):
+		"""A nearby point, kept inside the box."""
+		west, south, east, north = self.bbox
+		lat, lon = Geo.destination(lat, lon, self.rng.uniform(0, 360), self.rng.uniform(0, WANDER_KM))
+		return min(max(lat, south), north), min(max(lon, west), east)
+
+	def messages(self, count):
+		"""count (mid, subject, message text) tuples in date order."""
+		stations = {}
+		while len(stations) < max(1, int(count * STATIONS_PER_MESSAGE)):
+			stations[random_callsign(self.rng)] = self._point()
+		span = (self.end - self.start).total_seconds()
+		times = sorted(self.start + timedelta(seconds=self.rng.uniform(0, span)) for _ in range(count))
+		for when in times:
+			callsign = self.rng.choice(list(stations))
+			stations[callsign] = self._wander(*stations[callsign])
+			lat, lon = stations[callsign]
+			subject, body, form = self._traffic(_weighted(self.rng, TRAFFIC_MIX), callsign, lat, lon, when)
+			mid = "".join(self.rng.choice(OutboundMessage.MID_CHARACTERS) for _ in range(OutboundMessage.MID_LENGTH))
+			text = OutboundMessage.message_text(mid, callsign, [self.rng.choice(self.recipients)], subject, body, [form], when, (lat, lon))
+			yield mid, subject, text
+
+	def _traffic(self, kind, callsign, lat, lon, when):
+		"""(subject, body, form attachment) for one message of a kind."""
+		place = self.rng.choice(PLACES)
+		grid = Geo.maidenhead(lat, lon)
+		if kind == "checkin":
+			form = OutboundMessage.check_in_form(callsign, lat, lon, self.rng.choice(["", "On battery", "Portable", "All OK"]), place, "EXERCISE", "ESV ARES", when)
+			return OutboundMessage.CHECK_IN_SUBJECT, OutboundMessage.check_in_body(callsign, lat, lon, "", place, "EXERCISE", when), form
+
+		precedence = _weighted(self.rng, PRECEDENCE_MIX)
+		prefix = "" if precedence == WinlinkPrecedence.ROUTINE else f"//WL2K {PRECEDENCE_CODES[precedence]}/ "
+		if kind == "ics213":
+			title, text = self.rng.choice(ICS213_TRAFFIC)
+			text = text.format(place=place, time=(when - timedelta(minutes=self.rng.randint(10, 240))).strftime("%H:%M"))
+			variables = {"to_name": "EOC", "fm_name": callsign, "subjectline": title, "message": text, "latitude": f"{lat:.6f}", "longitude": f"{lon:.6f}"}
+			form = OutboundMessage.form_attachment("ICS213_Initial", callsign, variables, when, grid)
+			return f"{prefix}{title} at {place}", text, form
+		if kind == "shelter":
+			capacity = self.rng.choice([50, 100, 150, 250])
+			occupancy = self.rng.randint(0, capacity)
+			variables = {"shelter_name": place, "shelter_status": "Open", "capacity": capacity, "occupancy": occupancy,
+				"meals": occupancy * self.rng.randint(1, 3), "staff": self.rng.randint(2, 12), "latitude": f"{lat:.6f}", "longitude": f"{lon:.6f}"}
+			form = OutboundMessage.form_attachment("ARC_Shelter_Status", callsign, variables, when, grid)
+			return f"{prefix}Shelter status {place}", f"{place}: {occupancy} of {capacity}", form
+		variables = {"incident_name": "ESV Exercise", "request_number": f"{self.rng.randint(1, 999):03d}", "requested_by": callsign,
+			"priority": "Urgent" if precedence != WinlinkPrecedence.ROUTINE else "Routine", "deliver_to": place,
+			"latitude": f"{lat:.6f}", "longitude": f"{lon:.6f}"}
+		for row, (kind_of, item) in enumerate(self.rng.sample(SUPPLIES, self.rng.randint(1, 3)), 1):
+			variables.update({f"qty{row}": self.rng.randint(1, 40), f"kind{row}": kind_of, f"item{row}": item})
+		form = OutboundMessage.form_attachment("ICS213RR", callsign, variables, when, grid)
+		return f"{prefix}Resource request {variables['request_number']} for {place}", f"Resource request for {place}", form
@@ -0,0 +1,114 @@
+#!/usr/bin/env python
+'''Small fixes applied to each decompressed message before it is parsed, for quirks of older templates'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import fnmatch
+import re
+
+# The parts of a message a transform can apply to.  Each is handled on its own after the message is split
+# by its Body: and File: lengths, so a transform that changes a part's length never misaligns the rest.
+HEADERS = "headers"
+BODY = "body"
+ATTACHMENTS = "attachments"
+PARTS = (HEADERS, BODY, ATTACHMENTS)
+
+STRIP_BOM = "strip_bom"  # Drop a UTF-8 byte order mark at the start of each part
+REPLACE = "replace"  # Replace text: {"find", "replace", "regex": false}
+RENAME_VARIABLES = "rename_variables"  # Rename form XML elements: {"names": {old: new}}, attachments only
+TYPES = (STRIP_BOM, REPLACE, RENAME_VARIABLES)
+
+UTF8_BOM = b"\xef\xbb\xbf"
+
+# mappings.json "transforms": applied in order, e.g.
+# [{"type": "strip_bom"},
+#  {"type": "replace", "find": "Lattitude", "replace": "Latitude", "in": ["attachments"], "files": "RMS_Express_Form_*"},
+#  {"type": "rename_variables", "names": {"gps_lat": "maplat", "gps_lon": "maplon"}, "files": "RMS_Express_Form_Damage*"}]
+# "in" lists the parts (default: all of them; rename_variables: attachments) and "files" is a glob on
+# attachment names (default: every attachment).
+TRANSFORMS = []
+
+
+def describe(transform):
+	"""A short name for a transform, as recorded on the messages it changed."""
+	return transform.get("name") or transform["type"]
+
+
+def check_transforms(value):
+	"""The transforms setting checked and filled in with its defaults.  Raises ValueError."""
+	if not isinstance(value, list) or not all(isinstance(t, dict) for t in value):
+		raise ValueError("transforms must be a list of objects")
+	checked = []
+	for number, transform in enumerate(value, 1):
+		transform = dict(transform)
+		kind = transform.get("type")
+		where = f"transform {number}"
+		if kind not in TYPES:
+			raise ValueError(f"{where}: type must be one of {', '.join(TYPES)}")
+		allowed = {"type", "name", "in", "files"}
+		parts = transform.setdefault("in", [ATTACHMENTS] if kind == RENAME_VARIABLES else list(PARTS))
+		if isinstance(parts, str):
+			parts = transform["in"] = [parts]
+		if not isinstance(parts, list) or not parts or not set(parts) <= set(PARTS):
+			raise ValueError(f"{where}: in must list parts from {', '.join(PARTS)}")
+		if not isinstance(transform.setdefault("files", "*"), str):
+			raise ValueError(f"{where}: files must be a glob such as RMS_Express_Form_*")
+		if kind == REPLACE:
+			allowed |= {"find", "replace", "regex"}
+			if not isinstance(transform.get("find"), str) or not transform["find"] or not isinstance(transform.get("replace"), str):
+				raise ValueError(f"{where}: replace needs find and replace text")
+			if transform.setdefault("regex", False):
+				try:
+					pattern = re.compile(transform["find"].encode("utf-8"))
+				except re.error as e:
+					raise ValueError(f"{where}: bad regex <{transform['find']}>: {e}")
+				try:
+					pattern.sub(transform["replace"].encode("utf-8"), b"")  # Parses the replacement even with no match
+				except (re.error, IndexError) as e:
+					raise ValueError(f"{where}: bad replacement <{transform['replace']}>: {e}")
+		elif kind == RENAME_VARIABLES:
+			allowed |= {"names"}
+			names = transform.get("names")
+			if parts != [ATTACHMENTS]:
+				raise ValueError(f"{where}: rename_variables only applies to attachments")
+			if not isinstance(names, dict) or not names or not all(re.fullmatch(r"[\w.\-]+", n) for n in list(names) + list(names.values())):
+				raise ValueError(f"{where}: rename_variables needs names mapping old variable names to new ones")
+		unknown = set(transform) - allowed
+		if unknown:
+			raise ValueError(f"{where}: unknown settings {', '.join(sorted(unknown))}")
+		checked.append(transform)
+	return checked
+
+
+def _transform(transform, data):
+	kind = transform["type"]
+	if kind == STRIP_BOM:
+		return data[len(UTF8_BOM):] if data.startswith(UTF8_BOM) else data
+	if kind == REPLACE:
+		find = transform["find"].encode("utf-8")
+		replace = transform["replace"].encode("utf-8")
+		return re.sub(find, replace, data) if transform["regex"] else data.replace(find, replace)
+	for old, new in transform["names"].items():
+		data = re.sub(rb"<(/?)" + re.escape(old.encode()) + rb"(?=[\s/>])", rb"<\g<1>" + new.encode(), data)
+	return data
+
+
+def apply(data, part, filename=None, transforms=None):
+	"""data (bytes of one part of a message) with the transforms for that part applied.  Returns the new
+	bytes and the names of the transforms that changed anything."""
+	applied = []
+	for transform in TRANSFORMS if transforms is None else transforms:
+		if part not in transform["in"]:
+			continue
+		if part == ATTACHMENTS and not fnmatch.fnmatch((filename or "").lower(), transform["files"].lower()):
+			continue
+		changed = _transform(transform, data)
+		if changed != data:
+			applied.append(describe(transform))
+			data = changed
+	return data, applied
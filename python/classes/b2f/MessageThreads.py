@@ -0,0 +1,98 @@
+#!/usr/bin/env python
+'''Links replies to the messages they answer so exchanges read as conversations'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+
+REPLY_PREFIX = re.compile(r"^\s*(?:(?:re|aw|ack)\s*:\s*)+", re.IGNORECASE)
+PRECEDENCE_PREFIX = re.compile(r"^//WL2K\s+[RPOZ]/\s*", re.IGNORECASE)
+MID_PATTERN = re.compile(r"\b[A-Z0-9]{12}\b")  # Winlink MIDs are 12 characters
+
+# Form variables holding the subject of an ICS-213 and its reply
+FORM_SUBJECT_VARIABLES = ["subjectline", "subject"]
+
+
+def subject_key(subject):
+	"""The subject with reply prefixes and the //WL2K precedence marker removed, for matching replies."""
+	subject = REPLY_PREFIX.sub("", subject or "")
+	subject = PRECEDENCE_PREFIX.sub("", subject)
+	subject = REPLY_PREFIX.sub("", subject)
+	return " ".join(subject.split()).lower()
+
+
+def is_reply_subject(subject):
+	return REPLY_PREFIX.match(PRECEDENCE_PREFIX.sub("", subject or "")) is not None
+
+
+def _subjects(message):
+	"""Keys of the header subject and any subject carried in the form."""
+	keys = {subject_key(message.subject)}
+	if message.form is not None:
+		keys.update(subject_key(message.form.variables.get(name)) for name in FORM_SUBJECT_VARIABLES if message.form.variables.get(name))
+	keys.discard("")
+	return keys
+
+
+def _station(callsign):
+	return (callsign or "").upper().split("-")[0]
+
+
+def find_parent(message, by_mid, by_subject):
+	"""The message this one answers: one whose MID it quotes, or else the latest earlier message from
+	another station with the same subject, if this one's subject marks it as a reply.  by_mid holds the
+	earlier messages by MID and by_subject, from remember, the latest of them from each station by
+	subject key."""
+	text = " ".join([message.subject or "", message.body or ""] + (list(message.form.variables.values()) if message.form is not None else []))
+	for mid in MID_PATTERN.findall(text.upper()):
+		parent = by_mid.get(mid)
+		if parent is not None and parent is not message:
+			return parent
+	subjects = [message.subject or ""] + ([message.form.variables.get(n) or "" for n in FORM_SUBJECT_VARIABLES] if message.form is not None else [])
+	if not any(is_reply_subject(s) for s in subjects):
+		return None
+	station = _station(message.sender)
+	latest = None  # (index, message)
+	for key in _subjects(message):
+		for sender, candidate in by_subject.get(key, {}).items():
+			if sender != station and (latest is None or candidate[0] > latest[0]):
+				latest = candidate
+	return latest[1] if latest is not None else None
+
+
+def remember(message, index, by_subject):
+	"""Record message, the index-th in date order, as the latest from its station under each of its subjects."""
+	station = _station(message.sender)
+	for key in _subjects(message):
+		by_subject.setdefault(key, {})[station] = (index, message)
+
+
+def link(messages):
+	"""Set in_reply_to, thread, and conversation on each message, and return the threads of more than
+	one message as a dict of root MID -> messages in date order."""
+	ordered = sorted(messages, key=lambda m: m.date)
+	by_mid = {}  # Earlier messages by MID
+	by_subject = {}  # Subject key -> station -> (index, latest earlier message)
+	roots = {}
+	for index, message in enumerate(ordered):
+		parent = find_parent(message, by_mid, by_subject)
+		message.in_reply_to = parent.mid if parent is not None else None
+		root = roots[id(parent)] if parent is not None else message
+		roots[id(message)] = root
+		message.thread = root.mid or root.message_id
+		if message.mid:
+			by_mid[message.mid.upper()] = message
+		remember(message, index, by_subject)
+	threads = {}
+	for message in ordered:
+		threads.setdefault(message.thread, []).append(message)
+	threads = {root: members for root, members in threads.items() if len(members) > 1}
+	for members in threads.values():
+		for message in members:
+			message.conversation = members
+	return threads
@@ -0,0 +1,137 @@
+#!/usr/bin/env python
+'''Builds Winlink messages to send: the B2 message text, its compressed transfer, and Check-In forms'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import secrets
+import string
+from xml.sax.saxutils import escape
+from classes.geo import Geo
+from classes.lzhuf import Lzhuf
+from classes.b2f.B2Message import SOH, NUL, STX, EOT
+from classes.forms.WinlinkForm import FORM_ATTACHMENT_PREFIX, FORM_ATTACHMENT_SUFFIX
+from classes.b2f.WinlinkTime import utc_now
+
+MID_LENGTH = 12
+MID_CHARACTERS = string.ascii_uppercase + string.digits
+MAX_BLOCK_SIZE = 250  # Data bytes per STX block
+MAX_TITLE_LENGTH = 80  # Of the subject in the transfer header
+
+CHECK_IN_FORM = "Winlink_Check_In"
+CHECK_IN_SUBJECT = "Winlink Check-In"
+CHECK_IN_TEMPLATE_VERSION = "Winlink Check-in 5.0.10"
+SETTINGS = ["EXERCISE", "REAL EVENT", "TEST"]
+
+
+def generate_mid():
+	"""A random 12-character message ID like the ones Winlink clients make."""
+	return "".join(secrets.choice(MID_CHARACTERS) for _ in range(MID_LENGTH))
+
+
+def format_location(lat, lon):
+	"""The X-Location header value, as B2Message.parse_headers reads it."""
+	return f"{abs(lat):.6f}{'N' if lat >= 0 else 'S'}, {abs(lon):.6f}{'E' if lon >= 0 else 'W'} (GPS)"
+
+
+def message_text(mid, sender, recipients, subject, body, files=None, when=None, location=None, message_type="Private"):
+	"""The uncompressed B2 message: headers, a blank line, the body, then each file, each followed by
+	CRLF.  recipients is a list of addresses; files is a list of (name, bytes)."""
+	files = files or []
+	body = body.replace("\r\n", "\n").replace("\n", "\r\n").encode("utf-8")
+	when = when or utc_now()
+	lines = [
+		f"Mid: {mid}",
+		f"Date: {when.strftime('%Y/%m/%d %H:%M')}",
+		f"Type: {message_type}",
+		f"From: {sender}",
+	]
+	lines.extend(f"To: {recipient}" for recipient in recipients)
+	lines.append(f"Subject: {subject}")
+	lines.append(f"Mbo: {sender}")
+	if location is not None:
+		lines.append(f"X-Location: {format_location(*location)}")
+	lines.append(f"Body: {len(body)}")
+	lines.extend(f"File: {len(data)} {name}" for name, data in files)
+	text = ("\r\n".join(lines) + "\r\n\r\n").encode("ascii", errors="replace") + body + b"\r\n"
+	for _, data in files:
+		text += data + b"\r\n"
+	return text
+
+
+def transfer(subject, text):
+	"""The compressed B2 transfer of a message, as it appears on the wire after the proposal and as
+	the capture files read by esvmap hold it: the header, STX blocks, EOT, and checksum."""
+	compressed = Lzhuf.compress(text)
+	title = subject.encode("ascii", errors="replace")[:MAX_TITLE_LENGTH]
+	offset = b"0"
+	frame = bytearray([SOH, len(title) + len(offset) + 2]) + title + bytes([NUL]) + offset + bytes([NUL])
+	for start in range(0, len(compressed), MAX_BLOCK_SIZE):
+		block = compressed[start:start + MAX_BLOCK_SIZE]
+		frame += bytes([STX, len(block)]) + block
+	frame += bytes([EOT, (-sum(compressed)) & 0xFF])
+	return bytes(frame), compressed
+
+
+def proposal(mid, size, compressed_size):
+	"""The proposal a client sends before the transfer: FC EM <MID> <size> <compressed size> 0."""
+	return f"FC EM {mid} {size} {compressed_size} 0"
+
+
+def proposal_checksum(proposals):
+	"""The two hex digits after F>: the negated sum of the proposal lines, carriage returns included."""
+	return f"{(-sum(proposals.encode('ascii', errors='replace'))) & 0xFF:02X}"
+
+
+def form_attachment(form_type, callsign, variables, when=None, grid=""):
+	"""(attachment name, XML) of an RMS Express form whose viewer is <form_type>_Viewer.html."""
+	when = when or utc_now()
+	parameters = {
+		"xml_file_version": "1.0",
+		"submission_datetime": when.strftime("%Y%m%d%H%M%S"),
+		"senders_callsign": callsign,
+		"grid_square": grid,
+		"display_form": f"{form_type}_Viewer.html",
+		"reply_template": "",
+	}
+
+	def section(name, values):
+		return [f"  <{name}>"] + [f"    <{k}>{escape(str(v))}</{k}>" for k, v in values.items()] + [f"  </{name}>"]
+
+	lines = ['<?xml version="1.0"?>', "<RMS_Express_Form>"] + section("form_parameters", parameters) + section("variables", variables) + ["</RMS_Express_Form>"]
+	return f"{FORM_ATTACHMENT_PREFIX}{form_type}{FORM_ATTACHMENT_SUFFIX}", ("\r\n".join(lines) + "\r\n").encode("utf-8")
+
+
+def check_in_form(callsign, lat=None, lon=None, comments="", location="", setting=SETTINGS[0], organization="", when=None):
+	"""(attachment name, XML) of a Winlink Check-In form."""
+	when = when or utc_now()
+	grid = Geo.maidenhead(lat, lon) if lat is not None else ""
+	variables = {
+		"msgsender": callsign,
+		"templateversion": CHECK_IN_TEMPLATE_VERSION,
+		"setting": setting,
+		"organization": organization,
+		"datetime": when.strftime("%Y-%m-%d %H:%M:%SZ"),
+		"location": location,
+		"latitude": f"{lat:.6f}" if lat is not None else "",
+		"longitude": f"{lon:.6f}" if lon is not None else "",
+		"grid": grid,
+		"comments": comments,
+	}
+	return form_attachment(CHECK_IN_FORM, callsign, variables, when, grid)
+
+
+def check_in_body(callsign, lat, lon, comments, location, setting, when):
+	"""The plain-text body sent with the form, for stations that don't show forms."""
+	lines = [CHECK_IN_SUBJECT, "", setting, f"Station: {callsign}", f"Date/Time: {when.strftime('%Y-%m-%d %H:%M')}Z"]
+	if location:
+		lines.append(f"Location: {location}")
+	if lat is not None:
+		lines.append(f"Position: {lat:.6f}, {lon:.6f} ({Geo.maidenhead(lat, lon)})")
+	if comments:
+		lines += ["", comments]
+	return "\n".join(lines) + "\n"
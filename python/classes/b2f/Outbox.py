@@ -0,0 +1,137 @@
+#!/usr/bin/env python
+'''Messages waiting to go to other stations over a peer-to-peer session, kept as B2 transfer files'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import os
+import threading
+from classes.b2f.B2Message import B2Message, transfer_length
+from classes.b2f import OutboundMessage
+
+OUTBOX_FOLDER_NAME = "outbox"
+SENT_FOLDER_NAME = "sent"  # Under the outbox folder; transfers move here once a peer has taken them
+OUTBOX_FILE_EXTENSION = ".b2f"
+
+
+def base_callsign(address):
+	"""The callsign in an address such as W6EI-10 or w6ei@winlink.org, without SSID or domain."""
+	return address.strip().upper().split("@")[0].split("-")[0]
+
+
+class OutboundEntry:
+	"""One message in the outbox: its compressed transfer, and what the proposal for it says."""
+
+	def __init__(self, filename, frame, mid, size, compressed_size, recipients):
+		self.filename = filename  # The outbox file holding it; a file may hold several transfers
+		self.frame = frame  # The complete B2 transfer, as sent after the peer accepts the proposal
+		self.mid = mid
+		self.size = size  # Uncompressed bytes
+		self.compressed_size = compressed_size
+		self.recipients = recipients  # To: and Cc: addresses
+
+	@property
+	def proposal(self):
+		return OutboundMessage.proposal(self.mid, self.size, self.compressed_size)
+
+	def addressed_to(self, callsign):
+		"""True if the message is for callsign, whatever SSID either of them has."""
+		return base_callsign(callsign) in {base_callsign(r) for r in self.recipients}
+
+
+class Outbox:
+	"""A folder of .b2f transfers to send, as esvmap checkin and generate write them.  Sessions offer a
+	peer the messages addressed to it, and move each transfer to sent/ once the peer has taken it."""
+
+	def __init__(self, folder=OUTBOX_FOLDER_NAME, enable_debug=False):
+		self.folder = folder
+		self.enable_debug = enable_debug
+		self.lock = threading.Lock()  # Sessions with two peers may finish at once
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _read_file(self, filename):
+		"""The entries in one outbox file.  A transfer that can't be decoded is logged and skipped, since
+		its proposal can't be written without the sizes and MID inside it."""
+		with open(filename, 'rb') as f:
+			raw_data = f.read()
+		entries = []
+		while raw_data:
+			try:
+				length = transfer_length(raw_data)
+			except ValueError as e:
+				self.logger.error(f"Outbox file {filename} is malformed after {len(entries)} messages: {e}")
+				break
+			frame = bytes(raw_data[:length])
+			raw_data = raw_data[length:]
+			message = B2Message(os.path.basename(filename), frame, None, None, enable_debug=self.enable_debug)
+			try:
+				message.parse()
+			except Exception as e:
+				self.logger.error(f"Skipping a message in outbox file {filename}: {e}")
+				continue
+			if message.decompressed_data is None or not message.mid:
+				self.logger.error(f"Skipping a message in outbox file {filename}: {message.decompression_error or 'no Mid: header'}")
+				continue
+			recipients = [line.split(":", 1)[1].strip() for line in (message.headers or "").splitlines() if line.startswith(("To:", "Cc:"))]
+			entries.append(OutboundEntry(filename, frame, message.mid, len(message.decompressed_data), len(message.compressed_data), recipients))
+		return entries
+
+	def entries(self):
+		"""Every message waiting to be sent, oldest file first."""
+		if not os.path.isdir(self.folder):
+			return []
+		entries = []
+		with self.lock:
+			for name in sorted(os.listdir(self.folder)):
+				filename = os.path.join(self.folder, name)
+				if name.lower().endswith(OUTBOX_FILE_EXTENSION) and os.path.isfile(filename):
+					entries.extend(self._read_file(filename))
+		return entries
+
+	def for_station(self, callsign):
+		"""The messages waiting for callsign."""
+		return [entry for entry in self.entries() if entry.addressed_to(callsign)]
+
+	def mark_sent(self, entries):
+		"""Move the transfers a peer has taken to sent/.  A file keeps whatever it holds that wasn't sent."""
+		sent_folder = os.path.join(self.folder, SENT_FOLDER_NAME)
+		with self.lock:
+			for filename in sorted({entry.filename for entry in entries}):
+				sent = {entry.frame for entry in entries if entry.filename == filename}
+				try:
+					with open(filename, 'rb') as f:
+						raw_data = f.read()
+					frames = []
+					while raw_data:
+						length = transfer_length(raw_data)
+						frames.append(bytes(raw_data[:length]))
+						raw_data = raw_data[length:]
+					os.makedirs(sent_folder, exist_ok=True)
+					with open(os.path.join(sent_folder, os.path.basename(filename)), 'ab') as f:
+						f.write(b"".join(frame for frame in frames if frame in sent))
+					remaining = [frame for frame in frames if frame not in sent]
+					if remaining:
+						with open(filename, 'wb') as f:
+							f.write(b"".join(remaining))
+					else:
+						os.remove(filename)
+					self._log_debug(f"Sent {len(frames) - len(remaining)} messages from {filename}")
+				except (OSError, ValueError) as e:
+					self.logger.error(f"Error moving sent messages out of {filename}; they may be offered again: {e}")
@@ -0,0 +1,248 @@
+#!/usr/bin/env python
+'''Peer-to-peer B2F sessions: two stations exchange traffic directly, with no CMS in the path'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import re
+import socket
+from classes.b2f import OutboundMessage
+from classes.b2f.B2Message import SOH, STX, EOT
+from classes.server.IngestPipeline import IngestPipeline
+from classes.b2f.Outbox import Outbox, OUTBOX_FOLDER_NAME
+from classes.store.WinlinkMailMessage import WinlinkMailMessage, MAILBOX_FOLDER_NAME, mailbox_mids
+from classes.b2f.WinlinkTime import utc_now
+
+P2P_FILE_NAME = "p2p.json"  # This station's callsign and outbox; the server acts as a CMS gateway if the file is absent
+P2P_SID = "[ESVMAP-1.0-B2F$]"
+SESSION_TIMEOUT_SECONDS = 60
+SID_PATTERN = re.compile(r"^\[.*\]$")
+PROMPT_PATTERN = re.compile(r"^(?:(?P<caller>\S+) de )?(?P<station>\S+?)\s*>$")  # "K6ABC de W6EI-10>" or "CMS>"
+CMS_PROMPT_STATION = "CMS"
+ACCEPT = "+"
+REJECT = "-"
+ACCEPTED_ANSWERS = "Y+"  # FS answers that mean "send it now"; N and - reject, L and = defer
+
+
+class SessionError(Exception):
+	pass
+
+
+def p2p_prompt(caller, station):
+	"""What the answering station sends when it is ready for the caller's SID and proposals."""
+	return f"{caller} de {station}>"
+
+
+def load_station(filename, enable_debug=False):
+	"""(callsign, Outbox) from a JSON file such as {"callsign": "W6EI-10", "outbox": "outbox"}.
+	Raises ValueError for bad settings."""
+	with open(filename, 'r') as f:
+		try:
+			config = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(config, dict) or not isinstance(config.get("callsign"), str) or not config["callsign"].strip():
+		raise ValueError(f"{filename}: expected {{\"callsign\": \"...\", \"outbox\": \"{OUTBOX_FOLDER_NAME}\"}}")
+	unknown = set(config) - {"callsign", "outbox"}
+	if unknown:
+		raise ValueError(f"Unknown settings in {filename}: {', '.join(sorted(unknown))}")
+	return config["callsign"].strip().upper(), Outbox(config.get("outbox", OUTBOX_FOLDER_NAME), enable_debug)
+
+
+def read_line(stream):
+	"""A protocol line, which the other end finishes with a carriage return."""
+	line = bytearray()
+	while True:
+		byte = stream.read(1)
+		if not byte:
+			raise SessionError("connection closed by the peer")
+		if byte == b"\r":
+			return line.decode("utf-8", errors="replace").strip()
+		line += byte
+
+
+def _read_exactly(stream, count):
+	data = stream.read(count)
+	if len(data) != count:
+		raise SessionError("connection closed in the middle of a transfer")
+	return data
+
+
+def read_transfer(stream):
+	"""One B2 transfer from the stream, read by its framing: the header, STX blocks, EOT, and checksum."""
+	start = _read_exactly(stream, 2)
+	if start[0] != SOH:
+		raise SessionError(f"expected SOH at the start of a transfer, got 0x{start[0]:02X}")
+	frame = bytearray(start) + _read_exactly(stream, start[1])
+	while True:
+		marker = _read_exactly(stream, 2)
+		frame += marker
+		if marker[0] == EOT:
+			return bytes(frame)
+		if marker[0] != STX:
+			raise SessionError(f"expected STX or EOT in a transfer, got 0x{marker[0]:02X}")
+		frame += _read_exactly(stream, marker[1])
+
+
+def parse_proposal(line):
+	"""(type, MID, size, compressed size) from an FC proposal line.  Raises SessionError."""
+	parts = line.split()
+	try:
+		return parts[1], parts[2], int(parts[3]), int(parts[4])
+	except (IndexError, ValueError):
+		raise SessionError(f"bad proposal <{line}>")
+
+
+class P2PClient:
+	"""Calls another station running this tool (or Winlink Express listening for P2P telnet), sends it the
+	outbox messages addressed to it, and saves what it sends back in the mailbox folder.  Against a CMS
+	gateway the same session just delivers, since a gateway has nothing to send back here."""
+
+	def __init__(self, host, port, callsign, password=None, outbox=None, folder=MAILBOX_FOLDER_NAME, peer=None,
+			source_path=None, timeout=SESSION_TIMEOUT_SECONDS, enable_debug=False):
+		self.host = host
+		self.port = port
+		self.callsign = callsign.upper()
+		self.password = password
+		self.outbox = outbox if outbox is not None else Outbox(enable_debug=enable_debug)
+		self.folder = folder
+		self.peer = peer.upper() if peer else None  # Whose messages to send; by default the station named in its prompt
+		self.source_path = source_path
+		self.timeout = timeout
+		self.enable_debug = enable_debug
+		self.pipeline = IngestPipeline(workers=0, enable_debug=enable_debug)
+		self.peer_sid = None
+		self.sent = []  # MIDs the peer took
+		self.deferred = []  # MIDs the peer declined or put off
+		self.received = []  # WinlinkMailMessages saved from the peer
+		self.refused = []  # MIDs the peer proposed that we already had
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _send(self, connection, text):
+		connection.sendall(text.encode("ascii", errors="replace"))
+		self._log_debug(f"Sent: <{text.strip()}>")
+
+	def _login(self, connection, stream):
+		"""Answer the callsign and password prompts, then return the station named in the peer's prompt."""
+		while True:
+			line = read_line(stream)
+			self._log_debug(f"Received: <{line}>")
+			if line.startswith("Callsign"):
+				self._send(connection, f"{self.callsign}\r")
+			elif line.startswith("Password"):
+				self._send(connection, f"{self.password or self.callsign}\r")
+			elif SID_PATTERN.match(line):
+				self.peer_sid = line
+			else:
+				prompt = PROMPT_PATTERN.match(line)
+				if prompt:
+					return prompt.group("station").upper()
+
+	def _source(self, peer):
+		"""Provenance of messages the peer sends on this session."""
+		return {
+			"kind": "p2p",
+			"gateway": peer,
+			"address": f"{self.host}:{self.port}",
+			"sid": self.peer_sid.strip("[]") if self.peer_sid else None,
+			"path": self.source_path,
+			"received": utc_now().isoformat(timespec="seconds"),  # By this station's clock; see ClockSkew
+		}
+
+	def _offer(self, connection, stream, offers):
+		"""Propose offers to the peer and send the ones it accepts.  Returns them; they count as sent
+		once the peer takes its turn."""
+		proposals = "".join(f"{entry.proposal}\r" for entry in offers)
+		self._send(connection, f"{proposals}F> {OutboundMessage.proposal_checksum(proposals)}\r")
+		answer = self._expect_answer(stream)
+		if len(answer) != len(offers):
+			raise SessionError(f"peer answered <FS {answer}> to {len(offers)} proposals")
+		accepted = [entry for entry, code in zip(offers, answer) if code in ACCEPTED_ANSWERS]
+		self.deferred.extend(entry.mid for entry, code in zip(offers, answer) if code not in ACCEPTED_ANSWERS)
+		if accepted:
+			connection.sendall(b"".join(entry.frame for entry in accepted))
+			self._log_debug(f"Sent {len(accepted)} transfers")
+		return accepted
+
+	def _expect_answer(self, stream):
+		while True:
+			line = read_line(stream)
+			if line.startswith("FS"):
+				return line[2:].strip().upper().replace(" ", "")
+			if not line.startswith(";"):
+				raise SessionError(f"expected FS, got <{line}>")
+
+	def _receive(self, connection, stream, first, peer):
+		"""Take the peer's proposals, starting with the line first, and save the transfers it sends.
+		Messages already in the mailbox, or proposed twice, are refused so the peer stops offering them."""
+		proposals = []
+		line = first
+		while not line.startswith("F>"):
+			if line.startswith("FC"):
+				proposals.append(parse_proposal(line))
+			elif not line.startswith(";"):
+				raise SessionError(f"expected a proposal or F>, got <{line}>")
+			line = read_line(stream)
+		known = set(mailbox_mids(self.folder))
+		answers = []
+		for _, mid, _, _ in proposals:
+			answers.append(REJECT if mid in known else ACCEPT)
+			known.add(mid)
+		self._send(connection, f"FS {''.join(answers)}\r")
+		accepted = [proposal for proposal, answer in zip(proposals, answers) if answer == ACCEPT]
+		self.refused.extend(mid for (_, mid, _, _), answer in zip(proposals, answers) if answer == REJECT)
+		for message_type, mid, size, compressed_size in accepted:
+			frame = read_transfer(stream)
+			message = WinlinkMailMessage(message_type, mid, size, compressed_size, enable_debug=self.enable_debug, folder=self.folder, source=self._source(peer))
+			message.capture(frame)
+			self.pipeline.submit(message, len(frame))
+			self.received.append(message)
+			self._log_debug(f"Received {mid} from {peer}")
+
+	def exchange(self):
+		"""Run one session to the end.  Returns the peer's callsign.  Raises SessionError or OSError if the
+		session breaks off; what was finished before that stays sent and saved."""
+		with socket.create_connection((self.host, self.port), timeout=self.timeout) as connection, connection.makefile('rb') as stream:
+			station = self._login(connection, stream)
+			peer = self.peer or station
+			self._send(connection, f"{P2P_SID}\r")
+			offers = [] if peer == CMS_PROMPT_STATION else self.outbox.for_station(peer)
+			awaiting = []  # Sent, waiting for the peer to move on
+			while True:
+				# Our turn: propose what is left for the peer, or say we have nothing
+				if offers:
+					awaiting = self._offer(connection, stream, offers)
+					offers = []
+				else:
+					self._send(connection, "FF\r")
+				# The peer's turn; taking it tells us our transfers arrived
+				line = read_line(stream)
+				self._log_debug(f"Received: <{line}>")
+				if awaiting:
+					self.outbox.mark_sent(awaiting)
+					self.sent.extend(entry.mid for entry in awaiting)
+					awaiting = []
+				if line.startswith("FQ"):
+					return peer
+				if line.startswith("FF"):
+					self._send(connection, "FQ\r")
+					return peer
+				self._receive(connection, stream, line, peer)
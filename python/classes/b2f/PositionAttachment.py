@@ -0,0 +1,105 @@
+#!/usr/bin/env python
+'''Locations sent as a separate XML attachment (e.g. pos.xml) rather than in the form's variables'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import re
+import xml.etree.ElementTree as ET
+from classes.forms.WinlinkForm import FORM_ATTACHMENT_PREFIX, DEFAULT_POSITION_ROLE
+
+# Attachment names that hold a position: pos.xml, position.xml, gps.xml, location.xml, alone or after
+# a prefix such as Damage_Assessment_pos.xml
+POSITION_ATTACHMENT = re.compile(r"(?:^|[_\-. ])(?:pos|position|gps|location)\.xml$", re.IGNORECASE)
+
+# Element and attribute names, compared without case or namespace
+LATITUDE_NAMES = ["latitude", "lat"]
+LONGITUDE_NAMES = ["longitude", "lon", "long", "lng"]
+ROLE_NAMES = ["role", "type", "name"]  # Say which location this is; the first one found wins
+
+# A coordinate in decimal degrees, with the hemisphere as a sign or a letter before or after it
+COORDINATE = re.compile(r"^\s*(?P<before>[NSEW])?\s*(?P<value>[-+]?\d+(?:\.\d+)?)\s*°?\s*(?P<after>[NSEW])?\s*$", re.IGNORECASE)
+
+
+def _local_name(tag):
+	return tag.rsplit("}", 1)[-1].lower()
+
+
+def parse_coordinate(text, axis):
+	"""Decimal degrees from text such as -122.12, 122.12W, or N37.42; None if it isn't one.
+	axis is "latitude" or "longitude", and decides which hemisphere letters are allowed."""
+	match = COORDINATE.match(text or "")
+	if not match or (match.group("before") and match.group("after")):
+		return None
+	value = float(match.group("value"))
+	hemisphere = (match.group("before") or match.group("after") or "").upper()
+	if hemisphere:
+		if hemisphere not in ("NS" if axis == "latitude" else "EW") or value < 0:
+			return None
+		if hemisphere in "SW":
+			value = -value
+	return value
+
+
+class PositionAttachment:
+	"""A position attachment.  Any element with lat and lon attributes (as in GPX), or with latitude and
+	longitude child elements, is a location.  Its role comes from a role, type, or name attribute or
+	child, or else it is the form's own location."""
+
+	def __init__(self, filename, data, enable_debug=False):
+		self.enable_debug = enable_debug
+		self.filename = filename
+		self.data = data  # Raw XML bytes
+		self.locations = []  # (role, latitude, longitude), in document order
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@staticmethod
+	def is_position_attachment(filename):
+		"""True if the attachment name marks it as a position file; form attachments never are."""
+		return filename is not None and not filename.startswith(FORM_ATTACHMENT_PREFIX) and POSITION_ATTACHMENT.search(filename) is not None
+
+	def parse(self):
+		"""Find the locations in the XML.  Raises ValueError if the XML is malformed."""
+		try:
+			root = ET.fromstring(self.data)
+		except ET.ParseError as e:
+			raise ValueError(f"Position attachment {self.filename}: malformed XML: {e}")
+		self.locations = []
+		for element in root.iter():
+			values = {_local_name(name): value for name, value in element.attrib.items()}
+			values.update({_local_name(child.tag): (child.text or "").strip() for child in element if len(child) == 0})
+			latitude = next((parse_coordinate(values[n], "latitude") for n in LATITUDE_NAMES if n in values), None)
+			longitude = next((parse_coordinate(values[n], "longitude") for n in LONGITUDE_NAMES if n in values), None)
+			if latitude is None or longitude is None:
+				continue
+			if not (-90.0 <= latitude <= 90.0 and -180.0 <= longitude <= 180.0) or (latitude == 0.0 and longitude == 0.0):
+				self._log_debug(f"{self.filename}: ignoring implausible position {latitude}, {longitude}")
+				continue
+			role = next((values[n] for n in ROLE_NAMES if values.get(n)), DEFAULT_POSITION_ROLE)
+			self.locations.append((role.strip().lower().replace(" ", "_"), latitude, longitude))
+		self._log_debug(f"{self.filename}: {len(self.locations)} positions")
+
+	def positions(self):
+		"""Locations in the attachment as a list of (role, latitude, longitude), one per role.  When a role
+		has several, as the points of a track do, the last is the latest."""
+		latest = {}
+		for role, latitude, longitude in self.locations:
+			latest[role] = (latitude, longitude)
+		return [(role, latitude, longitude) for role, (latitude, longitude) in latest.items()]
@@ -0,0 +1,73 @@
+#!/usr/bin/env python
+'''Which station a message came from: callsign, SSID, and the path it arrived by'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+# Operators run several stations under one callsign, told apart by SSID (W1ABC-5 in the car, W1ABC-10
+# at home), and one station may reach us over HF one hour and the mesh the next.  How finely to tell them apart:
+PATH = "path"  # Callsign, SSID, and path: W1ABC-5 via HF and W1ABC-5 via VHF are two stations
+SSID = "ssid"  # Callsign and SSID
+CALLSIGN = "callsign"  # Callsign alone: every SSID and path collapses into one station
+MODES = (PATH, SSID, CALLSIGN)
+
+_mode = PATH
+
+
+def set_mode(mode):
+	global _mode
+	if mode not in MODES:
+		raise ValueError(f"Unknown station identity {mode}; expected one of {', '.join(MODES)}")
+	_mode = mode
+
+
+def current_mode():
+	return _mode
+
+
+def parse_address(address):
+	"""(callsign, SSID or None) from an address such as w1abc-5@winlink.org."""
+	callsign, _, ssid = (address or "").strip().upper().split("@")[0].partition("-")
+	return callsign, ssid or None
+
+
+class Station:
+	"""One station's identity, as fine as the mode asks for.  Stations with the same identity are equal."""
+
+	def __init__(self, callsign, ssid=None, path=None):
+		self.callsign = callsign
+		self.ssid = ssid
+		self.path = path
+
+	@property
+	def key(self):
+		return (self.callsign, self.ssid or "", self.path or "")
+
+	@property
+	def label(self):
+		"""e.g. W1ABC-5 via HF"""
+		name = f"{self.callsign}-{self.ssid}" if self.ssid else self.callsign
+		return f"{name} via {self.path}" if self.path else name
+
+	def __eq__(self, other):
+		return isinstance(other, Station) and self.key == other.key
+
+	def __hash__(self):
+		return hash(self.key)
+
+	def __repr__(self):
+		return f"Station({self.label})"
+
+
+def identify(message, mode=None):
+	"""The station that sent message, or None if it has no sender."""
+	mode = mode or _mode
+	callsign, ssid = parse_address(message.sender)
+	if not callsign:
+		return None
+	path = (message.source or {}).get("path")
+	return Station(callsign, ssid if mode != CALLSIGN else None, path.upper() if path and mode == PATH else None)
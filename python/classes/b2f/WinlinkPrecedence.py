@@ -0,0 +1,61 @@
+#!/usr/bin/env python
+'''Extracts message precedence and form severity, and the symbology they imply'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+
+ROUTINE = "Routine"
+PRIORITY = "Priority"
+IMMEDIATE = "Immediate"
+FLASH = "Flash"
+
+# Rank used to compare urgency; higher is more urgent
+PRECEDENCE_RANK = {ROUTINE: 0, PRIORITY: 1, IMMEDIATE: 2, FLASH: 3}
+
+# Winlink Express marks precedence with a subject prefix such as "//WL2K P/"
+SUBJECT_PRECEDENCE = re.compile(r"^//WL2K\s+([RPOZ])/\s*", re.IGNORECASE)
+SUBJECT_PRECEDENCE_CODES = {"R": ROUTINE, "P": PRIORITY, "O": IMMEDIATE, "Z": FLASH}
+
+# Form variables that carry a severity or priority, and how their values rank
+SEVERITY_VARIABLES = ["precedence", "priority", "severity", "urgency"]
+SEVERITY_RANK = {
+	"routine": 0, "low": 0, "minor": 0, "r": 0,
+	"priority": 1, "medium": 1, "moderate": 1, "p": 1,
+	"immediate": 2, "high": 2, "severe": 2, "urgent": 2, "emergency": 2, "o": 2,
+	"flash": 3, "critical": 3, "extreme": 3, "z": 3,
+}
+
+# Marker styling (simplestyle-spec) for each rank
+SYMBOLOGY = {
+	0: {"marker-color": "#3388ff", "marker-size": "small"},
+	1: {"marker-color": "#ffaa00", "marker-size": "medium"},
+	2: {"marker-color": "#ff3300", "marker-size": "large"},
+	3: {"marker-color": "#cc00cc", "marker-size": "large"},
+}
+
+
+def subject_precedence(subject):
+	"""The precedence named by a subject prefix, or None if there isn't one."""
+	match = SUBJECT_PRECEDENCE.match(subject or "")
+	return SUBJECT_PRECEDENCE_CODES[match.group(1).upper()] if match else None
+
+
+def form_severity(variables):
+	"""The first recognized severity value in a form's variables, as (variable value, rank), or (None, None)."""
+	for name in SEVERITY_VARIABLES:
+		value = (variables.get(name) or "").strip()
+		rank = SEVERITY_RANK.get(value.lower())
+		if rank is not None:
+			return value, rank
+	return None, None
+
+
+def symbology(rank):
+	"""Marker styling for an urgency rank."""
+	return dict(SYMBOLOGY.get(rank, SYMBOLOGY[0]))
@@ -0,0 +1,123 @@
+#!/usr/bin/env python
+'''Parses the assorted timestamp formats found in Winlink messages and forms'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+from datetime import datetime, timezone
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+# Zone assumed for timestamps that don't say which zone they are in.  The B2 Date: header
+# is always UTC; form fields are usually whatever the operator's laptop clock said.
+DEFAULT_TIMEZONE = "UTC"
+
+# Zone used when rendering timestamps for people to read
+DISPLAY_TIMEZONE = "America/Los_Angeles"
+
+# Tried in order.  Formats without a zone are interpreted in the default timezone.
+TIMESTAMP_FORMATS = [
+	"%Y/%m/%d %H:%M",  # B2 Date: header
+	"%Y/%m/%d %H:%M:%S",
+	"%Y-%m-%d %H:%M",
+	"%Y-%m-%d %H:%M:%S",
+	"%Y-%m-%dT%H:%M:%S",
+	"%Y-%m-%dT%H:%M:%S%z",
+	"%Y%m%d%H%M%S",  # Form <submission_datetime>
+	"%Y%m%d%H%M",
+	"%m/%d/%Y %H:%M",
+	"%m/%d/%Y %H:%M:%S",
+	"%m/%d/%Y %I:%M %p",
+	"%m/%d/%Y %I:%M:%S %p",
+	"%d %b %Y %H:%M",
+	"%d %b %Y %H%M",
+]
+
+# Suffixes operators append to say which zone a time is in
+UTC_SUFFIXES = ["UTC", "GMT", "Z"]
+LOCAL_SUFFIXES = ["LOCAL", "L"]
+
+
+_missing_zones = set()  # Built-in zones the system has no data for, each warned about once
+
+
+def _zone(name):
+	"""The zone called name.  Zones set from the command line have been checked; a built-in one the
+	system has no data for (Windows without the tzdata package) is taken as UTC, with a warning."""
+	if name == "UTC":
+		return timezone.utc
+	try:
+		return ZoneInfo(name)
+	except ZoneInfoNotFoundError:
+		if name not in _missing_zones:
+			_missing_zones.add(name)
+			logging.getLogger(__name__).warning(f"No time zone data for {name}, so times are shown in UTC; install the tzdata package")
+		return timezone.utc
+
+
+def check_timezone(name):
+	"""name, if it is a zone the system knows, such as America/Los_Angeles.  Raises ValueError if not."""
+	try:
+		ZoneInfo(name)
+	except (ZoneInfoNotFoundError, ValueError):
+		raise ValueError(f"Unknown time zone <{name}>")
+	return name
+
+
+def set_default_timezone(name):
+	"""Set the zone assumed for timestamps without one.  Raises ValueError for unknown zones."""
+	global DEFAULT_TIMEZONE
+	DEFAULT_TIMEZONE = check_timezone(name)
+
+
+def set_display_timezone(name):
+	"""Set the zone used by to_local().  Raises ValueError for unknown zones."""
+	global DISPLAY_TIMEZONE
+	DISPLAY_TIMEZONE = check_timezone(name)
+
+
+def parse_timestamp(text, default_timezone=None):
+	"""Parse text into an aware datetime in UTC, or return None if no format matches."""
+	if text is None:
+		return None
+	text = " ".join(text.split())  # Drops stray \r and collapses runs of whitespace
+	if not text:
+		return None
+
+	zone = _zone(default_timezone or DEFAULT_TIMEZONE)
+	upper = text.upper()
+	for suffix in UTC_SUFFIXES:
+		if upper.endswith(suffix):
+			zone = timezone.utc
+			text = text[:-len(suffix)].strip()
+			break
+	else:
+		for suffix in LOCAL_SUFFIXES:
+			if upper.endswith(" " + suffix):
+				zone = _zone(DISPLAY_TIMEZONE)
+				text = text[:-len(suffix)].strip()
+				break
+
+	for format in TIMESTAMP_FORMATS:
+		try:
+			parsed = datetime.strptime(text, format)
+		except ValueError:
+			continue
+		if parsed.tzinfo is None:
+			parsed = parsed.replace(tzinfo=zone)
+		return parsed.astimezone(timezone.utc)
+	return None
+
+
+def to_local(when):
+	"""Render an aware datetime in the display timezone."""
+	return when.astimezone(_zone(DISPLAY_TIMEZONE))
+
+
+def utc_now():
+	"""The current time as an aware UTC datetime."""
+	return datetime.now(timezone.utc)
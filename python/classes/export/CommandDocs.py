@@ -0,0 +1,360 @@
+#!/usr/bin/env python
+'''Shell completion scripts and a man page, generated from an argparse command tree'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import argparse
+import datetime
+
+BASH = "bash"
+ZSH = "zsh"
+FISH = "fish"
+SHELLS = (BASH, ZSH, FISH)
+
+# What an option's or argument's value completes to
+FILES = "files"
+FOLDERS = "folders"
+FILE_METAVARS = {"FILE", "file", "path"}
+FOLDER_METAVARS = {"FOLDER", "MAILBOX"}
+FILE_DESTS = {"output", "config", "manifest", "paths", "files", "file", "csv"}  # Values named by their dest alone
+FOLDER_DESTS = {"mailbox"}
+
+
+class Argument:
+	"""One option or positional argument of a command, as the docs and completions need it."""
+
+	def __init__(self, parser, action):
+		self.flags = list(action.option_strings)
+		self.dest = action.dest
+		self.metavar = action.metavar if isinstance(action.metavar, str) else (action.dest.upper() if self.flags else action.dest)
+		self.takes_value = action.nargs != 0
+		self.repeats = isinstance(action, argparse._AppendAction) or action.nargs in ("*", "+")
+		self.optional = bool(self.flags) or action.nargs in ("?", "*")
+		self.choices = [str(c) for c in action.choices] if action.choices else None
+		self.help = parser._get_formatter()._expand_help(action) if action.help else ""
+		if self.choices:
+			self.completes = self.choices
+		elif self.metavar in FILE_METAVARS or self.dest in FILE_DESTS:
+			self.completes = FILES
+		elif self.metavar in FOLDER_METAVARS or self.dest in FOLDER_DESTS:
+			self.completes = FOLDERS
+		else:
+			self.completes = None
+
+	@property
+	def value_text(self):
+		return "{" + ",".join(self.choices) + "}" if self.choices else self.metavar
+
+
+class Command:
+	"""A parser's options, positional arguments, and subcommands."""
+
+	def __init__(self, name, parser, help_text=""):
+		self.name = name
+		self.help = help_text or parser.description or ""
+		self.usage = parser.format_usage().strip()
+		self.options = []
+		self.positionals = []
+		self.commands = []
+		for action in parser._actions:
+			if action.help == argparse.SUPPRESS:
+				continue
+			if isinstance(action, argparse._SubParsersAction):
+				helps = {choice.dest: choice.help for choice in action._choices_actions}
+				self.commands.extend(Command(n, p, helps.get(n, "")) for n, p in action.choices.items())
+			elif action.option_strings:
+				self.options.append(Argument(parser, action))
+			else:
+				self.positionals.append(Argument(parser, action))
+
+	def value_options(self):
+		return [o for o in self.options if o.takes_value]
+
+
+def command_tree(parser):
+	"""The Command for parser and everything under it."""
+	return Command(parser.prog, parser)
+
+
+def _words(items):
+	return " ".join(items)
+
+
+# bash
+
+def _bash_values(argument):
+	if argument.completes == FILES:
+		return 'COMPREPLY=($(compgen -f -- "$cur"))'
+	if argument.completes == FOLDERS:
+		return 'COMPREPLY=($(compgen -d -- "$cur"))'
+	if argument.completes:
+		return f'COMPREPLY=($(compgen -W "{_words(argument.completes)}" -- "$cur"))'
+	return "COMPREPLY=()"
+
+
+def _bash_level(command, indent, last):
+	"""Completion of one command's words: an option's value, an option, then a positional argument."""
+	lines = []
+	values = command.value_options()
+	if values:
+		lines.append(f'{indent}case "$prev" in')
+		for option in values:
+			lines.append(f"{indent}\t{'|'.join(option.flags)}) {_bash_values(option)}; return ;;")
+		lines.append(f"{indent}esac")
+	lines.append(f'{indent}if [[ "$cur" == -* ]]; then')
+	lines.append(f'{indent}\tCOMPREPLY=($(compgen -W "{_words(f for o in command.options for f in o.flags)}" -- "$cur"))')
+	lines.append(f"{indent}\treturn")
+	lines.append(f"{indent}fi")
+	lines.append(f"{indent}{last}")
+	return lines
+
+
+def bash_completion(tree, names):
+	"""A bash completion script for tree, registered for each of names."""
+	function = f"_{tree.name.replace('.', '_').replace('-', '_')}"
+	global_values = [f for o in tree.value_options() for f in o.flags]
+	lines = [
+		f"# bash completion for {tree.name}, generated by {tree.name} completion bash",
+		f"{function}()",
+		"{",
+		'\tlocal cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" command="" i',
+		"\tfor ((i = 1; i < COMP_CWORD; i++)); do",
+		'\t\tcase "${COMP_WORDS[i]}" in',
+	]
+	if global_values:
+		lines.append(f"\t\t\t{'|'.join(global_values)}) ((i++)) ;;")
+	lines += [
+		"\t\t\t-*) ;;",
+		'\t\t\t*) command="${COMP_WORDS[i]}"; break ;;',
+		"\t\tesac",
+		"\tdone",
+		'\tcase "$command" in',
+		'\t\t"")',
+	]
+	lines += _bash_level(tree, "\t\t\t", f'COMPREPLY=($(compgen -W "{_words(c.name for c in tree.commands)}" -- "$cur"))')
+	lines.append("\t\t\t;;")
+	for command in tree.commands:
+		words = [w for p in command.positionals if isinstance(p.completes, list) for w in p.completes]
+		files = any(p.completes == FILES for p in command.positionals)
+		if words and files:
+			last = f'COMPREPLY=($(compgen -W "{_words(words)}" -- "$cur") $(compgen -f -- "$cur"))'
+		elif words:
+			last = f'COMPREPLY=($(compgen -W "{_words(words)}" -- "$cur"))'
+		elif files:
+			last = 'COMPREPLY=($(compgen -f -- "$cur"))'
+		else:
+			last = "COMPREPLY=()"
+		lines.append(f"\t\t{command.name})")
+		lines += _bash_level(command, "\t\t\t", last)
+		lines.append("\t\t\t;;")
+	lines += [
+		"\tesac",
+		"}",
+		f"complete -o filenames -F {function} {' '.join(names)}",
+	]
+	return "\n".join(lines) + "\n"
+
+
+# zsh
+
+def _zsh_quote(text):
+	return "'" + text.replace("'", "'\\''") + "'"
+
+
+def _zsh_action(argument):
+	if argument.completes == FILES:
+		return "_files"
+	if argument.completes == FOLDERS:
+		return "_files -/"
+	if argument.completes:
+		return "(" + " ".join(argument.completes) + ")"
+	return " "
+
+
+def _zsh_help(text):
+	return text.replace("\\", "\\\\").replace("[", "\\[").replace("]", "\\]")
+
+
+def _zsh_specs(command):
+	"""_arguments specs for a command's options and positional arguments."""
+	specs = []
+	for option in command.options:
+		value = f":{option.metavar.replace(':', '')}:{_zsh_action(option)}" if option.takes_value else ""
+		for flag in option.flags:
+			exclusive = "" if option.repeats else "(" + " ".join(option.flags) + ")"
+			specs.append(_zsh_quote(f"{'*' if option.repeats else exclusive}{flag}[{_zsh_help(option.help)}]{value}"))
+	for positional in command.positionals:
+		if positional.repeats:
+			prefix = "*:"
+		else:
+			prefix = "::" if positional.optional else ":"
+		specs.append(_zsh_quote(f"{prefix}{positional.metavar.replace(':', '')}:{_zsh_action(positional)}"))
+	return specs
+
+
+def zsh_completion(tree, names):
+	"""A zsh completion function for tree, for the names given; install it as _<name> in $fpath."""
+	function = f"_{tree.name.replace('.', '_').replace('-', '_')}"
+	lines = [
+		f"#compdef {' '.join(names)}",
+		f"# zsh completion for {tree.name}, generated by {tree.name} completion zsh",
+		"",
+		f"{function}() {{",
+		'\tlocal curcontext="$curcontext" state line',
+		"\ttypeset -A opt_args",
+		"\t_arguments -C \\",
+	]
+	lines += [f"\t\t{spec} \\" for spec in _zsh_specs(tree)]
+	lines += [
+		"\t\t'1:command:->command' \\",
+		"\t\t'*::argument:->argument'",
+		"\tcase $state in",
+		"\t\tcommand)",
+		"\t\t\tlocal -a commands",
+		"\t\t\tcommands=(",
+	]
+	lines += [f"\t\t\t\t{_zsh_quote(c.name + ':' + c.help)}" for c in tree.commands]
+	lines += [
+		"\t\t\t)",
+		f"\t\t\t_describe -t commands {_zsh_quote(tree.name + ' command')} commands",
+		"\t\t\t;;",
+		"\t\targument)",
+		"\t\t\tcase $line[1] in",
+	]
+	for command in tree.commands:
+		specs = _zsh_specs(command)
+		lines.append(f"\t\t\t\t{command.name})")
+		lines.append("\t\t\t\t\t_arguments \\")
+		lines += [f"\t\t\t\t\t\t{spec} \\" for spec in specs[:-1]]
+		lines.append(f"\t\t\t\t\t\t{specs[-1]}" if specs else "\t\t\t\t\t\t''")
+		lines.append("\t\t\t\t\t;;")
+	lines += [
+		"\t\t\tesac",
+		"\t\t\t;;",
+		"\tesac",
+		"}",
+		"",
+		f'{function} "$@"',
+	]
+	return "\n".join(lines) + "\n"
+
+
+# fish
+
+def _fish_quote(text):
+	return "'" + text.replace("\\", "\\\\").replace("'", "\\'") + "'"
+
+
+def _fish_flags(option):
+	flags = []
+	for flag in option.flags:
+		if flag.startswith("--"):
+			flags.append(f"-l {flag[2:]}")
+		elif len(flag) == 2:
+			flags.append(f"-s {flag[1]}")
+		else:
+			flags.append(f"-o {flag[1:]}")
+	return " ".join(flags)
+
+
+def _fish_value(argument):
+	if not argument.takes_value:
+		return ""
+	if argument.completes == FILES or argument.completes == FOLDERS:
+		return " -r -F"
+	if argument.completes:
+		return f" -x -a {_fish_quote(' '.join(argument.completes))}"
+	return " -x"
+
+
+def fish_completion(tree, names):
+	"""fish completions for tree, for each of names."""
+	lines = [f"# fish completion for {tree.name}, generated by {tree.name} completion fish"]
+	commands = " ".join(c.name for c in tree.commands)
+	lines.append(f"for command in {' '.join(names)}")
+	lines.append("\tcomplete -c $command -f")
+	top = "-n __fish_use_subcommand"
+	for option in tree.options:
+		lines.append(f"\tcomplete -c $command {top} {_fish_flags(option)}{_fish_value(option)} -d {_fish_quote(option.help)}")
+	for command in tree.commands:
+		lines.append(f"\tcomplete -c $command {top} -a {command.name} -d {_fish_quote(command.help)}")
+	for command in tree.commands:
+		seen = f"-n {_fish_quote('__fish_seen_subcommand_from ' + command.name)}"
+		for option in command.options:
+			lines.append(f"\tcomplete -c $command {seen} {_fish_flags(option)}{_fish_value(option)} -d {_fish_quote(option.help)}")
+		for positional in command.positionals:
+			if positional.completes in (FILES, FOLDERS):
+				lines.append(f"\tcomplete -c $command {seen} -F")
+			elif positional.completes:
+				lines.append(f"\tcomplete -c $command {seen} -a {_fish_quote(' '.join(positional.completes))} -d {_fish_quote(positional.help)}")
+	lines.append("end")
+	return "\n".join(lines) + "\n"
+
+
+def completion(tree, shell, names=None):
+	"""The completion script for shell, one of SHELLS."""
+	names = names or [tree.name]
+	if shell == BASH:
+		return bash_completion(tree, names)
+	if shell == ZSH:
+		return zsh_completion(tree, names)
+	if shell == FISH:
+		return fish_completion(tree, names)
+	raise ValueError(f"Unknown shell {shell}; expected one of {', '.join(SHELLS)}")
+
+
+# man
+
+def _roff(text):
+	"""text escaped for roff, so backslashes, hyphens, and leading dots print as they are."""
+	text = text.replace("\\", "\\e").replace("-", "\\-")
+	return "\\&" + text if text.startswith((".", "'")) else text
+
+
+def _man_arguments(command):
+	lines = []
+	for argument in command.positionals + command.options:
+		if argument.flags:
+			name = ", ".join(f"\\fB{_roff(f)}\\fR" + (f" \\fI{_roff(argument.value_text)}\\fR" if argument.takes_value else "") for f in argument.flags)
+		else:
+			name = f"\\fI{_roff(argument.value_text)}\\fR"
+		lines += [".TP", name, _roff(argument.help or "")]
+	return lines
+
+
+def man_page(tree, section=1, sections=(), date=None):
+	"""A man page for tree in roff: name, synopsis, description, global options, and every command with
+	its options.  sections adds (heading, [(term, text)]) pairs after the commands, e.g. the exit status."""
+	date = date or datetime.date.today()
+	name = tree.name
+	lines = [
+		f'.TH {_roff(name.upper())} {section} "{date.isoformat()}" "{_roff(name)}" "User Commands"',
+		".SH NAME",
+		f"{_roff(name)} \\- {_roff(tree.help.strip().splitlines()[0] if tree.help.strip() else name)}",
+		".SH SYNOPSIS",
+		f"\\fB{_roff(name)}\\fR [\\fIglobal options\\fR] \\fIcommand\\fR [\\fIoptions\\fR] [\\fIarguments\\fR]",
+		".SH DESCRIPTION",
+		_roff(f"Global options go before the command.  {name} COMMAND -h prints a command's options."),
+		".SH GLOBAL OPTIONS",
+	]
+	lines += _man_arguments(tree)
+	lines.append(".SH COMMANDS")
+	for command in tree.commands:
+		usage = command.usage.removeprefix("usage: ")
+		lines += [
+			f".SS {_roff(command.name)}",
+			_roff(command.help[:1].upper() + command.help[1:] + ("." if command.help and not command.help.endswith(".") else "")),
+			".PP",
+			_roff(" ".join(usage.split())),  # Joined, since argparse wraps usage to the terminal it runs in
+		]
+		lines += _man_arguments(command)
+	for heading, entries in sections:
+		lines.append(f".SH {_roff(heading.upper())}")
+		for term, text in entries:
+			lines += [".TP", f"\\fB{_roff(str(term))}\\fR", _roff(text)]
+	return "\n".join(lines) + "\n"
@@ -0,0 +1,143 @@
+#!/usr/bin/env python
+'''Everything produced for an exercise or incident in one ZIP: map layers, a message log, the ICS-309, and attachments'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import csv
+import datetime
+import io
+import json
+import os
+import tempfile
+import zipfile
+from classes.geo import Annotations
+from classes.store import BlobStore
+from classes.export import MapExport
+from classes.export import PeriodReport
+from classes.forms import Redaction
+from classes.geo import WeatherHazards
+from classes.store.OperationalPeriods import OperationalPeriod
+from classes.store.WinlinkMailMessage import safe_filename
+from classes.b2f.WinlinkTime import utc_now
+
+BUNDLE_NAME_DEFAULT = "Exercise"
+MANIFEST_NAME = "manifest.json"
+GEOJSON_NAME = "messages.geojson"
+KMZ_NAME = "messages.kmz"
+CSV_NAME = "messages.csv"
+LOG_NAME = "ics309.pdf"
+RANGE_PADDING = datetime.timedelta(minutes=1)  # Around the messages when the range isn't given
+ATTACHMENTS_FOLDER = "attachments"  # attachments/<MID>/<file name>
+CSV_FIELDS = ["mid", "date", "sender", "recipient", "subject", "precedence", "form_type", "latitude", "longitude", "source_path", "gateway", "attachments"]
+
+
+def select(messages, start=None, end=None):
+	"""The messages dated within [start, end), in export order; None leaves that end open."""
+	return MapExport.ordered(m for m in messages if (start is None or m.date >= start) and (end is None or m.date < end))
+
+
+def message_rows(messages):
+	"""One row of the message log per message, with the redaction policy applied to subjects."""
+	rows = []
+	for message in messages:
+		located = MapExport.message_features(message)
+		longitude, latitude = located[0]["geometry"]["coordinates"][:2] if located else (None, None)
+		source = message.source or {}
+		rows.append({
+			"mid": message.mid,
+			"date": message.date.isoformat(),
+			"sender": message.sender,
+			"recipient": message.recipient,
+			"subject": Redaction.scrub_text(message.subject),
+			"precedence": message.precedence,
+			"form_type": message.form.form_type if message.form is not None else None,
+			"latitude": latitude,
+			"longitude": longitude,
+			"source_path": source.get("path"),
+			"gateway": source.get("gateway"),
+			"attachments": "; ".join(a.filename for a in message.attachments),
+		})
+	return rows
+
+
+def message_csv(messages):
+	"""The message log as CSV text, one row per message."""
+	rows = io.StringIO(newline='')
+	writer = csv.DictWriter(rows, fieldnames=CSV_FIELDS)
+	writer.writeheader()
+	writer.writerows(message_rows(messages))
+	return rows.getvalue()
+
+
+def attachment_data(attachment):
+	"""The attachment's bytes: loaded with the message, or fetched from the blob store it went to.
+	None if it is in neither."""
+	if attachment.data is not None:
+		return attachment.data
+	if attachment.location is None:
+		return None
+	try:
+		return BlobStore.fetch(attachment.location)
+	except (KeyError, OSError, ValueError):
+		return None
+
+
+def write_bundle(output, messages, name=BUNDLE_NAME_DEFAULT, start=None, end=None, incident=None, operator=None, net=None, static_map=None):
+	"""Write the ZIP to output, a file name or a binary stream (which need not be seekable, so the
+	bundle can go straight to stdout or a socket).  messages should already be narrowed to the time
+	range; start defaults to the first of them and end to just after the last.  Returns the manifest, which is also
+	the bundle's first entry: what it holds, and the attachments left out."""
+	if start is None:
+		start = messages[0].date if messages else (end or utc_now()) - RANGE_PADDING
+	if end is None:
+		end = (messages[-1].date if messages else start) + RANGE_PADDING  # The end is exclusive
+	annotations = Annotations.features()
+	hazards = WeatherHazards.features(messages, end)
+	features = hazards + [f for m in messages for f in MapExport.message_features(m)] + annotations
+	manifest = {
+		"name": name,
+		"start": start.isoformat(),
+		"end": end.isoformat(),
+		"generated": utc_now().isoformat(timespec="seconds"),
+		"messages": len(messages),
+		"features": len(features),
+		"annotations": len(annotations),
+		"hazards": len(hazards),
+		"files": [GEOJSON_NAME, KMZ_NAME, CSV_NAME, LOG_NAME],
+		"attachments": [],
+		"withheld": [],  # {"mid", "file", "reason"} for attachments not included
+	}
+	attachments = []
+	for message in messages:
+		for attachment in message.attachments:
+			entry = {"mid": message.mid, "file": attachment.filename}
+			form_type = message.form.form_type if message.form is not None else None
+			if Redaction.redacts_form(form_type):
+				manifest["withheld"].append(dict(entry, reason=f"{form_type} forms are redacted"))
+				continue
+			data = attachment_data(attachment)
+			if data is None:
+				manifest["withheld"].append(dict(entry, reason="not saved" if attachment.location is None else f"could not be read from {attachment.location}"))
+				continue
+			path = f"{ATTACHMENTS_FOLDER}/{safe_filename(message.mid or 'unknown')}/{safe_filename(attachment.filename)}"
+			attachments.append((path, data))
+			manifest["attachments"].append(dict(entry, path=path, size=len(data)))
+
+	with zipfile.ZipFile(output, 'w', zipfile.ZIP_DEFLATED) as bundle:
+		bundle.writestr(MANIFEST_NAME, json.dumps(manifest, indent=4))
+		bundle.writestr(GEOJSON_NAME, json.dumps(MapExport.feature_collection(features, name), indent=4))
+		bundle.writestr(KMZ_NAME, MapExport.kmz_document(name, [(name, features)]))
+		bundle.writestr(CSV_NAME, message_csv(messages))
+		# The PDF writer wants a file name
+		with tempfile.TemporaryDirectory() as folder:
+			log_filename = os.path.join(folder, LOG_NAME)
+			PeriodReport.write_period_report(log_filename, name, OperationalPeriod(name, start, end), messages, incident, operator, net, static_map)
+			bundle.write(log_filename, LOG_NAME)
+		for path, data in attachments:
+			bundle.writestr(path, data)
+	return manifest
@@ -0,0 +1,84 @@
+#!/usr/bin/env python
+'''Which exported features changed since the last run, so incremental exports only move the differences'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import hashlib
+import json
+import logging
+import os
+
+STATE_FILE_SUFFIX = ".state.json"  # Beside the job's output, or named after the job in the working directory
+
+
+def feature_digest(feature):
+	"""A hash of everything the feature says, so any change to its position or properties shows."""
+	return hashlib.sha256(json.dumps(feature, sort_keys=True, default=str).encode("utf-8")).hexdigest()
+
+
+def removal(feature_id):
+	"""The feature sent in place of one that is no longer exported, e.g. after a redaction change."""
+	return {"type": "Feature", "id": feature_id, "geometry": None, "properties": {"removed": True}}
+
+
+class ChangeTracker:
+	"""Remembers the digest of each feature a job last delivered, by feature id, in a JSON state file."""
+
+	def __init__(self, filename, enable_debug=False):
+		self.filename = filename
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def load(self):
+		"""{feature id: digest} as of the last delivery; empty before the first, or if the file is unreadable,
+		in which case the next run sends everything."""
+		try:
+			with open(self.filename, 'r') as f:
+				state = json.load(f)
+		except FileNotFoundError:
+			return {}
+		except (OSError, ValueError) as e:
+			self.logger.error(f"Ignoring export state {self.filename}; everything will be sent again: {e}")
+			return {}
+		return state.get("features", {}) if isinstance(state, dict) else {}
+
+	def changes(self, features):
+		"""(features that are new or changed, ids no longer exported, the state once they are delivered)."""
+		previous = self.load()
+		state = {}
+		changed = []
+		for feature in features:
+			digest = feature_digest(feature)
+			state[str(feature["id"])] = digest
+			if previous.get(str(feature["id"])) != digest:
+				changed.append(feature)
+		removed = sorted(set(previous) - set(state))
+		self._log_debug(f"{len(changed)} of {len(features)} features changed and {len(removed)} removed since {self.filename}")
+		return changed, removed, state
+
+	def save(self, state):
+		"""Record state as delivered.  Written in one step, so an interrupted run leaves the old state."""
+		folder = os.path.dirname(self.filename)
+		if folder:
+			os.makedirs(folder, exist_ok=True)
+		temporary = f"{self.filename}.tmp"
+		with open(temporary, 'w') as f:
+			json.dump({"features": state}, f)
+		os.replace(temporary, self.filename)
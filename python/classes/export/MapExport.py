@@ -0,0 +1,258 @@
+#!/usr/bin/env python
+'''Turns decoded messages into GeoJSON features and KML placemarks'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import hashlib
+import io
+import json
+import zipfile
+from xml.sax.saxutils import escape
+from classes.b2f import WinlinkPrecedence
+from classes.forms import FormStyles
+from classes.geo import Units
+from classes.forms import Redaction
+from classes.b2f import StationIdentity
+from classes.store import StationRoster
+from classes.export import Translation
+from classes.geo import Elevation
+from classes.b2f.WinlinkTime import to_local
+from classes.b2f.B2Message import REPORTER_ROLE
+
+FEATURE_ID_LENGTH = 16  # Hex digits of the hash kept in a feature id
+KMZ_DATE_TIME = (1980, 1, 1, 0, 0, 0)  # Stamped on doc.kml instead of the time of export
+
+
+def has_position(message):
+	"""True if the message reported a position (0, 0 means none was given).  Bulletins never count."""
+	if message.is_bulletin():
+		return False
+	return message.position["latitude"] != 0.0 or message.position["longitude"] != 0.0
+
+
+def message_properties(message):
+	"""The properties a message contributes to its map feature."""
+	source = message.source or {}
+	form_type = message.form.form_type if message.form is not None else None
+	station = StationIdentity.identify(message)
+	properties = {
+		"mid": message.mid,
+		"callsign": message.sender,
+		"station": station.label if station is not None else None,  # Per --stations: e.g. W1ABC-5 via HF
+		"recipient": message.recipient,
+		"subject": Redaction.scrub_text(message.subject),
+		"date": message.date.isoformat(),
+		"local_date": to_local(message.date).isoformat(),
+		"clock_offset_seconds": message.clock_offset.total_seconds() if message.clock_offset is not None else None,
+		"form_type": form_type,
+		"precedence": message.precedence,
+		"severity": message.severity,
+		"urgency": message.urgency(),
+		"source_path": source.get("path"),
+		"gateway": source.get("gateway"),
+		"in_reply_to": message.in_reply_to,
+		"thread": message.thread,
+	}
+	properties.update(StationRoster.properties(message.sender))  # Who the station is, from --roster
+	if Elevation.current_model() is not None and has_position(message):
+		# The reporter's ground elevation, from --dem; each feature has that of its own point
+		properties["elevation_m"] = Elevation.elevation_m(message.position["latitude"], message.position["longitude"])
+	if message.conversation:
+		properties["conversation"] = [
+			{"mid": m.mid, "sender": m.sender, "date": m.date.isoformat(), "subject": Redaction.scrub_text(m.subject)}
+			for m in message.conversation
+		]
+	if message.form is not None:
+		fields = Redaction.scrub_fields(form_type, {name: value for name, value in message.form.variables.items() if value})
+		properties["fields"] = {name: Units.render(value) for name, value in fields.items()}
+	properties.update(WinlinkPrecedence.symbology(properties["urgency"]))
+	style = FormStyles.form_style(message.form)  # Per form type, from styles.json
+	popup = style.pop(FormStyles.POPUP, None)
+	properties.update(style)
+	if popup is not None:
+		values = {k: v for k, v in properties.items() if k not in ("fields", "conversation")}
+		values.update(properties.get("fields", {}))  # Already redacted and in the chosen units
+		properties[FormStyles.DESCRIPTION] = FormStyles.render_popup(popup, values)
+	return properties
+
+
+def feature_id(mid, role):
+	"""The id of the feature placing role in message mid: a hash of the two, so the same message gets
+	the same ids on every export, wherever it is run."""
+	return hashlib.sha256(f"{mid}/{role}".encode("utf-8")).hexdigest()[:FEATURE_ID_LENGTH]
+
+
+def export_order(message):
+	"""Sort key putting messages in date order, ties broken by MID, so every export lists them alike."""
+	return (message.date, message.mid or message.message_id or "")
+
+
+def ordered(messages):
+	return sorted(messages, key=export_order)
+
+
+def exported_positions(message):
+	"""The message's (role, latitude, longitude) positions that may leave this system: only the
+	reporter's for forms whose locations are redacted."""
+	positions = message.positions()
+	if message.form is not None and not Redaction.exports_form_positions(message.form.form_type):
+		positions = [p for p in positions if p[0] == REPORTER_ROLE]
+	return positions
+
+
+def message_features(message):
+	"""One GeoJSON Point feature per location in the message (reporter, incident, ...).  Each has
+	an id from feature_id, and features from the same message share their mid and related ids.
+	A probable duplicate has none; the message it repeats is already on the map."""
+	if message.is_bulletin() or message.duplicate_of is not None:
+		return []
+	positions = exported_positions(message)
+	mid = message.mid or message.message_id
+	ids = [feature_id(mid, role) for role, _, _ in positions]
+	features = []
+	for (role, latitude, longitude), own_id in zip(positions, ids):
+		properties = message_properties(message)
+		properties["role"] = role
+		properties["related"] = [i for i in ids if i != own_id]
+		if Elevation.current_model() is not None:
+			properties["elevation_m"] = Elevation.elevation_m(latitude, longitude)
+		features.append({
+			"type": "Feature",
+			"id": own_id,
+			"geometry": {"type": "Point", "coordinates": [longitude, latitude]},
+			"properties": properties,
+		})
+	return features
+
+
+def feature_collection(features, name=None):
+	"""Wrap features in a FeatureCollection, optionally named, in z_order."""
+	collection = {"type": "FeatureCollection", "features": sorted(features, key=FormStyles.z_order)}
+	if name is not None:
+		collection["name"] = name
+	return collection
+
+
+def write_geojson(filename, collection):
+	with open(filename, 'w') as f:
+		json.dump(collection, f, indent=4)
+
+
+def _kml_coordinates(points):
+	return " ".join(f"{lon},{lat}" for lon, lat in (p[:2] for p in points))
+
+
+def _kml_geometry(geometry):
+	"""KML for a GeoJSON Point, LineString, or Polygon (its outer ring)."""
+	if geometry["type"] == "LineString":
+		return f"<LineString><coordinates>{_kml_coordinates(geometry['coordinates'])}</coordinates></LineString>"
+	if geometry["type"] == "Polygon":
+		ring = _kml_coordinates(geometry["coordinates"][0])
+		return f"<Polygon><outerBoundaryIs><LinearRing><coordinates>{ring}</coordinates></LinearRing></outerBoundaryIs></Polygon>"
+	return f"<Point><coordinates>{_kml_coordinates([geometry['coordinates']])}</coordinates></Point>"
+
+
+def _kml_color(color, opacity=1.0):
+	"""A #rrggbb color as KML's aabbggrr."""
+	color = color.lstrip("#")
+	return f"{round(opacity * 255):02x}{color[4:6]}{color[2:4]}{color[0:2]}"
+
+
+def _kml_style(properties):
+	"""A KML Style from a feature's simplestyle properties and icon, or "" if it has none."""
+	styles = []
+	if properties.get("marker-color") or properties.get(FormStyles.ICON):
+		color = f"<color>{_kml_color(properties['marker-color'])}</color>" if properties.get("marker-color") else ""
+		icon = f"<Icon><href>{escape(properties[FormStyles.ICON])}</href></Icon>" if properties.get(FormStyles.ICON) else ""
+		styles.append(f"<IconStyle>{color}{icon}</IconStyle>")
+	if properties.get("stroke"):
+		styles.append(f"<LineStyle><color>{_kml_color(properties['stroke'], properties.get('stroke-opacity', 1.0))}</color></LineStyle>")
+	if properties.get("fill"):
+		styles.append(f"<PolyStyle><color>{_kml_color(properties['fill'], properties.get('fill-opacity', 0.6))}</color></PolyStyle>")
+	return f"<Style>{''.join(styles)}</Style>" if styles else ""
+
+
+def _popup_text(name, value):
+	if isinstance(value, list):
+		return ", ".join(str(Translation.value_text(name, v)) for v in value)
+	return str(Translation.value_text(name, value))
+
+
+def _placemark(feature):
+	properties = feature["properties"]
+	if properties.get(FormStyles.DESCRIPTION) is not None:
+		# The form type's popup template, as text
+		description = escape(str(properties[FormStyles.DESCRIPTION])).replace("\n", "<br/>")
+	else:
+		flat = {k: v for k, v in properties.items() if k not in ("fields", "conversation")}
+		flat.update(properties.get("fields", {}))
+		rows = "".join(
+			f"<tr><td>{escape(str(Translation.label(k)))}</td><td>{escape(_popup_text(k, v))}</td></tr>"
+			for k, v in flat.items() if v is not None and not k.startswith(("marker-", "stroke", "fill")) and k not in (FormStyles.ICON, FormStyles.LAYER, FormStyles.Z_ORDER)
+		)
+		for entry in properties.get("conversation", []):
+			marker = "&#9656; " if entry["mid"] == properties.get("mid") else ""
+			rows += f"<tr><td>{marker}{escape(entry['date'][:16])}</td><td>{escape(str(entry['sender']))}: {escape(str(entry['subject']))}</td></tr>"
+		description = f"<table>{rows}</table>"
+	lines = [
+		"<Placemark>",
+		f"<name>{escape(str(properties.get('callsign') or properties.get('mid') or properties.get('label') or ''))}</name>",
+		f"<description><![CDATA[{description}]]></description>",
+	]
+	if properties.get("date"):
+		lines.append(f"<TimeStamp><when>{escape(properties['date'])}</when></TimeStamp>")
+	style = _kml_style(properties)
+	if style:
+		lines.append(style)
+	lines.append(_kml_geometry(feature["geometry"]))
+	lines.append("</Placemark>")
+	return "\n".join(lines)
+
+
+def kml_document(name, folders):
+	"""A KML document with one folder per (folder name, features) pair.  Features with a layer go in a
+	folder of that name inside their own."""
+	lines = [
+		'<?xml version="1.0" encoding="UTF-8"?>',
+		'<kml xmlns="http://www.opengis.net/kml/2.2">',
+		"<Document>",
+		f"<name>{escape(name)}</name>",
+	]
+	for folder_name, features in folders:
+		lines.append("<Folder>")
+		lines.append(f"<name>{escape(folder_name)}</name>")
+		features = sorted(features, key=FormStyles.z_order)
+		lines.extend(_placemark(feature) for feature in features if not feature["properties"].get(FormStyles.LAYER))
+		layers = {}
+		for feature in features:
+			if feature["properties"].get(FormStyles.LAYER):
+				layers.setdefault(str(feature["properties"][FormStyles.LAYER]), []).append(feature)
+		for layer, layer_features in layers.items():
+			lines.append(f"<Folder>\n<name>{escape(layer)}</name>")
+			lines.extend(_placemark(feature) for feature in layer_features)
+			lines.append("</Folder>")
+		lines.append("</Folder>")
+	lines.append("</Document>")
+	lines.append("</kml>")
+	return "\n".join(lines) + "\n"
+
+
+def kmz_document(name, folders):
+	"""kml_document zipped as a KMZ.  Its timestamp is fixed, so the same features give the same bytes."""
+	entry = zipfile.ZipInfo("doc.kml", KMZ_DATE_TIME)
+	entry.compress_type = zipfile.ZIP_DEFLATED
+	buffer = io.BytesIO()
+	with zipfile.ZipFile(buffer, 'w') as kmz:
+		kmz.writestr(entry, kml_document(name, folders))
+	return buffer.getvalue()
+
+
+def write_kml(filename, name, folders):
+	"""Write a KML document with one folder per (folder name, features) pair."""
+	with open(filename, 'w', encoding='utf-8') as f:
+		f.write(kml_document(name, folders))
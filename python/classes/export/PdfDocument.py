@@ -0,0 +1,160 @@
+#!/usr/bin/env python
+'''A small PDF writer: text in the standard Helvetica fonts, lines, boxes, circles, and RGB images'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import zlib
+
+LETTER = (612, 792)  # Points
+LANDSCAPE_LETTER = (792, 612)
+TABLOID = (792, 1224)
+A4 = (595, 842)
+A3 = (842, 1191)
+
+FONTS = {"regular": "Helvetica", "bold": "Helvetica-Bold"}
+AVERAGE_CHARACTER_WIDTH = 0.52  # Of the font size, for Helvetica; close enough for fitting text
+BEZIER_CIRCLE = 0.5523  # Control point distance for a quarter circle
+
+
+def _escape(text):
+	"""A PDF string literal body for text, in the WinAnsi encoding of the standard fonts."""
+	data = str(text).encode("cp1252", errors="replace").decode("latin-1")
+	return data.replace("\\", "\\\\").replace("(", "\\(").replace(")", "\\)").replace("\r", "").replace("\n", " ")
+
+
+def hex_color(color):
+	"""#rrggbb as an (r, g, b) tuple of 0..1 values."""
+	color = color.lstrip("#")
+	return tuple(int(color[i:i + 2], 16) / 255 for i in (0, 2, 4))
+
+
+class PdfDocument:
+	"""Pages are drawn with the origin at the bottom left, in points."""
+
+	def __init__(self, size=LETTER):
+		self.size = size
+		self.pages = []  # Content stream operators per page
+		self.images = []  # (width, height, RGB bytes)
+		self.page_images = []  # Image numbers used on each page
+		self.opacities = set()  # Fill opacities used, each a graphics state named /GS<percent>
+
+	@property
+	def width(self):
+		return self.size[0]
+
+	@property
+	def height(self):
+		return self.size[1]
+
+	def add_page(self):
+		self.pages.append([])
+		self.page_images.append(set())
+
+	def _draw(self, operators):
+		self.pages[-1].append(operators)
+
+	def text(self, x, y, text, size=10, bold=False, color=(0, 0, 0)):
+		font = "F2" if bold else "F1"
+		self._draw(f"{color[0]:.3f} {color[1]:.3f} {color[2]:.3f} rg BT /{font} {size} Tf {x:.2f} {y:.2f} Td ({_escape(text)}) Tj ET")
+
+	@staticmethod
+	def fit(text, width, size=10):
+		"""text cut down, with an ellipsis, to roughly fit width points."""
+		text = " ".join(str(text or "").split())
+		limit = max(int(width / (size * AVERAGE_CHARACTER_WIDTH)), 1)
+		return text if len(text) <= limit else text[:max(limit - 3, 0)] + "..."
+
+	def line(self, x1, y1, x2, y2, width=0.5, color=(0, 0, 0)):
+		self._draw(f"{color[0]:.3f} {color[1]:.3f} {color[2]:.3f} RG {width} w {x1:.2f} {y1:.2f} m {x2:.2f} {y2:.2f} l S")
+
+	def rect(self, x, y, w, h, width=0.5, color=(0, 0, 0), fill=None):
+		operators = f"{color[0]:.3f} {color[1]:.3f} {color[2]:.3f} RG {width} w "
+		if fill is not None:
+			operators += f"{fill[0]:.3f} {fill[1]:.3f} {fill[2]:.3f} rg {x:.2f} {y:.2f} {w:.2f} {h:.2f} re B"
+		else:
+			operators += f"{x:.2f} {y:.2f} {w:.2f} {h:.2f} re S"
+		self._draw(operators)
+
+	def polyline(self, points, width=0.5, color=(0, 0, 0), closed=False):
+		"""Stroke a path through [(x, y)], back to the start if closed."""
+		path = " ".join(f"{x:.2f} {y:.2f} {'m' if i == 0 else 'l'}" for i, (x, y) in enumerate(points))
+		self._draw(f"{color[0]:.3f} {color[1]:.3f} {color[2]:.3f} RG {width} w 1 j {path} {'s' if closed else 'S'}")
+
+	def polygon(self, points, fill, opacity=1.0, width=0.5, color=(0, 0, 0)):
+		"""Fill a closed path through [(x, y)], see-through by opacity, and stroke its outline."""
+		percent = round(opacity * 100)
+		self.opacities.add(percent)
+		path = " ".join(f"{x:.2f} {y:.2f} {'m' if i == 0 else 'l'}" for i, (x, y) in enumerate(points))
+		self._draw(f"q /GS{percent} gs {fill[0]:.3f} {fill[1]:.3f} {fill[2]:.3f} rg {path} h f Q")
+		self.polyline(points, width, color, closed=True)
+
+	def clip(self, x, y, w, h):
+		"""Draw only inside the box until end_clip()."""
+		self._draw(f"q {x:.2f} {y:.2f} {w:.2f} {h:.2f} re W n")
+
+	def end_clip(self):
+		self._draw("Q")
+
+	def circle(self, x, y, r, fill=(0, 0, 0), color=(0, 0, 0), width=0.5):
+		k = BEZIER_CIRCLE * r
+		path = (
+			f"{x + r:.2f} {y:.2f} m "
+			f"{x + r:.2f} {y + k:.2f} {x + k:.2f} {y + r:.2f} {x:.2f} {y + r:.2f} c "
+			f"{x - k:.2f} {y + r:.2f} {x - r:.2f} {y + k:.2f} {x - r:.2f} {y:.2f} c "
+			f"{x - r:.2f} {y - k:.2f} {x - k:.2f} {y - r:.2f} {x:.2f} {y - r:.2f} c "
+			f"{x + k:.2f} {y - r:.2f} {x + r:.2f} {y - k:.2f} {x + r:.2f} {y:.2f} c"
+		)
+		self._draw(f"{color[0]:.3f} {color[1]:.3f} {color[2]:.3f} RG {fill[0]:.3f} {fill[1]:.3f} {fill[2]:.3f} rg {width} w {path} B")
+
+	def image(self, x, y, w, h, pixels, pixel_width, pixel_height):
+		"""Draw RGB pixel data (3 bytes per pixel, rows top to bottom) scaled into the box."""
+		self.images.append((pixel_width, pixel_height, bytes(pixels)))
+		number = len(self.images)
+		self.page_images[-1].add(number)
+		self._draw(f"q {w:.2f} 0 0 {h:.2f} {x:.2f} {y:.2f} cm /Im{number} Do Q")
+
+	def save(self, filename):
+		with open(filename, 'wb') as f:
+			f.write(self.to_bytes())
+
+	def to_bytes(self):
+		"""The whole document as PDF bytes."""
+		objects = []  # Bodies, numbered from 1
+
+		def add(body):
+			objects.append(body)
+			return len(objects)
+
+		catalog = add(None)
+		pages = add(None)
+		fonts = {key: add(f"<< /Type /Font /Subtype /Type1 /BaseFont /{name} /Encoding /WinAnsiEncoding >>".encode()) for key, name in FONTS.items()}
+		images = []
+		for w, h, pixels in self.images:
+			data = zlib.compress(pixels)
+			images.append(add(f"<< /Type /XObject /Subtype /Image /Width {w} /Height {h} /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length {len(data)} >>\nstream\n".encode() + data + b"\nendstream"))
+		kids = []
+		for operators, used in zip(self.pages, self.page_images):
+			data = zlib.compress("\n".join(operators).encode("latin-1"))
+			content = add(f"<< /Length {len(data)} /Filter /FlateDecode >>\nstream\n".encode() + data + b"\nendstream")
+			xobjects = " ".join(f"/Im{n} {images[n - 1]} 0 R" for n in sorted(used))
+			states = " ".join(f"/GS{percent} << /Type /ExtGState /ca {percent / 100} >>" for percent in sorted(self.opacities))
+			resources = f"<< /Font << /F1 {fonts['regular']} 0 R /F2 {fonts['bold']} 0 R >> /XObject << {xobjects} >> /ExtGState << {states} >> >>"
+			kids.append(add(f"<< /Type /Page /Parent {pages} 0 R /MediaBox [0 0 {self.width} {self.height}] /Resources {resources} /Contents {content} 0 R >>".encode()))
+		objects[catalog - 1] = f"<< /Type /Catalog /Pages {pages} 0 R >>".encode()
+		objects[pages - 1] = f"<< /Type /Pages /Kids [{' '.join(f'{k} 0 R' for k in kids)}] /Count {len(kids)} >>".encode()
+
+		output = bytearray(b"%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+		offsets = []
+		for number, body in enumerate(objects, 1):
+			offsets.append(len(output))
+			output += f"{number} 0 obj\n".encode() + body + b"\nendobj\n"
+		xref = len(output)
+		output += f"xref\n0 {len(objects) + 1}\n0000000000 65535 f \n".encode()
+		output += "".join(f"{offset:010d} 00000 n \n" for offset in offsets).encode()
+		output += f"trailer\n<< /Size {len(objects) + 1} /Root {catalog} 0 R >>\nstartxref\n{xref}\n%%EOF\n".encode()
+		return bytes(output)
@@ -0,0 +1,177 @@
+#!/usr/bin/env python
+'''PDF documentation for an operational period: a map, the reports received, and an ICS-309 log'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import math
+from classes.forms import FormStyles
+from classes.export import MapExport
+from classes.forms import Redaction
+from classes.export.PdfDocument import PdfDocument, LANDSCAPE_LETTER, hex_color
+from classes.export.Translation import tr, value_text
+from classes.b2f.WinlinkTime import to_local, utc_now
+
+MARGIN = 40
+ROW_HEIGHT = 14
+FONT_SIZE = 8
+GRID_COLOR = (0.8, 0.8, 0.8)
+MIN_MAP_SPAN_DEGREES = 0.02  # So a single station still gets a sensible map around it
+MAP_PADDING = 0.1  # Fraction of the span added around the features
+
+
+def _mercator_y(latitude):
+	latitude = max(min(latitude, 85.0), -85.0)
+	return math.degrees(math.log(math.tan(math.radians(45.0 + latitude / 2.0))))
+
+
+def _local(when):
+	return to_local(when).strftime("%Y-%m-%d %H:%M")
+
+
+class _Projection:
+	"""Fits longitude/latitude into a box on the page, Web Mercator, keeping the aspect ratio."""
+
+	def __init__(self, features, x, y, w, h):
+		points = [f["geometry"]["coordinates"] for f in features]
+		lons = [p[0] for p in points]
+		ys = [_mercator_y(p[1]) for p in points]
+		west, east = min(lons), max(lons)
+		south, north = min(ys), max(ys)
+		span = max(east - west, north - south, MIN_MAP_SPAN_DEGREES) * (1 + 2 * MAP_PADDING)
+		self.scale = min(w, h) / span
+		self.center = ((west + east) / 2, (south + north) / 2)
+		self.box = (x, y, w, h)
+
+	def __call__(self, longitude, latitude):
+		x, y, w, h = self.box
+		return x + w / 2 + (longitude - self.center[0]) * self.scale, y + h / 2 + (_mercator_y(latitude) - self.center[1]) * self.scale
+
+	def bounds(self):
+		"""West, south, east, north of the box in degrees."""
+		x, y, w, h = self.box
+		west = self.center[0] - w / 2 / self.scale
+		east = self.center[0] + w / 2 / self.scale
+		south = math.degrees(2 * math.atan(math.exp(math.radians(self.center[1] - h / 2 / self.scale)))) - 90.0
+		north = math.degrees(2 * math.atan(math.exp(math.radians(self.center[1] + h / 2 / self.scale)))) - 90.0
+		return west, south, east, north
+
+
+def _grid_step(span):
+	"""A round graticule spacing giving a handful of lines across span degrees."""
+	for step in (0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10, 20):
+		if span / step <= 8:
+			return step
+	return 30
+
+
+def draw_map(pdf, features, x, y, w, h):
+	"""Plot features as colored dots with callsign labels over a latitude/longitude grid."""
+	pdf.rect(x, y, w, h)
+	if not features:
+		pdf.text(x + w / 2 - 60, y + h / 2, tr("No positions reported"), size=10)
+		return
+	project = _Projection(features, x, y, w, h)
+	west, south, east, north = project.bounds()
+	step = _grid_step(max(east - west, north - south))
+	longitude = math.ceil(west / step) * step
+	while longitude < east:
+		px, _ = project(longitude, south)
+		pdf.line(px, y, px, y + h, width=0.3, color=GRID_COLOR)
+		pdf.text(px + 2, y + 3, f"{longitude:.3f}", size=6, color=(0.5, 0.5, 0.5))
+		longitude += step
+	latitude = math.ceil(south / step) * step
+	while latitude < north:
+		_, py = project(west, latitude)
+		pdf.line(x, py, x + w, py, width=0.3, color=GRID_COLOR)
+		pdf.text(x + 3, py + 2, f"{latitude:.3f}", size=6, color=(0.5, 0.5, 0.5))
+		latitude += step
+	for feature in sorted(features, key=FormStyles.z_order):
+		px, py = project(*feature["geometry"]["coordinates"][:2])
+		properties = feature["properties"]
+		pdf.circle(px, py, 3.5, fill=hex_color(properties.get("marker-color", "#3388ff")))
+		pdf.text(px + 5, py - 3, properties.get("callsign") or properties.get("mid") or "", size=7)
+
+
+def _draw_table(pdf, start_page, columns, rows):
+	"""Lay out rows under column headings, starting a new page via start_page() whenever one fills.
+	start_page returns the y coordinate where the table may begin on its page."""
+	def headings(top):
+		left = MARGIN
+		for title, width in columns:
+			pdf.text(left + 2, top - 10, title, size=FONT_SIZE, bold=True)
+			left += width
+		pdf.line(MARGIN, top - ROW_HEIGHT, MARGIN + sum(w for _, w in columns), top - ROW_HEIGHT)
+		return top - ROW_HEIGHT
+
+	top = headings(start_page())
+	for row in rows:
+		if top - ROW_HEIGHT < MARGIN:
+			top = headings(start_page())
+		left = MARGIN
+		for (_, width), value in zip(columns, row):
+			pdf.text(left + 2, top - 10, pdf.fit(value, width - 4, FONT_SIZE), size=FONT_SIZE)
+			left += width
+		pdf.line(MARGIN, top - ROW_HEIGHT, MARGIN + sum(w for _, w in columns), top - ROW_HEIGHT, width=0.2, color=GRID_COLOR)
+		top -= ROW_HEIGHT
+
+
+def write_period_report(filename, name, period, messages, incident=None, operator=None, net=None, static_map=None):
+	"""Write the PDF for one operational period.  period is an OperationalPeriod, or None for
+	messages outside every period.  With a StaticMap the map is drawn over its tiles."""
+	messages = sorted(messages, key=lambda m: m.date)
+	span = tr("{start} to {end}", start=_local(period.start), end=_local(period.end)) if period is not None else tr("Outside every operational period")
+	pdf = PdfDocument(LANDSCAPE_LETTER)
+	top = pdf.height - MARGIN
+
+	pdf.add_page()
+	pdf.text(MARGIN, top - 16, f"{incident + ': ' if incident else ''}{name}", size=16, bold=True)
+	pdf.text(MARGIN, top - 32, span, size=10)
+	features = [f for m in messages for f in MapExport.message_features(m)]
+	map_box = (MARGIN, MARGIN + 20, pdf.width - 2 * MARGIN, top - 48 - MARGIN - 20)
+	if static_map is not None:
+		raster = static_map.render(features, int(map_box[2]), int(map_box[3]))
+		pdf.image(*map_box, raster.pixels, raster.width, raster.height)
+		pdf.rect(*map_box)
+	else:
+		draw_map(pdf, features, *map_box)
+	pdf.text(MARGIN, MARGIN + 6, tr("{messages} messages, {features} located features.  Generated {time}.", messages=len(messages), features=len(features), time=_local(utc_now())), size=FONT_SIZE)
+
+	def report_page():
+		pdf.add_page()
+		pdf.text(MARGIN, top - 14, tr("Reports received: {name}", name=name), size=12, bold=True)
+		return top - 24
+
+	report_columns = [(tr("Time"), 80), (tr("From"), 70), (tr("To"), 70), (tr("Precedence"), 60), (tr("Form"), 100), (tr("Subject"), 232), (tr("Position"), 100)]
+	report_rows = []
+	for message in messages:
+		located = MapExport.message_features(message)
+		position = ""
+		if located:
+			longitude, latitude = located[0]["geometry"]["coordinates"][:2]
+			position = f"{latitude:.4f}, {longitude:.4f}"
+		report_rows.append([
+			_local(message.date), message.sender, message.recipient, value_text("precedence", message.precedence),
+			message.form.form_type if message.form is not None else "", Redaction.scrub_text(message.subject), position,
+		])
+	_draw_table(pdf, report_page, report_columns, report_rows)
+
+	def log_page():
+		pdf.add_page()
+		pdf.text(MARGIN, top - 14, tr("COMMUNICATIONS LOG (ICS 309)"), size=12, bold=True)
+		pdf.text(MARGIN, top - 30, tr("1. Incident Name: {incident}", incident=incident or ""), size=FONT_SIZE)
+		pdf.text(MARGIN + 260, top - 30, tr("2. Operational Period: {span}", span=span), size=FONT_SIZE)
+		pdf.text(MARGIN, top - 42, tr("3. Radio Net Name or Position/Tactical Call: {net}", net=net or ""), size=FONT_SIZE)
+		pdf.text(MARGIN + 260, top - 42, tr("4. Radio Operator (Name, Call Sign): {operator}", operator=operator or ""), size=FONT_SIZE)
+		pdf.text(MARGIN, top - 58, tr("5. Record of messages:"), size=FONT_SIZE, bold=True)
+		return top - 64
+
+	log_columns = [(tr("Time"), 90), (tr("From (Call Sign/ID)"), 120), (tr("To (Call Sign/ID)"), 120), (tr("Msg # (MID)"), 100), (tr("Message"), 282)]
+	log_rows = [[_local(m.date), m.sender, m.recipient, m.mid or "", Redaction.scrub_text(m.subject)] for m in messages]
+	_draw_table(pdf, log_page, log_columns, log_rows)
+	pdf.text(MARGIN, MARGIN - 20, tr("6. Prepared by: {operator}    Date/Time Prepared: {time}", operator=operator or "", time=_local(utc_now())), size=FONT_SIZE)
+	pdf.save(filename)
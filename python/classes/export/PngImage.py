@@ -0,0 +1,249 @@
+#!/usr/bin/env python
+'''An RGB raster that can be read from and written to PNG, with a few drawing primitives'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import struct
+import zlib
+
+PNG_SIGNATURE = b"\x89PNG\r\n\x1a\n"
+
+# PNG color types and the number of samples per pixel in each
+GRAY, RGB, PALETTE, GRAY_ALPHA, RGBA = 0, 2, 3, 4, 6
+SAMPLES = {GRAY: 1, RGB: 3, PALETTE: 1, GRAY_ALPHA: 2, RGBA: 4}
+
+# 5x7 glyphs for map labels, seven rows of five columns each, "#" for ink
+GLYPHS = {
+	"A": [" ### ", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"],
+	"B": ["#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "],
+	"C": [" ### ", "#   #", "#    ", "#    ", "#    ", "#   #", " ### "],
+	"D": ["#### ", "#   #", "#   #", "#   #", "#   #", "#   #", "#### "],
+	"E": ["#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#####"],
+	"F": ["#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "],
+	"G": [" ### ", "#   #", "#    ", "# ###", "#   #", "#   #", " ####"],
+	"H": ["#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"],
+	"I": [" ### ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "],
+	"J": ["  ###", "   # ", "   # ", "   # ", "   # ", "#  # ", " ##  "],
+	"K": ["#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"],
+	"L": ["#    ", "#    ", "#    ", "#    ", "#    ", "#    ", "#####"],
+	"M": ["#   #", "## ##", "# # #", "#   #", "#   #", "#   #", "#   #"],
+	"N": ["#   #", "##  #", "# # #", "#  ##", "#   #", "#   #", "#   #"],
+	"O": [" ### ", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "],
+	"P": ["#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "],
+	"Q": [" ### ", "#   #", "#   #", "#   #", "# # #", "#  # ", " ## #"],
+	"R": ["#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"],
+	"S": [" ####", "#    ", "#    ", " ### ", "    #", "    #", "#### "],
+	"T": ["#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "],
+	"U": ["#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "],
+	"V": ["#   #", "#   #", "#   #", "#   #", "#   #", " # # ", "  #  "],
+	"W": ["#   #", "#   #", "#   #", "# # #", "# # #", "# # #", " # # "],
+	"X": ["#   #", "#   #", " # # ", "  #  ", " # # ", "#   #", "#   #"],
+	"Y": ["#   #", "#   #", " # # ", "  #  ", "  #  ", "  #  ", "  #  "],
+	"Z": ["#####", "    #", "   # ", "  #  ", " #   ", "#    ", "#####"],
+	"0": [" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "],
+	"1": ["  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "],
+	"2": [" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"],
+	"3": ["#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "],
+	"4": ["   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "],
+	"5": ["#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "],
+	"6": ["  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "],
+	"7": ["#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "],
+	"8": [" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "],
+	"9": [" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "],
+	"-": ["     ", "     ", "     ", "#####", "     ", "     ", "     "],
+	"/": ["     ", "    #", "   # ", "  #  ", " #   ", "#    ", "     "],
+	".": ["     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "],
+	" ": ["     ", "     ", "     ", "     ", "     ", "     ", "     "],
+}
+GLYPH_WIDTH, GLYPH_HEIGHT = 5, 7
+
+
+def _paeth(a, b, c):
+	p = a + b - c
+	pa, pb, pc = abs(p - a), abs(p - b), abs(p - c)
+	if pa <= pb and pa <= pc:
+		return a
+	return b if pb <= pc else c
+
+
+class Raster:
+	"""width x height RGB pixels, rows top to bottom."""
+
+	def __init__(self, width, height, background=(255, 255, 255)):
+		self.width = width
+		self.height = height
+		self.pixels = bytearray(bytes(background) * (width * height))
+
+	@classmethod
+	def from_png(cls, data):
+		"""Decode a non-interlaced PNG, compositing any transparency over white.  Raises ValueError
+		for files it can't read."""
+		if not data.startswith(PNG_SIGNATURE):
+			raise ValueError("Not a PNG file")
+		position = len(PNG_SIGNATURE)
+		header, palette, transparency, compressed = None, b"", b"", bytearray()
+		while position + 8 <= len(data):
+			length, kind = struct.unpack(">I4s", data[position:position + 8])
+			chunk = data[position + 8:position + 8 + length]
+			position += 12 + length
+			if kind == b"IHDR":
+				if len(chunk) != 13:
+					raise ValueError("PNG header is truncated")
+				header = struct.unpack(">IIBBBBB", chunk)
+			elif kind == b"PLTE":
+				palette = chunk
+			elif kind == b"tRNS":
+				transparency = chunk
+			elif kind == b"IDAT":
+				compressed += chunk
+			elif kind == b"IEND":
+				break
+		if header is None:
+			raise ValueError("PNG has no IHDR chunk")
+		width, height, depth, color_type, _, _, interlace = header
+		if interlace or color_type not in SAMPLES or (depth != 8 and color_type != PALETTE):
+			raise ValueError(f"Unsupported PNG: color type {color_type}, bit depth {depth}, interlace {interlace}")
+		try:
+			raw = zlib.decompress(bytes(compressed))
+		except zlib.error as e:
+			raise ValueError(f"Corrupt PNG data: {e}")
+
+		samples = SAMPLES[color_type]
+		stride = (width * samples * depth + 7) // 8
+		pixel_bytes = max(samples * depth // 8, 1)
+		if len(raw) < height * (stride + 1):
+			raise ValueError(f"PNG is truncated: {len(raw)} bytes of image data, {height * (stride + 1)} expected")
+		rows = []
+		previous = bytearray(stride)
+		for y in range(height):
+			start = y * (stride + 1)
+			filter_type = raw[start]
+			line = bytearray(raw[start + 1:start + 1 + stride])
+			if filter_type == 1:
+				for i in range(pixel_bytes, stride):
+					line[i] = (line[i] + line[i - pixel_bytes]) & 0xFF
+			elif filter_type == 2:
+				for i in range(stride):
+					line[i] = (line[i] + previous[i]) & 0xFF
+			elif filter_type == 3:
+				for i in range(stride):
+					left = line[i - pixel_bytes] if i >= pixel_bytes else 0
+					line[i] = (line[i] + ((left + previous[i]) >> 1)) & 0xFF
+			elif filter_type == 4:
+				for i in range(stride):
+					left = line[i - pixel_bytes] if i >= pixel_bytes else 0
+					upper_left = previous[i - pixel_bytes] if i >= pixel_bytes else 0
+					line[i] = (line[i] + _paeth(left, previous[i], upper_left)) & 0xFF
+			elif filter_type != 0:
+				raise ValueError(f"Unknown PNG filter {filter_type}")
+			rows.append(line)
+			previous = line
+
+		raster = cls(width, height)
+		out = raster.pixels
+		for y, line in enumerate(rows):
+			if color_type == RGB:
+				out[y * width * 3:(y + 1) * width * 3] = line
+				continue
+			for x in range(width):
+				if color_type == PALETTE:
+					bit = x * depth
+					index = (line[bit // 8] >> (8 - depth - bit % 8)) & ((1 << depth) - 1)
+					if index * 3 + 3 > len(palette):
+						raise ValueError(f"PNG color {index} is not in its palette of {len(palette) // 3}")
+					r, g, b = palette[index * 3:index * 3 + 3]
+					alpha = transparency[index] if index < len(transparency) else 255
+				elif color_type == RGBA:
+					r, g, b, alpha = line[x * 4:x * 4 + 4]
+				elif color_type == GRAY:
+					r = g = b = line[x]
+					alpha = 255
+				else:
+					r = g = b = line[x * 2]
+					alpha = line[x * 2 + 1]
+				if alpha != 255:
+					r, g, b = ((c * alpha + 255 * (255 - alpha)) // 255 for c in (r, g, b))
+				offset = (y * width + x) * 3
+				out[offset:offset + 3] = bytes((r, g, b))
+		return raster
+
+	def to_png(self):
+		"""Encode as an 8-bit RGB PNG."""
+		def chunk(kind, body):
+			return struct.pack(">I", len(body)) + kind + body + struct.pack(">I", zlib.crc32(kind + body) & 0xFFFFFFFF)
+
+		stride = self.width * 3
+		raw = b"".join(b"\x00" + bytes(self.pixels[y * stride:(y + 1) * stride]) for y in range(self.height))
+		header = struct.pack(">IIBBBBB", self.width, self.height, 8, RGB, 0, 0, 0)
+		return PNG_SIGNATURE + chunk(b"IHDR", header) + chunk(b"IDAT", zlib.compress(raw, 9)) + chunk(b"IEND", b"")
+
+	def set(self, x, y, color):
+		if 0 <= x < self.width and 0 <= y < self.height:
+			offset = (y * self.width + x) * 3
+			self.pixels[offset:offset + 3] = bytes(color)
+
+	def paste(self, other, left, top):
+		"""Copy another raster in with its top left corner at (left, top), clipped to this one."""
+		for y in range(max(0, -top), min(other.height, self.height - top)):
+			x0 = max(0, -left)
+			x1 = min(other.width, self.width - left)
+			if x1 <= x0:
+				return
+			source = (y * other.width + x0) * 3
+			target = ((y + top) * self.width + left + x0) * 3
+			self.pixels[target:target + (x1 - x0) * 3] = other.pixels[source:source + (x1 - x0) * 3]
+
+	def line(self, x0, y0, x1, y1, color, width=1):
+		"""A straight line between two points, width pixels wide."""
+		steps = max(1, int(max(abs(x1 - x0), abs(y1 - y0))))
+		reach = range(-(width // 2), width - width // 2)
+		for i in range(steps + 1):
+			x = round(x0 + (x1 - x0) * i / steps)
+			y = round(y0 + (y1 - y0) * i / steps)
+			for dy in reach:
+				for dx in reach:
+					self.set(x + dx, y + dy, color)
+
+	def fill_polygon(self, points, color, opacity=1.0):
+		"""Fill the inside of a closed ring of (x, y) points, blended over what is there by opacity."""
+		if len(points) < 3:
+			return
+		edges = list(zip(points, points[1:] + points[:1]))
+		for y in range(max(0, int(min(p[1] for p in points))), min(self.height, int(max(p[1] for p in points)) + 1)):
+			row = y + 0.5
+			crossings = sorted(x0 + (row - y0) * (x1 - x0) / (y1 - y0) for (x0, y0), (x1, y1) in edges if (y0 <= row) != (y1 <= row))
+			for start, end in zip(crossings[::2], crossings[1::2]):
+				for x in range(max(0, round(start)), min(self.width, round(end))):
+					offset = (y * self.width + x) * 3
+					self.pixels[offset:offset + 3] = bytes(round(old * (1 - opacity) + new * opacity) for old, new in zip(self.pixels[offset:offset + 3], color))
+
+	def disc(self, cx, cy, radius, fill, outline=(0, 0, 0)):
+		"""A filled circle with a one-pixel outline."""
+		for y in range(int(cy - radius - 1), int(cy + radius + 2)):
+			for x in range(int(cx - radius - 1), int(cx + radius + 2)):
+				d = ((x - cx) ** 2 + (y - cy) ** 2) ** 0.5
+				if d <= radius - 1:
+					self.set(x, y, fill)
+				elif d <= radius:
+					self.set(x, y, outline)
+
+	def text(self, x, y, text, color=(0, 0, 0), halo=(255, 255, 255)):
+		"""Draw text in the built-in 5x7 font with its top left at (x, y); unknown characters are skipped.
+		A one-pixel halo keeps labels readable over the map."""
+		for pass_color, offsets in ((halo, [(-1, 0), (1, 0), (0, -1), (0, 1)]), (color, [(0, 0)])):
+			left = x
+			for character in str(text).upper():
+				glyph = GLYPHS.get(character)
+				if glyph is None:
+					continue
+				for row in range(GLYPH_HEIGHT):
+					for column in range(GLYPH_WIDTH):
+						if glyph[row][column] == "#":
+							for dx, dy in offsets:
+								self.set(left + column + dx, y + row + dy, pass_color)
+				left += GLYPH_WIDTH + 1
@@ -0,0 +1,280 @@
+#!/usr/bin/env python
+'''A one-page map for printing and pinning to the wall: title, legend, scale bar, north arrow, and title block'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import datetime
+import math
+from classes.forms import FormStyles
+from classes.geo import Geo
+from classes.b2f import WinlinkPrecedence
+from classes.geo.Annotations import ANNOTATION_ROLE
+from classes.geo.WeatherHazards import HAZARD_ROLE
+from classes.export.PdfDocument import PdfDocument, LETTER, TABLOID, A4, A3, hex_color
+from classes.export.StaticMap import view, world_pixel, pixel_latitude, meters_per_pixel, feature_bounds, geometry_points, TILE_SIZE
+from classes.export.Translation import tr
+from classes.b2f.WinlinkTime import to_local, utc_now
+
+PAPERS = {"letter": LETTER, "tabloid": TABLOID, "a4": A4, "a3": A3}  # Letter is ANSI A, tabloid ANSI B
+PAPER_DEFAULT = "letter"
+LANDSCAPE = "landscape"
+PORTRAIT = "portrait"
+ORIENTATIONS = (LANDSCAPE, PORTRAIT)
+
+MARGIN = 28  # Points; about 10 mm, inside what most printers can reach
+GAP = 10
+HEADER_HEIGHT = 40
+SIDEBAR_WIDTH = 170
+TITLE_BLOCK_HEIGHT = 150
+LEGEND_ROW = 13
+PRINT_DPI = 150  # Resolution of the tiles under the map
+POINTS_PER_METER = 72 / 0.0254
+BACKGROUND_COLOR = (0.95, 0.95, 0.95)
+GRID_COLOR = (0.8, 0.8, 0.8)
+LABEL_COLOR = (0.4, 0.4, 0.4)
+MARKER_RADIUS = 3.5
+LINE_WIDTH = 2
+DEFAULT_MARKER_COLOR = "#3388ff"
+DEFAULT_STROKE_COLOR = "#555555"
+RANK_NAMES = {rank: name for name, rank in WinlinkPrecedence.PRECEDENCE_RANK.items()}
+
+
+def local_time(when):
+	"""A time as printed: the display timezone, to the minute."""
+	return to_local(when).strftime("%Y-%m-%d %H:%M %Z")
+
+
+def _nice(value):
+	"""The largest 1, 2, or 5 times a power of ten that is at most value."""
+	power = 10 ** math.floor(math.log10(value))
+	return next(step * power for step in (5, 2, 1) if step * power <= value)
+
+
+def _measurement(properties):
+	"""How big an annotation is, as the legend shows it."""
+	if "area_km2" in properties:
+		return f"{properties['area_km2']:.2f} km² ({properties['area_mi2']:.2f} mi²)"
+	if "length_km" in properties:
+		return f"{properties['length_km']:.2f} km ({properties['length_mi']:.2f} mi)"
+	return properties.get("note") or ""
+
+
+class _MapFrame:
+	"""Web Mercator pixels, at PRINT_DPI, placed in a box on the page."""
+
+	def __init__(self, features, x, y, w, h, bbox=None, zoom=None):
+		self.box = (x, y, w, h)
+		self.scale = PRINT_DPI / 72
+		self.pixel_size = (int(w * self.scale), int(h * self.scale))
+		self.bbox = bbox or (feature_bounds(features) if features else None)
+		self.zoom, self.left, self.top = view(features, self.pixel_size[0], self.pixel_size[1], self.bbox, zoom)
+
+	def __call__(self, longitude, latitude):
+		x, y, w, h = self.box
+		px, py = world_pixel(latitude, longitude, self.zoom)
+		return x + (px - self.left) / self.scale, y + h - (py - self.top) / self.scale
+
+	def bounds(self):
+		"""West, south, east, north of the box in degrees."""
+		world = TILE_SIZE * 2 ** self.zoom
+		west = self.left / world * 360.0 - 180.0
+		east = (self.left + self.pixel_size[0]) / world * 360.0 - 180.0
+		return west, pixel_latitude(self.top + self.pixel_size[1], self.zoom), east, pixel_latitude(self.top, self.zoom)
+
+	def meters_per_point(self):
+		"""Ground distance across one point of paper at the center of the map."""
+		latitude = pixel_latitude(self.top + self.pixel_size[1] / 2, self.zoom)
+		return meters_per_pixel(latitude, self.zoom) * self.scale
+
+
+def _draw_graticule(pdf, frame):
+	"""Latitude/longitude lines, for maps printed without tiles."""
+	x, y, w, h = frame.box
+	pdf.rect(x, y, w, h, fill=BACKGROUND_COLOR)
+	west, south, east, north = frame.bounds()
+	step = next((s for s in (0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10, 20, 30) if max(east - west, north - south) / s <= 8), 45)
+	longitude = math.ceil(west / step) * step
+	while longitude < east:
+		px, _ = frame(longitude, south)
+		pdf.line(px, y, px, y + h, width=0.3, color=GRID_COLOR)
+		pdf.text(px + 2, y + h - 8, f"{longitude:.3f}", size=6, color=LABEL_COLOR)
+		longitude += step
+	latitude = math.ceil(south / step) * step
+	while latitude < north:
+		_, py = frame(west, latitude)
+		pdf.line(x, py, x + w, py, width=0.3, color=GRID_COLOR)
+		pdf.text(x + 3, py + 2, f"{latitude:.3f}", size=6, color=LABEL_COLOR)
+		latitude += step
+
+
+def _draw_features(pdf, frame, features):
+	"""Lines and areas first, then markers on top, each with its label, in z_order."""
+	features = sorted(features, key=FormStyles.z_order)
+	for feature in features:
+		if feature["geometry"]["type"] == "Point":
+			continue
+		properties = feature["properties"]
+		points = [frame(lon, lat) for lon, lat in geometry_points(feature["geometry"])]
+		stroke = hex_color(properties.get("stroke", DEFAULT_STROKE_COLOR))
+		if feature["geometry"]["type"] == "Polygon" and properties.get("fill") and properties.get("fill-opacity", 0.6) > 0:
+			pdf.polygon(points, hex_color(properties["fill"]), properties.get("fill-opacity", 0.6), width=LINE_WIDTH, color=stroke)
+		else:
+			pdf.polyline(points, width=LINE_WIDTH, color=stroke, closed=feature["geometry"]["type"] == "Polygon")
+		pdf.text(points[0][0] + 4, points[0][1] + 3, properties.get("label") or "", size=7, bold=True)
+	for feature in features:
+		if feature["geometry"]["type"] != "Point":
+			continue
+		properties = feature["properties"]
+		px, py = frame(*feature["geometry"]["coordinates"][:2])
+		pdf.circle(px, py, MARKER_RADIUS, fill=hex_color(properties.get("marker-color", DEFAULT_MARKER_COLOR)))
+		pdf.text(px + 5, py - 2.5, properties.get("callsign") or properties.get("mid") or properties.get("label") or "", size=6)
+
+
+def _draw_north_arrow(pdf, frame):
+	x, y, w, h = frame.box
+	ax, top = x + w - 20, y + h - 12
+	pdf.rect(ax - 9, top - 34, 18, 30, width=0.3, color=GRID_COLOR, fill=(1, 1, 1))
+	pdf.line(ax, top - 30, ax, top - 16, width=1.2)
+	pdf.polyline([(ax - 4, top - 20), (ax, top - 14), (ax + 4, top - 20)], width=1.2)
+	pdf.text(ax - 3.5, top - 12, "N", size=8, bold=True)
+
+
+def _draw_scale_bar(pdf, frame):
+	"""Metric and imperial bars in the bottom left corner of the map."""
+	x, y, w, h = frame.box
+	meters_per_point = frame.meters_per_point()
+	target = w / 5 * meters_per_point  # About a fifth of the map's width
+	meters = _nice(target)
+	if target >= Geo.KM_PER_MILE * 1000 / 2:
+		imperial_unit, imperial_meters = "mi", Geo.KM_PER_MILE * 1000
+	else:
+		imperial_unit, imperial_meters = "ft", 0.3048
+	imperial = _nice(target / imperial_meters)
+	bars = [
+		(meters / meters_per_point, f"{meters / 1000:g} km" if meters >= 1000 else f"{meters:g} m"),
+		(imperial * imperial_meters / meters_per_point, f"{imperial:g} {imperial_unit}"),
+	]
+	width = max(length for length, _ in bars)
+	pdf.rect(x + 6, y + 6, width + 50, 36, width=0.3, color=GRID_COLOR, fill=(1, 1, 1))
+	for row, (length, text) in enumerate(bars):
+		bar_y = y + 30 - row * 16
+		quarter = length / 4
+		for i in range(4):
+			pdf.rect(x + 12 + i * quarter, bar_y, quarter, 3, width=0.4, fill=(0, 0, 0) if i % 2 == 0 else (1, 1, 1))
+		pdf.text(x + 16 + length, bar_y - 1, text, size=7)
+
+
+def _legend_swatch(pdf, x, y, feature):
+	properties = feature["properties"]
+	kind = feature["geometry"]["type"]
+	if kind == "Point":
+		pdf.circle(x + 6, y + 3, MARKER_RADIUS, fill=hex_color(properties.get("marker-color", DEFAULT_MARKER_COLOR)))
+	elif kind == "LineString":
+		pdf.line(x, y + 3, x + 12, y + 3, width=LINE_WIDTH, color=hex_color(properties.get("stroke", DEFAULT_STROKE_COLOR)))
+	else:
+		pdf.rect(x + 1, y - 1, 10, 8, width=LINE_WIDTH / 2, color=hex_color(properties.get("stroke", DEFAULT_STROKE_COLOR)))
+
+
+def _draw_legend(pdf, features, x, top, bottom):
+	"""Precedence of the traffic shown, the hazards in force, then each annotation with its size, down to bottom."""
+	pdf.text(x, top - 12, tr("Legend"), size=11, bold=True)
+	y = top - 30
+	rows = []
+	ranks = {}
+	for feature in features:
+		properties = feature["properties"]
+		if properties.get("role") not in (ANNOTATION_ROLE, HAZARD_ROLE) and feature["geometry"]["type"] == "Point":
+			rank = properties.get("urgency") or 0
+			first, count = ranks.get(rank, (feature, 0))
+			ranks[rank] = (first, count + 1)
+	if ranks:
+		rows.append((None, tr("Precedence"), None))
+		rows.extend((first, f"{tr(RANK_NAMES.get(rank, WinlinkPrecedence.ROUTINE))} ({count})", None) for rank, (first, count) in sorted(ranks.items(), reverse=True))
+	hazards = {}
+	for feature in features:
+		if feature["properties"].get("role") == HAZARD_ROLE:
+			hazards.setdefault(feature["properties"]["event"], feature)
+	if hazards:
+		rows.append((None, tr("Hazards"), None))
+		rows.extend((feature, event, None) for event, feature in hazards.items())
+	annotations = [f for f in features if f["properties"].get("role") == ANNOTATION_ROLE]
+	if annotations:
+		rows.append((None, tr("Annotations"), None))
+		rows.extend((f, f["properties"].get("label") or tr(f["properties"].get("kind") or ""), _measurement(f["properties"])) for f in annotations)
+	for index, (swatch, text, detail) in enumerate(rows):
+		height = LEGEND_ROW * (2 if detail else 1)
+		if y - height < bottom:
+			pdf.text(x, y, tr("... and {count} more", count=len(rows) - index), size=7, color=LABEL_COLOR)
+			break
+		if swatch is None:
+			y -= 4
+			pdf.text(x, y, text, size=8, bold=True)
+		else:
+			_legend_swatch(pdf, x, y, swatch)
+			pdf.text(x + 18, y, pdf.fit(text, SIDEBAR_WIDTH - 18, 8), size=8)
+			if detail:
+				y -= LEGEND_ROW - 3
+				pdf.text(x + 18, y, pdf.fit(detail, SIDEBAR_WIDTH - 18, 7), size=7, color=LABEL_COLOR)
+		y -= LEGEND_ROW
+
+
+def _draw_title_block(pdf, x, y, entries):
+	"""A ruled box of caption and value pairs, as on a drawing sheet."""
+	rows = len(entries)
+	row_height = TITLE_BLOCK_HEIGHT / rows
+	pdf.rect(x, y, SIDEBAR_WIDTH, TITLE_BLOCK_HEIGHT, width=1)
+	for index, (caption, value, bold) in enumerate(entries):
+		row_top = y + TITLE_BLOCK_HEIGHT - index * row_height
+		if index:
+			pdf.line(x, row_top, x + SIDEBAR_WIDTH, row_top, width=0.3)
+		pdf.text(x + 4, row_top - 8, caption, size=6, color=LABEL_COLOR)
+		pdf.text(x + 4, row_top - row_height + 5, pdf.fit(value, SIDEBAR_WIDTH - 8, 10 if bold else 8), size=10 if bold else 8, bold=bold)
+
+
+def print_map(features, title, paper=PAPER_DEFAULT, orientation=LANDSCAPE, subtitle=None, incident=None, operator=None,
+		static_map=None, bbox=None, zoom=None):
+	"""A one-page PdfDocument of features on paper (one of PAPERS) in orientation.  With a StaticMap the map
+	is drawn over its tiles, otherwise over a latitude/longitude grid.  bbox and zoom work as for StaticMap."""
+	width, height = PAPERS[paper]
+	pdf = PdfDocument((max(width, height), min(width, height)) if orientation == LANDSCAPE else (width, height))
+	pdf.add_page()
+	top = pdf.height - MARGIN
+	pdf.text(MARGIN, top - 18, pdf.fit(title, pdf.width - 2 * MARGIN, 18), size=18, bold=True)
+	if subtitle:
+		pdf.text(MARGIN, top - 32, pdf.fit(subtitle, pdf.width - 2 * MARGIN, 10), size=10)
+
+	map_box = (MARGIN, MARGIN, pdf.width - 2 * MARGIN - GAP - SIDEBAR_WIDTH, pdf.height - 2 * MARGIN - HEADER_HEIGHT)
+	frame = _MapFrame(features, *map_box, bbox=bbox, zoom=zoom)
+	pdf.clip(*map_box)
+	if static_map is not None and static_map.tiles:
+		raster = static_map.render([], frame.pixel_size[0], frame.pixel_size[1], frame.bbox, frame.zoom)
+		pdf.image(*map_box, raster.pixels, raster.width, raster.height)
+	else:
+		_draw_graticule(pdf, frame)
+	_draw_features(pdf, frame, features)
+	pdf.end_clip()
+	pdf.rect(*map_box, width=1)
+	_draw_north_arrow(pdf, frame)
+	_draw_scale_bar(pdf, frame)
+
+	sidebar = MARGIN + map_box[2] + GAP
+	_draw_legend(pdf, features, sidebar, MARGIN + map_box[3], MARGIN + TITLE_BLOCK_HEIGHT + GAP)
+	traffic = [f for f in features if f["properties"].get("role") not in (ANNOTATION_ROLE, HAZARD_ROLE)]
+	dates = [datetime.datetime.fromisoformat(f["properties"]["date"]) for f in traffic if f["properties"].get("date")]
+	messages = len({f["properties"].get("mid") or f.get("id") for f in traffic})
+	scale = round(frame.meters_per_point() * POINTS_PER_METER, -2)
+	_draw_title_block(pdf, sidebar, MARGIN, [
+		(tr("Title"), title, True),
+		(tr("Incident"), incident or "", False),
+		(tr("Prepared by"), operator or "", False),
+		(tr("Data through"), local_time(max(dates)) if dates else "", False),
+		(tr("Printed"), local_time(utc_now()), False),
+		(tr("Scale"), f"1:{scale:,.0f}  {paper.upper()} {tr(orientation)}", False),
+		(tr("Shown"), tr("{messages} messages, {features} features", messages=messages, features=len(features)), False),
+	])
+	return pdf
@@ -0,0 +1,216 @@
+#!/usr/bin/env python
+'''Renders map images from local slippy-map tiles with message features drawn on top'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import math
+import os
+from classes.forms import FormStyles
+from classes.export.PngImage import Raster
+from classes.export.PdfDocument import hex_color
+
+TILE_SIZE = 256
+MAX_ZOOM = 19
+MAX_IMAGE_SIZE = 8192  # Pixels on a side; a raster this big is already 200 MB
+MISSING_TILE_COLOR = (232, 232, 232)
+GRID_COLOR = (200, 200, 200)
+MARKER_RADIUS = 5
+DEFAULT_MARKER_COLOR = "#3388ff"
+FIT_PADDING = 40  # Pixels kept clear around the features when the zoom is chosen automatically
+MIN_SPAN_DEGREES = 0.01  # So a single station still gets a sensible map around it
+LINE_WIDTH = 3
+DEFAULT_STROKE_COLOR = "#555555"
+WEB_MERCATOR_RADIUS_M = 6378137.0  # The sphere tiles are drawn on
+
+
+def world_pixel(latitude, longitude, zoom):
+	"""Web Mercator pixel coordinates of a point at a zoom level."""
+	latitude = max(min(latitude, 85.05112878), -85.05112878)
+	scale = TILE_SIZE * 2 ** zoom
+	x = (longitude + 180.0) / 360.0 * scale
+	y = (1.0 - math.log(math.tan(math.radians(latitude)) + 1.0 / math.cos(math.radians(latitude))) / math.pi) / 2.0 * scale
+	return x, y
+
+
+def pixel_latitude(y, zoom):
+	"""Latitude of a Web Mercator pixel row at a zoom level."""
+	n = math.pi * (1.0 - 2.0 * y / (TILE_SIZE * 2 ** zoom))
+	return math.degrees(math.atan(math.sinh(n)))
+
+
+def meters_per_pixel(latitude, zoom):
+	"""Ground distance across one pixel at a latitude and zoom level."""
+	return math.cos(math.radians(latitude)) * 2 * math.pi * WEB_MERCATOR_RADIUS_M / (TILE_SIZE * 2 ** zoom)
+
+
+def fit_zoom(west, south, east, north, width, height):
+	"""The highest zoom at which the box fits in width x height pixels less the padding."""
+	for zoom in range(MAX_ZOOM, -1, -1):
+		x0, y0 = world_pixel(north, west, zoom)
+		x1, y1 = world_pixel(south, east, zoom)
+		if x1 - x0 <= width - 2 * FIT_PADDING and y1 - y0 <= height - 2 * FIT_PADDING:
+			return zoom
+	return 0
+
+
+def geometry_points(geometry):
+	"""The [lon, lat] positions of a Point, LineString, or Polygon (its outer ring)."""
+	if geometry["type"] == "Point":
+		return [geometry["coordinates"][:2]]
+	if geometry["type"] == "LineString":
+		return [p[:2] for p in geometry["coordinates"]]
+	return [p[:2] for p in geometry["coordinates"][0]]
+
+
+def feature_bounds(features):
+	"""West, south, east, north around the features' points, at least MIN_SPAN_DEGREES across."""
+	points = [p for f in features for p in geometry_points(f["geometry"])]
+	west, east = min(p[0] for p in points), max(p[0] for p in points)
+	south, north = min(p[1] for p in points), max(p[1] for p in points)
+	pad_lon = max(MIN_SPAN_DEGREES - (east - west), 0) / 2
+	pad_lat = max(MIN_SPAN_DEGREES - (north - south), 0) / 2
+	return west - pad_lon, south - pad_lat, east + pad_lon, north + pad_lat
+
+
+def view(features, width, height, bbox=None, zoom=None):
+	"""(zoom, left, top): the zoom and world pixel of the top left corner of a width x height map
+	centered on bbox, or on the features if no bbox is given, at zoom or the highest zoom that fits."""
+	if bbox is None:
+		bbox = feature_bounds(features) if features else (-180.0, -85.0, 180.0, 85.0)
+	west, south, east, north = bbox
+	if zoom is None:
+		zoom = fit_zoom(west, south, east, north, width, height)
+	x0, y0 = world_pixel(north, west, zoom)
+	x1, y1 = world_pixel(south, east, zoom)
+	return zoom, (x0 + x1) / 2 - width / 2, (y0 + y1) / 2 - height / 2
+
+
+def parse_bbox(text):
+	"""Parse "west,south,east,north" in decimal degrees.  Raises ValueError if malformed."""
+	parts = [float(p) for p in text.split(",")]
+	if len(parts) != 4 or parts[0] >= parts[2] or parts[1] >= parts[3]:
+		raise ValueError(f"Expected WEST,SOUTH,EAST,NORTH but got <{text}>")
+	return tuple(parts)
+
+
+def parse_size(text):
+	"""Parse "WIDTHxHEIGHT" in pixels, each 1 to MAX_IMAGE_SIZE.  Raises ValueError if malformed."""
+	try:
+		width, height = (int(n) for n in text.lower().split("x"))
+	except ValueError:
+		raise ValueError(f"Expected WIDTHxHEIGHT but got <{text}>")
+	if not (1 <= width <= MAX_IMAGE_SIZE and 1 <= height <= MAX_IMAGE_SIZE):
+		raise ValueError(f"Image size <{text}> must be 1 to {MAX_IMAGE_SIZE} pixels on a side")
+	return width, height
+
+
+def check_zoom(zoom):
+	"""zoom, if it is a tile zoom level from 0 to MAX_ZOOM.  Raises ValueError if not."""
+	if not 0 <= zoom <= MAX_ZOOM:
+		raise ValueError(f"Zoom {zoom} must be 0 to {MAX_ZOOM}")
+	return zoom
+
+
+class StaticMap:
+	"""Draws maps from a folder of tiles laid out as {zoom}/{x}/{y}.png, as written by most tile
+	downloaders.  Without a tile folder the map is a plain latitude/longitude grid."""
+
+	def __init__(self, tiles=None, enable_debug=False):
+		self.tiles = tiles
+		self.enable_debug = enable_debug
+		self._cache = {}
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _tile(self, zoom, x, y):
+		"""The tile raster, or None if it is missing or unreadable."""
+		key = (zoom, x % 2 ** zoom, y)
+		if key not in self._cache:
+			self._cache[key] = None
+			if self.tiles is not None and 0 <= y < 2 ** zoom:
+				path = os.path.join(self.tiles, str(zoom), str(key[1]), f"{y}.png")
+				try:
+					with open(path, 'rb') as f:
+						self._cache[key] = Raster.from_png(f.read())
+				except OSError:
+					self._log_debug(f"No tile {path}")
+				except ValueError as e:
+					self.logger.warning(f"Skipping tile {path}: {e}")
+		return self._cache[key]
+
+	def render(self, features, width, height, bbox=None, zoom=None):
+		"""A Raster of width x height centered on bbox (west, south, east, north), or on the features
+		if no bbox is given, at zoom or the highest zoom that fits."""
+		zoom, left, top = view(features, width, height, bbox, zoom)
+		raster = Raster(width, height, MISSING_TILE_COLOR)
+		found = 0
+		for ty in range(int(top // TILE_SIZE), int((top + height) // TILE_SIZE) + 1):
+			for tx in range(int(left // TILE_SIZE), int((left + width) // TILE_SIZE) + 1):
+				tile = self._tile(zoom, tx, ty)
+				if tile is not None:
+					raster.paste(tile, int(tx * TILE_SIZE - left), int(ty * TILE_SIZE - top))
+					found += 1
+		if found == 0:
+			self._draw_grid(raster, zoom, left, top)
+		self._log_debug(f"Rendered zoom {zoom} with {found} tiles")
+
+		for feature in sorted(features, key=FormStyles.z_order):
+			properties = feature["properties"]
+			if feature["geometry"]["type"] != "Point":
+				# Lines and areas, such as annotations and hazards, are drawn as their outline, over their fill
+				# for areas, with the label at the start
+				pixels = [world_pixel(lat, lon, zoom) for lon, lat in geometry_points(feature["geometry"])]
+				pixels = [(x - left, y - top) for x, y in pixels]
+				if feature["geometry"]["type"] == "Polygon" and properties.get("fill") and properties.get("fill-opacity", 0.6) > 0:
+					fill = tuple(round(c * 255) for c in hex_color(properties["fill"]))
+					raster.fill_polygon(pixels, fill, properties.get("fill-opacity", 0.6))
+				color = tuple(round(c * 255) for c in hex_color(properties.get("stroke", DEFAULT_STROKE_COLOR)))
+				for (x0, y0), (x1, y1) in zip(pixels, pixels[1:]):
+					raster.line(x0, y0, x1, y1, color, LINE_WIDTH)
+				raster.text(int(pixels[0][0] + 4), int(pixels[0][1] - 3), properties.get("label") or "")
+				continue
+			longitude, latitude = feature["geometry"]["coordinates"][:2]
+			px, py = world_pixel(latitude, longitude, zoom)
+			px, py = px - left, py - top
+			color = tuple(round(c * 255) for c in hex_color(properties.get("marker-color", DEFAULT_MARKER_COLOR)))
+			raster.disc(px, py, MARKER_RADIUS, color)
+			raster.text(int(px + MARKER_RADIUS + 3), int(py - 3), properties.get("callsign") or properties.get("mid") or properties.get("label") or "")
+		return raster
+
+	def _draw_grid(self, raster, zoom, left, top):
+		"""Latitude/longitude lines in place of missing tiles."""
+		scale = TILE_SIZE * 2 ** zoom
+		west = left / scale * 360.0 - 180.0
+		east = (left + raster.width) / scale * 360.0 - 180.0
+		step = next((s for s in (0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10, 20, 30) if (east - west) / s <= 8), 45)
+		longitude = math.ceil(west / step) * step
+		while longitude < east:
+			x = int(world_pixel(0, longitude, zoom)[0] - left)
+			for y in range(raster.height):
+				raster.set(x, y, GRID_COLOR)
+			longitude += step
+		south = pixel_latitude(top + raster.height, zoom)
+		north = pixel_latitude(top, zoom)
+		latitude = math.ceil(south / step) * step
+		while latitude < north:
+			y = int(world_pixel(latitude, 0, zoom)[1] - top)
+			for x in range(raster.width):
+				raster.set(x, y, GRID_COLOR)
+			latitude += step
@@ -0,0 +1,249 @@
+#!/usr/bin/env python
+'''Translations of the text in map products (popups, layer names, and reports) for the audience's language'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import contextlib
+import json
+import string
+import threading
+
+ENGLISH = "en"
+SPANISH = "es"
+TRANSLATIONS_FILE_NAME = "translations.json"  # More languages, or changes to these, keyed by language code
+
+# Labels shown for feature properties in popups.  Property names themselves never change, so programs
+# reading the GeoJSON see the same keys in every language; other form fields keep their variable names.
+LABELS = {
+	"mid": "Message ID",
+	"callsign": "Call sign",
+	"sender": "From",
+	"recipient": "To",
+	"subject": "Subject",
+	"date": "Date (UTC)",
+	"local_date": "Local time",
+	"clock_offset_seconds": "Clock offset (seconds)",
+	"form_type": "Form",
+	"precedence": "Precedence",
+	"severity": "Severity",
+	"urgency": "Urgency",
+	"source_path": "Path",
+	"gateway": "Gateway",
+	"operator": "Operator",
+	"agency": "Agency",
+	"capabilities": "Capabilities",
+	"in_reply_to": "In reply to",
+	"thread": "Conversation",
+	"role": "Location",
+	"related": "Related",
+	"elevation_m": "Elevation (m)",
+	"shelter": "Shelter",
+	"address": "Address",
+	"status": "Status",
+	"manager": "Manager",
+	"phone": "Phone",
+	"capacity": "Capacity",
+	"occupancy": "Occupancy",
+	"meals": "Meals",
+	"staff": "Staff",
+	"occupancy_pct": "Occupancy (%)",
+	"available": "Available",
+	"filled_by": "Filled by",
+	"filled_date": "Filled on",
+	"locator": "Grid square",
+	"reports": "Reports",
+	"stations": "Stations",
+	"first_date": "First report",
+	"last_date": "Last report",
+}
+# Properties whose values are words of ours rather than what a station sent, so they are translated too
+TRANSLATED_VALUES = {"role", "precedence"}
+
+# English text -> the same text in each other language.  Text a language lacks is shown in English.
+# {name} placeholders are filled in after translation, so a translation may move them.
+CATALOG = {
+	SPANISH: {
+		# Popup labels
+		"Message ID": "ID del mensaje",
+		"Call sign": "Indicativo",
+		"From": "De",
+		"To": "Para",
+		"Subject": "Asunto",
+		"Date (UTC)": "Fecha (UTC)",
+		"Local time": "Hora local",
+		"Clock offset (seconds)": "Desfase del reloj (segundos)",
+		"Form": "Formulario",
+		"Precedence": "Precedencia",
+		"Severity": "Gravedad",
+		"Urgency": "Urgencia",
+		"Path": "Vía",
+		"Gateway": "Pasarela",
+		"Operator": "Operador",
+		"Agency": "Agencia",
+		"Capabilities": "Capacidades",
+		"In reply to": "En respuesta a",
+		"Conversation": "Conversación",
+		"Location": "Ubicación",
+		"Related": "Relacionados",
+		"Elevation (m)": "Elevación (m)",
+		"Shelter": "Refugio",
+		"Address": "Dirección",
+		"Status": "Estado",
+		"Manager": "Responsable",
+		"Phone": "Teléfono",
+		"Capacity": "Capacidad",
+		"Occupancy": "Ocupación",
+		"Meals": "Comidas",
+		"Staff": "Personal",
+		"Occupancy (%)": "Ocupación (%)",
+		"Available": "Disponible",
+		"Filled by": "Atendida por",
+		"Filled on": "Atendida el",
+		"Grid square": "Cuadrícula",
+		"Reports": "Informes",
+		"Stations": "Estaciones",
+		"First report": "Primer informe",
+		"Last report": "Último informe",
+		# Precedences
+		"Routine": "Rutina",
+		"Priority": "Prioridad",
+		"Immediate": "Inmediato",
+		"Flash": "Relámpago",
+		# Location roles
+		"reporter": "remitente",
+		"form": "formulario",
+		"incident": "incidente",
+		"staging_area": "área de espera",
+		"site": "sitio",
+		# Layer names
+		"Shelters": "Refugios",
+		"Resource requests": "Solicitudes de recursos",
+		"Grid density": "Densidad por cuadrícula",
+		"Operational periods": "Períodos operacionales",
+		"Unassigned": "Sin asignar",
+		# Period reports
+		"No positions reported": "No se reportaron posiciones",
+		"Outside every operational period": "Fuera de todo período operacional",
+		"{start} to {end}": "{start} a {end}",
+		"{messages} messages, {features} located features.  Generated {time}.": "{messages} mensajes, {features} elementos ubicados.  Generado {time}.",
+		"Reports received: {name}": "Informes recibidos: {name}",
+		"Time": "Hora",
+		"Position": "Posición",
+		"COMMUNICATIONS LOG (ICS 309)": "REGISTRO DE COMUNICACIONES (ICS 309)",
+		"1. Incident Name: {incident}": "1. Nombre del incidente: {incident}",
+		"2. Operational Period: {span}": "2. Período operacional: {span}",
+		"3. Radio Net Name or Position/Tactical Call: {net}": "3. Red de radio o posición/indicativo táctico: {net}",
+		"4. Radio Operator (Name, Call Sign): {operator}": "4. Operador de radio (nombre, indicativo): {operator}",
+		"5. Record of messages:": "5. Registro de mensajes:",
+		"From (Call Sign/ID)": "De (indicativo/ID)",
+		"To (Call Sign/ID)": "Para (indicativo/ID)",
+		"Msg # (MID)": "N.º de mensaje (MID)",
+		"Message": "Mensaje",
+		"6. Prepared by: {operator}    Date/Time Prepared: {time}": "6. Preparado por: {operator}    Fecha/hora de preparación: {time}",
+		# Print layout
+		"Situation map": "Mapa de situación",
+		"Legend": "Leyenda",
+		"Annotations": "Anotaciones",
+		"Hazards": "Peligros",
+		"note": "nota",
+		"line": "línea",
+		"area": "área",
+		"... and {count} more": "... y {count} más",
+		"Title": "Título",
+		"Incident": "Incidente",
+		"Prepared by": "Preparado por",
+		"Data through": "Datos hasta",
+		"Printed": "Impreso",
+		"Scale": "Escala",
+		"landscape": "horizontal",
+		"portrait": "vertical",
+		"Shown": "Mostrado",
+		"{messages} messages, {features} features": "{messages} mensajes, {features} elementos",
+	},
+}
+
+_language = ENGLISH
+_override = threading.local()  # Language of products built on this thread inside using()
+
+
+def languages():
+	return [ENGLISH] + sorted(CATALOG)
+
+
+def check_language(language):
+	if language not in languages():
+		raise ValueError(f"Unknown language {language}; expected one of {', '.join(languages())}")
+	return language
+
+
+def set_language(language):
+	"""Choose the language of the products built from now on."""
+	global _language
+	_language = check_language(language)
+
+
+def current_language():
+	return getattr(_override, "language", None) or _language
+
+
+@contextlib.contextmanager
+def using(language):
+	"""Build products in language on this thread for the duration, e.g. one export job's."""
+	previous = getattr(_override, "language", None)
+	_override.language = check_language(language) if language is not None else previous
+	try:
+		yield
+	finally:
+		_override.language = previous
+
+
+def tr(text, **values):
+	"""text in the current language, with its {name} placeholders filled from values."""
+	translated = CATALOG.get(current_language(), {}).get(text, text)
+	return translated.format(**values) if values else translated
+
+
+def label(name):
+	"""What to call a feature property in a popup."""
+	return tr(LABELS[name]) if name in LABELS else name
+
+
+def value_text(name, value):
+	"""A property's value as shown in a popup."""
+	return tr(value) if name in TRANSLATED_VALUES and isinstance(value, str) else value
+
+
+def _placeholders(text):
+	return {name for _, name, _, _ in string.Formatter().parse(text) if name is not None}
+
+
+def load_translations(filename):
+	"""Add the languages in a JSON file, e.g. {"es": {"Shelters": "Albergues"}, "fr": {...}}, to
+	CATALOG.  A language already known keeps the text the file doesn't give.  Raises ValueError if
+	the file is malformed."""
+	with open(filename, 'r', encoding='utf-8') as f:
+		try:
+			config = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(config, dict):
+		raise ValueError(f"{filename}: expected a JSON object keyed by language")
+	for language, texts in config.items():
+		if language == ENGLISH:
+			raise ValueError(f"{filename}: English is the text being translated; give other languages")
+		if not isinstance(texts, dict) or not all(isinstance(k, str) and isinstance(v, str) for k, v in texts.items()):
+			raise ValueError(f"{filename}: {language} must map English text to its translation")
+		for text, translated in texts.items():
+			try:
+				unknown = _placeholders(translated) - _placeholders(text)
+			except ValueError as e:
+				raise ValueError(f"{filename}: {language} translation of <{text}>: {e}")
+			if unknown:
+				raise ValueError(f"{filename}: {language} translation of <{text}> has placeholders the text lacks: {', '.join(sorted(unknown))}")
+	for language, texts in config.items():
+		CATALOG.setdefault(language, {}).update(texts)
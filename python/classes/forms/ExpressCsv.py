@@ -0,0 +1,81 @@
+#!/usr/bin/env python
+'''Reads the message index CSV that Winlink Express writes with "Generate CSV"'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import csv
+from classes.b2f.B2Message import B2Message
+from classes.b2f.WinlinkTime import parse_timestamp
+
+# Column headings seen in Winlink Express exports (compared without case or spaces), by the field they hold
+COLUMN_ALIASES = {
+	"mid": ["messageid", "mid", "msgid", "id"],
+	"date": ["date", "datetime", "date/time", "sent", "time", "utc"],
+	"sender": ["from", "sender"],
+	"recipient": ["to", "recipient", "recipients"],
+	"cc": ["cc"],
+	"subject": ["subject"],
+	"size": ["size", "bytes"],
+	"attachments": ["attachments", "attachment", "files"],
+	"folder": ["folder", "mailbox"],
+}
+B2_DATE_FORMAT = "%Y/%m/%d %H:%M"
+
+
+def _heading(text):
+	return "".join(str(text).lower().split()).replace("_", "")
+
+
+def read_index(filename, timezone=None):
+	"""The rows of a Winlink Express CSV as dicts keyed by the fields in COLUMN_ALIASES, plus "date"
+	as an aware datetime (or None) and "extra" holding any other columns.  Rows without a MID are
+	skipped.  Raises ValueError if no column holds message IDs."""
+	with open(filename, 'r', newline='', encoding='utf-8-sig', errors='replace') as f:
+		rows = list(csv.reader(f))
+	if not rows:
+		return []
+	columns = {}
+	for index, heading in enumerate(rows[0]):
+		for field, aliases in COLUMN_ALIASES.items():
+			if _heading(heading) in aliases and field not in columns.values():
+				columns[index] = field
+				break
+	if "mid" not in columns.values():
+		raise ValueError(f"No message ID column in {filename} (headings: {', '.join(rows[0])})")
+
+	entries = []
+	for row in rows[1:]:
+		entry = {field: "" for field in COLUMN_ALIASES}
+		entry["extra"] = {}
+		for index, value in enumerate(row):
+			if index in columns:
+				entry[columns[index]] = value.strip()
+			elif index < len(rows[0]) and value.strip():
+				entry["extra"][rows[0][index]] = value.strip()
+		if not entry["mid"]:
+			continue
+		entry["date"] = parse_timestamp(entry["date"], timezone) if entry["date"] else None
+		entries.append(entry)
+	return entries
+
+
+def placeholder_message(entry, enable_debug=False):
+	"""A B2Message with only the headers a CSV row gives, for a message whose payload we don't have."""
+	lines = [f"Mid: {entry['mid']}"]
+	if entry["date"] is not None:
+		lines.append(f"Date: {entry['date'].strftime(B2_DATE_FORMAT)}")
+	lines.append(f"From: {entry['sender']}")
+	for header, field in (("To", "recipient"), ("Cc", "cc")):
+		# One header per address, as on the wire; Express separates addresses with semicolons
+		lines.extend(f"{header}: {address.strip()}" for address in entry[field].replace(";", ",").split(",") if address.strip())
+	lines.append(f"Subject: {entry['subject']}")
+	headers = "\r\n".join(lines) + "\r\n"
+	message = B2Message(entry["mid"], b"", None, None, enable_debug=enable_debug)
+	message.headers = headers
+	message.parse_headers(headers)
+	return message
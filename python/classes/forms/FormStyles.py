@@ -0,0 +1,74 @@
+#!/usr/bin/env python
+'''Marker styling, layer group, and drawing order chosen by form type and form field values'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import string
+
+# Keys of a form style that aren't simplestyle properties
+ICON = "icon"  # URL of a marker image, for KML
+LAYER = "layer"  # Name of the layer group the form's features belong to
+Z_ORDER = "z_order"  # Features with a higher z_order are drawn later, on top
+WHEN = "when"  # [{"fields": {variable: value}, ...style}] applied in order where every listed field matches
+POPUP = "popup"  # string.Template text of the feature's description, e.g. "$callsign: $damage_summary"
+DESCRIPTION = "description"  # The feature property holding the rendered popup
+
+# Styles by form type, laid over the precedence styling.  None are built in; styles.json adds them, e.g.
+# {"Damage_Assessment": {"marker-symbol": "danger", "layer": "Damage", "z_order": 1, "popup": "$address: $damage_summary",
+#  "when": [{"fields": {"power": "OUT"}, "marker-color": "#ff0000"}]}}
+FORM_STYLES = {}
+
+
+def check_style(form_type, style):
+	"""Raises ValueError unless style is a form style: an object whose when rules each list the fields
+	they match."""
+	if not isinstance(style, dict):
+		raise ValueError(f"the style for {form_type} must be an object")
+	if not isinstance(style.get(Z_ORDER, 0), (int, float)):
+		raise ValueError(f"{Z_ORDER} for {form_type} must be a number")
+	rules = style.get(WHEN, [])
+	if not isinstance(rules, list) or not all(isinstance(r, dict) and isinstance(r.get("fields"), dict) and r["fields"] for r in rules):
+		raise ValueError(f"each {WHEN} rule for {form_type} needs the fields it matches")
+	for popup in [style.get(POPUP)] + [r.get(POPUP) for r in rules]:
+		if popup is not None and not (isinstance(popup, str) and string.Template(popup).is_valid()):
+			raise ValueError(f"{POPUP} for {form_type} must be text with $name or ${{name}} placeholders ($$ for a dollar sign)")
+
+
+def _matches(fields, variables):
+	return all((variables.get(name) or "").strip().lower() == str(value).strip().lower() for name, value in fields.items())
+
+
+def form_style(form):
+	"""The style for a form as feature properties: its form type's style, with each matching when rule
+	on top.  Empty if the form type has none."""
+	style = FORM_STYLES.get(form.form_type) if form is not None else None
+	if not style:
+		return {}
+	properties = {k: v for k, v in style.items() if k != WHEN}
+	for rule in style.get(WHEN, []):
+		if _matches(rule["fields"], form.variables):
+			properties.update((k, v) for k, v in rule.items() if k != "fields")
+	return properties
+
+
+class _Blank(dict):
+	"""Fields for a popup; a placeholder for a field the form left empty comes out empty."""
+
+	def __missing__(self, name):
+		return ""
+
+
+def render_popup(template, values):
+	"""The popup template with each $name replaced by values[name] as text, lists joined by commas."""
+	text = {k: ", ".join(str(v) for v in value) if isinstance(value, list) else str(value) for k, value in values.items() if value is not None}
+	return string.Template(template).substitute(_Blank(text))
+
+
+def z_order(feature):
+	"""Sort key drawing features of a higher z_order later; the others keep their order."""
+	return feature["properties"].get(Z_ORDER) or 0
@@ -0,0 +1,80 @@
+#!/usr/bin/env python
+'''Fills in the HTML viewer that goes with a Winlink form template'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import html
+import logging
+import os
+import re
+from classes.forms import Redaction
+
+# Viewer templates mark each value as {var name}; a few other tags come from Winlink Express itself
+VAR_TAG = re.compile(r"\{var\s+([^}\s]+)\s*\}", re.IGNORECASE)
+OTHER_TAGS = re.compile(r"\{(FormFolder|MsgSender|MsgTo|MsgSubject|MsgOriginalBody|SeqNum)\}", re.IGNORECASE)
+VIEWER_SUFFIX = "_Viewer.html"
+
+
+class FormViewer:
+	"""Finds viewer HTML files in a folder of form templates (searched recursively) and fills them in."""
+
+	def __init__(self, folder, enable_debug=False):
+		self.folder = folder
+		self.enable_debug = enable_debug
+		self.viewers = {}  # Lower-case file name -> path
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+		for root, _, names in os.walk(folder):
+			for name in names:
+				if name.lower().endswith(".html"):
+					self.viewers.setdefault(name.lower(), os.path.join(root, name))
+		self._log_debug(f"Found {len(self.viewers)} HTML templates in {folder}")
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def viewer_for(self, form):
+		"""Path of the viewer for a form (a WinlinkForm): its display_form, or <form type>_Viewer.html."""
+		candidates = [form.parameters.get("display_form") or "", f"{form.form_type}{VIEWER_SUFFIX}"]
+		for name in candidates:
+			path = self.viewers.get(os.path.basename(name).lower())
+			if path is not None:
+				return path
+		return None
+
+	def render(self, message, variables=None):
+		"""The viewer HTML for a message's form filled in with variables (the form's own by default),
+		or None if there is no form or no viewer for it.  The subject and body are scrubbed as exports are."""
+		if message.form is None:
+			return None
+		path = self.viewer_for(message.form)
+		if path is None:
+			self._log_debug(f"No viewer for form {message.form.form_type}")
+			return None
+		with open(path, 'r', encoding='utf-8', errors='replace') as f:
+			template = f.read()
+		variables = message.form.variables if variables is None else variables
+		lookup = {name.lower(): value for name, value in variables.items()}
+		others = {
+			"formfolder": os.path.dirname(path),
+			"msgsender": message.sender,
+			"msgto": message.recipient,
+			"msgsubject": Redaction.scrub_text(message.subject),
+			"msgoriginalbody": Redaction.scrub_text(message.body),
+			"seqnum": "",
+		}
+		filled = VAR_TAG.sub(lambda m: html.escape(str(lookup.get(m.group(1).lower()) or "")), template)
+		return OTHER_TAGS.sub(lambda m: html.escape(str(others[m.group(1).lower()] or "")), filled)
@@ -0,0 +1,229 @@
+#!/usr/bin/env python
+'''Form field mappings and map styling read from JSON files, and reloaded when the files change'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import copy
+import json
+import logging
+import os
+import threading
+from classes.geo import Annotations, GridDensity, WeatherHazards
+from classes.forms import FormStyles, ResourceRequest, ShelterStatus, TemplateVersions, Welfare, WinlinkForm
+from classes.b2f import IngestTransforms, WinlinkPrecedence
+
+MAPPINGS_FILE_NAME = "mappings.json"  # Which form variables mean what
+STYLES_FILE_NAME = "styles.json"  # How markers are drawn
+WATCH_INTERVAL_SECONDS = 2.0
+
+# Settings in each file and the table each one adjusts.  A reload builds each table afresh and then
+# rebinds the module's name for it, so a reader on another thread sees either the old table or the new
+# one, never one half cleared; readers must therefore look a table up through its module, never import
+# it by name.  A dict setting replaces the listed keys and keeps the others; a set setting adds form
+# types; a list setting replaces the whole list.
+MAPPINGS = {
+	"required_variables": (WinlinkForm, "REQUIRED_VARIABLES"),  # {form type: [variable]}
+	"positions": (WinlinkForm, "FORM_POSITIONS"),  # {form type: [{"role", "latitude", "longitude"}]}
+	"shelter_forms": (ShelterStatus, "SHELTER_FORM_TYPES"),  # [form type]
+	"shelter_fields": (ShelterStatus, "SHELTER_FIELDS"),  # {field: [variable]}, first match wins
+	"shelter_numbers": (ShelterStatus, "NUMBER_FIELDS"),
+	"request_forms": (ResourceRequest, "RESOURCE_REQUEST_FORM_TYPES"),
+	"request_fields": (ResourceRequest, "REQUEST_FIELDS"),
+	"welfare_fields": (Welfare, "WELFARE_FIELDS"),
+	"severity_variables": (WinlinkPrecedence, "SEVERITY_VARIABLES"),  # [variable], checked in order
+	"severity_values": (WinlinkPrecedence, "SEVERITY_RANK"),  # {value: rank 0-3}
+	"template_versions": (TemplateVersions, "TEMPLATE_VERSIONS"),  # {form type: [template version]}
+	"transforms": (IngestTransforms, "TRANSFORMS"),  # [{"type", ...}] applied before each message is parsed
+}
+STYLES = {
+	"precedence": (WinlinkPrecedence, "SYMBOLOGY"),  # {rank 0-3: simplestyle properties}
+	"shelter_occupancy": (ShelterStatus, "OCCUPANCY_SYMBOLOGY"),  # [[fraction of capacity or null, properties]]
+	"shelter_unknown": (ShelterStatus, "UNKNOWN_SYMBOLOGY"),
+	"request_status": (ResourceRequest, "STATUS_SYMBOLOGY"),  # {"open" or "filled": properties}
+	"grid_density": (GridDensity, "DENSITY_SYMBOLOGY"),  # [[fraction of the busiest square or null, properties]]
+	"annotations": (Annotations, "ANNOTATION_SYMBOLOGY"),  # simplestyle properties for notes, lines, and areas
+	"hazards": (WeatherHazards, "HAZARD_SYMBOLOGY"),  # {significance W, A, Y, or S: properties}
+	"hazard_colors": (WeatherHazards, "HAZARD_COLORS"),  # {phenomenon.significance, e.g. WS.W: color}
+	"forms": (FormStyles, "FORM_STYLES"),  # {form type: properties, icon, layer, z_order, and when rules}
+}
+REPROCESS_FAILED = "reprocess_failed"  # mappings.json: retry the quarantined messages after each change
+
+# The tables as shipped, so a setting removed from a file goes back to its default
+DEFAULTS = {name: copy.deepcopy(getattr(module, attribute)) for name, (module, attribute) in {**MAPPINGS, **STYLES}.items()}
+
+
+def _check_table(filename, name, value, default):
+	"""The setting from a file converted to the table's own types.  Raises ValueError if it doesn't fit."""
+	if isinstance(default, set):
+		if not isinstance(value, list) or not all(isinstance(v, str) for v in value):
+			raise ValueError(f"{filename}: {name} must be a list of form types")
+		return set(value)
+	if isinstance(default, list):
+		if not isinstance(value, list):
+			raise ValueError(f"{filename}: {name} must be a list")
+		if name in ("shelter_occupancy", "grid_density"):
+			if not value or not all(isinstance(v, list) and len(v) == 2 and isinstance(v[1], dict) for v in value):
+				raise ValueError(f"{filename}: {name} must be a list of [fraction, style] pairs")
+			return [(limit, style) for limit, style in value]
+		if name == "transforms":
+			try:
+				return IngestTransforms.check_transforms(value)
+			except ValueError as e:
+				raise ValueError(f"{filename}: {e}")
+		return list(value)
+	if not isinstance(value, dict):
+		raise ValueError(f"{filename}: {name} must be an object")
+	if name == "precedence":
+		try:
+			value = {int(rank): style for rank, style in value.items()}
+		except ValueError:
+			raise ValueError(f"{filename}: precedence is keyed by rank, 0 (routine) to 3 (flash)")
+	if name == "severity_values":
+		if not all(v in WinlinkPrecedence.PRECEDENCE_RANK.values() for v in value.values()):
+			raise ValueError(f"{filename}: severity_values ranks must be 0 (routine) to 3 (flash)")
+		value = {k.lower(): v for k, v in value.items()}
+	if name == "template_versions":
+		if not all(isinstance(v, list) and v and all(isinstance(n, str) for n in v) for v in value.values()):
+			raise ValueError(f"{filename}: template_versions must map each form type to a list of versions")
+	if name == "forms":
+		try:
+			for form_type, style in value.items():
+				FormStyles.check_style(form_type, style)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if name == "positions":
+		for form_type, pairs in value.items():
+			if not all(isinstance(p, dict) and {"role", "latitude", "longitude"} <= set(p) for p in pairs):
+				raise ValueError(f"{filename}: positions for {form_type} need role, latitude, and longitude")
+	return value
+
+
+def load_config(filename, tables):
+	"""Read one of the files into {setting: value}, checked against tables (MAPPINGS or STYLES).
+	Raises ValueError for unknown or malformed settings."""
+	with open(filename, 'r') as f:
+		try:
+			config = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(config, dict):
+		raise ValueError(f"{filename}: expected a JSON object")
+	extra = {REPROCESS_FAILED} if tables is MAPPINGS else set()
+	unknown = set(config) - set(tables) - extra
+	if unknown:
+		raise ValueError(f"Unknown settings in {filename}: {', '.join(sorted(unknown))}")
+	return {name: value if name in extra else _check_table(filename, name, value, DEFAULTS[name]) for name, value in config.items()}
+
+
+def apply_config(config, tables):
+	"""Set every table in tables to its default with config's settings on top."""
+	for name, (module, attribute) in tables.items():
+		value = copy.deepcopy(DEFAULTS[name])
+		if name in config:
+			if isinstance(value, dict):
+				value.update(config[name])
+			elif isinstance(value, set):
+				value |= config[name]
+			else:
+				value = list(config[name])
+		setattr(module, attribute, value)
+
+
+class MappingConfig:
+	"""The mappings and styles files, either of which may be absent.  load() applies them; watch() starts
+	a thread that loads them again whenever one changes, so adjustments during an exercise take effect
+	without a restart.  A file with mistakes in it is reported and the settings in force are kept."""
+
+	def __init__(self, mappings_file=MAPPINGS_FILE_NAME, styles_file=STYLES_FILE_NAME, on_change=None, enable_debug=False):
+		self.files = {MAPPINGS_FILE_NAME: mappings_file, STYLES_FILE_NAME: styles_file}
+		self.tables = {MAPPINGS_FILE_NAME: MAPPINGS, STYLES_FILE_NAME: STYLES}
+		self.on_change = on_change  # Called with the mappings config after a change has been applied
+		self.enable_debug = enable_debug
+		self.config = {MAPPINGS_FILE_NAME: {}, STYLES_FILE_NAME: {}}
+		self.stamps = {}  # File -> (mtime, size) when last read, None if it was absent
+		self.failed_stamps = None  # The same, for the last edit that could not be loaded
+		self.reloads = 0
+		self.stop_event = threading.Event()
+		self.thread = None
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@property
+	def reprocess_failed(self):
+		return bool(self.config[MAPPINGS_FILE_NAME].get(REPROCESS_FAILED))
+
+	@staticmethod
+	def _stamp(filename):
+		try:
+			status = os.stat(filename)
+		except OSError:
+			return None
+		return status.st_mtime_ns, status.st_size
+
+	def load(self):
+		"""Read both files and apply them.  Returns the names of the files whose settings changed.
+		Raises ValueError or OSError, changing nothing, if either file can't be used."""
+		loaded = {}
+		for key, filename in self.files.items():
+			stamp = self._stamp(filename) if filename else None
+			loaded[key] = (stamp, load_config(filename, self.tables[key]) if stamp is not None else {})
+		changed = [self.files[key] for key, (_, config) in loaded.items() if config != self.config[key]]
+		for key, (stamp, config) in loaded.items():
+			self.stamps[key] = stamp
+			self.config[key] = config
+			apply_config(config, self.tables[key])
+		return changed
+
+	def check(self):
+		"""Reload if either file was created, changed, or removed since it was last read.  Returns the
+		names of the files whose settings changed."""
+		if all(self._stamp(filename) == self.stamps.get(key) for key, filename in self.files.items() if filename):
+			return []
+		try:
+			changed = self.load()
+		except (OSError, ValueError) as e:
+			# Not marked as read, so it is tried again; but only reported once per edit
+			stamps = {key: self._stamp(filename) for key, filename in self.files.items() if filename}
+			if stamps != self.failed_stamps:
+				self.logger.error(f"Keeping the current mappings and styles: {e}")
+				self.failed_stamps = stamps
+			return []
+		if changed:
+			self.reloads += 1
+			self.logger.info(f"Reloaded {', '.join(changed)}")
+			if self.on_change is not None:
+				try:
+					self.on_change(self.config[MAPPINGS_FILE_NAME])
+				except Exception as e:
+					self.logger.error(f"Error after reloading {', '.join(changed)}: {e}")
+		return changed
+
+	def _watch(self, interval):
+		while not self.stop_event.wait(interval):
+			self.check()
+
+	def watch(self, interval=WATCH_INTERVAL_SECONDS):
+		"""Check the files every interval seconds on a background thread."""
+		self.thread = threading.Thread(target=self._watch, args=(interval,), name="mapping-config", daemon=True)
+		self.thread.start()
+
+	def stop(self):
+		self.stop_event.set()
+		if self.thread is not None:
+			self.thread.join()
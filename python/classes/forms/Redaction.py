@@ -0,0 +1,138 @@
+#!/usr/bin/env python
+'''Removes personal information from message data before it is exported'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import fnmatch
+import hashlib
+import json
+import re
+from classes.forms.Welfare import WELFARE_FORM_TYPES
+from classes.forms.WinlinkForm import WinlinkForm
+
+REDACTED = "[redacted]"
+
+# Policy actions for a field
+KEEP = "keep"  # Export as received, without pattern masking
+HASH = "hash"  # Replace with a short keyed hash, so equal values can still be matched up
+DROP = "drop"  # Leave the field out
+REDACT = "redact"  # Replace with REDACTED
+ACTIONS = (KEEP, HASH, DROP, REDACT)
+HASH_LENGTH = 12
+
+# Text that is personal information wherever it appears
+PATTERNS = {
+	# Grouped as phone numbers are written, 650-555-1234 or (650) 555 1234: a bare run of digits is
+	# more often a decimal coordinate or an ID than a phone number
+	"phone": re.compile(r"(?<![\w.+-])(?:\+?1[-.\s])?(?:\(\d{3}\)\s?|\d{3}[-.\s])\d{3}[-.\s]\d{4}(?!\w|[.-]\d)"),
+	"email": re.compile(r"[\w.+-]+@[\w-]+(?:\.[\w-]+)+"),
+}
+
+# Welfare traffic is about private individuals, so by default its name, phone, address, and email
+# fields are removed and the locations in the form are left off the map.  Phone numbers and email
+# addresses are masked in every exported field.
+DEFAULT_REDACTION = {
+	"forms": sorted(WELFARE_FORM_TYPES) + ["*welfare*"],  # Globs on the form types whose fields are checked
+	"fields": ["*name*", "*phone*", "*address*", "*location*", "*email*"],  # Globs on the variable names removed
+	"patterns": sorted(PATTERNS),
+	"form_positions": False,  # Whether locations carried in the listed forms are exported
+	# Per form type, per field actions, checked before the rules above: {form glob: {field glob: action}}.
+	# The first matching form glob and then the first matching field glob decide.
+	"policy": {},
+	"salt": "",  # Mixed into hashes so they can't be reversed by hashing likely values; required for hash
+}
+
+_redaction = dict(DEFAULT_REDACTION)  # None when redaction is turned off
+
+
+def load_redaction(filename):
+	"""Read a redaction config like DEFAULT_REDACTION from a JSON file; missing keys keep their defaults.
+	Raises ValueError for unknown keys or patterns."""
+	with open(filename, 'r') as f:
+		config = json.load(f)
+	unknown = set(config) - set(DEFAULT_REDACTION)
+	if unknown:
+		raise ValueError(f"Unknown redaction settings: {', '.join(sorted(unknown))}")
+	for name in config.get("patterns", []):
+		if name not in PATTERNS:
+			raise ValueError(f"Unknown redaction pattern {name}; expected one of {', '.join(PATTERNS)}")
+	for form_glob, rules in config.get("policy", {}).items():
+		for field_glob, action in rules.items():
+			if action not in ACTIONS:
+				raise ValueError(f"Policy for {form_glob} field {field_glob}: unknown action {action}; expected one of {', '.join(ACTIONS)}")
+			if action == HASH and not config.get("salt"):
+				# Unsalted, a hash is undone by hashing likely names and numbers until one matches
+				raise ValueError(f"Policy for {form_glob} field {field_glob}: hash needs a salt; set one that isn't published with the map")
+	return dict(DEFAULT_REDACTION, **config)
+
+
+def set_redaction(config):
+	"""Use config (as returned by load_redaction), or None to export everything as received."""
+	global _redaction
+	_redaction = config
+
+
+def redacts_form(form_type):
+	"""True if the fields of this form type are subject to redaction."""
+	if _redaction is None or form_type is None:
+		return False
+	return any(fnmatch.fnmatch(form_type.lower(), glob.lower()) for glob in _redaction["forms"])
+
+
+def exports_form_positions(form_type):
+	return not redacts_form(form_type) or _redaction["form_positions"]
+
+
+def scrub_text(text):
+	"""Mask phone numbers, email addresses, and the like in text."""
+	if _redaction is None or not isinstance(text, str):
+		return text
+	for name in _redaction["patterns"]:
+		text = PATTERNS[name].sub(REDACTED, text)
+	return text
+
+
+def policy_action(form_type, name):
+	"""The policy's action for a field, or None if the policy doesn't cover it."""
+	for form_glob, rules in _redaction["policy"].items():
+		if fnmatch.fnmatch((form_type or "").lower(), form_glob.lower()):
+			for field_glob, action in rules.items():
+				if fnmatch.fnmatch(name.lower(), field_glob.lower()):
+					return action
+			return None
+	return None
+
+
+def hash_value(value):
+	"""A short hash of value, the same for the same value and salt."""
+	digest = hashlib.sha256((_redaction["salt"] + "\0" + str(value).strip().lower()).encode("utf-8"))
+	return digest.hexdigest()[:HASH_LENGTH]
+
+
+def scrub_fields(form_type, fields):
+	"""A copy of a form's fields with the policy applied, personal ones removed, and the rest scrubbed."""
+	if _redaction is None:
+		return dict(fields)
+	scrubbed = {}
+	for name, value in fields.items():
+		action = policy_action(form_type, name)
+		if action == DROP:
+			continue
+		if action == KEEP:
+			scrubbed[name] = value
+		elif action == HASH:
+			scrubbed[name] = hash_value(value) if value is not None else None
+		elif action == REDACT:
+			scrubbed[name] = REDACTED if value is not None else None
+		elif not exports_form_positions(form_type) and WinlinkForm.is_position_variable(form_type, name):
+			continue
+		elif redacts_form(form_type) and any(fnmatch.fnmatch(name.lower(), glob.lower()) for glob in _redaction["fields"]):
+			scrubbed[name] = REDACTED if value is not None else None
+		else:
+			scrubbed[name] = scrub_text(value)
+	return scrubbed
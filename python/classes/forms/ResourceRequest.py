@@ -0,0 +1,106 @@
+#!/usr/bin/env python
+'''ICS-213RR resource requests and whether a reply has filled them'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+from classes.b2f.MessageThreads import is_reply_subject, subject_key
+
+# Form types of the Winlink standard-template resource request
+RESOURCE_REQUEST_FORM_TYPES = {"ICS213RR", "ICS213RR_Initial", "ICS 213RR"}
+
+# Request-level fields and the template variables they may be found in, first match wins.  An ICS-213's
+# fm_name is who sent the message, not who needs the resources, so it isn't taken for requested_by.
+REQUEST_FIELDS = {
+	"incident": ["incident_name", "incname"],
+	"request_number": ["request_number", "reqnum", "order_num", "ordernum"],
+	"requested_by": ["requested_by", "reqby"],
+	"priority": ["priority"],
+	"deliver_to": ["deliver_to", "deliverto", "delivery_location", "deliveryloc"],
+	"substitutes": ["substitutes", "subs"],
+}
+
+# Line items are numbered variables such as qty1, kind1, item1, reqdate1 or qty_1
+ITEM_VARIABLE = re.compile(r"^(?P<field>[A-Za-z]+?)_?(?P<row>\d+)$")
+
+OPEN = "open"
+FILLED = "filled"
+
+STATUS_SYMBOLOGY = {
+	OPEN: {"marker-color": "#ff3300", "marker-symbol": "warehouse"},
+	FILLED: {"marker-color": "#2ca02c", "marker-symbol": "warehouse"},
+}
+
+
+def is_resource_request(message):
+	return message.form is not None and message.form.form_type in RESOURCE_REQUEST_FORM_TYPES
+
+
+class ResourceRequest:
+	"""A resource request and the reply that filled it, if any."""
+
+	def __init__(self, message):
+		self.message = message
+		variables = message.form.variables
+		self.fields = {}
+		self.sources = {}  # Field -> the template variable it was read from, for the redaction policy
+		for name, candidates in REQUEST_FIELDS.items():
+			source = next((c for c in candidates if variables.get(c)), None)
+			self.fields[name] = variables[source] if source else None
+			self.sources[name] = source
+		self.items = self._items(variables)
+		self.reply = None  # B2Message that answered the request
+
+	@staticmethod
+	def _items(variables):
+		"""Numbered line items as a list of dicts, in row order, leaving out empty rows."""
+		rows = {}
+		for name, value in variables.items():
+			match = ITEM_VARIABLE.match(name)
+			if match and value:
+				rows.setdefault(int(match.group("row")), {})[match.group("field").lower()] = value
+		return [rows[row] for row in sorted(rows)]
+
+	@property
+	def status(self):
+		return FILLED if self.reply is not None else OPEN
+
+	def is_reply(self, message):
+		"""True if message answers this request: it names the request's MID, or it comes back from a
+		different station with the same subject."""
+		request = self.message
+		if message is request or message.date < request.date:
+			return False
+		if request.mid and request.mid in (message.subject or "") + (message.body or ""):
+			return True
+		if (message.sender or "").upper() == (request.sender or "").upper():
+			return False
+		return subject_key(message.subject) == subject_key(request.subject) and is_reply_subject(message.subject)
+
+	def as_dict(self):
+		request = self.message
+		return dict(self.fields, **{
+			"mid": request.mid,
+			"sender": request.sender,
+			"recipient": request.recipient,
+			"subject": request.subject,
+			"date": request.date.isoformat(),
+			"items": self.items,
+			"status": self.status,
+			"filled_by": self.reply.mid if self.reply is not None else None,
+			"filled_date": self.reply.date.isoformat() if self.reply is not None else None,
+		})
+
+
+def track_requests(messages):
+	"""Resource requests among messages, each matched with the earliest later message that replies to it."""
+	requests = [ResourceRequest(m) for m in sorted(messages, key=lambda m: m.date) if is_resource_request(m)]
+	replies = sorted((m for m in messages if not is_resource_request(m) or is_reply_subject(m.subject)), key=lambda m: m.date)
+	for request in requests:
+		request.reply = next((m for m in replies if request.is_reply(m)), None)
+	return requests
@@ -0,0 +1,110 @@
+#!/usr/bin/env python
+'''American Red Cross shelter status reports and occupancy-based map styling'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+
+# Form types of shelter status templates.  Others with "shelter" in the name are accepted too.
+SHELTER_FORM_TYPES = {"ARC_Shelter_Status", "Shelter_Status", "Red_Cross_Shelter_Status", "ARC_Shelter_Report"}
+
+# Fields and the template variables they may be found in, first match wins.  Only names that mean
+# the field in a shelter form are listed; a generic name, such as a bare name or address, may just as
+# well be the sender's.  Templates that name a field otherwise are given in mappings.json.
+SHELTER_FIELDS = {
+	"shelter": ["shelter_name", "sheltername", "facility_name"],
+	"address": ["shelter_address"],
+	"status": ["shelter_status", "open_closed"],
+	"manager": ["shelter_manager", "manager", "contact_name"],
+	"phone": ["shelter_phone", "contact_phone"],
+}
+NUMBER_FIELDS = {
+	"capacity": ["capacity", "shelter_capacity", "max_capacity", "total_capacity"],
+	"occupancy": ["occupancy", "population", "current_population", "census", "clients", "residents"],
+	"meals": ["meals", "meals_served"],
+	"staff": ["staff", "workers", "staff_count"],
+}
+
+# Occupancy as a fraction of capacity -> style, checked in order; the last entry catches the rest
+OCCUPANCY_SYMBOLOGY = [
+	(0.75, {"marker-color": "#2ca02c", "marker-symbol": "lodging"}),
+	(0.95, {"marker-color": "#ffaa00", "marker-symbol": "lodging"}),
+	(None, {"marker-color": "#ff3300", "marker-symbol": "lodging"}),
+]
+UNKNOWN_SYMBOLOGY = {"marker-color": "#888888", "marker-symbol": "lodging"}
+
+
+def is_shelter_report(message):
+	if message.form is None:
+		return False
+	form_type = message.form.form_type
+	return form_type in SHELTER_FORM_TYPES or "shelter" in form_type.lower()
+
+
+def _number(text):
+	"""The first integer in text such as "120" or "120 cots", or None."""
+	match = re.search(r"\d[\d,]*", text or "")
+	return int(match.group(0).replace(",", "")) if match else None
+
+
+class ShelterReport:
+	"""One shelter status report."""
+
+	def __init__(self, message):
+		self.message = message
+		variables = message.form.variables
+		self.fields = {}
+		self.sources = {}  # Field -> the template variable it was read from, for the redaction policy
+		for name, candidates in SHELTER_FIELDS.items():
+			source = next((c for c in candidates if variables.get(c)), None)
+			self.fields[name] = variables[source] if source else None
+			self.sources[name] = source
+		for name, candidates in NUMBER_FIELDS.items():
+			self.fields[name] = next((n for n in (_number(variables.get(c)) for c in candidates) if n is not None), None)
+
+	@property
+	def name(self):
+		return self.fields["shelter"] or self.message.sender
+
+	def occupancy_ratio(self):
+		"""Occupancy over capacity, or None if either is missing."""
+		capacity, occupancy = self.fields["capacity"], self.fields["occupancy"]
+		if not capacity or occupancy is None:
+			return None
+		return occupancy / capacity
+
+	def symbology(self):
+		ratio = self.occupancy_ratio()
+		if ratio is None:
+			return dict(UNKNOWN_SYMBOLOGY)
+		for limit, style in OCCUPANCY_SYMBOLOGY:
+			if limit is None or ratio < limit:
+				return dict(style)
+
+	def as_dict(self):
+		ratio = self.occupancy_ratio()
+		return dict(self.fields, **{
+			"mid": self.message.mid,
+			"sender": self.message.sender,
+			"date": self.message.date.isoformat(),
+			"occupancy_pct": round(ratio * 100, 1) if ratio is not None else None,
+			"available": self.fields["capacity"] - self.fields["occupancy"] if ratio is not None else None,
+		})
+
+
+def latest_shelters(messages):
+	"""The most recent report from each shelter, keyed by shelter name, in name order."""
+	latest = {}
+	for message in messages:
+		if not is_shelter_report(message):
+			continue
+		report = ShelterReport(message)
+		key = report.name.upper()
+		if key not in latest or message.date > latest[key].message.date:
+			latest[key] = report
+	return [latest[key] for key in sorted(latest)]
@@ -0,0 +1,148 @@
+#!/usr/bin/env python
+'''Reads the Winlink Standard Templates ZIP and drafts the form mappings from its field names'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import html.parser
+import os
+import re
+import zipfile
+from classes.forms import TemplateVersions
+from classes.forms.WinlinkForm import find_position_variables
+
+TEMPLATE_SUFFIX = ".txt"
+FORM_SUFFIX = ".html"
+VIEWER_SUFFIX = "_viewer.html"
+FORM_LINE = re.compile(r"^\s*Form\s*:\s*(.+?)\s*$", re.IGNORECASE | re.MULTILINE)  # Form: ICS213_Initial.html,ICS213_Viewer.html
+TEXT_VARIABLE = re.compile(r"<var\s+([^>\s]+)\s*>", re.IGNORECASE)  # <var to_name> in a .txt template
+VIEWER_VARIABLE = re.compile(r"\{var\s+([^}\s]+)\s*\}", re.IGNORECASE)  # {var to_name} in a viewer
+FIELD_TAGS = ("input", "select", "textarea")
+NOT_FIELDS = ("submit", "button", "reset", "image", "file")  # Input types that send no variable
+
+# The mappings.json settings drafted from a pack, each keyed by form type
+GENERATED_SETTINGS = ("required_variables", "positions", "template_versions")
+
+
+class _FormFields(html.parser.HTMLParser):
+	"""The fields of an HTML form: field names in order, those marked required, and hidden values."""
+
+	def __init__(self):
+		super().__init__(convert_charrefs=True)
+		self.fields = []
+		self.required = []
+		self.values = {}
+
+	def handle_starttag(self, tag, attrs):
+		if tag not in FIELD_TAGS:
+			return
+		attributes = {name.lower(): value for name, value in attrs}
+		name = (attributes.get("name") or "").strip().lower()
+		if not name or (tag == "input" and (attributes.get("type") or "").lower() in NOT_FIELDS):
+			return
+		if name not in self.fields:
+			self.fields.append(name)
+		if "required" in attributes and name not in self.required:
+			self.required.append(name)
+		if attributes.get("value"):
+			self.values.setdefault(name, attributes["value"].strip())
+
+	handle_startendtag = handle_starttag
+
+
+def _text(data):
+	return data.decode("utf-8", errors="replace")
+
+
+def read_pack(filename):
+	"""The forms in a Standard Templates ZIP, sorted by form type.  Each is a dict with the form_type
+	Winlink Express records in its XML (its viewer's name without _Viewer.html), the template, the
+	fields in the order the form asks for them, the required ones, and the declared version or None.
+	Templates that send text only, with no HTML form, carry no form XML and are left out.  Raises
+	OSError or ValueError if the file can't be read as a ZIP."""
+	try:
+		with zipfile.ZipFile(filename) as pack:
+			files = {}
+			for entry in pack.infolist():
+				if not entry.is_dir():
+					files.setdefault(os.path.basename(entry.filename).lower(), entry.filename)
+			read = {name: pack.read(path) for name, path in files.items() if name.endswith((TEMPLATE_SUFFIX, FORM_SUFFIX))}
+	except zipfile.BadZipFile as e:
+		raise ValueError(f"{filename}: {e}")
+
+	forms = {}
+	for name in sorted(read):
+		if not name.endswith(TEMPLATE_SUFFIX):
+			continue
+		template = _text(read[name])
+		line = FORM_LINE.search(template)
+		pages = [os.path.basename(p.strip()).lower() for p in line.group(1).split(",")] if line else []
+		pages = [p for p in pages if p.endswith(FORM_SUFFIX)]
+		if not pages:
+			continue
+		viewers = [p for p in pages if p.endswith(VIEWER_SUFFIX)]
+		entries = [p for p in pages if not p.endswith(VIEWER_SUFFIX)]
+		form_page = os.path.basename(files.get(entries[0], entries[0])) if entries else None
+		viewer_page = os.path.basename(files.get(viewers[0], viewers[0])) if viewers else None
+		form_type = viewer_page[:-len(VIEWER_SUFFIX)] if viewer_page else form_page[:-len(FORM_SUFFIX)]
+		if form_type in forms:
+			continue  # The first template naming a form wins, as for FormViewer
+		parser = _FormFields()
+		for page in entries:
+			if page in read:
+				parser.feed(_text(read[page]))
+		# Fields the text template or the viewer use but the form sets from script
+		fields = list(parser.fields)
+		mentioned = TEXT_VARIABLE.findall(template)
+		for page in viewers:
+			mentioned += VIEWER_VARIABLE.findall(_text(read.get(page, b"")))
+		for variable in mentioned:
+			if variable.lower() not in fields:
+				fields.append(variable.lower())
+		version = next((parser.values[v] for v in TemplateVersions.VERSION_VARIABLES if parser.values.get(v)), None)
+		forms[form_type] = {
+			"form_type": form_type,
+			"template": os.path.basename(files[name]),
+			"form": form_page,
+			"viewer": viewer_page,
+			"fields": fields,
+			"required": parser.required,
+			"version": version,
+		}
+	return [forms[form_type] for form_type in sorted(forms)]
+
+
+def draft_mappings(forms):
+	"""The mappings.json settings for the forms from read_pack: required_variables for forms that mark
+	fields required, positions for forms with latitude/longitude field pairs, and template_versions
+	for forms that declare a version."""
+	mappings = {setting: {} for setting in GENERATED_SETTINGS}
+	for form in forms:
+		if form["required"]:
+			mappings["required_variables"][form["form_type"]] = list(form["required"])
+		pairs = find_position_variables(form["fields"])
+		if pairs:
+			mappings["positions"][form["form_type"]] = pairs
+		if form["version"]:
+			mappings["template_versions"][form["form_type"]] = [form["version"]]
+	return {setting: entries for setting, entries in mappings.items() if entries}
+
+
+def merge_mappings(existing, drafted):
+	"""existing mappings.json settings with the drafted ones laid over them.  A drafted form type replaces
+	that form type's entry, since its fields are those of the current templates; other form types and
+	settings are kept.  template_versions add the new version to those already listed, so traffic from
+	stations still on an older template isn't flagged."""
+	merged = dict(existing)
+	for setting, entries in drafted.items():
+		table = dict(merged.get(setting) or {})
+		for form_type, value in entries.items():
+			if setting == "template_versions":
+				value = list(table.get(form_type) or []) + [v for v in value if v not in (table.get(form_type) or [])]
+			table[form_type] = value
+		merged[setting] = dict(sorted(table.items()))
+	return merged
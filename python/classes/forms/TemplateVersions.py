@@ -0,0 +1,115 @@
+#!/usr/bin/env python
+'''Which versions of each form template the mappings were written for, and how received traffic compares'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+
+# Form variables (or parameters) in which templates declare their version, e.g. <templateversion>ICS 213 v3.2</templateversion>
+VERSION_VARIABLES = ["templateversion", "template_version", "form_version", "version"]
+VERSION_NUMBER = re.compile(r"\d+(?:\.\d+)*")
+MARKED_VERSION = re.compile(r"\bv(?:er(?:sion)?)?\.?\s*(\d+(?:\.\d+)*)", re.IGNORECASE)  # The 3.2 in "ICS 213 v3.2", not the 213
+
+# The template versions the mappings were written for, keyed by form type, e.g. {"ICS213_Initial": ["3.1", "3.2"]}.
+# Form types not listed here aren't checked.
+TEMPLATE_VERSIONS = {
+}
+
+KNOWN = "known"  # One of the listed versions
+NEWER = "newer"  # Later than every listed version; fields may have been added or renamed
+UNKNOWN = "unknown"  # Not listed, and not later than them all
+UNDECLARED = "undeclared"  # The form doesn't say which version it is
+UNTRACKED = "untracked"  # No versions are listed for the form type
+WARNING_STATUSES = (NEWER, UNKNOWN)
+
+
+def declared_version(form):
+	"""The template version a form declares, as written, or None."""
+	for values in (form.variables, form.parameters):
+		lowered = {name.lower(): value for name, value in values.items()}
+		for name in VERSION_VARIABLES:
+			if lowered.get(name):
+				return lowered[name]
+	return None
+
+
+def normalize(version):
+	"""The version number in a declared version, such as 3.2 from "ICS 213 v3.2", or the text itself if it has none."""
+	marked = MARKED_VERSION.search(version)
+	if marked:
+		return marked.group(1)
+	numbers = VERSION_NUMBER.findall(version)
+	return numbers[-1] if numbers else version.strip()
+
+
+def _key(version):
+	return tuple(int(part) for part in version.split(".")) if VERSION_NUMBER.fullmatch(version) else None
+
+
+def status(form_type, version):
+	"""How a form's declared version compares with the versions listed for its type: one of KNOWN,
+	NEWER, UNKNOWN, UNDECLARED, or UNTRACKED."""
+	listed = [normalize(v) for v in TEMPLATE_VERSIONS.get(form_type) or []]
+	if not listed:
+		return UNTRACKED
+	if version is None:
+		return UNDECLARED
+	version = normalize(version)
+	if version in listed:
+		return KNOWN
+	key = _key(version)
+	keys = [_key(v) for v in listed]
+	if key is not None and all(k is not None and key > k for k in keys):
+		return NEWER
+	return UNKNOWN
+
+
+def check(form):
+	"""Warnings for a form whose template is newer than, or otherwise not among, the versions its mappings
+	were written for."""
+	version = declared_version(form)
+	result = status(form.form_type, version)
+	if result not in WARNING_STATUSES:
+		return []
+	listed = ", ".join(TEMPLATE_VERSIONS[form.form_type])
+	if result == NEWER:
+		return [f"Form {form.form_type}: template version {version} is newer than the mappings (written for {listed})"]
+	return [f"Form {form.form_type}: template version {version} is not one the mappings were written for ({listed})"]
+
+
+def matrix(messages):
+	"""One row per form type and declared version seen in messages: how many messages used it, when, and how
+	it compares with the versions listed for the type.  Sorted by form type, then version."""
+	rows = {}
+	for message in messages:
+		if message.form is None:
+			continue
+		version = declared_version(message.form)
+		key = (message.form.form_type or "", normalize(version) if version is not None else "")
+		row = rows.get(key)
+		if row is None:
+			row = rows[key] = {
+				"form_type": message.form.form_type,
+				"version": key[1] or None,
+				"status": status(message.form.form_type, version),
+				"supported": list(TEMPLATE_VERSIONS.get(message.form.form_type) or []),
+				"messages": 0,
+				"senders": set(),
+				"first": message.date,
+				"last": message.date,
+			}
+		row["messages"] += 1
+		if message.sender:
+			row["senders"].add(message.sender)
+		row["first"] = min(row["first"], message.date)
+		row["last"] = max(row["last"], message.date)
+	result = []
+	for key in sorted(rows, key=lambda k: (k[0], _key(k[1]) or (), k[1])):
+		row = rows[key]
+		result.append(dict(row, senders=sorted(row["senders"]), first=row["first"].isoformat(), last=row["last"].isoformat()))
+	return result
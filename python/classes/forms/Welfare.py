@@ -0,0 +1,56 @@
+#!/usr/bin/env python
+'''Health and welfare inquiry forms'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+# Form types of welfare templates.  Others with "welfare" in the name are accepted too.
+WELFARE_FORM_TYPES = {"Welfare_Inquiry", "Welfare_Message", "Health_And_Welfare", "HW_Inquiry"}
+
+# Fields and the template variables they may be found in, first match wins.  Bare names such as name,
+# address, and phone aren't listed: a form may use them for either person, and guessing wrong would
+# swap the person asked about with the one asking.  Other names are given in mappings.json.
+WELFARE_FIELDS = {
+	"subject_name": ["subject_name", "person_name", "missing_name"],
+	"last_known_location": ["last_known_location", "last_location", "subject_address"],
+	"inquirer_name": ["inquirer_name", "requestor_name"],
+	"inquirer_phone": ["inquirer_phone", "requestor_phone", "callback_phone"],
+	"relationship": ["relationship"],
+	"status": ["status", "welfare_status", "condition"],
+	"message": ["message", "comments", "remarks"],
+}
+
+
+def is_welfare_form(form_type):
+	return form_type in WELFARE_FORM_TYPES or "welfare" in (form_type or "").lower()
+
+
+def is_welfare_inquiry(message):
+	return message.form is not None and is_welfare_form(message.form.form_type)
+
+
+class WelfareInquiry:
+	"""One welfare inquiry or reply."""
+
+	def __init__(self, message):
+		self.message = message
+		variables = message.form.variables
+		self.fields = {}
+		self.sources = {}  # Field -> the template variable it was read from, for the redaction policy
+		for name, candidates in WELFARE_FIELDS.items():
+			source = next((c for c in candidates if variables.get(c)), None)
+			self.fields[name] = variables[source] if source else None
+			self.sources[name] = source
+
+	def as_dict(self):
+		return dict(self.fields, **{
+			"mid": self.message.mid,
+			"sender": self.message.sender,
+			"recipient": self.message.recipient,
+			"date": self.message.date.isoformat(),
+			"form_type": self.message.form.form_type,
+		})
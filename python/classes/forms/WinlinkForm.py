@@ -0,0 +1,152 @@
+#!/usr/bin/env python
+'''Parses the RMS Express form XML carried as a message attachment'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import re
+import xml.etree.ElementTree as ET
+from classes.forms import TemplateVersions
+from classes.b2f.WinlinkTime import parse_timestamp
+
+FORM_ATTACHMENT_PREFIX = "RMS_Express_Form_"
+FORM_ATTACHMENT_SUFFIX = ".xml"
+
+# Variables every form is expected to carry in its <form_parameters> section
+REQUIRED_PARAMETERS = ["display_form", "senders_callsign", "submission_datetime"]
+
+# Additional <variables> that must be present, keyed by form type.  Form types not listed
+# here are only checked against REQUIRED_PARAMETERS.
+REQUIRED_VARIABLES = {
+	"ICS213_Initial": ["to_name", "fm_name", "subjectline", "message"],
+}
+
+DEFAULT_POSITION_ROLE = "form"  # Role for an unprefixed latitude/longitude pair
+_FORM_LOCATION = [{"role": DEFAULT_POSITION_ROLE, "latitude": "latitude", "longitude": "longitude"}]
+
+# Locations carried in form variables, keyed by form type.  Each entry names a role and the
+# variables holding its latitude and longitude in decimal degrees.  Forms not listed here are
+# searched for variable pairs named <role>_lat/<role>_lon or <role>_latitude/<role>_longitude.
+FORM_POSITIONS = {
+	"ICS213_Initial": _FORM_LOCATION,
+	"ICS213RR": _FORM_LOCATION,
+	"Winlink_Check_In": _FORM_LOCATION,
+	"ARC_Shelter_Status": _FORM_LOCATION,
+}
+
+# A latitude or longitude variable: the axis alone, or after a role and an underscore, as in site_lat.
+# map and gps may be run together with the axis (maplat, gpslon) and are the form's own location.
+POSITION_VARIABLE = re.compile(r"^(?:(?P<role>[a-z0-9]+(?:_[a-z0-9]+)*)_|map|gps)?(?P<axis>lat|latitude|lon|long|longitude)$", re.IGNORECASE)
+
+
+def find_position_variables(names):
+	"""Pair up variable names that look like latitudes and longitudes by their common prefix, as
+	[{"role", "latitude", "longitude"}] sorted by role."""
+	found = {}
+	for name in names:
+		match = POSITION_VARIABLE.match(name)
+		if match:
+			role = (match.group("role") or DEFAULT_POSITION_ROLE).lower()
+			axis = "latitude" if match.group("axis").lower().startswith("lat") else "longitude"
+			found.setdefault(role, {})[axis] = name
+	return [dict(role=role, **axes) for role, axes in sorted(found.items()) if len(axes) == 2]
+
+
+class WinlinkForm:
+	"""Class to represent the contents of a Winlink form attachment."""
+
+	def __init__(self, filename, data, enable_debug=False):
+		"""Initialize the form from the attachment name and its raw XML."""
+		self.enable_debug = enable_debug
+		self.filename = filename  # Name of the attachment the form came from
+		self.data = data  # Raw XML bytes
+		self.form_type = None  # e.g., "ICS213_Initial"
+		self.parameters = {}  # Contents of <form_parameters>
+		self.variables = {}  # Contents of <variables>
+		self.submission_time = None  # <submission_datetime> as an aware UTC datetime
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@staticmethod
+	def is_form_attachment(filename):
+		"""True if the attachment name follows the RMS Express form naming convention."""
+		return filename is not None and filename.startswith(FORM_ATTACHMENT_PREFIX) and filename.lower().endswith(FORM_ATTACHMENT_SUFFIX)
+
+	def parse(self):
+		"""Parse the XML into parameters and variables.  Raises ValueError if the XML is malformed."""
+		try:
+			root = ET.fromstring(self.data)
+		except ET.ParseError as e:
+			raise ValueError(f"Malformed form XML in {self.filename}: {e}")
+
+		parameters = root.find("form_parameters")
+		if parameters is not None:
+			self.parameters = {child.tag: (child.text or "").strip() for child in parameters}
+		variables = root.find("variables")
+		if variables is not None:
+			self.variables = {child.tag: (child.text or "").strip() for child in variables}
+
+		self.form_type = self._form_type()
+		self.submission_time = parse_timestamp(self.parameters.get("submission_datetime"))
+		self._log_debug(f"Form type is <{self.form_type}> with {len(self.variables)} variables")
+
+	def _form_type(self):
+		"""Derive the form type from the display form, falling back to the attachment name."""
+		name = self.parameters.get("display_form", "")
+		if not name:
+			name = self.filename[len(FORM_ATTACHMENT_PREFIX):]
+		name = name.rsplit(".", 1)[0]
+		if name.endswith("_Viewer"):
+			name = name[:-len("_Viewer")]
+		return name
+
+	@staticmethod
+	def is_position_variable(form_type, name):
+		"""True if the variable may hold a latitude or longitude: FORM_POSITIONS lists it for the form
+		type, or its name looks like one."""
+		pairs = FORM_POSITIONS.get(form_type) or []
+		return any(name in (pair["latitude"], pair["longitude"]) for pair in pairs) or POSITION_VARIABLE.match(name) is not None
+
+	def positions(self):
+		"""Locations in the form as a list of (role, latitude, longitude)."""
+		pairs = FORM_POSITIONS.get(self.form_type)
+		if pairs is None:
+			pairs = find_position_variables(self.variables)
+		positions = []
+		for pair in pairs:
+			try:
+				latitude = float(self.variables.get(pair["latitude"], ""))
+				longitude = float(self.variables.get(pair["longitude"], ""))
+			except ValueError:
+				continue
+			if -90.0 <= latitude <= 90.0 and -180.0 <= longitude <= 180.0 and (latitude != 0.0 or longitude != 0.0):
+				positions.append((pair["role"], latitude, longitude))
+		return positions
+
+	def validate(self):
+		"""Return a list of warnings for missing required parameters and variables."""
+		warnings = []
+		for name in REQUIRED_PARAMETERS:
+			if not self.parameters.get(name):
+				warnings.append(f"Form {self.form_type}: missing parameter {name}")
+		for name in REQUIRED_VARIABLES.get(self.form_type, []):
+			if not self.variables.get(name):
+				warnings.append(f"Form {self.form_type}: missing field {name}")
+		warnings.extend(TemplateVersions.check(self))
+		return warnings
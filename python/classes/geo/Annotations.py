@@ -0,0 +1,180 @@
+#!/usr/bin/env python
+'''Notes, lines, and areas net control draws on the map, kept with the mailbox and exported with the traffic'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import os
+import threading
+from classes.geo import Geo
+from classes.b2f.WinlinkTime import utc_now
+
+ANNOTATIONS_FILE_NAME = "annotations.json"  # In the mailbox folder
+ANNOTATION_ROLE = "annotation"
+
+NOTE = "note"  # A point with text, e.g. a staging area
+LINE = "line"  # A route or boundary, measured by length
+AREA = "area"  # A search sector or assignment, measured by area and perimeter
+KINDS = {NOTE: ("Point", 1), LINE: ("LineString", 2), AREA: ("Polygon", 3)}  # kind: (geometry, fewest points)
+
+# How annotations are drawn, in simplestyle; styles.json "annotations" adjusts these
+ANNOTATION_SYMBOLOGY = {
+	"marker-color": "#9467bd",
+	"marker-symbol": "star",
+	"stroke": "#9467bd",
+	"stroke-width": 3,
+	"fill": "#9467bd",
+	"fill-opacity": 0.15,
+}
+
+_store = None  # The AnnotationStore exports include; None leaves annotations out
+
+
+def measure(kind, points):
+	"""Measurements of (lat, lon) points drawn as kind: a line's length, an area's size and perimeter."""
+	if kind == LINE:
+		length = Geo.path_length_km(points)
+		return {"length_km": round(length, 3), "length_mi": round(length / Geo.KM_PER_MILE, 3)}
+	if kind == AREA:
+		perimeter = Geo.path_length_km(points + points[:1])
+		area = Geo.polygon_area_km2(points)
+		return {"area_km2": round(area, 4), "area_mi2": round(area / Geo.KM_PER_MILE ** 2, 4), "perimeter_km": round(perimeter, 3)}
+	return {}
+
+
+def _geometry(kind, points):
+	coordinates = [[round(lon, 6), round(lat, 6)] for lat, lon in points]
+	if kind == NOTE:
+		return {"type": "Point", "coordinates": coordinates[0]}
+	if kind == LINE:
+		return {"type": "LineString", "coordinates": coordinates}
+	return {"type": "Polygon", "coordinates": [coordinates + coordinates[:1]]}
+
+
+class AnnotationStore:
+	"""Annotations in a JSON file: {"next": n, "annotations": [{"id", "kind", "points", "label", ...}]}.
+	The file is read afresh each time, so exports pick up annotations added while they run."""
+
+	def __init__(self, filename, enable_debug=False):
+		self.filename = filename
+		self.enable_debug = enable_debug
+		self.lock = threading.Lock()
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _read(self):
+		try:
+			with open(self.filename, 'r') as f:
+				data = json.load(f)
+		except FileNotFoundError:
+			return {"next": 1, "annotations": []}
+		except ValueError as e:
+			raise ValueError(f"{self.filename}: {e}")
+		if not isinstance(data, dict) or not isinstance(data.get("annotations"), list):
+			raise ValueError(f"{self.filename}: expected {{\"annotations\": [...]}}")
+		return data
+
+	def _write(self, data):
+		folder = os.path.dirname(self.filename)
+		if folder:
+			os.makedirs(folder, exist_ok=True)
+		temporary = f"{self.filename}.tmp"
+		with open(temporary, 'w') as f:
+			json.dump(data, f, indent=4)
+		os.replace(temporary, self.filename)
+
+	def entries(self):
+		"""Every annotation, oldest first.  Raises ValueError if the file is damaged."""
+		with self.lock:
+			return self._read()["annotations"]
+
+	def add(self, kind, points, label=None, note=None, author=None):
+		"""Save an annotation of (lat, lon) points and return it.  Raises ValueError if the points don't
+		make a kind."""
+		if kind not in KINDS:
+			raise ValueError(f"Unknown annotation kind {kind}; expected one of {', '.join(KINDS)}")
+		fewest = KINDS[kind][1]
+		if len(points) < fewest or (kind == NOTE and len(points) != 1):
+			raise ValueError(f"A {kind} needs {'one point' if kind == NOTE else f'at least {fewest} points'}")
+		with self.lock:
+			data = self._read()
+			entry = {
+				"id": f"A{data.get('next', 1)}",
+				"kind": kind,
+				"points": [[lat, lon] for lat, lon in points],
+				"label": label,
+				"note": note,
+				"author": author,
+				"created": utc_now().isoformat(timespec="seconds"),
+			}
+			data["next"] = data.get("next", 1) + 1
+			data["annotations"].append(entry)
+			self._write(data)
+		self._log_debug(f"Added {kind} {entry['id']} to {self.filename}")
+		return entry
+
+	def remove(self, annotation_id):
+		"""Delete an annotation by id.  Returns it, or None if there is no such annotation."""
+		with self.lock:
+			data = self._read()
+			found = next((a for a in data["annotations"] if a["id"].upper() == annotation_id.upper()), None)
+			if found is not None:
+				data["annotations"].remove(found)
+				self._write(data)
+		return found
+
+	def features(self):
+		"""One GeoJSON feature per annotation, with its measurements and style."""
+		features = []
+		for entry in self.entries():
+			points = [tuple(p) for p in entry["points"]]
+			properties = {
+				"role": ANNOTATION_ROLE,
+				"kind": entry["kind"],
+				"label": entry.get("label"),
+				"note": entry.get("note"),
+				"author": entry.get("author"),
+				"date": entry.get("created"),
+			}
+			properties.update(measure(entry["kind"], points))
+			properties.update(ANNOTATION_SYMBOLOGY)
+			features.append({"type": "Feature", "id": f"annotation-{entry['id']}", "geometry": _geometry(entry["kind"], points), "properties": properties})
+		return features
+
+
+def set_store(store):
+	"""Include store's annotations in exports from now on; None leaves them out."""
+	global _store
+	_store = store
+
+
+def current_store():
+	return _store
+
+
+def features():
+	"""Features for the annotations exports include: none if no store is set, or if its file can't be read."""
+	if _store is None:
+		return []
+	try:
+		return _store.features()
+	except (OSError, ValueError) as e:
+		_store.logger.error(f"Leaving annotations out of the export: {e}")
+		return []
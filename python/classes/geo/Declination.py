@@ -0,0 +1,142 @@
+#!/usr/bin/env python
+'''Magnetic declination from a World Magnetic Model coefficient file'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import math
+from datetime import datetime, timezone
+
+# WGS 84 ellipsoid and the model's reference radius, in km
+WGS84_A = 6378.137
+WGS84_B = 6356.7523142
+WMM_REFERENCE_RADIUS = 6371.2
+
+VALID_YEARS = 5.0  # Each model release is good for five years from its epoch
+
+# NOAA publishes the model as WMM.COF (https://www.ncei.noaa.gov/products/world-magnetic-model).
+# The first line holds the epoch and model name, each following line "n m g h g_dot h_dot",
+# and a line of 9s ends the coefficients.
+
+
+def decimal_year(when):
+	"""A datetime as a fractional year, e.g. 2025.5 for early July 2025."""
+	start = datetime(when.year, 1, 1, tzinfo=when.tzinfo)
+	end = datetime(when.year + 1, 1, 1, tzinfo=when.tzinfo)
+	return when.year + (when - start).total_seconds() / (end - start).total_seconds()
+
+
+class MagneticModel:
+	"""Spherical harmonic coefficients of a World Magnetic Model release."""
+
+	def __init__(self, filename, enable_debug=False):
+		self.filename = filename
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+		self.epoch, self.name, self.degree, self.g, self.h, self.g_dot, self.h_dot = self._load(filename)
+		self._log_debug(f"Loaded {self.name} (epoch {self.epoch}, degree {self.degree}) from {filename}")
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@staticmethod
+	def _load(filename):
+		"""Parse a .COF file.  Raises ValueError if it is malformed."""
+		with open(filename, 'r') as f:
+			lines = [line.split() for line in f if line.strip()]
+		if not lines or len(lines[0]) < 2:
+			raise ValueError(f"{filename}: missing the epoch line")
+		epoch, name = float(lines[0][0]), lines[0][1]
+		terms = []
+		for fields in lines[1:]:
+			if fields[0].startswith("9999"):
+				break
+			if len(fields) != 6:
+				raise ValueError(f"{filename}: expected n m g h g_dot h_dot but got <{' '.join(fields)}>")
+			terms.append((int(fields[0]), int(fields[1])) + tuple(float(v) for v in fields[2:]))
+		if not terms:
+			raise ValueError(f"{filename}: no coefficients")
+		degree = max(n for n, _, _, _, _, _ in terms)
+		shape = [[0.0] * (degree + 1) for _ in range(degree + 1)]
+		g, h, g_dot, h_dot = ([row[:] for row in shape] for _ in range(4))
+		for n, m, gnm, hnm, gnm_dot, hnm_dot in terms:
+			g[n][m], h[n][m], g_dot[n][m], h_dot[n][m] = gnm, hnm, gnm_dot, hnm_dot
+		return epoch, name, degree, g, h, g_dot, h_dot
+
+	def field(self, latitude, longitude, height_km=0.0, when=None):
+		"""North, east, and down components of the field in nT at a geodetic position and time."""
+		when = when or datetime.now(timezone.utc)
+		dt = decimal_year(when) - self.epoch
+		if not (0.0 <= dt <= VALID_YEARS):
+			self.logger.warning(f"{self.name} is valid {self.epoch:g}-{self.epoch + VALID_YEARS:g}; {when.date()} is outside that")
+
+		# Geodetic to geocentric (spherical) coordinates
+		phi = math.radians(latitude)
+		sin_phi, cos_phi = math.sin(phi), math.cos(phi)
+		a2, b2 = WGS84_A ** 2, WGS84_B ** 2
+		q = math.sqrt(a2 * cos_phi ** 2 + b2 * sin_phi ** 2)
+		q1 = height_km * q
+		q2 = ((q1 + a2) / (q1 + b2)) ** 2
+		cos_theta = sin_phi / math.sqrt(q2 * cos_phi ** 2 + sin_phi ** 2)
+		sin_theta = max(math.sqrt(1.0 - cos_theta ** 2), 1e-10)  # Keep the poles finite
+		r = math.sqrt(height_km ** 2 + 2.0 * q1 + (WGS84_A ** 4 - (WGS84_A ** 4 - WGS84_B ** 4) * sin_phi ** 2) / q ** 2)
+		d = math.sqrt(a2 * cos_phi ** 2 + b2 * sin_phi ** 2)
+		cos_psi = (height_km + d) / r  # psi: geodetic minus geocentric latitude
+		sin_psi = (a2 - b2) * cos_phi * sin_phi / (r * d)
+
+		# Schmidt semi-normalized associated Legendre functions and their theta derivatives
+		size = self.degree + 1
+		p = [[0.0] * size for _ in range(size)]
+		dp = [[0.0] * size for _ in range(size)]
+		schmidt = [[0.0] * size for _ in range(size)]
+		p[0][0], schmidt[0][0] = 1.0, 1.0
+		for n in range(1, size):
+			schmidt[n][0] = schmidt[n - 1][0] * (2 * n - 1) / n
+			for m in range(1, n + 1):
+				schmidt[n][m] = schmidt[n][m - 1] * math.sqrt((n - m + 1) * (2 if m == 1 else 1) / (n + m))
+			for m in range(n + 1):
+				if m == n:
+					p[n][m] = sin_theta * p[n - 1][m - 1]
+					dp[n][m] = sin_theta * dp[n - 1][m - 1] + cos_theta * p[n - 1][m - 1]
+				else:
+					k = ((n - 1) ** 2 - m ** 2) / ((2 * n - 1) * (2 * n - 3)) if n > 1 else 0.0
+					p[n][m] = cos_theta * p[n - 1][m] - (k * p[n - 2][m] if n > 1 else 0.0)
+					dp[n][m] = cos_theta * dp[n - 1][m] - sin_theta * p[n - 1][m] - (k * dp[n - 2][m] if n > 1 else 0.0)
+
+		lam = math.radians(longitude)
+		b_r = b_theta = b_phi = 0.0
+		for n in range(1, size):
+			ratio = (WMM_REFERENCE_RADIUS / r) ** (n + 2)
+			for m in range(n + 1):
+				g = self.g[n][m] + dt * self.g_dot[n][m]
+				h = self.h[n][m] + dt * self.h_dot[n][m]
+				cos_m, sin_m = math.cos(m * lam), math.sin(m * lam)
+				pnm, dpnm = schmidt[n][m] * p[n][m], schmidt[n][m] * dp[n][m]
+				b_r += (n + 1) * ratio * (g * cos_m + h * sin_m) * pnm
+				b_theta -= ratio * (g * cos_m + h * sin_m) * dpnm
+				b_phi -= ratio * m * (-g * sin_m + h * cos_m) * pnm / sin_theta
+
+		# Rotate from geocentric back to geodetic north and down
+		north = -b_theta * cos_psi - b_r * sin_psi
+		east = b_phi
+		down = b_theta * sin_psi - b_r * cos_psi
+		return north, east, down
+
+	def declination(self, latitude, longitude, height_km=0.0, when=None):
+		"""Degrees magnetic north lies east (positive) or west (negative) of true north."""
+		north, east, _ = self.field(latitude, longitude, height_km, when)
+		return math.degrees(math.atan2(east, north))
@@ -0,0 +1,129 @@
+#!/usr/bin/env python
+'''Looks up ground elevation from local SRTM .hgt tiles'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import contextlib
+import logging
+import math
+import os
+import struct
+import threading
+
+SRTM_VOID = -32768  # Sample value for "no data"
+SRTM_SIZES = {1201 * 1201 * 2: 1201, 3601 * 3601 * 2: 3601}  # File size -> samples per side (3" and 1")
+
+_model = None  # The ElevationModel exported features are given elevations from, per --dem; None leaves them out
+_override = threading.local()  # Model of products built on this thread inside using()
+
+
+def tile_name(lat, lon):
+	"""The SRTM tile covering a point, e.g. N37W123.hgt.  Tiles are named for their SW corner."""
+	south = math.floor(lat)
+	west = math.floor(lon)
+	return f"{'N' if south >= 0 else 'S'}{abs(south):02d}{'E' if west >= 0 else 'W'}{abs(west):03d}.hgt"
+
+
+class ElevationModel:
+	"""Elevation lookups against a folder of SRTM .hgt files."""
+
+	def __init__(self, folder, enable_debug=False):
+		self.folder = folder
+		self.enable_debug = enable_debug
+		self.tiles = {}  # Tile name -> (samples per side, data) or None if the tile is unavailable
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _tile(self, name):
+		if name not in self.tiles:
+			path = os.path.join(self.folder, name)
+			tile = None
+			try:
+				with open(path, 'rb') as f:
+					data = f.read()
+				size = SRTM_SIZES.get(len(data))
+				if size is None:
+					self.logger.warning(f"Ignoring {path}: unexpected size {len(data)}")
+				else:
+					tile = (size, data)
+					self._log_debug(f"Loaded {path} ({size}x{size})")
+			except FileNotFoundError:
+				self._log_debug(f"No tile {path}")
+			self.tiles[name] = tile
+		return self.tiles[name]
+
+	def _sample(self, size, data, row, column):
+		row = min(max(row, 0), size - 1)
+		column = min(max(column, 0), size - 1)
+		value = struct.unpack_from(">h", data, (row * size + column) * 2)[0]
+		return None if value == SRTM_VOID else value
+
+	def elevation(self, lat, lon):
+		"""Elevation in meters at a point, interpolated between samples, or None if not covered."""
+		tile = self._tile(tile_name(lat, lon))
+		if tile is None:
+			return None
+		size, data = tile
+		# Row 0 is the north edge of the tile, column 0 the west edge
+		y = (math.floor(lat) + 1 - lat) * (size - 1)
+		x = (lon - math.floor(lon)) * (size - 1)
+		row, column = int(y), int(x)
+		dy, dx = y - row, x - column
+		corners = [
+			self._sample(size, data, row, column),
+			self._sample(size, data, row, column + 1),
+			self._sample(size, data, row + 1, column),
+			self._sample(size, data, row + 1, column + 1),
+		]
+		if any(c is None for c in corners):
+			valid = [c for c in corners if c is not None]
+			return float(valid[0]) if valid else None
+		top = corners[0] * (1 - dx) + corners[1] * dx
+		bottom = corners[2] * (1 - dx) + corners[3] * dx
+		return top * (1 - dy) + bottom * dy
+
+
+def set_model(model):
+	"""Give exported features their ground elevation from model from now on; None leaves it out."""
+	global _model
+	_model = model
+
+
+def current_model():
+	return getattr(_override, "model", None) or _model
+
+
+@contextlib.contextmanager
+def using(model):
+	"""Build products with elevations from model on this thread for the duration, e.g. one export job's.
+	None keeps the current model."""
+	previous = getattr(_override, "model", None)
+	_override.model = model if model is not None else previous
+	try:
+		yield
+	finally:
+		_override.model = previous
+
+
+def elevation_m(lat, lon):
+	"""Ground elevation at a point from the current model, in meters to 0.1 m, or None if there is no
+	model or it doesn't cover the point."""
+	model = current_model()
+	elevation = model.elevation(lat, lon) if model is not None else None
+	return round(elevation, 1) if elevation is not None else None
@@ -0,0 +1,158 @@
+#!/usr/bin/env python
+'''Great-circle distance, bearing, and related helpers'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import math
+
+EARTH_RADIUS_KM = 6371.0088  # Mean radius
+KM_PER_MILE = 1.609344
+
+SECTOR_NAMES_8 = ["N", "NE", "E", "SE", "S", "SW", "W", "NW"]
+SECTOR_NAMES_16 = ["N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"]
+
+
+def distance_km(lat1, lon1, lat2, lon2):
+	"""Haversine distance between two points in kilometers."""
+	phi1, phi2 = math.radians(lat1), math.radians(lat2)
+	dphi = math.radians(lat2 - lat1)
+	dlambda = math.radians(lon2 - lon1)
+	a = math.sin(dphi / 2) ** 2 + math.cos(phi1) * math.cos(phi2) * math.sin(dlambda / 2) ** 2
+	return 2 * EARTH_RADIUS_KM * math.asin(min(1.0, math.sqrt(a)))
+
+
+def bearing_degrees(lat1, lon1, lat2, lon2):
+	"""Initial true bearing from the first point to the second, 0 <= bearing < 360."""
+	phi1, phi2 = math.radians(lat1), math.radians(lat2)
+	dlambda = math.radians(lon2 - lon1)
+	y = math.sin(dlambda) * math.cos(phi2)
+	x = math.cos(phi1) * math.sin(phi2) - math.sin(phi1) * math.cos(phi2) * math.cos(dlambda)
+	return math.degrees(math.atan2(y, x)) % 360.0
+
+
+def destination(lat, lon, bearing, distance):
+	"""The point distance km from (lat, lon) along the given true bearing, as (lat, lon)."""
+	phi1 = math.radians(lat)
+	lambda1 = math.radians(lon)
+	theta = math.radians(bearing)
+	delta = distance / EARTH_RADIUS_KM
+	phi2 = math.asin(math.sin(phi1) * math.cos(delta) + math.cos(phi1) * math.sin(delta) * math.cos(theta))
+	lambda2 = lambda1 + math.atan2(math.sin(theta) * math.sin(delta) * math.cos(phi1), math.cos(delta) - math.sin(phi1) * math.sin(phi2))
+	return math.degrees(phi2), (math.degrees(lambda2) + 540.0) % 360.0 - 180.0
+
+
+def magnetic_bearing(bearing, declination):
+	"""Convert a true bearing to magnetic given the declination (east positive), 0 <= bearing < 360."""
+	return (bearing - declination) % 360.0
+
+
+def sector_name(bearing, sectors=SECTOR_NAMES_8):
+	"""Compass sector containing the bearing, e.g. "NE"."""
+	width = 360.0 / len(sectors)
+	return sectors[int(((bearing % 360.0) + width / 2) // width) % len(sectors)]
+
+
+def circle(lat, lon, radius, points=72):
+	"""A closed ring of (lon, lat) pairs approximating a circle of radius km, GeoJSON order."""
+	ring = []
+	for i in range(points):
+		lat2, lon2 = destination(lat, lon, i * 360.0 / points, radius)
+		ring.append([round(lon2, 6), round(lat2, 6)])
+	ring.append(ring[0])
+	return ring
+
+
+def path_length_km(points):
+	"""Length of a path through (lat, lon) points, in kilometers."""
+	return sum(distance_km(a[0], a[1], b[0], b[1]) for a, b in zip(points, points[1:]))
+
+
+def polygon_area_km2(points):
+	"""Area enclosed by (lat, lon) points on the sphere, in square kilometers; the ring closes itself."""
+	if len(points) < 3:
+		return 0.0
+	total = 0.0
+	for (lat1, lon1), (lat2, lon2) in zip(points, points[1:] + points[:1]):
+		total += math.radians(lon2 - lon1) * (2 + math.sin(math.radians(lat1)) + math.sin(math.radians(lat2)))
+	return abs(total) * EARTH_RADIUS_KM ** 2 / 2
+
+
+def _in_ring(lat, lon, ring):
+	"""Ray casting test against a ring of [lon, lat] pairs."""
+	inside = False
+	j = len(ring) - 1
+	for i in range(len(ring)):
+		xi, yi = ring[i][0], ring[i][1]
+		xj, yj = ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) and lon < (xj - xi) * (lat - yi) / (yj - yi) + xi:
+			inside = not inside
+		j = i
+	return inside
+
+
+def point_in_geometry(lat, lon, geometry):
+	"""True if the point is inside a GeoJSON Polygon or MultiPolygon (holes excluded)."""
+	if geometry["type"] == "Polygon":
+		polygons = [geometry["coordinates"]]
+	elif geometry["type"] == "MultiPolygon":
+		polygons = geometry["coordinates"]
+	else:
+		return False
+	for rings in polygons:
+		if rings and _in_ring(lat, lon, rings[0]) and not any(_in_ring(lat, lon, hole) for hole in rings[1:]):
+			return True
+	return False
+
+
+def parse_lat_lon(text):
+	"""Parse "lat,lon" in decimal degrees.  Raises ValueError if malformed or out of range."""
+	parts = text.split(",")
+	if len(parts) != 2:
+		raise ValueError(f"Expected LAT,LON but got <{text}>")
+	lat, lon = float(parts[0]), float(parts[1])
+	if not (-90.0 <= lat <= 90.0) or not (-180.0 <= lon <= 180.0):
+		raise ValueError(f"Position {lat}, {lon} is out of range")
+	return lat, lon
+
+
+def maidenhead(lat, lon, characters=6):
+	"""Maidenhead locator (e.g., CM87wk) of a point, 2, 4, 6, or 8 characters long."""
+	lon = min(max(lon + 180.0, 0.0), 359.999999)
+	lat = min(max(lat + 90.0, 0.0), 179.999999)
+	locator = chr(ord("A") + int(lon / 20)) + chr(ord("A") + int(lat / 10))
+	if characters < 4:
+		return locator
+	lon, lat = lon % 20, lat % 10
+	locator += str(int(lon / 2)) + str(int(lat))
+	lon, lat = lon % 2, lat % 1
+	if characters >= 6:
+		locator += chr(ord("a") + int(lon * 12)) + chr(ord("a") + int(lat * 24))
+		lon, lat = lon % (1 / 12), lat % (1 / 24)
+	if characters >= 8:
+		locator += str(int(lon * 120)) + str(int(lat * 240))
+	return locator
+
+
+# Size in degrees (longitude, latitude) of each pair of Maidenhead characters, and what the pair is made of
+MAIDENHEAD_STEPS = [(20.0, 10.0, "ABCDEFGHIJKLMNOPQR"), (2.0, 1.0, "0123456789"),
+	(2.0 / 24, 1.0 / 24, "abcdefghijklmnopqrstuvwx"), (2.0 / 240, 1.0 / 240, "0123456789")]
+
+
+def maidenhead_bounds(locator):
+	"""(west, south, east, north) of a Maidenhead square such as CM87 or CM87wk.  Raises ValueError if
+	the locator is malformed."""
+	if len(locator) not in (2, 4, 6, 8):
+		raise ValueError(f"Maidenhead locator {locator} must be 2, 4, 6, or 8 characters")
+	west, south = -180.0, -90.0
+	for i, (step_lon, step_lat, symbols) in enumerate(MAIDENHEAD_STEPS[:len(locator) // 2]):
+		pair = locator[2 * i:2 * i + 2].upper() if i == 0 else locator[2 * i:2 * i + 2].lower()
+		if pair[0] not in symbols or pair[1] not in symbols:
+			raise ValueError(f"Maidenhead locator {locator} is malformed at {pair}")
+		west += symbols.index(pair[0]) * step_lon
+		south += symbols.index(pair[1]) * step_lat
+	return west, south, west + step_lon, south + step_lat
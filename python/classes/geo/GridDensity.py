@@ -0,0 +1,102 @@
+#!/usr/bin/env python
+'''Counts of reports per Maidenhead grid square, as a GeoJSON polygon layer showing coverage'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+from classes.geo import Geo
+from classes.export import MapExport
+from classes.b2f import StationIdentity
+from classes.b2f.B2Message import REPORTER_ROLE
+
+PRECISIONS = [2, 4, 6, 8]  # Locator characters: fields (20° x 10°), squares (2° x 1°), subsquares, extended squares
+PRECISION_DEFAULT = 4
+
+# A square's count as a fraction of the busiest square's -> style, checked in order; the last entry
+# catches the rest
+DENSITY_SYMBOLOGY = [
+	(0.25, {"fill": "#ffffb2", "fill-opacity": 0.5, "stroke": "#bd0026", "stroke-width": 1}),
+	(0.50, {"fill": "#fecc5c", "fill-opacity": 0.55, "stroke": "#bd0026", "stroke-width": 1}),
+	(0.75, {"fill": "#fd8d3c", "fill-opacity": 0.6, "stroke": "#bd0026", "stroke-width": 1}),
+	(None, {"fill": "#e31a1c", "fill-opacity": 0.65, "stroke": "#bd0026", "stroke-width": 1}),
+]
+
+
+def check_precision(precision):
+	if precision not in PRECISIONS:
+		raise ValueError(f"Grid precision must be one of {', '.join(str(p) for p in PRECISIONS)} characters")
+	return precision
+
+
+def report_feature(message):
+	"""The feature a message is counted at: where its sender was, or else the first location its form
+	gives; None if it has neither (or they are redacted from exports)."""
+	features = MapExport.message_features(message)
+	if not features:
+		return None
+	return next((f for f in features if f["properties"]["role"] == REPORTER_ROLE), features[0])
+
+
+def report_location(message):
+	"""(latitude, longitude) of report_feature, or None."""
+	feature = report_feature(message)
+	if feature is None:
+		return None
+	longitude, latitude = feature["geometry"]["coordinates"][:2]
+	return latitude, longitude
+
+
+def symbology(count, busiest):
+	fraction = count / busiest if busiest else 0.0
+	for limit, style in DENSITY_SYMBOLOGY:
+		if limit is None or fraction <= limit:
+			return dict(style)
+	return dict(DENSITY_SYMBOLOGY[-1][1])
+
+
+def grid_squares(messages, precision=PRECISION_DEFAULT):
+	"""{locator: {"reports", "stations", "first", "last"}} for each square with a located message in it."""
+	check_precision(precision)
+	squares = {}
+	for message in messages:
+		location = report_location(message)
+		if location is None:
+			continue
+		locator = Geo.maidenhead(location[0], location[1], precision)
+		square = squares.setdefault(locator, {"reports": 0, "stations": set(), "first": message.date, "last": message.date})
+		square["reports"] += 1
+		station = StationIdentity.identify(message)
+		if station is not None:
+			square["stations"].add(station)
+		square["first"] = min(square["first"], message.date)
+		square["last"] = max(square["last"], message.date)
+	return squares
+
+
+def grid_features(messages, precision=PRECISION_DEFAULT):
+	"""One GeoJSON Polygon feature per grid square with reports in it, busiest first, shaded by its count
+	relative to the busiest square."""
+	squares = grid_squares(messages, precision)
+	busiest = max((s["reports"] for s in squares.values()), default=0)
+	features = []
+	for locator, square in sorted(squares.items(), key=lambda item: (-item[1]["reports"], item[0])):
+		west, south, east, north = Geo.maidenhead_bounds(locator)
+		properties = {
+			"locator": locator,
+			"reports": square["reports"],
+			"stations": len(square["stations"]),
+			"first_date": square["first"].isoformat(),
+			"last_date": square["last"].isoformat(),
+		}
+		properties.update(symbology(square["reports"], busiest))
+		features.append({
+			"type": "Feature",
+			"id": locator,
+			"geometry": {"type": "Polygon", "coordinates": [[[west, south], [east, south], [east, north], [west, north], [west, south]]]},
+			"properties": properties,
+		})
+	return features
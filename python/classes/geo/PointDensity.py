@@ -0,0 +1,93 @@
+#!/usr/bin/env python
+'''Heatmap points and precomputed marker clusters, for maps of thousands of reports'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+from classes.b2f import WinlinkPrecedence
+from classes.geo.GridDensity import report_feature
+from classes.export.StaticMap import check_zoom, world_pixel
+
+COUNT = "count"  # Every report weighs the same
+URGENCY = "urgency"  # Reports weigh 1 (routine) to 4 (flash)
+WEIGHTS = [COUNT, URGENCY]
+
+HEAT_DECIMALS = 5  # Reports within about a metre of each other are one heat point
+CLUSTER_RADIUS_DEFAULT = 80  # Pixels, as Leaflet.markercluster's maxClusterRadius
+ZOOMS_DEFAULT = "4-16"
+
+
+def parse_zooms(text):
+	"""A MIN-MAX (or single) zoom level range as a range.  Raises ValueError if it isn't one."""
+	low, _, high = text.partition("-")
+	low = check_zoom(int(low))
+	high = check_zoom(int(high)) if high else low
+	if high < low:
+		raise ValueError(f"Zoom range {text} runs backwards")
+	return range(low, high + 1)
+
+
+def _reports(messages):
+	"""(feature, urgency) for each message with a location, in date order."""
+	reports = []
+	for message in sorted(messages, key=lambda m: m.date):
+		feature = report_feature(message)
+		if feature is not None:
+			reports.append((feature, feature["properties"]["urgency"] or 0))
+	return reports
+
+
+def heat_points(messages, weight=COUNT):
+	"""{"max", "points"} for Leaflet.heat: points are [latitude, longitude, intensity], with reports at
+	the same place added together, and max is the greatest intensity, to pass as the layer's max option."""
+	if weight not in WEIGHTS:
+		raise ValueError(f"Weight must be one of {', '.join(WEIGHTS)}")
+	points = {}
+	for feature, urgency in _reports(messages):
+		longitude, latitude = feature["geometry"]["coordinates"][:2]
+		key = (round(latitude, HEAT_DECIMALS), round(longitude, HEAT_DECIMALS))
+		points[key] = points.get(key, 0) + (1 + urgency if weight == URGENCY else 1)
+	return {
+		"max": max(points.values(), default=0),
+		"points": [[latitude, longitude, intensity] for (latitude, longitude), intensity in sorted(points.items())],
+	}
+
+
+def cluster_features(messages, zooms, radius=CLUSTER_RADIUS_DEFAULT):
+	"""GeoJSON Point features, one per cluster at each zoom level: the reports that fall in the same
+	radius by radius pixel cell at that zoom, placed at their mean position.  A viewer shows the features
+	whose zoom matches its own.  A cluster of one names the report's feature in feature_id."""
+	if radius <= 0:
+		raise ValueError("Cluster radius must be greater than 0")
+	reports = _reports(messages)
+	features = []
+	for zoom in zooms:
+		cells = {}
+		for feature, urgency in reports:
+			longitude, latitude = feature["geometry"]["coordinates"][:2]
+			x, y = world_pixel(latitude, longitude, zoom)
+			cells.setdefault((int(x // radius), int(y // radius)), []).append((feature, urgency))
+		for (column, row), members in sorted(cells.items()):
+			longitudes = [f["geometry"]["coordinates"][0] for f, _ in members]
+			latitudes = [f["geometry"]["coordinates"][1] for f, _ in members]
+			urgency = max(u for _, u in members)
+			properties = {
+				"zoom": zoom,
+				"cluster": len(members) > 1,
+				"point_count": len(members),
+				"feature_id": members[0][0]["id"] if len(members) == 1 else None,
+				"urgency": urgency,
+				"bbox": [min(longitudes), min(latitudes), max(longitudes), max(latitudes)],
+			}
+			properties.update(WinlinkPrecedence.symbology(urgency))
+			features.append({
+				"type": "Feature",
+				"id": f"{zoom}/{column}/{row}",
+				"geometry": {"type": "Point", "coordinates": [sum(longitudes) / len(members), sum(latitudes) / len(members)]},
+				"properties": properties,
+			})
+	return features
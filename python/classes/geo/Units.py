@@ -0,0 +1,104 @@
+#!/usr/bin/env python
+'''Recognizes measurements in form fields and renders them in the units the audience expects'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import re
+
+SPEED = "speed"
+TEMPERATURE = "temperature"
+LENGTH = "length"
+
+# Spellings seen in form fields -> (quantity, canonical unit)
+UNIT_ALIASES = {
+	"mph": (SPEED, "mph"), "mi/h": (SPEED, "mph"),
+	"kt": (SPEED, "kt"), "kts": (SPEED, "kt"), "knot": (SPEED, "kt"), "knots": (SPEED, "kt"),
+	"km/h": (SPEED, "km/h"), "kmh": (SPEED, "km/h"), "kph": (SPEED, "km/h"),
+	"m/s": (SPEED, "m/s"),
+	"f": (TEMPERATURE, "°F"), "°f": (TEMPERATURE, "°F"), "degf": (TEMPERATURE, "°F"),
+	"c": (TEMPERATURE, "°C"), "°c": (TEMPERATURE, "°C"), "degc": (TEMPERATURE, "°C"),
+	"ft": (LENGTH, "ft"), "feet": (LENGTH, "ft"), "'": (LENGTH, "ft"),
+	"m": (LENGTH, "m"), "meters": (LENGTH, "m"), "metres": (LENGTH, "m"),
+	"mi": (LENGTH, "mi"), "miles": (LENGTH, "mi"),
+	"km": (LENGTH, "km"),
+	"nmi": (LENGTH, "nmi"), "nm": (LENGTH, "nmi"),
+}
+
+# Spellings matched before case is folded and left as received.  A lowercase m is metres, but a
+# capital M is a nautical mile on charts and some write it for statute miles.  Write NM or nmi.
+AMBIGUOUS_UNITS = {"M"}
+
+# Factors to each quantity's base unit (m/s and m); temperatures are handled separately
+TO_BASE = {
+	"mph": 0.44704, "kt": 0.514444, "km/h": 1 / 3.6, "m/s": 1.0,
+	"ft": 0.3048, "m": 1.0, "mi": 1609.344, "km": 1000.0, "nmi": 1852.0,
+}
+
+# Named sets of preferred units.  Lengths keep their scale: short lengths (ft, m) and long
+# ones (mi, km, nmi) convert within their own class.
+PRESETS = {
+	"us": {SPEED: "mph", TEMPERATURE: "°F", "short": "ft", "long": "mi"},
+	"metric": {SPEED: "km/h", TEMPERATURE: "°C", "short": "m", "long": "km"},
+	"nautical": {SPEED: "kt", TEMPERATURE: "°C", "short": "ft", "long": "nmi"},
+}
+LONG_LENGTHS = {"mi", "km", "nmi"}
+
+MEASUREMENT = re.compile(r"^\s*(?P<value>[-+]?\d+(?:\.\d+)?)\s*(?P<unit>°\s*[FfCc]|[A-Za-z/']+)\s*$")
+
+_preferred = None  # One of PRESETS, or None to leave values as received
+
+
+def set_units(preset):
+	"""Choose a preset by name, or None to render values as received."""
+	global _preferred
+	if preset is not None and preset not in PRESETS:
+		raise ValueError(f"Unknown units {preset}; expected one of {', '.join(PRESETS)}")
+	_preferred = PRESETS[preset] if preset is not None else None
+
+
+def parse_measurement(text):
+	"""Split text like "15 kts" or "72°F" into (value, quantity, unit), or None if it isn't one."""
+	match = MEASUREMENT.match(text or "")
+	if not match:
+		return None
+	unit = match.group("unit").replace(" ", "")
+	if unit in AMBIGUOUS_UNITS:
+		return None
+	alias = UNIT_ALIASES.get(unit.lower())
+	if alias is None:
+		return None
+	return float(match.group("value")), alias[0], alias[1]
+
+
+def convert(value, quantity, unit, target):
+	"""Convert value from unit to target, both canonical units of quantity."""
+	if unit == target:
+		return value
+	if quantity == TEMPERATURE:
+		return (value - 32) * 5 / 9 if target == "°C" else value * 9 / 5 + 32
+	return value * TO_BASE[unit] / TO_BASE[target]
+
+
+def render(text):
+	"""Render a field value in the preferred units if it is a measurement; otherwise, or if it is already
+	in them, return it unchanged."""
+	if _preferred is None:
+		return text
+	measurement = parse_measurement(text)
+	if measurement is None:
+		return text
+	value, quantity, unit = measurement
+	if quantity == LENGTH:
+		target = _preferred["long" if unit in LONG_LENGTHS else "short"]
+	else:
+		target = _preferred[quantity]
+	if unit == target:
+		return text  # Not rounded or respelled when nothing is converted
+	converted = convert(value, quantity, unit, target)
+	separator = "" if target.startswith("°") else " "
+	return f"{converted:.0f}{separator}{target}" if abs(converted) >= 10 else f"{converted:.1f}{separator}{target}"
@@ -0,0 +1,353 @@
+#!/usr/bin/env python
+'''NWS watches, warnings, and advisories relayed as Winlink bulletins, mapped onto their forecast zones and counties'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import datetime
+import json
+import re
+import urllib.request
+from classes.export import MapExport
+from classes.b2f.WinlinkTime import utc_now
+
+ZONES_FILE_NAME = "ugc_zones.geojson"  # In the working directory
+NWS_ZONES_URL = "https://api.weather.gov/zones?type={kind}&area={state}&include_geometry=true"
+NWS_ZONE_TYPES = ("forecast", "county")  # Public forecast zones (Z) and counties (C)
+NWS_USER_AGENT = "esv-forms-to-map (bob@rail.com)"  # api.weather.gov refuses requests without one
+FETCH_TIMEOUT_SECONDS = 60
+SIMPLIFY_TOLERANCE_DEFAULT = 0.005  # Degrees, about 500 m; the zones are drawn, not measured
+COORDINATE_DIGITS = 4  # About 10 m
+HAZARD_ROLE = "hazard"
+
+# A text product is split into segments by $$.  Each segment starts with a UGC block naming the zones
+# (Z) or counties (C) it covers, e.g. CAZ006-007>009-CAC001-141200-, where 141200 is the day, hour, and
+# minute (UTC) the segment expires.  The block may wrap over several lines.
+UGC_START = re.compile(r"^[A-Z]{2}[CZ]\d{3}[->]")
+UGC_END = re.compile(r"\d{6}-$")
+UGC_CODE = re.compile(r"^([A-Z]{2}[CZ])?(\d{3})(?:>(\d{3}))?$")
+UGC = re.compile(r"^[A-Z]{2}[CZ]\d{3}$")
+# P-VTEC, e.g. /O.NEW.KMTR.WS.W.0003.261014T1200Z-261015T0000Z/
+VTEC = re.compile(r"/[OTEX]\.([A-Z]{3})\.([A-Z]{4})\.([A-Z]{2})\.([A-Z])\.(\d{4})\.(\d{6}T\d{4}Z)-(\d{6}T\d{4}Z)/")
+VTEC_UNTIL_FURTHER_NOTICE = "000000T0000Z"
+HEADLINE = re.compile(r"^\.\.\.(.+?)\.\.\.$", re.MULTILINE | re.DOTALL)  # May wrap over several lines
+ENDING_ACTIONS = {"CAN", "EXP", "UPG"}  # The segment ends the event in its zones
+
+PHENOMENA = {
+	"AF": "Ashfall", "AS": "Air Stagnation", "AV": "Avalanche", "BW": "Brisk Wind", "BZ": "Blizzard",
+	"CF": "Coastal Flood", "CW": "Cold Weather", "DS": "Dust Storm", "DU": "Blowing Dust", "EC": "Extreme Cold",
+	"EH": "Excessive Heat", "EW": "Extreme Wind", "FA": "Flood", "FF": "Flash Flood", "FG": "Dense Fog",
+	"FL": "Flood", "FR": "Frost", "FW": "Fire Weather", "FZ": "Freeze", "GL": "Gale", "HF": "Hurricane Force Wind",
+	"HT": "Heat", "HU": "Hurricane", "HW": "High Wind", "HY": "Hydrologic", "HZ": "Hard Freeze", "IS": "Ice Storm",
+	"LE": "Lake Effect Snow", "LS": "Lakeshore Flood", "MA": "Marine", "RP": "Rip Current", "SC": "Small Craft",
+	"SE": "Hazardous Seas", "SM": "Dense Smoke", "SR": "Storm", "SS": "Storm Surge", "SU": "High Surf",
+	"SV": "Severe Thunderstorm", "TO": "Tornado", "TR": "Tropical Storm", "TS": "Tsunami", "TY": "Typhoon",
+	"UP": "Heavy Freezing Spray", "WC": "Wind Chill", "WI": "Wind", "WS": "Winter Storm", "WW": "Winter Weather",
+	"ZF": "Freezing Fog", "ZR": "Freezing Rain",
+}
+SIGNIFICANCE = {"W": "Warning", "A": "Watch", "Y": "Advisory", "S": "Statement", "F": "Forecast", "O": "Outlook", "N": "Synopsis"}
+EVENT_NAMES = {"FW.W": "Red Flag Warning", "MA.W": "Special Marine Warning", "MA.S": "Marine Weather Statement"}
+
+# Overlay styling (simplestyle-spec) by significance, under the station markers
+HAZARD_SYMBOLOGY = {
+	"W": {"stroke": "#e31a1c", "stroke-width": 2, "fill": "#e31a1c", "fill-opacity": 0.25},
+	"A": {"stroke": "#ff7f00", "stroke-width": 2, "fill": "#ff7f00", "fill-opacity": 0.2},
+	"Y": {"stroke": "#d4a017", "stroke-width": 2, "fill": "#ffd700", "fill-opacity": 0.2},
+	"S": {"stroke": "#808080", "stroke-width": 1, "fill": "#c0c0c0", "fill-opacity": 0.15},
+}
+# The NWS colors of the commonest events, by phenomenon.significance, laid over HAZARD_SYMBOLOGY
+HAZARD_COLORS = {
+	"TO.W": "#ff0000", "TO.A": "#ffff00", "SV.W": "#ffa500", "SV.A": "#db7093", "FF.W": "#8b0000",
+	"FA.W": "#00ff00", "FL.W": "#00ff00", "WS.W": "#ff69b4", "WS.A": "#4682b4", "BZ.W": "#ff4500",
+	"IS.W": "#8b008b", "WW.Y": "#7b68ee", "HW.W": "#daa520", "WI.Y": "#d2b48c", "FW.W": "#ff1493",
+	"FW.A": "#ffdead", "EH.W": "#c71585", "HT.Y": "#ff7f50", "FG.Y": "#708090", "HU.W": "#dc143c",
+	"TR.W": "#b22222", "SS.W": "#c0c0c0", "TS.W": "#fd6347", "CF.W": "#228b22",
+}
+
+_zones = {}  # {UGC code: {"name", "polygons"}} from the zone file
+
+
+def event_name(phenomenon, significance):
+	"""What the NWS calls an event, e.g. Winter Storm Warning for WS.W."""
+	code = f"{phenomenon}.{significance}"
+	if code in EVENT_NAMES:
+		return EVENT_NAMES[code]
+	return f"{PHENOMENA.get(phenomenon, phenomenon)} {SIGNIFICANCE.get(significance, significance)}"
+
+
+def symbology(phenomenon, significance):
+	"""Overlay styling for an event."""
+	style = dict(HAZARD_SYMBOLOGY.get(significance, HAZARD_SYMBOLOGY["S"]))
+	color = HAZARD_COLORS.get(f"{phenomenon}.{significance}")
+	if color:
+		style.update({"stroke": color, "fill": color})
+	return style
+
+
+def expand_ugc(block):
+	"""The UGC codes in a block such as CAZ006-007>009-CAC001-141200-, and its expiry as DDHHMM."""
+	codes = []
+	prefix = None
+	expires = None
+	for token in block.replace("\n", "").replace(" ", "").strip("-").split("-"):
+		if re.fullmatch(r"\d{6}", token):
+			expires = token
+			continue
+		match = UGC_CODE.match(token)
+		if match is None:
+			continue
+		prefix = match.group(1) or prefix
+		if prefix is None:
+			continue
+		first = int(match.group(2))
+		last = int(match.group(3)) if match.group(3) else first
+		codes.extend(f"{prefix}{n:03d}" for n in range(first, last + 1))
+	return codes, expires
+
+
+def _vtec_time(text):
+	if text == VTEC_UNTIL_FURTHER_NOTICE:
+		return None
+	return datetime.datetime.strptime(text, "%y%m%dT%H%MZ").replace(tzinfo=datetime.timezone.utc)
+
+
+def _purge_time(ddhhmm, issued):
+	"""The UGC expiry as a datetime, in the month of issued or the next one."""
+	day, hour, minute = int(ddhhmm[:2]), int(ddhhmm[2:4]), int(ddhhmm[4:])
+	when = issued.replace(day=1, hour=hour, minute=minute, second=0, microsecond=0)
+	if day < issued.day:
+		when = (when + datetime.timedelta(days=32)).replace(day=1)
+	try:
+		return when.replace(day=day)
+	except ValueError:
+		return None
+
+
+def parse_segments(text, issued):
+	"""The segments of a text product: [{"zones": [UGC], "expires", "vtec": [...], "headline"}].  issued
+	(the bulletin's date) dates the UGC expiry.  Text with no UGC block gives no segments."""
+	segments = []
+	for chunk in text.replace("\r", "").split("$$"):
+		lines = [line.strip() for line in chunk.split("\n")]
+		start = next((i for i, line in enumerate(lines) if UGC_START.match(line)), None)
+		if start is None:
+			continue
+		end = start
+		while end < len(lines) - 1 and not UGC_END.search(lines[end]):
+			end += 1
+		zones, expires = expand_ugc("".join(lines[start:end + 1]))
+		rest = lines[end + 1:]
+		vtec = []
+		for match in VTEC.finditer("\n".join(rest)):
+			action, office, phenomenon, significance, number, begins, ends = match.groups()
+			vtec.append({"action": action, "office": office, "phenomenon": phenomenon, "significance": significance,
+				"number": int(number), "begins": _vtec_time(begins), "ends": _vtec_time(ends)})
+		headlines = [" ".join(match.group(1).split()) for match in HEADLINE.finditer("\n".join(rest))]
+		segments.append({
+			"zones": zones,
+			"expires": _purge_time(expires, issued) if expires else None,
+			"vtec": vtec,
+			"headline": " ".join(headlines) or None,
+		})
+	return segments
+
+
+def active_hazards(messages, at=None):
+	"""The hazards in force at at (default now) from the bulletins among messages, latest word first:
+	[{"event", "phenomenon", "significance", "office", "number", "zone", "begins", "ends", "headline", "mid", "issued"}].
+	A later bulletin about the same event and zone replaces an earlier one; a cancellation or expiry removes it."""
+	at = at or utc_now()
+	hazards = {}  # (office, phenomenon, significance, number, zone): hazard
+	for message in sorted((m for m in messages if m.is_bulletin() and m.body), key=MapExport.export_order):
+		for segment in parse_segments(message.body, message.date):
+			events = segment["vtec"] or [{"action": "NEW", "office": "", "phenomenon": "", "significance": "S", "number": 0, "begins": None, "ends": None}]
+			for event in events:
+				for zone in segment["zones"]:
+					key = (event["office"], event["phenomenon"], event["significance"], event["number"] or message.mid, zone)
+					if event["action"] in ENDING_ACTIONS:
+						hazards.pop(key, None)
+						continue
+					hazards[key] = {
+						"event": event_name(event["phenomenon"], event["significance"]) if event["phenomenon"] else (segment["headline"] or message.subject),
+						"phenomenon": event["phenomenon"] or None,
+						"significance": event["significance"],
+						"office": event["office"] or None,
+						"number": event["number"] or None,
+						"action": event["action"],
+						"zone": zone,
+						"begins": event["begins"],
+						"ends": event["ends"] or segment["expires"],
+						"headline": segment["headline"],
+						"mid": message.mid,
+						"issued": message.date,
+					}
+	active = [h for h in hazards.values() if h["ends"] is None or h["ends"] > at]
+	return sorted(active, key=lambda h: (h["issued"], h["zone"], h["mid"] or "", h["office"] or "", h["phenomenon"] or "", h["significance"], h["number"] or 0), reverse=True)
+
+
+def _zone_code(properties):
+	"""The UGC code of a zone or county in the zone file, from api.weather.gov's id or the NWS shapefiles' fields."""
+	for name in ("ugc", "UGC", "id", "ID"):
+		value = str(properties.get(name) or "").upper()
+		if UGC.match(value):
+			return value
+	if properties.get("STATE_ZONE"):
+		value = str(properties["STATE_ZONE"]).upper()
+		return f"{value[:2]}Z{value[2:]}"
+	if properties.get("STATE") and properties.get("FIPS"):
+		return f"{str(properties['STATE']).upper()}C{str(properties['FIPS'])[-3:]}"
+	return None
+
+
+def read_collection(filename):
+	"""A GeoJSON FeatureCollection from a file.  Raises ValueError if the file is malformed."""
+	with open(filename, 'r') as f:
+		try:
+			collection = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(collection, dict) or not isinstance(collection.get("features"), list):
+		raise ValueError(f"{filename}: expected a GeoJSON FeatureCollection")
+	return collection
+
+
+def _zone_parts(feature):
+	"""(UGC code, name, polygons) of a zone or county feature, or None if it has no code or no area."""
+	properties = feature.get("properties") or {}
+	geometry = feature.get("geometry") or {}
+	code = _zone_code(properties)
+	if code is None or geometry.get("type") not in ("Polygon", "MultiPolygon"):
+		return None
+	polygons = [geometry["coordinates"]] if geometry["type"] == "Polygon" else geometry["coordinates"]
+	return code, properties.get("name") or properties.get("NAME") or properties.get("COUNTYNAME"), [polygon for polygon in polygons if polygon]
+
+
+def load_zones(filename):
+	"""{UGC code: {"name", "polygons": [outer ring of [lon, lat]]}} from a GeoJSON FeatureCollection of forecast
+	zones and counties.  Holes are left out.  Raises ValueError if the file is malformed."""
+	zones = {}
+	for feature in read_collection(filename)["features"]:
+		parts = _zone_parts(feature)
+		if parts is None:
+			continue
+		code, name, polygons = parts
+		zone = zones.setdefault(code, {"name": name, "polygons": []})
+		zone["polygons"].extend(polygon[0] for polygon in polygons)
+	return zones
+
+
+def fetch_zones(state, kind):
+	"""The NWS zones of kind (one of NWS_ZONE_TYPES) in a state, with their shapes, from api.weather.gov as
+	a FeatureCollection.  Raises OSError if it can't be fetched and ValueError if the answer isn't one."""
+	url = NWS_ZONES_URL.format(kind=kind, state=state.upper())
+	request = urllib.request.Request(url, headers={"User-Agent": NWS_USER_AGENT, "Accept": "application/geo+json"})
+	with urllib.request.urlopen(request, timeout=FETCH_TIMEOUT_SECONDS) as response:
+		try:
+			collection = json.load(response)
+		except ValueError as e:
+			raise ValueError(f"{url}: {e}")
+	if not isinstance(collection, dict) or not isinstance(collection.get("features"), list):
+		raise ValueError(f"{url}: expected a GeoJSON FeatureCollection")
+	return collection
+
+
+def _offset(point, start, end):
+	"""How far point lies from the segment start-end, in degrees as drawn."""
+	dx, dy = end[0] - start[0], end[1] - start[1]
+	if dx == 0 and dy == 0:
+		return ((point[0] - start[0]) ** 2 + (point[1] - start[1]) ** 2) ** 0.5
+	t = max(0.0, min(1.0, ((point[0] - start[0]) * dx + (point[1] - start[1]) * dy) / (dx * dx + dy * dy)))
+	return ((point[0] - start[0] - t * dx) ** 2 + (point[1] - start[1] - t * dy) ** 2) ** 0.5
+
+
+def simplify_ring(ring, tolerance=SIMPLIFY_TOLERANCE_DEFAULT):
+	"""A closed ring of [lon, lat] rounded to COORDINATE_DIGITS, without the points that lie within tolerance
+	degrees of the outline through the rest (Douglas-Peucker).  A ring that would collapse is only rounded."""
+	points = []
+	for point in ring:
+		rounded = [round(point[0], COORDINATE_DIGITS), round(point[1], COORDINATE_DIGITS)]
+		if not points or rounded != points[-1]:
+			points.append(rounded)
+	if len(points) < 4 or tolerance <= 0:
+		return points
+	keep = {0, len(points) - 1}
+	spans = [(0, len(points) - 1)]
+	while spans:
+		first, last = spans.pop()
+		if last - first < 2:
+			continue
+		offset, index = max((_offset(points[i], points[first], points[last]), i) for i in range(first + 1, last))
+		if offset > tolerance:
+			keep.add(index)
+			spans.extend([(first, index), (index, last)])
+	simplified = [points[i] for i in sorted(keep)]
+	return simplified if len(simplified) >= 4 else points
+
+
+def build_zone_file(collections, states=None, tolerance=SIMPLIFY_TOLERANCE_DEFAULT):
+	"""A small zone file, as load_zones reads it, from FeatureCollections of NWS zones and counties such as
+	fetch_zones returns or ogr2ogr makes of the NWS shapefiles.  Each zone becomes one MultiPolygon
+	feature with its ugc and name, holes left out and outlines simplified.  states (two-letter codes)
+	keeps only the zones in them.  Features are sorted by UGC code."""
+	states = {state.upper() for state in states} if states else None
+	features = {}
+	for collection in collections:
+		for feature in collection.get("features") or []:
+			parts = _zone_parts(feature)
+			if parts is None or (states is not None and parts[0][:2] not in states):
+				continue
+			code, name, polygons = parts
+			zone = features.setdefault(code, {"type": "Feature", "properties": {"ugc": code, "name": name}, "geometry": {"type": "MultiPolygon", "coordinates": []}})
+			zone["geometry"]["coordinates"].extend([simplify_ring(polygon[0], tolerance)] for polygon in polygons)
+	return {"type": "FeatureCollection", "features": [features[code] for code in sorted(features)]}
+
+
+def set_zones(zones):
+	global _zones
+	_zones = zones or {}
+
+
+def zone_count():
+	return len(_zones)
+
+
+def unmapped(hazards):
+	"""Zones named by hazards that the zone file doesn't have."""
+	return sorted({h["zone"] for h in hazards if h["zone"] not in _zones})
+
+
+def hazard_features(hazards):
+	"""One Polygon feature per zone part of each hazard, for the overlay.  Zones the zone file lacks are left out."""
+	features = []
+	for hazard in hazards:
+		zone = _zones.get(hazard["zone"])
+		if zone is None:
+			continue
+		number = f"{hazard['phenomenon']}.{hazard['significance']}-{hazard['number']}" if hazard["phenomenon"] else hazard["mid"]
+		properties = {
+			"role": HAZARD_ROLE,
+			"label": hazard["event"],
+			"event": hazard["event"],
+			"zone": hazard["zone"],
+			"zone_name": zone["name"],
+			"office": hazard["office"],
+			"headline": hazard["headline"],
+			"begins": hazard["begins"].isoformat() if hazard["begins"] else None,
+			"ends": hazard["ends"].isoformat() if hazard["ends"] else None,
+			"bulletin": hazard["mid"],
+			"date": hazard["issued"].isoformat(),
+		}
+		properties.update(symbology(hazard["phenomenon"], hazard["significance"]))
+		for part, ring in enumerate(zone["polygons"]):
+			feature_id = f"hazard-{hazard['office'] or ''}-{number}-{hazard['zone']}" + (f"-{part + 1}" if part else "")
+			features.append({"type": "Feature", "id": feature_id, "geometry": {"type": "Polygon", "coordinates": [ring]}, "properties": dict(properties)})
+	return features
+
+
+def features(messages, at=None):
+	"""The overlay for the hazards in force at at (default now) among messages' bulletins."""
+	return hazard_features(active_hazards(messages, at))
@@ -0,0 +1,374 @@
+#!/usr/bin/env python
+'''Compresses and decompresses messages with LZHUF, in the variant Winlink uses for B2 transfers'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import io
+import struct
+
+# LZHUF (Okumura and Yoshizaki) with the 2 KB window FBB and Winlink use.  The B2 variant puts a
+# CRC-16 (XMODEM, of everything after it) and the uncompressed length in front, both little endian.
+N = 2048  # Ring buffer size
+F = 60  # Longest match
+THRESHOLD = 2  # Matches this long or shorter are sent as literals
+N_CHAR = 256 - THRESHOLD + F  # Literals 0..255, then match lengths THRESHOLD + 1 .. F
+T = N_CHAR * 2 - 1  # Nodes in the Huffman tree
+R = T - 1  # Its root
+MAX_FREQ = 0x8000  # Rebuild the tree when the root count reaches this
+MAX_CHAIN = 64  # Candidates tried per match; more compresses slightly better, slower
+HEADER_SIZE = 6  # CRC-16 and uncompressed length
+CHUNK_SIZE = 65536  # Bytes read from or decoded to a stream at a time
+
+# Static code for the upper 6 bits of a match position: lengths as in LZHUF.C, codes assigned in order
+POSITION_LENGTHS = [3] * 1 + [4] * 3 + [5] * 8 + [6] * 12 + [7] * 24 + [8] * 16
+POSITION_CODES = [sum(1 << (8 - l) for l in POSITION_LENGTHS[:i]) for i in range(len(POSITION_LENGTHS))]
+# The same code as the decoder reads it: the upper 6 bits, and the code's length, for each leading byte
+POSITION_BY_BYTE = [(upper, length) for upper, length in enumerate(POSITION_LENGTHS) for _ in range(1 << (8 - length))]
+
+
+def crc16(data, crc=0):
+	"""CRC-16/XMODEM: polynomial 0x1021, initial value 0.  Pass the CRC of the data before this to
+	continue it."""
+	for byte in data:
+		crc ^= byte << 8
+		for _ in range(8):
+			crc = ((crc << 1) ^ 0x1021) & 0xFFFF if crc & 0x8000 else (crc << 1) & 0xFFFF
+	return crc
+
+
+class _AdaptiveHuffman:
+	"""The frequency-ordered tree of LZHUF.C, updated after every symbol."""
+
+	def __init__(self):
+		self.freq = [0] * (T + 1)
+		self.parent = [0] * (T + N_CHAR)
+		self.son = [0] * T
+		for i in range(N_CHAR):
+			self.freq[i] = 1
+			self.son[i] = i + T
+			self.parent[i + T] = i
+		i, j = 0, N_CHAR
+		while j <= R:
+			self.freq[j] = self.freq[i] + self.freq[i + 1]
+			self.son[j] = i
+			self.parent[i] = self.parent[i + 1] = j
+			i, j = i + 2, j + 1
+		self.freq[T] = 0xFFFF  # Sentinel
+		self.parent[R] = 0
+
+	def _rebuild(self):
+		"""Halve the counts and rebuild the tree."""
+		freq, son, parent = self.freq, self.son, self.parent
+		j = 0
+		for i in range(T):
+			if son[i] >= T:
+				freq[j] = (freq[i] + 1) // 2
+				son[j] = son[i]
+				j += 1
+		i, j = 0, N_CHAR
+		while j < T:
+			f = freq[i] + freq[i + 1]
+			freq[j] = f
+			k = j - 1
+			while f < freq[k]:
+				k -= 1
+			k += 1
+			freq[k + 1:j + 1] = freq[k:j]
+			freq[k] = f
+			son[k + 1:j + 1] = son[k:j]
+			son[k] = i
+			i, j = i + 2, j + 1
+		for i in range(T):
+			k = son[i]
+			parent[k] = i
+			if k < T:
+				parent[k + 1] = i
+
+	def update(self, symbol):
+		freq, son, parent = self.freq, self.son, self.parent
+		if freq[R] == MAX_FREQ:
+			self._rebuild()
+		c = parent[symbol + T]
+		while True:
+			freq[c] += 1
+			k = freq[c]
+			l = c + 1
+			if k > freq[l]:
+				# Swap with the last node of a lower count to keep the counts in order
+				l += 1
+				while k > freq[l]:
+					l += 1
+				l -= 1
+				freq[c] = freq[l]
+				freq[l] = k
+				i = son[c]
+				parent[i] = l
+				if i < T:
+					parent[i + 1] = l
+				j = son[l]
+				son[l] = i
+				parent[j] = c
+				if j < T:
+					parent[j + 1] = c
+				son[c] = j
+				c = l
+			c = parent[c]
+			if c == 0:
+				break
+
+	def code(self, symbol):
+		"""(code, length) for a symbol, read from leaf to root."""
+		code, length = 0, 0
+		k = self.parent[symbol + T]
+		while k != R:
+			code |= (k & 1) << length
+			length += 1
+			k = self.parent[k]
+		return code, length
+
+
+class _BitWriter:
+
+	def __init__(self):
+		self.data = bytearray()
+		self.buffer = 0
+		self.count = 0
+
+	def write(self, value, length):
+		self.buffer = (self.buffer << length) | value
+		self.count += length
+		while self.count >= 8:
+			self.count -= 8
+			self.data.append((self.buffer >> self.count) & 0xFF)
+		self.buffer &= (1 << self.count) - 1
+
+	def flush(self):
+		if self.count:
+			self.data.append((self.buffer << (8 - self.count)) & 0xFF)
+			self.count = self.buffer = 0
+		return bytes(self.data)
+
+
+class _BitReader:
+
+	def __init__(self, source):
+		"""Read bits from a binary file object, a chunk at a time."""
+		self.source = source
+		self.data = b""
+		self.index = 0
+		self.buffer = 0
+		self.count = 0
+
+	def _fill(self):
+		"""Make the next byte available; False at the end of the source."""
+		if self.index >= len(self.data):
+			self.data = self.source.read(CHUNK_SIZE)
+			self.index = 0
+		return self.index < len(self.data)
+
+	def read(self, length):
+		"""The next length bits as a number.  Raises ValueError at the end of the data."""
+		while self.count < length:
+			if not self._fill():
+				raise ValueError("LZHUF data ends early")
+			self.buffer = (self.buffer << 8) | self.data[self.index]
+			self.index += 1
+			self.count += 8
+		self.count -= length
+		value = (self.buffer >> self.count) & ((1 << length) - 1)
+		self.buffer &= (1 << self.count) - 1
+		return value
+
+	def peek_byte(self):
+		"""The next 8 bits, without consuming them; short data is padded with zeros, as LZHUF.C does."""
+		while self.count < 8 and self._fill():
+			self.buffer = (self.buffer << 8) | self.data[self.index]
+			self.index += 1
+			self.count += 8
+		if self.count >= 8:
+			return (self.buffer >> (self.count - 8)) & 0xFF
+		return (self.buffer << (8 - self.count)) & 0xFF
+
+
+class _CrcReader:
+	"""A binary file object's data, with the CRC-16 of what has been read from it."""
+
+	def __init__(self, source, crc=0):
+		self.source = source
+		self.crc = crc
+
+	def read(self, size=-1):
+		data = self.source.read(size)
+		self.crc = crc16(data, self.crc)
+		return data
+
+
+def _lzhuf(data):
+	"""The LZHUF bit stream for data, without the length or CRC."""
+	huffman = _AdaptiveHuffman()
+	bits = _BitWriter()
+
+	def symbol(value):
+		bits.write(*huffman.code(value))
+		huffman.update(value)
+
+	# The decoder's window starts out as N - F spaces, so matches may reach back into them
+	text = b" " * (N - F) + bytes(data)
+	heads = {}  # Three-byte prefix -> most recent position
+	previous = [0] * len(text)  # Position -> earlier position with the same prefix, or -1
+
+	def insert(position):
+		key = text[position:position + 3]
+		previous[position] = heads.get(key, -1)
+		heads[key] = position
+
+	for position in range(N - F - 3, N - F):
+		insert(position)
+	position = N - F
+	while position < len(text):
+		longest, distance = 0, 0
+		limit = min(F, len(text) - position)
+		candidate = heads.get(text[position:position + 3], -1) if limit > THRESHOLD else -1
+		tries = 0
+		while candidate >= 0 and position - candidate <= N - F and tries < MAX_CHAIN:
+			length = 0
+			while length < limit and text[candidate + length] == text[position + length]:
+				length += 1
+			if length > longest:
+				longest, distance = length, position - candidate
+				if length == limit:
+					break
+			candidate = previous[candidate]
+			tries += 1
+		if longest <= THRESHOLD:
+			symbol(text[position])
+			longest = 1
+		else:
+			symbol(255 - THRESHOLD + longest)
+			match = distance - 1
+			bits.write(POSITION_CODES[match >> 6] >> (8 - POSITION_LENGTHS[match >> 6]), POSITION_LENGTHS[match >> 6])
+			bits.write(match & 0x3F, 6)
+		for step in range(longest):
+			if position + step + 3 <= len(text):
+				insert(position + step)
+		position += longest
+	return bits.flush()
+
+
+def compress(data):
+	"""The B2 compressed image of data: CRC-16, uncompressed length, and the LZHUF stream."""
+	body = struct.pack("<I", len(data)) + _lzhuf(data)
+	return struct.pack("<H", crc16(body)) + body
+
+
+def _unlzhuf(bits, length):
+	"""Decode length bytes from a _BitReader, yielding them a chunk at a time.  Raises ValueError if the
+	stream is too short."""
+	huffman = _AdaptiveHuffman()
+	window = bytearray(b" " * N)
+	r = N - F
+	out = bytearray()
+	remaining = length
+	while remaining > 0:
+		node = huffman.son[R]
+		while node < T:
+			node = huffman.son[node + bits.read(1)]
+		value = node - T
+		huffman.update(value)
+		if value < 256:
+			out.append(value)
+			window[r] = value
+			r = (r + 1) & (N - 1)
+		else:
+			upper, code_length = POSITION_BY_BYTE[bits.peek_byte()]
+			bits.read(code_length)
+			match = (upper << 6) | bits.read(6)
+			start = r - match - 1
+			for k in range(value - 255 + THRESHOLD):
+				byte = window[(start + k) & (N - 1)]
+				out.append(byte)
+				window[r] = byte
+				r = (r + 1) & (N - 1)
+		if len(out) >= CHUNK_SIZE or len(out) >= remaining:
+			chunk = bytes(out[:remaining])
+			remaining -= len(chunk)
+			out.clear()
+			yield chunk
+
+
+def decompress(data):
+	"""The message in a B2 compressed image, as compress() makes it.  Raises ValueError if the image is
+	truncated or its CRC doesn't match."""
+	if len(data) < HEADER_SIZE:
+		raise ValueError(f"Compressed image is only {len(data)} bytes")
+	crc, length = struct.unpack("<HI", data[:HEADER_SIZE])
+	if crc16(data[2:]) != crc:
+		raise ValueError(f"CRC mismatch: the image says 0x{crc:04X}, its contents give 0x{crc16(data[2:]):04X}")
+	return b"".join(_unlzhuf(_BitReader(io.BytesIO(data[HEADER_SIZE:])), length))
+
+
+class LzhufReader(io.RawIOBase):
+	"""The message in a B2 compressed image, decoded as it is read from a binary file object, so a large
+	one needn't be held in memory whole.  The image is read to its end, where its CRC is checked:
+	the read that reaches the end of the message raises ValueError if the image was damaged."""
+
+	def __init__(self, source):
+		"""Read the image from source.  Raises ValueError if it is too short to have a header."""
+		super().__init__()
+		header = source.read(HEADER_SIZE)
+		if len(header) < HEADER_SIZE:
+			raise ValueError(f"Compressed image is only {len(header)} bytes")
+		self.crc, self.length = struct.unpack("<HI", header)
+		self._source = _CrcReader(source, crc16(header[2:]))
+		self._chunks = _unlzhuf(_BitReader(self._source), self.length)
+		self._pending = b""
+
+	def readable(self):
+		return True
+
+	def readinto(self, buffer):
+		if not self._pending:
+			self._pending = next(self._chunks, b"")
+			if not self._pending:
+				self._check()
+				return 0
+		count = min(len(buffer), len(self._pending))
+		buffer[:count] = self._pending[:count]
+		self._pending = self._pending[count:]
+		return count
+
+	def _check(self):
+		if self._source is None:
+			return
+		while self._source.read(CHUNK_SIZE):
+			pass
+		crc, self._source = self._source.crc, None
+		if crc != self.crc:
+			raise ValueError(f"CRC mismatch: the image says 0x{self.crc:04X}, its contents give 0x{crc:04X}")
+
+
+def compress_stream(source, destination):
+	"""Compress what is left in binary file object source and write the image to destination, returning
+	the bytes written.  The length and CRC lead the image, so the data is read whole first."""
+	image = compress(source.read())
+	destination.write(image)
+	return len(image)
+
+
+def decompress_stream(source, destination):
+	"""Decode the B2 compressed image read from binary file object source, writing the message to
+	destination a chunk at a time, and return its length.  Raises ValueError as LzhufReader does;
+	what was written before the damage was found is left in destination."""
+	reader = LzhufReader(source)
+	written = 0
+	while True:
+		chunk = reader.read(CHUNK_SIZE)
+		if not chunk:
+			return written
+		destination.write(chunk)
+		written += len(chunk)
@@ -0,0 +1,171 @@
+#!/usr/bin/env python
+'''Matches incoming messages against alert rules and notifies staff'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import os
+import re
+from classes.geo import Geo
+from classes.forms import Redaction
+from classes.export.MapExport import exported_positions
+from classes.server.Notifier import create_notifier
+
+ALERTS_FILE_NAME = "alerts.json"  # Alert rules and notifiers; alerts are off if the file is absent
+INSIDE = "inside"
+OUTSIDE = "outside"
+
+
+class Geofence:
+	"""A named zone: a GeoJSON Polygon or MultiPolygon."""
+
+	def __init__(self, name, geometry):
+		if geometry.get("type") not in ("Polygon", "MultiPolygon"):
+			raise ValueError(f"Geofence {name}: geometry must be a Polygon or MultiPolygon")
+		self.name = name
+		self.geometry = geometry
+
+	def contains(self, latitude, longitude):
+		return Geo.point_in_geometry(latitude, longitude, self.geometry)
+
+
+def load_geofences(filename):
+	"""Read zones from a GeoJSON FeatureCollection; each feature's "name" property names its zone."""
+	with open(filename, 'r') as f:
+		collection = json.load(f)
+	zones = {}
+	for index, feature in enumerate(collection.get("features", [])):
+		name = (feature.get("properties") or {}).get("name") or f"zone {index + 1}"
+		zones[name] = Geofence(name, feature.get("geometry") or {})
+	return zones
+
+
+class AlertRule:
+	"""Conditions a message must meet to raise an alert.  Every condition given must hold."""
+
+	def __init__(self, name, notify, min_urgency=None, form_type=None, sender=None, subject=None, geofence=None, when=INSIDE, role=None):
+		self.name = name
+		self.notify = notify  # Names of the notifiers to use
+		self.min_urgency = min_urgency  # 0 (routine) .. 3 (flash)
+		self.form_type = form_type
+		self.sender = sender.upper() if sender else None
+		self.subject = re.compile(subject, re.IGNORECASE) if subject else None  # Regular expression
+		self.geofence = geofence  # Geofence, or None
+		if when not in (INSIDE, OUTSIDE):
+			raise ValueError(f"Alert rule {name}: when must be {INSIDE} or {OUTSIDE}")
+		self.when = when  # Whether a position must fall inside or outside the geofence
+		self.role = role  # Only test positions with this role (e.g., "reporter"); None tests all
+
+	def matches(self, message):
+		"""True if the message (a B2Message) meets every condition of the rule."""
+		if self.min_urgency is not None and message.urgency() < self.min_urgency:
+			return False
+		if self.form_type is not None and (message.form is None or message.form.form_type != self.form_type):
+			return False
+		if self.sender is not None and (message.sender or "").upper().split("-")[0] != self.sender.split("-")[0]:
+			return False
+		if self.subject is not None and not self.subject.search(message.subject or ""):
+			return False
+		if self.geofence is not None:
+			positions = [p for p in message.positions() if self.role is None or p[0] == self.role]
+			if not positions:
+				return False
+			inside = [self.geofence.contains(lat, lon) for _, lat, lon in positions]
+			if self.when == INSIDE and not any(inside):
+				return False
+			if self.when == OUTSIDE and any(inside):
+				return False
+		return True
+
+
+class AlertEngine:
+	"""Holds the configured notifiers and rules and evaluates messages against them."""
+
+	def __init__(self, notifiers=None, rules=None, enable_debug=False):
+		self.notifiers = notifiers or {}  # Name -> Notifier
+		self.rules = rules or []
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@classmethod
+	def from_file(cls, filename, enable_debug=False):
+		"""Load notifiers and rules from a JSON file:
+		{"notifiers": {"eoc": {"type": "ntfy", "url": "http://ntfy.local.mesh", "topic": "esv"}},
+		 "geofences": "zones.geojson",
+		 "rules": [{"name": "urgent", "min_urgency": 2, "notify": ["eoc"]},
+		           {"name": "strays", "geofence": "Shelter A", "when": "outside", "notify": ["eoc"]}]}
+		The geofences file is relative to the config file.  Raises ValueError for bad entries."""
+		with open(filename, 'r') as f:
+			config = json.load(f)
+		notifiers = {name: create_notifier(name, entry, enable_debug) for name, entry in config.get("notifiers", {}).items()}
+		zones = {}
+		if config.get("geofences"):
+			zones = load_geofences(os.path.join(os.path.dirname(filename), config["geofences"]))
+		rules = []
+		for entry in config.get("rules", []):
+			entry = dict(entry)
+			name = entry.pop("name", f"rule {len(rules) + 1}")
+			notify = entry.pop("notify", [])
+			for notifier in notify:
+				if notifier not in notifiers:
+					raise ValueError(f"Alert rule {name}: unknown notifier {notifier}")
+			if "geofence" in entry:
+				zone = entry["geofence"]
+				if zone not in zones:
+					raise ValueError(f"Alert rule {name}: unknown geofence {zone}")
+				entry["geofence"] = zones[zone]
+			try:
+				rules.append(AlertRule(name, notify, **entry))
+			except (TypeError, re.error) as e:
+				raise ValueError(f"Alert rule {name}: {e}")
+		return cls(notifiers, rules, enable_debug)
+
+	def describe(self, message):
+		"""Title and text of the alert for a message, redacted as the exports are, since alerts go to
+		outside services."""
+		location = ""
+		if message.position["latitude"] != 0.0 or message.position["longitude"] != 0.0:
+			location = f" at {message.position['latitude']:.5f}, {message.position['longitude']:.5f}"
+		title = f"{message.precedence} from {message.sender}: {Redaction.scrub_text(message.subject)}"
+		text = f"{message.sender} to {message.recipient}{location}, {message.date.isoformat()}"
+		if message.form is not None:
+			text += f" ({message.form.form_type})"
+		return title, text
+
+	def evaluate(self, message):
+		"""Notify for every rule the message matches.  Returns the names of the rules that matched.
+		A probable duplicate raises no alert; the message it repeats already did."""
+		if message.duplicate_of is not None:
+			self._log_debug(f"Message {message.mid or message.message_id} is a probable duplicate of {message.duplicate_of}; no alerts")
+			return []
+		matched = []
+		for rule in self.rules:
+			if not rule.matches(message):
+				continue
+			matched.append(rule.name)
+			title, text = self.describe(message)
+			if rule.geofence is not None:
+				text += f" ({rule.when} {rule.geofence.name})"
+			details = {"rule": rule.name, "mid": message.mid, "sender": message.sender, "subject": Redaction.scrub_text(message.subject), "positions": exported_positions(message)}
+			self.logger.info(f"Message {message.mid or message.message_id} matched alert rule {rule.name}")
+			for name in rule.notify:
+				self.notifiers[name].notify(title, text, message.urgency(), details)
+		return matched
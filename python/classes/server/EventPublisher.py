@@ -0,0 +1,221 @@
+#!/usr/bin/env python
+'''Publishes an event for each message received to MQTT, NATS, or Redis pub/sub'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import socket
+import struct
+from classes.export import MapExport
+
+EVENTS_FILE_NAME = "events.json"  # MQTT, NATS, and Redis publishers; events are off if the file is absent
+BROKER_TIMEOUT_SECONDS = 10
+MQTT_PORT = 1883
+NATS_PORT = 4222
+REDIS_PORT = 6379
+MQTT_KEEPALIVE_SECONDS = 60
+CLIENT_NAME = "esvmap"  # MQTT client ID and NATS connection name
+
+
+def message_event(message):
+	"""The event published for a received message (a B2Message): its properties and map features, with
+	the same redaction as the exports."""
+	return {
+		"event": "message",
+		"mid": message.mid,
+		"properties": MapExport.message_properties(message),
+		"features": MapExport.message_features(message),
+	}
+
+
+class EventPublisher:
+	"""A broker that events are published to.  Each event opens a connection of its own, so a broker
+	restart costs nothing but the events sent while it was down.  Subclasses implement send()."""
+
+	def __init__(self, name, host, port, topic, enable_debug=False):
+		self.name = name
+		self.host = host
+		self.port = port
+		self.topic = topic  # Topic, subject, or channel, as the broker calls it
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def send(self, payload):
+		"""Publish payload (bytes) to the topic.  Raises an exception if the broker doesn't take it."""
+		raise NotImplementedError
+
+	def payload(self, event):
+		return json.dumps(event, default=str).encode("utf-8")
+
+	def dry_run(self, event):
+		"""Describe what publish() would send, without connecting to the broker."""
+		if not self.host or not self.topic:
+			raise ValueError("a host and a topic are needed")
+		return f"{self.host}:{self.port} {self.topic} ({len(self.payload(event))} bytes)"
+
+	def publish(self, event):
+		"""Publish an event (a dict) as JSON, logging rather than raising on failure.  Returns True if
+		the broker took it."""
+		try:
+			self.send(self.payload(event))
+			self._log_debug(f"Event publisher {self.name} published {event.get('event')} to {self.topic}")
+			return True
+		except Exception as e:
+			self.logger.error(f"Event publisher {self.name} failed to publish to {self.host}:{self.port}: {e}")
+			return False
+
+	def _connect(self):
+		return socket.create_connection((self.host, self.port), timeout=BROKER_TIMEOUT_SECONDS)
+
+
+def _read_line(stream):
+	line = stream.readline()
+	if not line:
+		raise ConnectionError("connection closed by the broker")
+	return line.decode("utf-8", errors="replace").rstrip("\r\n")
+
+
+class MqttPublisher(EventPublisher):
+	"""MQTT 3.1.1 at QoS 0."""
+
+	def __init__(self, name, host, topic, port=MQTT_PORT, username=None, password=None, client_id=CLIENT_NAME, retain=False, enable_debug=False):
+		super().__init__(name, host, port, topic, enable_debug)
+		self.username = username
+		self.password = password
+		self.client_id = client_id
+		self.retain = retain  # Keep the last event on the broker for new subscribers
+
+	@staticmethod
+	def _string(text):
+		data = text.encode("utf-8")
+		return struct.pack(">H", len(data)) + data
+
+	@staticmethod
+	def _packet(header, body):
+		length, remaining = b"", len(body)
+		while True:
+			digit, remaining = remaining % 128, remaining // 128
+			length += bytes([digit | (0x80 if remaining else 0)])
+			if not remaining:
+				return bytes([header]) + length + body
+
+	def send(self, payload):
+		flags = 0x02  # Clean session
+		credentials = b""
+		if self.username is not None:
+			flags |= 0x80
+			credentials += self._string(self.username)
+			if self.password is not None:
+				flags |= 0x40
+				credentials += self._string(self.password)
+		connect = self._string("MQTT") + bytes([4, flags]) + struct.pack(">H", MQTT_KEEPALIVE_SECONDS) + self._string(self.client_id) + credentials
+		with self._connect() as connection, connection.makefile('rb') as stream:
+			connection.sendall(self._packet(0x10, connect))
+			acknowledgement = stream.read(4)
+			if len(acknowledgement) < 4 or acknowledgement[0] != 0x20 or acknowledgement[3] != 0:
+				raise ConnectionError(f"broker refused the connection ({acknowledgement.hex()})")
+			connection.sendall(self._packet(0x30 | (0x01 if self.retain else 0), self._string(self.topic) + payload))
+			connection.sendall(self._packet(0xE0, b""))
+
+
+class NatsPublisher(EventPublisher):
+	"""NATS core publish, confirmed by a PING/PONG round trip so errors are seen."""
+
+	def __init__(self, name, host, subject, port=NATS_PORT, user=None, password=None, token=None, enable_debug=False):
+		super().__init__(name, host, port, subject, enable_debug)
+		self.user = user
+		self.password = password
+		self.token = token
+
+	def send(self, payload):
+		options = {"verbose": False, "pedantic": False, "name": CLIENT_NAME, "lang": "python", "version": "1"}
+		if self.user is not None:
+			options.update({"user": self.user, "pass": self.password or ""})
+		if self.token is not None:
+			options["auth_token"] = self.token
+		with self._connect() as connection, connection.makefile('rb') as stream:
+			info = _read_line(stream)
+			if not info.startswith("INFO"):
+				raise ConnectionError(f"unexpected greeting <{info[:40]}>")
+			connection.sendall(f"CONNECT {json.dumps(options)}\r\n".encode("utf-8"))
+			connection.sendall(f"PUB {self.topic} {len(payload)}\r\n".encode("utf-8") + payload + b"\r\nPING\r\n")
+			while True:
+				reply = _read_line(stream)
+				if reply.startswith("-ERR"):
+					raise ConnectionError(reply[5:].strip(" '"))
+				if reply == "PONG":
+					return
+				if reply == "PING":
+					connection.sendall(b"PONG\r\n")
+
+
+class RedisPublisher(EventPublisher):
+	"""Redis PUBLISH to a channel."""
+
+	def __init__(self, name, host, channel, port=REDIS_PORT, password=None, username=None, enable_debug=False):
+		super().__init__(name, host, port, channel, enable_debug)
+		self.password = password
+		self.username = username  # Redis 6 ACL user; None uses the default user
+
+	@staticmethod
+	def _command(*arguments):
+		parts = [a if isinstance(a, bytes) else str(a).encode("utf-8") for a in arguments]
+		return f"*{len(parts)}\r\n".encode() + b"".join(f"${len(p)}\r\n".encode() + p + b"\r\n" for p in parts)
+
+	@staticmethod
+	def _reply(stream):
+		reply = _read_line(stream)
+		if reply.startswith("-"):
+			raise ConnectionError(reply[1:])
+		return reply
+
+	def send(self, payload):
+		with self._connect() as connection, connection.makefile('rb') as stream:
+			if self.password is not None:
+				credentials = [self.username, self.password] if self.username else [self.password]
+				connection.sendall(self._command("AUTH", *credentials))
+				self._reply(stream)
+			connection.sendall(self._command("PUBLISH", self.topic, payload))
+			receivers = self._reply(stream)
+			self._log_debug(f"Redis channel {self.topic} had {receivers.lstrip(':')} subscribers")
+
+
+EVENT_PUBLISHER_TYPES = {"mqtt": MqttPublisher, "nats": NatsPublisher, "redis": RedisPublisher}
+
+
+def create_event_publisher(name, config, enable_debug=False):
+	"""Build an event publisher from its config entry, e.g. {"type": "nats", "host": "eoc.local.mesh", "subject": "esv.messages"}."""
+	config = dict(config)
+	kind = config.pop("type", None)
+	if kind not in EVENT_PUBLISHER_TYPES:
+		raise ValueError(f"Event publisher {name}: unknown type {kind}")
+	try:
+		return EVENT_PUBLISHER_TYPES[kind](name, enable_debug=enable_debug, **config)
+	except TypeError as e:
+		raise ValueError(f"Event publisher {name}: {e}")
+
+
+def load_event_publishers(filename, enable_debug=False):
+	"""Read publishers from a JSON file: {"publishers": {"eoc": {"type": "redis", "host": "...", "channel": "esv"}}}.
+	Raises ValueError for bad entries."""
+	with open(filename, 'r') as f:
+		config = json.load(f)
+	return [create_event_publisher(name, entry, enable_debug) for name, entry in config.get("publishers", {}).items()]
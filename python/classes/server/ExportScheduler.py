@@ -0,0 +1,379 @@
+#!/usr/bin/env python
+'''Runs export jobs on a schedule so downstream consumers always have a fresh map file'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import datetime
+import json
+import logging
+import os
+import re
+import time
+from classes.geo import Annotations
+from classes.export import ExportBundle
+from classes.geo import Elevation
+from classes.geo.Elevation import ElevationModel
+from classes.export.ExportChanges import ChangeTracker, STATE_FILE_SUFFIX, removal
+from classes.geo import GridDensity
+from classes.export import MapExport
+from classes.export import PrintLayout
+from classes.export import Translation
+from classes.geo import WeatherHazards
+from classes.server.Publisher import HttpPublisher, create_publisher
+from classes.export.StaticMap import StaticMap, parse_bbox, parse_size
+from classes.store.WinlinkMailMessage import safe_filename
+
+EXPORT_FORMATS = {
+	"geojson": "application/geo+json",
+	"kml": "application/vnd.google-earth.kml+xml",
+	"kmz": "application/vnd.google-earth.kmz",
+	"png": "image/png",
+	"pdf": "application/pdf",  # The print layout
+	"csv": "text/csv",
+	"ndjson": "application/x-ndjson",  # One feature per line
+}
+INCREMENTAL_FORMATS = ("geojson", "ndjson")  # Formats that can carry just the features that changed
+INTERVAL = re.compile(r"^\s*(\d+)\s*([smhd]?)\s*$", re.IGNORECASE)
+INTERVAL_SECONDS = {"": 60, "s": 1, "m": 60, "h": 3600, "d": 86400}  # A bare number is minutes
+CRON_FIELDS = [("minute", 0, 59), ("hour", 0, 23), ("day", 1, 31), ("month", 1, 12), ("weekday", 0, 6)]
+POLL_SECONDS = 5
+MAP_SIZE_DEFAULT = (800, 600)
+
+
+def parse_interval(text):
+	"""Seconds in "30s", "10m", "1h", "1d", or a bare number of minutes.  Raises ValueError."""
+	match = INTERVAL.match(str(text))
+	if match is None or int(match.group(1)) == 0:
+		raise ValueError(f"Bad interval <{text}>; expected e.g. 30s, 10m, 1h")
+	return int(match.group(1)) * INTERVAL_SECONDS[match.group(2).lower()]
+
+
+def _cron_field(text, low, high):
+	"""The set of values a cron field allows: *, */n, a, a-b, a-b/n, a/n (a to the end, every n), and
+	comma-separated lists."""
+	values = set()
+	for part in text.split(","):
+		step = None
+		if "/" in part:
+			part, step = part.split("/", 1)
+			step = int(step)
+		if part == "*":
+			start, end = low, high
+		elif "-" in part:
+			start, end = (int(n) for n in part.split("-", 1))
+		else:
+			start = int(part)
+			end = high if step is not None else start
+		step = 1 if step is None else step
+		if start < low or end > high or start > end or step < 1:
+			raise ValueError(f"<{text}> is outside {low}-{high}")
+		values.update(range(start, end + 1, step))
+	return values
+
+
+class CronSchedule:
+	"""A five-field cron expression (minute hour day month weekday, Sunday is 0) in local time."""
+
+	def __init__(self, expression):
+		parts = expression.split()
+		if len(parts) != len(CRON_FIELDS):
+			raise ValueError(f"Bad cron expression <{expression}>; expected five fields")
+		try:
+			self.fields = {name: _cron_field(part, low, high) for part, (name, low, high) in zip(parts, CRON_FIELDS)}
+		except ValueError as e:
+			raise ValueError(f"Bad cron expression <{expression}>: {e}")
+		# As in cron, when both day and weekday are restricted (neither starts with *), either may match
+		self.either_day = not parts[2].startswith("*") and not parts[4].startswith("*")
+		self.expression = expression
+
+	def matches(self, when):
+		"""True if the schedule fires in the minute containing when (a local datetime)."""
+		day = when.day in self.fields["day"]
+		weekday = (when.weekday() + 1) % 7 in self.fields["weekday"]
+		return (when.minute in self.fields["minute"] and when.hour in self.fields["hour"]
+			and when.month in self.fields["month"] and ((day or weekday) if self.either_day else (day and weekday)))
+
+
+class ExportJob:
+	"""One export: which messages, in what format, where it goes, and how often."""
+
+	def __init__(self, name, format, every=None, cron=None, output=None, url=None, method="PUT", headers=None, publish=None,
+			file_name=None, paths=None, source_path=None, gateway=None, tiles=None, size=None, bbox=None, grid=None, language=None,
+			incremental=False, state=None, annotations=True, hazards=True, paper=None, orientation=None, dem=None, enable_debug=False):
+		if format not in EXPORT_FORMATS:
+			raise ValueError(f"Export job {name}: format must be one of {', '.join(sorted(EXPORT_FORMATS))}")
+		if (every is None) == (cron is None):
+			raise ValueError(f"Export job {name}: give exactly one of every or cron")
+		if output is None and url is None and not publish:
+			raise ValueError(f"Export job {name}: give an output file, a url, or publishers")
+		self.name = name
+		self.format = format
+		try:
+			self.interval = parse_interval(every) if every is not None else None
+			self.cron = CronSchedule(cron) if cron is not None else None
+			self.size = parse_size(size) if size else MAP_SIZE_DEFAULT
+			self.bbox = parse_bbox(bbox) if bbox else None
+			self.grid = GridDensity.check_precision(grid) if grid is not None else None  # Grid square precision of a density layer
+			if self.grid is not None and format not in INCREMENTAL_FORMATS:
+				raise ValueError("a grid density layer is only exported as geojson or ndjson")
+			if incremental and format not in INCREMENTAL_FORMATS:
+				raise ValueError(f"only {' and '.join(INCREMENTAL_FORMATS)} exports can be incremental")
+			self.language = Translation.check_language(language) if language is not None else None  # None: the scheduler's language
+			if paper is not None and paper not in PrintLayout.PAPERS:
+				raise ValueError(f"paper must be one of {', '.join(PrintLayout.PAPERS)}")
+			if orientation is not None and orientation not in PrintLayout.ORIENTATIONS:
+				raise ValueError(f"orientation must be {' or '.join(PrintLayout.ORIENTATIONS)}")
+			if dem is not None and not os.path.isdir(dem):
+				raise ValueError(f"dem folder {dem} doesn't exist")
+		except ValueError as e:
+			raise ValueError(f"Export job {name}: {e}")
+		self.output = output
+		self.publish = publish or []  # Names of the publishers to deliver to
+		self.publishers = []  # Publishers, filled in by the scheduler from publish
+		if url is not None:
+			# Shorthand for an HTTP publisher of its own.  PUT replaces the file; some servers want POST.
+			self.publishers.append(HttpPublisher(f"{name} url", url, method, headers, enable_debug=enable_debug))
+		self.file_name = file_name or (os.path.basename(output) if output else f"{safe_filename(name)}.{format}")
+		self.paths = paths  # Folders or files to read; None means the scheduler's default
+		self.source_path = source_path
+		self.gateway = gateway
+		self.tiles = tiles  # For png and pdf: folder of {z}/{x}/{y}.png tiles
+		self.paper = paper or PrintLayout.PAPER_DEFAULT  # For pdf
+		self.orientation = orientation or PrintLayout.LANDSCAPE
+		self.elevation = ElevationModel(dem, enable_debug=enable_debug) if dem is not None else None  # None: the scheduler's --dem
+		self.annotations = bool(annotations) and self.grid is None  # Include net control's notes, lines, and areas
+		self.hazards = bool(hazards) and self.grid is None  # Include the NWS hazards overlay from weather bulletins
+		self.incremental = bool(incremental)  # Deliver only the features that changed since the last delivery
+		self.tracker = None
+		if self.incremental:
+			self.tracker = ChangeTracker(state or f"{output or safe_filename(name)}{STATE_FILE_SUFFIX}", enable_debug)
+		self.last_run = None  # time.time() of the last run
+		self.last_minute = None  # Last cron minute run, so a minute isn't run twice
+
+	def due(self, now):
+		"""True if the job should run at now (time.time())."""
+		if self.interval is not None:
+			return self.last_run is None or now - self.last_run >= self.interval
+		minute = datetime.datetime.fromtimestamp(now).replace(second=0, microsecond=0)
+		return minute != self.last_minute and self.cron.matches(minute)
+
+	def mark_run(self, now):
+		self.last_run = now
+		self.last_minute = datetime.datetime.fromtimestamp(now).replace(second=0, microsecond=0)
+
+	@property
+	def source(self):
+		"""What the job reads.  Jobs that read the same thing share one pass over it."""
+		return (tuple(self.paths) if self.paths else None, self.source_path, self.gateway)
+
+	def build(self, messages, shared=None):
+		"""The export of messages (B2Messages, in date order) as bytes in the job's format and language.
+		shared is a dict kept for one pass over the messages, so jobs in other formats reuse the
+		features built for the first."""
+		with Translation.using(self.language), Elevation.using(self.elevation):
+			return self._build(messages, {} if shared is None else shared)
+
+	def build_changes(self, messages, shared=None):
+		"""For an incremental job: (the features that changed since the last delivery, and removals for
+		those no longer exported, as bytes in the job's format, or None if nothing changed; the state to
+		save once the bytes are delivered)."""
+		with Translation.using(self.language), Elevation.using(self.elevation):
+			changed, removed, state = self.tracker.changes(self._features(messages, {} if shared is None else shared))
+			if not changed and not removed:
+				return None, state
+			return self._encode(changed + [removal(feature_id) for feature_id in removed]), state
+
+	def _features(self, messages, shared):
+		if self.grid is not None:
+			return GridDensity.grid_features(messages, self.grid)
+		# Popups are in the job's language and elevations from its dem, so features are only shared
+		# between jobs with the same ones
+		model = Elevation.current_model()
+		key = (Translation.current_language(), model.folder if model is not None else None)
+		if key not in shared:
+			shared[key] = [f for m in messages for f in MapExport.message_features(m)]
+		if "annotations" not in shared:
+			shared["annotations"] = Annotations.features()
+			shared["hazards"] = WeatherHazards.features(messages)
+		# Hazards come first so they are drawn under the station markers
+		return (shared["hazards"] if self.hazards else []) + shared[key] + (shared["annotations"] if self.annotations else [])
+
+	def _build(self, messages, shared):
+		if self.format == "csv":
+			return ExportBundle.message_csv(messages).encode("utf-8")
+		return self._encode(self._features(messages, shared))
+
+	def _encode(self, features):
+		if self.format == "geojson":
+			return json.dumps(MapExport.feature_collection(features, self.name), indent=4).encode("utf-8")
+		if self.format == "ndjson":
+			return "".join(json.dumps(feature) + "\n" for feature in features).encode("utf-8")
+		if self.format == "png":
+			return StaticMap(self.tiles).render(features, self.size[0], self.size[1], self.bbox).to_png()
+		if self.format == "pdf":
+			return PrintLayout.print_map(features, self.name, self.paper, self.orientation, static_map=StaticMap(self.tiles), bbox=self.bbox).to_bytes()
+		if self.format == "kml":
+			return MapExport.kml_document(self.name, [(self.name, features)]).encode("utf-8")
+		return MapExport.kmz_document(self.name, [(self.name, features)])
+
+
+def write_atomically(filename, data):
+	"""Replace filename with data so readers never see a partly written file."""
+	folder = os.path.dirname(filename)
+	if folder:
+		os.makedirs(folder, exist_ok=True)
+	temporary = f"{filename}.tmp"
+	with open(temporary, 'wb') as f:
+		f.write(data)
+	os.replace(temporary, filename)
+
+
+def append_to(filename, data):
+	"""Add data to the end of filename, as an incremental NDJSON export grows."""
+	folder = os.path.dirname(filename)
+	if folder:
+		os.makedirs(folder, exist_ok=True)
+	with open(filename, 'ab') as f:
+		f.write(data)
+
+
+class ExportScheduler:
+	"""Runs export jobs when they are due.  load(paths, source_path, gateway) returns the messages
+	to export, so the scheduler works on whatever mailbox or capture folders the caller reads."""
+
+	def __init__(self, jobs, load, paths=None, enable_debug=False):
+		self.jobs = jobs
+		self.load = load
+		self.paths = paths or []  # Default for jobs that don't name their own
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@classmethod
+	def from_file(cls, filename, load, paths=None, incremental=False, enable_debug=False):
+		"""Load publishers and jobs from a JSON file:
+		{"publishers": {"node2": {"type": "rsync", "target": "root@node2.local.mesh:/www/esv/", "port": 2222}},
+		 "jobs": [{"name": "mesh map", "format": "kmz", "every": "10m", "output": "/www/export/esv.kmz", "publish": ["node2"]},
+		          {"name": "county", "format": "geojson", "cron": "0 * * * *", "url": "http://eoc.local.mesh/esv.geojson"}]}
+		With incremental, every geojson and ndjson job that doesn't say otherwise is incremental.
+		Raises ValueError for bad entries."""
+		with open(filename, 'r') as f:
+			config = json.load(f)
+		publishers = {name: create_publisher(name, entry, enable_debug) for name, entry in config.get("publishers", {}).items()}
+		jobs = []
+		for entry in config.get("jobs", []):
+			entry = dict(entry)
+			name = entry.pop("name", f"job {len(jobs) + 1}")
+			if incremental and entry.get("format") in INCREMENTAL_FORMATS:
+				entry.setdefault("incremental", True)
+			try:
+				job = ExportJob(name, enable_debug=enable_debug, **entry)
+			except TypeError as e:
+				raise ValueError(f"Export job {name}: {e}")
+			for publisher in job.publish:
+				if publisher not in publishers:
+					raise ValueError(f"Export job {name}: unknown publisher {publisher}")
+				job.publishers.append(publishers[publisher])
+			jobs.append(job)
+		return cls(jobs, load, paths, enable_debug)
+
+	def run_job(self, job):
+		"""Build and deliver one job now.  Returns True if every destination got the file."""
+		return self.run_jobs([job])[job.name]
+
+	def run_jobs(self, jobs):
+		"""Build and deliver jobs now, reading and parsing the messages once for all the jobs that read
+		the same paths, however many formats they write.  Returns {job name: True if every destination
+		got the file}."""
+		passes = {}
+		for job in jobs:
+			passes.setdefault(job.source, []).append(job)
+		delivered = {}
+		for group in passes.values():
+			first = group[0]
+			try:
+				messages = MapExport.ordered(self.load(first.paths or self.paths, first.source_path, first.gateway))
+			except Exception as e:
+				for job in group:
+					self.logger.error(f"Export job {job.name} failed: {e}")
+					delivered[job.name] = False
+				continue
+			self._log_debug(f"Read {len(messages)} messages once for export jobs {', '.join(job.name for job in group)}")
+			shared = {}
+			for job in group:
+				delivered[job.name] = self._deliver(job, messages, shared)
+		return delivered
+
+	def _deliver(self, job, messages, shared):
+		state = None
+		try:
+			if job.incremental:
+				data, state = job.build_changes(messages, shared)
+				if data is None:
+					self._log_debug(f"Export job {job.name}: nothing changed")
+					return True
+			else:
+				data = job.build(messages, shared)
+		except Exception as e:
+			self.logger.error(f"Export job {job.name} failed: {e}")
+			return False
+		delivered = True
+		for publisher in job.publishers:
+			if not publisher.publish(job.file_name, data, EXPORT_FORMATS[job.format]):
+				delivered = False
+		appends = job.incremental and job.format == "ndjson"
+		if job.output is not None and appends and not delivered:
+			# The changes will be sent again, so adding them to the file now would add them twice
+			self._log_debug(f"Export job {job.name}: not appending to {job.output} until every publisher has the changes")
+		elif job.output is not None:
+			try:
+				if appends:
+					append_to(job.output, data)
+				else:
+					write_atomically(job.output, data)
+				self._log_debug(f"Export job {job.name} wrote {len(data)} bytes to {job.output}")
+			except OSError as e:
+				self.logger.error(f"Export job {job.name} could not write {job.output}: {e}")
+				delivered = False
+		if state is not None and delivered:
+			# Until every destination has the changes, the next run sends them again
+			try:
+				job.tracker.save(state)
+			except OSError as e:
+				self.logger.error(f"Export job {job.name} could not save {job.tracker.filename}: {e}")
+				delivered = False
+		return delivered
+
+	def run_pending(self, now=None):
+		"""Run every job that is due.  Returns the names of the jobs that ran."""
+		now = time.time() if now is None else now
+		due = [job for job in self.jobs if job.due(now)]
+		for job in due:
+			job.mark_run(now)
+		self.run_jobs(due)
+		return [job.name for job in due]
+
+	def run_forever(self, stop=None):
+		"""Run jobs as they come due until stop (a threading.Event) is set or the process is interrupted."""
+		while stop is None or not stop.is_set():
+			self.run_pending()
+			if stop is not None:
+				stop.wait(POLL_SECONDS)
+			else:
+				time.sleep(POLL_SECONDS)
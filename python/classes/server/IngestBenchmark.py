@@ -0,0 +1,229 @@
+#!/usr/bin/env python
+'''Measures how fast a running server ingests messages: sustained rate and time to acknowledge'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import math
+import queue
+import socket
+import threading
+import time
+from classes.b2f import OutboundMessage
+from classes.b2f.WinlinkTime import utc_now
+
+CLIENT_SID = "[ESVMAP-1.0-B2F$]"
+LOGIN_PROMPT = "Callsign :"
+PASSWORD_PROMPT = "Password :"
+SERVER_PROMPT = "CMS>"
+SESSION_TIMEOUT_SECONDS = 30
+SUSTAINED_FRACTION = 0.95  # Share of the target rate that counts as keeping up
+PERCENTILES = [50, 90, 99]
+ACCEPTED_ANSWERS = "Y+"  # FS answers that mean "send it now"; N and - reject, L and = defer
+TRACE_ROOT = "message"  # The span a message's trace starts with, carrying its MID
+TRACED_STAGES = ["parse", "store"]  # Stages timed from the server's traces, after FF
+
+
+def percentile(values, p):
+	"""Nearest-rank percentile of a list of numbers, or None if it is empty."""
+	if not values:
+		return None
+	ordered = sorted(values)
+	return ordered[max(0, math.ceil(p / 100 * len(ordered)) - 1)]
+
+
+def latency_summary(seconds):
+	"""p50/p90/p99/max/mean of latencies, in milliseconds."""
+	summary = {f"p{p}_ms": _ms(percentile(seconds, p)) for p in PERCENTILES}
+	summary["max_ms"] = _ms(max(seconds)) if seconds else None
+	summary["mean_ms"] = _ms(sum(seconds) / len(seconds)) if seconds else None
+	return summary
+
+
+def _ms(seconds):
+	return round(seconds * 1000, 1) if seconds is not None else None
+
+
+def traced_stages(spans, mids):
+	"""The time the server spent in each of TRACED_STAGES on the given messages, from the spans of its
+	traces file, as latency summaries keyed by stage, and the number of those messages it traced.  A
+	message's spans are found by the MID on its trace's root span, which is written when the server is
+	done with it."""
+	wanted = set(mids)
+	traces = {span["trace_id"] for span in spans if span.get("parent_id") is None and span.get("name") == TRACE_ROOT and (span.get("attributes") or {}).get("mid") in wanted}
+	seconds = {stage: [] for stage in TRACED_STAGES}
+	for span in spans:
+		if span.get("trace_id") in traces and span.get("name") in seconds:
+			seconds[span["name"]].append(span["duration_ms"] / 1000)
+	return {stage: latency_summary(durations) for stage, durations in seconds.items()}, len(traces)
+
+
+def prepare_messages(traffic, count):
+	"""(mid, proposal, transfer) for count messages from an ExerciseTraffic, compressed ahead of time so
+	the benchmark measures the server rather than the encoder."""
+	prepared = []
+	for mid, subject, text in traffic.messages(count):
+		frame, compressed = OutboundMessage.transfer(subject, text)
+		prepared.append((mid, OutboundMessage.proposal(mid, len(text), len(compressed)), frame))
+	return prepared
+
+
+class SessionError(Exception):
+	pass
+
+
+class IngestBenchmark:
+	"""Delivers prepared messages to a server over telnet at a target rate, batch messages per session, from
+	up to concurrency sessions at once.  Each session's acknowledgement time runs from the last byte of its
+	transfer to the server's FF.  The server reads a transfer until its read timeout and sends FF once the
+	batch is queued, so this is mostly that timeout; decoding and saving come after FF, and the server's
+	traces time them (see traced_stages)."""
+
+	def __init__(self, host, port, rate, batch=1, concurrency=8, callsign="BENCH", password="", timeout=SESSION_TIMEOUT_SECONDS, enable_debug=False):
+		self.host = host
+		self.port = port
+		self.rate = rate  # Messages per second
+		self.batch = batch
+		self.concurrency = concurrency
+		self.callsign = callsign
+		self.password = password
+		self.timeout = timeout
+		self.enable_debug = enable_debug
+		self.sessions = []  # One dict per session, filled in as they finish
+		self.lock = threading.Lock()
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@staticmethod
+	def _read_line(stream):
+		"""A line from the server, which ends its lines with a carriage return."""
+		line = bytearray()
+		while True:
+			byte = stream.read(1)
+			if not byte:
+				raise SessionError("connection closed by the server")
+			if byte == b"\r":
+				return line.decode("utf-8", errors="replace").strip()
+			line += byte
+
+	def _expect(self, stream, prefix):
+		while True:
+			line = self._read_line(stream)
+			if line.startswith(prefix):
+				return line
+			self._log_debug(f"Skipping <{line}> while waiting for {prefix}")
+
+	def session(self, messages):
+		"""Deliver messages in one session.  Returns the seconds from the end of the transfer to FF (None if
+		nothing was sent) and the MIDs the server accepted; it may defer the rest when it is busy."""
+		with socket.create_connection((self.host, self.port), timeout=self.timeout) as connection, connection.makefile('rb') as stream:
+			self._expect(stream, LOGIN_PROMPT)
+			connection.sendall(f"{self.callsign}\r".encode())
+			self._expect(stream, PASSWORD_PROMPT)
+			connection.sendall(f"{self.password or self.callsign}\r".encode())
+			self._expect(stream, SERVER_PROMPT)
+			proposals = "".join(f"{proposal}\r" for _, proposal, _ in messages)
+			connection.sendall(f"{CLIENT_SID}\r{proposals}F> {OutboundMessage.proposal_checksum(proposals)}\r".encode())
+			answer = self._expect(stream, "FS")[2:].strip().upper()
+			if len(answer) != len(messages):
+				raise SessionError(f"server answered <FS {answer}> to {len(messages)} proposals")
+			accepted = [(mid, frame) for (mid, _, frame), code in zip(messages, answer) if code in ACCEPTED_ANSWERS]
+			acknowledged = None
+			if accepted:
+				connection.sendall(b"".join(frame for _, frame in accepted))
+				sent = time.monotonic()
+				self._expect(stream, "FF")
+				acknowledged = time.monotonic() - sent
+			connection.sendall(b"FQ\r")
+			return acknowledged, [mid for mid, _ in accepted]
+
+	def _worker(self, work, start):
+		while True:
+			try:
+				due, messages = work.get_nowait()
+			except queue.Empty:
+				return
+			delay = start + due - time.monotonic()
+			if delay > 0:
+				time.sleep(delay)
+			began = time.monotonic()
+			record = {"mids": [mid for mid, _, _ in messages], "accepted": [], "began": began - start, "lag": max(0.0, began - start - due)}
+			try:
+				record["acknowledged"], record["accepted"] = self.session(messages)
+			except (OSError, SessionError) as e:
+				record["error"] = str(e) or e.__class__.__name__
+				self._log_debug(f"Session for {record['mids'][0]} failed: {record['error']}")
+			record["duration"] = time.monotonic() - began
+			with self.lock:
+				self.sessions.append(record)
+
+	def run(self, messages):
+		"""Deliver every prepared message on schedule and return the results as a dict."""
+		work = queue.Queue()
+		batches = [messages[i:i + self.batch] for i in range(0, len(messages), self.batch)]
+		for index, batch in enumerate(batches):
+			work.put((index * self.batch / self.rate, batch))
+		self.sessions = []
+		started = utc_now()
+		start = time.monotonic()
+		workers = [threading.Thread(target=self._worker, args=(work, start), daemon=True) for _ in range(min(self.concurrency, len(batches)))]
+		for worker in workers:
+			worker.start()
+		for worker in workers:
+			worker.join()
+		elapsed = time.monotonic() - start
+		return self.results(started, elapsed, len(messages))
+
+	@property
+	def accepted_mids(self):
+		"""MIDs of the messages the server acknowledged with FF."""
+		return [mid for s in self.sessions for mid in s["accepted"]]
+
+	def results(self, started, elapsed, offered):
+		succeeded = [s for s in self.sessions if "error" not in s]
+		errors = {}
+		for s in self.sessions:
+			if "error" in s:
+				errors[s["error"]] = errors.get(s["error"], 0) + 1
+		accepted = sum(len(s["accepted"]) for s in succeeded)
+		deferred = sum(len(s["mids"]) - len(s["accepted"]) for s in succeeded)
+		# The rate is taken over the sending window, which stretches when the sessions fall behind
+		# schedule, rather than over the run, whose end waits out the last session
+		window = max((s["began"] for s in self.sessions), default=0.0) + self.batch / self.rate
+		achieved = accepted / window if window > 0 else 0.0
+		return {
+			"server": f"{self.host}:{self.port}",
+			"started": started.isoformat(timespec="seconds"),
+			"target_rate": self.rate,
+			"batch": self.batch,
+			"concurrency": self.concurrency,
+			"elapsed_seconds": round(elapsed, 3),
+			"window_seconds": round(window, 3),
+			"messages_offered": offered,
+			"messages_accepted": accepted,
+			"messages_deferred": deferred,
+			"sessions": len(self.sessions),
+			"sessions_failed": len(self.sessions) - len(succeeded),
+			"errors": errors,
+			"achieved_rate": round(achieved, 2),
+			"sustained": achieved >= self.rate * SUSTAINED_FRACTION and accepted == offered,
+			"acknowledgement": latency_summary([s["acknowledged"] for s in succeeded if s["acknowledged"] is not None]),
+			"session_duration": latency_summary([s["duration"] for s in succeeded]),
+			"schedule_lag": latency_summary([s["lag"] for s in self.sessions]),
+		}
@@ -0,0 +1,348 @@
+#!/usr/bin/env python
+'''Decodes, saves, and announces received messages on worker threads, through a bounded queue'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import collections
+import json
+import logging
+import threading
+import time
+import traceback
+from classes.server.EventPublisher import message_event
+from classes.store.IngestJournal import SAVED, QUARANTINED
+from classes.server import Tracing
+from classes.store.WinlinkMailMessage import WinlinkMailMessage
+
+BLOCK = "block"  # Hold the sending session until there is room
+DROP = "drop"  # Turn away new messages: defer proposals, drop transfers that don't fit
+OLDEST = "oldest"  # Make room by dropping the oldest queued messages
+POLICIES = [BLOCK, DROP, OLDEST]
+
+CAPACITY_DEFAULT = 200  # Messages
+MAX_BYTES_DEFAULT = 8 * 1024 * 1024  # Raw transfer bytes held in the queue
+BLOCK_TIMEOUT_SECONDS = 30  # How long a blocked session waits before its message is dropped
+SPILL_POLL_SECONDS = 1  # How often an idle worker looks for spilled messages to take back
+WORKERS_DEFAULT = 1
+ACCEPT = "Y"  # FS answers: take the message now
+DEFER = "L"  # or send it again later
+
+
+class BoundedQueue:
+	"""A FIFO limited in both entries and bytes, with a policy for what happens when it is full."""
+
+	def __init__(self, capacity=CAPACITY_DEFAULT, max_bytes=MAX_BYTES_DEFAULT, policy=BLOCK, block_timeout=BLOCK_TIMEOUT_SECONDS):
+		if policy not in POLICIES:
+			raise ValueError(f"Unknown queue policy {policy}; expected one of {', '.join(POLICIES)}")
+		if capacity < 1 or max_bytes < 1:
+			raise ValueError("Queue capacity and max_bytes must be positive")
+		self.capacity = capacity
+		self.max_bytes = max_bytes
+		self.policy = policy
+		self.block_timeout = block_timeout
+		self.items = collections.deque()  # (item, size)
+		self.bytes = 0
+		self.closed = False
+		self.condition = threading.Condition()
+		self.dropped = []  # Items dropped to keep within the limits, oldest first; see take_dropped()
+		self.high_water = 0
+		self.blocked_seconds = 0.0
+
+	def __len__(self):
+		with self.condition:
+			return len(self.items)
+
+	def _fits(self, size):
+		# An item bigger than max_bytes is let into an empty queue rather than refused forever
+		return len(self.items) < self.capacity and (self.bytes + size <= self.max_bytes or not self.items)
+
+	def room(self):
+		"""Entries that can be added now without waiting or dropping anything."""
+		with self.condition:
+			return max(0, self.capacity - len(self.items))
+
+	def put(self, item, size):
+		"""Queue an item of size bytes.  Returns False if the item itself was dropped."""
+		with self.condition:
+			if self.closed:
+				raise RuntimeError("Queue is closed")
+			if self.policy == BLOCK and not self._fits(size):
+				started = time.monotonic()
+				self.condition.wait_for(lambda: self._fits(size) or self.closed, self.block_timeout)
+				self.blocked_seconds += time.monotonic() - started
+			if self.policy == OLDEST and size <= self.max_bytes:
+				# An item bigger than max_bytes would empty the queue to get in, so only it is refused
+				while self.items and not self._fits(size):
+					old, old_size = self.items.popleft()
+					self.bytes -= old_size
+					self.dropped.append(old)
+			if not self._fits(size):
+				self.dropped.append(item)
+				return False
+			self.items.append((item, size))
+			self.bytes += size
+			self.high_water = max(self.high_water, len(self.items))
+			self.condition.notify_all()
+			return True
+
+	def get(self, timeout=None):
+		"""The oldest item, waiting for one up to timeout seconds; None if there is none, or the queue is closed and empty."""
+		with self.condition:
+			self.condition.wait_for(lambda: self.items or self.closed, timeout)
+			if not self.items:
+				return None
+			item, size = self.items.popleft()
+			self.bytes -= size
+			self.condition.notify_all()
+			return item
+
+	def take_dropped(self):
+		"""Items dropped since the last call."""
+		with self.condition:
+			dropped, self.dropped = self.dropped, []
+			return dropped
+
+	def close(self):
+		"""Refuse new items and wake every waiter; queued items can still be taken."""
+		with self.condition:
+			self.closed = True
+			self.condition.notify_all()
+
+
+class IngestPipeline:
+	"""Takes captured WinlinkMailMessages from connections and decodes, saves, alerts on, and publishes
+	each one on worker threads, so a burst of traffic waits in a bounded queue rather than in memory
+	without limit.  With workers=0 messages are processed on the submitting thread, as they once were."""
+
+	def __init__(self, alerts=None, events=None, capacity=CAPACITY_DEFAULT, max_bytes=MAX_BYTES_DEFAULT, policy=BLOCK,
+			block_timeout=BLOCK_TIMEOUT_SECONDS, workers=WORKERS_DEFAULT, journal=None, enable_debug=False):
+		self.alerts = alerts  # AlertEngine, or None if alerting is off
+		self.events = events or []  # EventPublishers told about each message received
+		self.journal = journal  # IngestJournal each transfer is written to before it is queued, or None
+		self.queue = BoundedQueue(capacity, max_bytes, policy, block_timeout)
+		self.enable_debug = enable_debug
+		self.processed = 0
+		self.failed = 0
+		self.quarantined = 0
+		self.dropped = 0
+		self.spilled = collections.deque()  # (journal sequence, mailbox folder) of dropped messages kept in the journal
+		self.lock = threading.Lock()
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+		self.workers = [threading.Thread(target=self._work, name=f"ingest-{i + 1}", daemon=True) for i in range(workers)]
+		for worker in self.workers:
+			worker.start()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@classmethod
+	def from_file(cls, filename, alerts=None, events=None, journal=None, enable_debug=False):
+		"""A pipeline configured from a JSON file, e.g. {"capacity": 100, "max_bytes": 4000000, "policy": "oldest"}.
+		Raises ValueError for bad settings."""
+		with open(filename, 'r') as f:
+			config = json.load(f)
+		try:
+			return cls(alerts, events, journal=journal, enable_debug=enable_debug, **config)
+		except TypeError as e:
+			raise ValueError(f"{filename}: {e}")
+
+	def answers(self, count):
+		"""The FS answer for count proposals.  Under the drop policy, proposals beyond the room left
+		are deferred so the client keeps them for its next session instead of sending them now."""
+		if not self.workers or self.queue.policy != DROP:
+			return ACCEPT * count
+		accepted = min(count, self.queue.room())
+		if accepted < count:
+			self.logger.warning(f"Ingest queue full: deferring {count - accepted} of {count} proposals")
+		return ACCEPT * accepted + DEFER * (count - accepted)
+
+	def submit(self, message, size):
+		"""Hand over a captured message whose raw transfer is size bytes.  Returns False if it was lost:
+		dropped from a full queue with no journal to keep it in, so the client must not be told it
+		arrived.  With a journal, the transfer is on disk when this returns, and a message the queue
+		has no room for waits there until it does."""
+		self._trace(message)
+		if self.journal is not None:
+			with Tracing.span("journal", message.span):
+				message.journal_sequence = self.journal.append(message, message.b2.raw_data)
+		if not self.workers:
+			self.process(message)
+			return True
+		message.queued_at = Tracing.now()
+		self.queue.put(message, size)
+		lost = False
+		for dropped in self.queue.take_dropped():
+			if dropped.journal_sequence is not None:
+				# Only the sequence number is held, so spilled messages cost no memory
+				with self.lock:
+					self.spilled.append((dropped.journal_sequence, dropped.folder))
+				self.logger.warning(f"Ingest queue full ({self.queue.policy}): message {dropped.message_id} waits in the journal")
+				dropped.span.set_attribute("spilled", True)
+			else:
+				with self.lock:
+					self.dropped += 1
+				lost = lost or dropped is message
+				self.logger.error(f"Ingest queue full ({self.queue.policy}): dropped message {dropped.message_id}")
+				dropped.span.set_error(f"dropped: ingest queue full ({self.queue.policy})")
+			dropped.span.end()
+		return not lost
+
+	@staticmethod
+	def _trace(message):
+		"""Start the message's trace here if its connection didn't, as for journal replays."""
+		if message.span is None:
+			message.span = Tracing.start_span("message", mid=message.message_id, bytes=len(message.b2.raw_data) if message.b2 is not None else None)
+
+	def _from_journal(self, sequence, description, raw, folder=None):
+		options = {"folder": folder} if folder else {}
+		message = WinlinkMailMessage(description.get("type"), description.get("mid"), description.get("size"), description.get("compressed_size"),
+			enable_debug=self.enable_debug, source=description.get("source"), **options)
+		message.journal_sequence = sequence
+		message.capture(raw)
+		return message
+
+	def replay(self, folder=None):
+		"""Process, on this thread, the journal entries a crash left unfinished.  Call it at startup,
+		before connections arrive.  Returns the number replayed."""
+		if self.journal is None:
+			return 0
+		entries = self.journal.pending()
+		for sequence, description, raw in entries:
+			self.logger.warning(f"Replaying message {description.get('mid')} received {description.get('received')} from the journal")
+			self.process(self._from_journal(sequence, description, raw, folder))
+		return len(entries)
+
+	def _take_spilled(self):
+		"""Process the oldest message spilled to the journal, if there is one.  Returns True if there was."""
+		with self.lock:
+			if not self.spilled:
+				return False
+			sequence, folder = self.spilled.popleft()
+		try:
+			entry = self.journal.read(sequence)
+		except (OSError, ValueError) as e:
+			# Still open in the journal, so the next start replays it
+			self.logger.error(f"Error reading spilled message {sequence} from the journal: {e}")
+			return True
+		if entry is not None:
+			self._log_debug(f"Taking message {entry[0].get('mid')} back from the journal")
+			self.process(self._from_journal(sequence, *entry, folder))
+		return True
+
+	def _complete(self, message, status):
+		if self.journal is not None and message.journal_sequence is not None:
+			try:
+				self.journal.complete(message.journal_sequence, status)
+			except OSError as e:
+				self.logger.error(f"Error updating the journal for {message.message_id}: {e}")
+
+	def _work(self):
+		while True:
+			message = self.queue.get(SPILL_POLL_SECONDS)
+			if message is None:
+				if self.queue.closed and not len(self.queue):
+					return
+				# The queue is idle, so spilled messages no longer crowd out new ones
+				self._take_spilled()
+				continue
+			Tracing.record("queue", message.span, message.queued_at, Tracing.now(), policy=self.queue.policy)
+			self.process(message)
+
+	def process(self, message):
+		"""Decode, save, and announce one message.  A message that can't be decoded, or that crashes a
+		parser, is quarantined with its diagnostics; either way it costs only that message."""
+		self._trace(message)
+		try:
+			with Tracing.activate(message.span):
+				self._process(message)
+		finally:
+			message.span.end()
+
+	def _process(self, message):
+		problem = message.decode()
+		if problem is None:
+			with Tracing.span("store") as span:
+				try:
+					message.save_message_to_files()
+				except Exception as e:
+					problem = "save", f"{e.__class__.__name__}: {e}", traceback.format_exc()
+					span.set_error(problem[1])
+		if problem is not None:
+			message.span.set_error(f"quarantined at {problem[0]}: {problem[1]}")
+			self._quarantine(message, problem)
+			return
+		if message.b2.form is not None:
+			message.span.set_attribute("form_type", message.b2.form.form_type)
+		self._complete(message, SAVED)
+		self._evaluate_alerts(message)
+		self._publish_events(message)
+		with self.lock:
+			self.processed += 1
+
+	def _quarantine(self, message, problem):
+		try:
+			message.quarantine(*problem)
+		except Exception as e:
+			# Left open in the journal, so the next start tries it again
+			with self.lock:
+				self.failed += 1
+			self.logger.error(f"Error quarantining message {message.message_id} ({problem[0]}: {problem[1]}): {e}")
+			return
+		with self.lock:
+			self.quarantined += 1
+		self._complete(message, QUARANTINED)
+
+	def _evaluate_alerts(self, message):
+		"""Run the alert rules over a received message; alerting problems never interrupt the session."""
+		if self.alerts is None or message.b2 is None or message.b2.decompressed_data is None:
+			return
+		with Tracing.span("alerts") as span:
+			try:
+				self.alerts.evaluate(message.b2)
+			except Exception as e:
+				self.logger.error(f"Error evaluating alerts for {message.message_id}: {e}")
+				span.set_error(e)
+
+	def _publish_events(self, message):
+		"""Tell the event brokers about a received message; broker problems never interrupt the session."""
+		if not self.events or message.b2 is None or message.b2.decompressed_data is None:
+			return
+		with Tracing.span("publish", publishers=len(self.events)):
+			try:
+				event = message_event(message.b2)
+			except Exception as e:
+				self.logger.error(f"Error building the event for {message.message_id}: {e}")
+				return
+			# A span per broker, so a sluggish one stands out
+			for publisher in self.events:
+				with Tracing.span(f"publish {publisher.name}", broker=f"{publisher.host}:{publisher.port}", topic=publisher.topic) as span:
+					if not publisher.publish(event):
+						span.set_error(f"{publisher.name} did not take the event")
+
+	def stats(self):
+		with self.lock:
+			counts = {"processed": self.processed, "quarantined": self.quarantined, "failed": self.failed, "dropped": self.dropped, "spilled": len(self.spilled)}
+		counts.update({"queued": len(self.queue), "high_water": self.queue.high_water, "blocked_seconds": round(self.queue.blocked_seconds, 1)})
+		return counts
+
+	def close(self, timeout=None):
+		"""Stop taking messages and wait up to timeout seconds for the workers to finish the queue."""
+		self.queue.close()
+		for worker in self.workers:
+			worker.join(timeout)
+		if self.journal is not None:
+			self.journal.close()
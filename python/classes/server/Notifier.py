@@ -0,0 +1,147 @@
+#!/usr/bin/env python
+'''Delivers alert notifications to phones via ntfy or Pushover'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import urllib.parse
+import urllib.request
+
+NOTIFY_TIMEOUT_SECONDS = 10
+PUSHOVER_URL = "https://api.pushover.net/1/messages.json"
+
+# Service priorities indexed by urgency rank 0 (routine) .. 3 (flash).  ntfy names its five levels
+# min/low/default/high/urgent; Pushover uses -2 .. 2 and 2 requires acknowledgement, so it isn't used.
+NTFY_PRIORITIES = ["default", "high", "urgent", "urgent"]
+PUSHOVER_PRIORITIES = [0, 1, 1, 1]
+
+
+class Notifier:
+	"""Something that can deliver a short alert.  Subclasses implement send()."""
+
+	def __init__(self, name, enable_debug=False):
+		self.name = name
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def send(self, title, text, urgency=0, details=None):
+		"""Deliver an alert.  details is a dict of structured data that notifiers may include.
+		Raises an exception if delivery fails."""
+		raise NotImplementedError
+
+	def notify(self, title, text, urgency=0, details=None):
+		"""Deliver an alert, logging rather than raising on failure.  Returns True if it was delivered."""
+		try:
+			self.send(title, text, urgency, details)
+			self._log_debug(f"Notifier {self.name} sent <{title}>")
+			return True
+		except Exception as e:
+			self.logger.error(f"Notifier {self.name} failed to send <{title}>: {e}")
+			return False
+
+	def target(self):
+		"""The URL send() posts to."""
+		return self.url
+
+	def dry_run(self, title, text, urgency=0, details=None):
+		"""Describe the alert send() would post, without posting it.  Raises ValueError if the URL
+		couldn't be posted to."""
+		url = self.target()
+		parts = urllib.parse.urlsplit(url)
+		if parts.scheme not in ("http", "https") or not parts.hostname:
+			raise ValueError(f"{url} is not an http or https URL")
+		return f"POST {url} <{title}>"
+
+	def _post(self, url, data, headers):
+		request = urllib.request.Request(url, data=data, headers=headers, method="POST")
+		with urllib.request.urlopen(request, timeout=NOTIFY_TIMEOUT_SECONDS) as response:
+			return response.read()
+
+
+class NtfyNotifier(Notifier):
+	"""Publishes to a topic on an ntfy.sh-compatible server, which can be self-hosted on the mesh."""
+
+	def __init__(self, name, url, topic, token=None, enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.url = url.rstrip("/")
+		self.topic = topic
+		self.token = token  # Access token, for servers that require one
+
+	def send(self, title, text, urgency=0, details=None):
+		headers = {"Title": title, "Priority": NTFY_PRIORITIES[min(max(urgency, 0), 3)]}
+		if self.token:
+			headers["Authorization"] = f"Bearer {self.token}"
+		self._post(self.target(), text.encode("utf-8"), headers)
+
+	def target(self):
+		return f"{self.url}/{urllib.parse.quote(self.topic)}"
+
+
+class PushoverNotifier(Notifier):
+	"""Sends through the Pushover API."""
+
+	def __init__(self, name, token, user, url=PUSHOVER_URL, enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.token = token  # Application token
+		self.user = user  # User or group key
+		self.url = url
+
+	def send(self, title, text, urgency=0, details=None):
+		data = urllib.parse.urlencode({
+			"token": self.token,
+			"user": self.user,
+			"title": title,
+			"message": text,
+			"priority": PUSHOVER_PRIORITIES[min(max(urgency, 0), 3)],
+		}).encode("utf-8")
+		self._post(self.url, data, {"Content-Type": "application/x-www-form-urlencoded"})
+
+
+class WebhookNotifier(Notifier):
+	"""POSTs the alert as JSON to a URL, for tools that take webhooks."""
+
+	def __init__(self, name, url, headers=None, enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.url = url
+		self.headers = headers or {}  # Extra request headers, e.g. for authentication
+
+	def send(self, title, text, urgency=0, details=None):
+		body = {"title": title, "text": text, "urgency": urgency}
+		if details is not None:
+			body["details"] = details
+		headers = {"Content-Type": "application/json"}
+		headers.update(self.headers)
+		self._post(self.url, json.dumps(body, default=str).encode("utf-8"), headers)
+
+
+NOTIFIER_TYPES = {"ntfy": NtfyNotifier, "pushover": PushoverNotifier, "webhook": WebhookNotifier}
+
+
+def create_notifier(name, config, enable_debug=False):
+	"""Build a notifier from its config entry, e.g. {"type": "ntfy", "url": "...", "topic": "..."}."""
+	config = dict(config)
+	kind = config.pop("type", None)
+	if kind not in NOTIFIER_TYPES:
+		raise ValueError(f"Notifier {name}: unknown type {kind}")
+	try:
+		return NOTIFIER_TYPES[kind](name, enable_debug=enable_debug, **config)
+	except TypeError as e:
+		raise ValueError(f"Notifier {name}: {e}")
@@ -0,0 +1,165 @@
+#!/usr/bin/env python
+'''Pushes generated exports to web servers elsewhere on the mesh, by HTTP PUT, scp, or rsync'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import os
+import shutil
+import subprocess
+import tempfile
+import urllib.parse
+import urllib.request
+
+PUBLISH_TIMEOUT_SECONDS = 60
+SSH_OPTIONS = ["-o", "BatchMode=yes", "-o", "ConnectTimeout=15"]  # Never stop to ask for a password
+
+
+class Publisher:
+	"""Somewhere an export can be delivered.  Subclasses implement send()."""
+
+	def __init__(self, name, enable_debug=False):
+		self.name = name
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def send(self, file_name, data, content_type):
+		"""Deliver data as file_name.  Raises an exception if delivery fails."""
+		raise NotImplementedError
+
+	def publish(self, file_name, data, content_type="application/octet-stream"):
+		"""Deliver data, logging rather than raising on failure.  Returns True if it was delivered."""
+		try:
+			self.send(file_name, data, content_type)
+			self._log_debug(f"Publisher {self.name} delivered {file_name} ({len(data)} bytes)")
+			return True
+		except Exception as e:
+			self.logger.error(f"Publisher {self.name} failed to deliver {file_name}: {e}")
+			return False
+
+	def dry_run(self, file_name, data, content_type="application/octet-stream"):
+		"""Check what can be checked without delivering anything, and describe the delivery that would
+		be made.  Raises ValueError if it couldn't be made."""
+		raise NotImplementedError
+
+
+class HttpPublisher(Publisher):
+	"""Uploads with HTTP PUT (or POST).  A url ending in "/" is a folder and the file name is appended."""
+
+	def __init__(self, name, url, method="PUT", headers=None, enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.url = url
+		self.method = method
+		self.headers = headers or {}  # Extra request headers, e.g. for authentication
+
+	def _url(self, file_name):
+		return self.url + urllib.parse.quote(file_name) if self.url.endswith("/") else self.url
+
+	def send(self, file_name, data, content_type):
+		url = self._url(file_name)
+		headers = {"Content-Type": content_type}
+		headers.update(self.headers)
+		request = urllib.request.Request(url, data=data, headers=headers, method=self.method)
+		with urllib.request.urlopen(request, timeout=PUBLISH_TIMEOUT_SECONDS) as response:
+			response.read()
+
+	def stream(self, file_name, chunks, content_type):
+		"""Send chunks (an iterable of bytes) with chunked transfer encoding, each as soon as it is made,
+		so the server can start on the first before the last exists.  Raises an exception if delivery fails."""
+		# urllib sends an iterable body chunked when it has no Content-Length
+		self.send(file_name, (chunk for chunk in chunks if chunk), content_type)
+
+	def dry_run(self, file_name, data, content_type="application/octet-stream"):
+		url = self._url(file_name)
+		parts = urllib.parse.urlsplit(url)
+		if parts.scheme not in ("http", "https") or not parts.hostname:
+			raise ValueError(f"{url} is not an http or https URL")
+		return f"{self.method} {url} ({len(data)} bytes, {content_type})"
+
+
+class _CopyPublisher(Publisher):
+	"""Copies a staged file with an external command over ssh: target is [user@]host:/folder/ or a
+	full remote path.  AREDN nodes run ssh on port 2222 and serve /www."""
+
+	def __init__(self, name, target, port=None, identity=None, enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.target = target
+		self.port = port
+		self.identity = identity  # Private key file; the key must not need a passphrase
+
+	def command(self, path):
+		raise NotImplementedError
+
+	def send(self, file_name, data, content_type):
+		with tempfile.TemporaryDirectory() as folder:
+			path = os.path.join(folder, file_name)
+			with open(path, 'wb') as f:
+				f.write(data)
+			command = self.command(path)
+			result = subprocess.run(command, capture_output=True, text=True, timeout=PUBLISH_TIMEOUT_SECONDS)
+			if result.returncode != 0:
+				raise RuntimeError(f"{command[0]} exited with {result.returncode}: {result.stderr.strip()}")
+
+	def dry_run(self, file_name, data, content_type="application/octet-stream"):
+		command = self.command(file_name)
+		for program in (command[0], "ssh"):
+			if shutil.which(program) is None:
+				raise ValueError(f"{program} is not on the PATH")
+		if self.identity and not os.path.isfile(self.identity):
+			raise ValueError(f"identity file {self.identity} does not exist")
+		return f"{' '.join(command)} ({len(data)} bytes)"
+
+
+class ScpPublisher(_CopyPublisher):
+
+	def command(self, path):
+		command = ["scp", "-q"] + SSH_OPTIONS
+		if self.port:
+			command += ["-P", str(self.port)]
+		if self.identity:
+			command += ["-i", self.identity]
+		return command + [path, self.target]
+
+
+class RsyncPublisher(_CopyPublisher):
+	"""rsync writes to a temporary name and renames, so the node never serves half a file."""
+
+	def command(self, path):
+		shell = ["ssh"] + SSH_OPTIONS
+		if self.port:
+			shell += ["-p", str(self.port)]
+		if self.identity:
+			shell += ["-i", self.identity]
+		return ["rsync", "--times", f"--timeout={PUBLISH_TIMEOUT_SECONDS}", "-e", " ".join(shell), path, self.target]
+
+
+PUBLISHER_TYPES = {"http": HttpPublisher, "scp": ScpPublisher, "rsync": RsyncPublisher}
+
+
+def create_publisher(name, config, enable_debug=False):
+	"""Build a publisher from its config entry, e.g. {"type": "rsync", "target": "node2.local.mesh:/www/esv/"}."""
+	config = dict(config)
+	kind = config.pop("type", None)
+	if kind not in PUBLISHER_TYPES:
+		raise ValueError(f"Publisher {name}: unknown type {kind}")
+	try:
+		return PUBLISHER_TYPES[kind](name, enable_debug=enable_debug, **config)
+	except TypeError as e:
+		raise ValueError(f"Publisher {name}: {e}")
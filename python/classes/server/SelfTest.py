@@ -0,0 +1,280 @@
+#!/usr/bin/env python
+'''Runs a known message through the whole pipeline offline, so a node can be checked before it goes on the air'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import csv
+import io
+import json
+import logging
+import os
+import time
+import xml.etree.ElementTree as ET
+import zipfile
+from classes.export import ExportBundle
+from classes.export import MapExport
+from classes.b2f import OutboundMessage
+from classes.server.AlertEngine import AlertEngine
+from classes.b2f.B2Message import B2Message, decompressor
+from classes.server.EventPublisher import load_event_publishers, message_event
+from classes.server.ExportScheduler import ExportJob, ExportScheduler, EXPORT_FORMATS
+from classes.b2f.WinlinkTime import utc_now
+
+# The sample: a Check-In from a fixed station and place, dated when the test runs so no check
+# mistakes it for stale traffic
+SAMPLE_MID = "SELFTEST0001"
+SAMPLE_CALLSIGN = "N0CALL"
+SAMPLE_RECIPIENT = "EOC"
+SAMPLE_POSITION = (37.4275, -122.1697)
+SAMPLE_LOCATION = "Self-test"
+SAMPLE_COMMENTS = "esvmap selftest"
+SAMPLE_SETTING = "TEST"
+POSITION_TOLERANCE = 1e-4  # Degrees; the form carries six decimal places
+PNG_SIGNATURE = b"\x89PNG\r\n\x1a\n"
+PDF_SIGNATURE = b"%PDF-"
+
+PASS = "pass"
+FAIL = "fail"
+SKIP = "skip"  # A stage it depends on failed, so it wasn't run
+
+
+def sample_message(when=None):
+	"""(MID, uncompressed message text, compressed B2 transfer) of the sample Check-In."""
+	when = (when or utc_now()).replace(second=0, microsecond=0)  # Message dates carry minutes only
+	lat, lon = SAMPLE_POSITION
+	form = OutboundMessage.check_in_form(SAMPLE_CALLSIGN, lat, lon, SAMPLE_COMMENTS, SAMPLE_LOCATION, SAMPLE_SETTING, when=when)
+	body = OutboundMessage.check_in_body(SAMPLE_CALLSIGN, lat, lon, SAMPLE_COMMENTS, SAMPLE_LOCATION, SAMPLE_SETTING, when)
+	text = OutboundMessage.message_text(SAMPLE_MID, SAMPLE_CALLSIGN, [SAMPLE_RECIPIENT], OutboundMessage.CHECK_IN_SUBJECT, body,
+		[form], when, SAMPLE_POSITION)
+	data, _ = OutboundMessage.transfer(OutboundMessage.CHECK_IN_SUBJECT, text)
+	return SAMPLE_MID, text, data
+
+
+def check_export(format, data):
+	"""A short description of an export's contents.  Raises ValueError if it isn't a well-formed file
+	of its format."""
+	if format == "png":
+		if not data.startswith(PNG_SIGNATURE):
+			raise ValueError("not a PNG image")
+		return f"PNG image, {len(data)} bytes"
+	if format == "pdf":
+		if not data.startswith(PDF_SIGNATURE):
+			raise ValueError("not a PDF document")
+		return f"PDF document, {len(data)} bytes"
+	if format == "geojson":
+		collection = json.loads(data)
+		if collection.get("type") != "FeatureCollection":
+			raise ValueError("not a GeoJSON FeatureCollection")
+		return f"{len(collection['features'])} features"
+	if format == "ndjson":
+		features = [json.loads(line) for line in data.decode("utf-8").splitlines() if line.strip()]
+		if any(feature.get("type") != "Feature" for feature in features):
+			raise ValueError("a line is not a GeoJSON Feature")
+		return f"{len(features)} features"
+	if format == "csv":
+		rows = list(csv.reader(io.StringIO(data.decode("utf-8"))))
+		if len(rows) < 2:
+			raise ValueError("no rows below the header")
+		return f"{len(rows) - 1} rows"
+	if format == "kmz":
+		with zipfile.ZipFile(io.BytesIO(data)) as archive:
+			if "doc.kml" not in archive.namelist():
+				raise ValueError("no doc.kml in the archive")
+			data = archive.read("doc.kml")
+	try:
+		placemarks = len(ET.fromstring(data).findall(".//{http://www.opengis.net/kml/2.2}Placemark"))
+	except ET.ParseError as e:
+		raise ValueError(f"malformed KML: {e}")
+	return f"{placemarks} placemarks"
+
+
+class SelfTest:
+	"""The stages of a self-test, each recorded as {"stage", "status", "detail", "seconds"}.  Exporters
+	and publishers named in the config files are exercised without delivering anything: exports are
+	built and checked but not written, and network destinations are checked as far as they can be
+	without connecting."""
+
+	def __init__(self, exports=None, events=None, alerts=None, enable_debug=False):
+		self.exports = exports  # Export jobs config file, as for esvmap schedule
+		self.events = events  # Event publishers config file, as main.py reads it
+		self.alerts = alerts  # Alert rules and notifiers config file, as main.py reads it
+		self.enable_debug = enable_debug
+		self.results = []
+		self.message = None
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _stage(self, stage, check, *arguments):
+		"""Run check(*arguments), which returns a description or raises, and record the outcome."""
+		started = time.monotonic()
+		try:
+			detail, status = check(*arguments), PASS
+		except Exception as e:
+			detail, status = str(e) or type(e).__name__, FAIL
+		result = {"stage": stage, "status": status, "detail": detail, "seconds": round(time.monotonic() - started, 3)}
+		self._log_debug(f"Self-test {stage}: {status}: {detail}")
+		self.results.append(result)
+		return status == PASS
+
+	def _skip(self, stage, reason):
+		self.results.append({"stage": stage, "status": SKIP, "detail": reason, "seconds": 0.0})
+
+	def run(self):
+		"""Run every stage and return the results, in order."""
+		self.results = []
+		self.message = None
+		sample = []
+		if not self._stage("encode", self._encode, sample):
+			return self.results
+		_, text, data = sample[0]
+		if not self._stage("decompress", self._decompress, text, data):
+			for stage in ("parse", "geocode", "exports"):
+				self._skip(stage, "decompress failed")
+			return self.results
+		if not self._stage("parse", self._parse):
+			for stage in ("geocode", "exports"):
+				self._skip(stage, "parse failed")
+			return self.results
+		if not self._stage("geocode", self._geocode):
+			self._skip("exports", "geocode failed")
+			return self.results
+		for format in EXPORT_FORMATS:
+			self._stage(f"export {format}", self._export, format)
+		self._stage("export bundle", self._bundle)
+		if self.exports:
+			self._jobs()
+		if self.events:
+			self._events()
+		if self.alerts:
+			self._alerts()
+		return self.results
+
+	def _encode(self, sample):
+		sample.append(sample_message())
+		mid, text, data = sample[0]
+		return f"{mid}: {len(text)} bytes, a {len(data)}-byte transfer"
+
+	def _decompress(self, text, data):
+		message = B2Message("selftest", data, None, None, enable_debug=self.enable_debug)
+		message.parse()
+		program = os.path.basename(decompressor())
+		if message.decompression_error is not None:
+			raise RuntimeError(f"{message.decompression_error} (decompressor {program})")
+		if message.decompressed_data != text:
+			raise RuntimeError(f"The {program} decompressor returned {len(message.decompressed_data or b'')} bytes, not the {len(text)} sent")
+		self.message = message
+		return f"{len(text)} bytes, as sent, by the {program} decompressor"
+
+	def _parse(self):
+		message = self.message
+		if message.parse_error is not None:
+			raise RuntimeError(f"parser failure in {message.parse_error['stage']}: {message.parse_error['error']}")
+		if message.mid != SAMPLE_MID or message.sender != SAMPLE_CALLSIGN:
+			raise RuntimeError(f"read {message.mid} from {message.sender}, not {SAMPLE_MID} from {SAMPLE_CALLSIGN}")
+		form_type = message.form.form_type if message.form is not None else None
+		if form_type != OutboundMessage.CHECK_IN_FORM:
+			raise RuntimeError(f"read form {form_type}, not {OutboundMessage.CHECK_IN_FORM}")
+		return f"{form_type} from {message.sender}"
+
+	def _geocode(self):
+		positions = self.message.positions()
+		roles = {role for role, _, _ in positions}
+		lat, lon = SAMPLE_POSITION
+		for role, latitude, longitude in positions:
+			if abs(latitude - lat) > POSITION_TOLERANCE or abs(longitude - lon) > POSITION_TOLERANCE:
+				raise RuntimeError(f"{role} placed at {latitude:.5f}, {longitude:.5f}, not {lat:.5f}, {lon:.5f}")
+		features = MapExport.message_features(self.message)
+		if not features:
+			raise RuntimeError("no map features")
+		return f"{', '.join(sorted(roles))} at {lat:.5f}, {lon:.5f}; {len(features)} features"
+
+	def _export(self, format):
+		data = ExportJob("selftest", format, every="1m", output=os.devnull).build([self.message])
+		return check_export(format, data)
+
+	def _bundle(self):
+		output = io.BytesIO()
+		manifest = ExportBundle.write_bundle(output, [self.message], "selftest")
+		with zipfile.ZipFile(io.BytesIO(output.getvalue())) as bundle:
+			missing = [name for name in manifest["files"] if name not in bundle.namelist()]
+		if missing:
+			raise RuntimeError(f"{', '.join(missing)} missing from the bundle")
+		return f"{len(manifest['files'])} files, {len(output.getvalue())} bytes"
+
+	def _jobs(self):
+		scheduler = []
+		if not self._stage(f"config {self.exports}", self._load_jobs, scheduler):
+			return
+		for job in scheduler[0].jobs:
+			built = []
+			if not self._stage(f"job {job.name}", self._build_job, job, built):
+				for publisher in job.publishers:
+					self._skip(f"publish {job.name} to {publisher.name}", "the export failed")
+				continue
+			for publisher in job.publishers:
+				self._stage(f"publish {job.name} to {publisher.name}", publisher.dry_run, job.file_name, built[0], EXPORT_FORMATS[job.format])
+
+	def _load_jobs(self, scheduler):
+		# Jobs are given the sample rather than reading the folders they name
+		scheduler.append(ExportScheduler.from_file(self.exports, lambda paths, source_path, gateway: [self.message], enable_debug=self.enable_debug))
+		return f"{len(scheduler[0].jobs)} jobs"
+
+	def _build_job(self, job, built):
+		# Incremental jobs get a full build, so their saved state is neither read nor changed
+		data = job.build([self.message])
+		detail = check_export(job.format, data)
+		built.append(data)
+		if job.output is not None:
+			# The scheduler makes missing folders, so the nearest one that exists must be writable
+			folder = os.path.dirname(os.path.abspath(job.output))
+			while not os.path.isdir(folder) and os.path.dirname(folder) != folder:
+				folder = os.path.dirname(folder)
+			if not os.access(folder, os.W_OK):
+				raise RuntimeError(f"{folder} is not writable")
+			detail += f"; would write {len(data)} bytes to {job.output}"
+		return detail
+
+	def _events(self):
+		publishers = []
+		if not self._stage(f"config {self.events}", self._load_events, publishers):
+			return
+		event = message_event(self.message)
+		for publisher in publishers:
+			self._stage(f"event {publisher.name}", publisher.dry_run, event)
+
+	def _load_events(self, publishers):
+		publishers.extend(load_event_publishers(self.events, self.enable_debug))
+		return f"{len(publishers)} event publishers"
+
+	def _load_alerts(self, engines):
+		engine = AlertEngine.from_file(self.alerts, self.enable_debug)
+		engines.append(engine)
+		# Nothing is sent for the rules the sample matches; they are listed so the rules can be checked
+		matched = [rule.name for rule in engine.rules if rule.matches(self.message)]
+		return f"{len(engine.rules)} alert rules, {len(matched)} matching the sample" + (f" ({', '.join(matched)})" if matched else "")
+
+	def _alerts(self):
+		engines = []
+		if not self._stage(f"config {self.alerts}", self._load_alerts, engines):
+			return
+		engine = engines[0]
+		# The sample needn't match any rule, so every notifier is tried, not only those a rule would call
+		title, text = engine.describe(self.message)
+		for name, notifier in engine.notifiers.items():
+			self._stage(f"notify {name}", notifier.dry_run, title, text, self.message.urgency(), {"rule": None, "mid": self.message.mid})
@@ -0,0 +1,378 @@
+#!/usr/bin/env python
+'''Traces each received message through the pipeline as OpenTelemetry spans, to find the slow stages'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import collections
+import contextlib
+import json
+import logging
+import random
+import threading
+import time
+from classes.server.Publisher import HttpPublisher
+
+SERVICE_NAME = "esv-forms-to-map"
+SCOPE_NAME = "esv-forms-to-map.pipeline"
+OTLP_URL_DEFAULT = "http://localhost:4318/v1/traces"  # OTLP over HTTP, as collectors and Jaeger accept it
+QUEUE_SIZE_DEFAULT = 2048  # Finished spans held for export; beyond this the oldest are dropped
+BATCH_SIZE_DEFAULT = 512
+FLUSH_SECONDS_DEFAULT = 5.0
+
+# OTLP span kinds and status codes
+KIND_INTERNAL = 1
+KIND_SERVER = 2
+STATUS_UNSET = 0
+STATUS_OK = 1
+STATUS_ERROR = 2
+
+_tracer = None  # The Tracer spans go to; None while tracing is off
+_context = threading.local()  # Stack of the spans active on this thread
+
+
+def now():
+	"""The time in nanoseconds since the epoch, as spans record it."""
+	return time.time_ns()
+
+
+def _otlp_value(value):
+	if isinstance(value, bool):
+		return {"boolValue": value}
+	if isinstance(value, int):
+		return {"intValue": str(value)}
+	if isinstance(value, float):
+		return {"doubleValue": value}
+	return {"stringValue": str(value)}
+
+
+def _otlp_attributes(attributes):
+	return [{"key": key, "value": _otlp_value(value)} for key, value in attributes.items() if value is not None]
+
+
+class Span:
+	"""One timed stage of the work on a message.  Child spans share their root's trace id."""
+
+	recording = True
+
+	def __init__(self, tracer, name, trace_id, parent_id=None, start=None, attributes=None, kind=KIND_INTERNAL):
+		self.tracer = tracer
+		self.name = name
+		self.trace_id = trace_id  # 32 hex digits
+		self.span_id = f"{random.getrandbits(64):016x}"
+		self.parent_id = parent_id
+		self.kind = kind
+		self.start = start if start is not None else now()
+		self.end_time = None
+		self.attributes = dict(attributes or {})
+		self.status = STATUS_UNSET
+		self.status_message = None
+
+	def set_attribute(self, key, value):
+		self.attributes[key] = value
+
+	def set_error(self, message):
+		self.status = STATUS_ERROR
+		self.status_message = str(message)
+
+	def end(self, end=None):
+		"""Finish the span and hand it to the tracer for export; ending it again does nothing."""
+		if self.end_time is not None:
+			return
+		self.end_time = end if end is not None else now()
+		self.tracer.finished(self)
+
+	@property
+	def duration_ms(self):
+		return (self.end_time - self.start) / 1e6 if self.end_time is not None else None
+
+	def as_otlp(self):
+		span = {
+			"traceId": self.trace_id,
+			"spanId": self.span_id,
+			"name": self.name,
+			"kind": self.kind,
+			"startTimeUnixNano": str(self.start),
+			"endTimeUnixNano": str(self.end_time),
+			"attributes": _otlp_attributes(self.attributes),
+			"status": {"code": self.status, **({"message": self.status_message} if self.status_message else {})},
+		}
+		if self.parent_id is not None:
+			span["parentSpanId"] = self.parent_id
+		return span
+
+	def as_dict(self):
+		"""The span as one line of a traces file."""
+		return {
+			"trace_id": self.trace_id,
+			"span_id": self.span_id,
+			"parent_id": self.parent_id,
+			"name": self.name,
+			"start": self.start,
+			"end": self.end_time,
+			"duration_ms": round(self.duration_ms, 3),
+			"attributes": {k: v for k, v in self.attributes.items() if v is not None},
+			"status": "error" if self.status == STATUS_ERROR else "ok",
+			"error": self.status_message,
+		}
+
+
+class _NoSpan:
+	"""Stands in for a span while tracing is off, or when the message's trace wasn't sampled."""
+
+	recording = False
+	span_id = None
+
+	def set_attribute(self, key, value):
+		pass
+
+	def set_error(self, message):
+		pass
+
+	def end(self, end=None):
+		pass
+
+
+NO_SPAN = _NoSpan()
+
+
+class OtlpExporter:
+	"""Sends spans to an OpenTelemetry collector with OTLP/HTTP, JSON encoded."""
+
+	def __init__(self, url=OTLP_URL_DEFAULT, headers=None, enable_debug=False):
+		self.url = url
+		self.http = HttpPublisher("otlp", url, "POST", headers, enable_debug=enable_debug)
+
+	def export(self, spans, service_name):
+		payload = {"resourceSpans": [{
+			"resource": {"attributes": _otlp_attributes({"service.name": service_name})},
+			"scopeSpans": [{"scope": {"name": SCOPE_NAME}, "spans": [span.as_otlp() for span in spans]}],
+		}]}
+		self.http.send("", json.dumps(payload).encode("utf-8"), "application/json")
+
+	def __str__(self):
+		return self.url
+
+
+class FileExporter:
+	"""Appends spans to a file, one JSON object per line, for `esvmap traces` or any other reader."""
+
+	def __init__(self, filename):
+		self.filename = filename
+
+	def export(self, spans, service_name):
+		with open(self.filename, 'a', encoding='utf-8') as f:
+			for span in spans:
+				f.write(json.dumps(span.as_dict(), default=str) + "\n")
+
+	def __str__(self):
+		return self.filename
+
+
+class Tracer:
+	"""Collects finished spans and exports them in batches on a background thread, so a slow or absent
+	collector never holds up a message.  sample_ratio is the fraction of messages traced."""
+
+	def __init__(self, exporters, service_name=SERVICE_NAME, sample_ratio=1.0, queue_size=QUEUE_SIZE_DEFAULT,
+			batch_size=BATCH_SIZE_DEFAULT, flush_seconds=FLUSH_SECONDS_DEFAULT, enable_debug=False):
+		if not 0.0 <= sample_ratio <= 1.0:
+			raise ValueError("Tracing sample_ratio must be between 0 and 1")
+		if queue_size < 1 or batch_size < 1:
+			raise ValueError("Tracing queue_size and batch_size must be positive")
+		self.exporters = exporters
+		self.service_name = service_name
+		self.sample_ratio = sample_ratio
+		self.batch_size = batch_size
+		self.flush_seconds = flush_seconds
+		self.enable_debug = enable_debug
+		self.spans = collections.deque(maxlen=queue_size)
+		self.condition = threading.Condition()
+		self.closed = False
+		self.exported = 0
+		self.dropped = 0
+		self.failing = set()  # Exporters whose last export failed, so each outage is logged once
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+		self.thread = threading.Thread(target=self._export_loop, name="tracing", daemon=True)
+		self.thread.start()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@classmethod
+	def from_file(cls, filename, enable_debug=False):
+		"""A tracer configured from a JSON file, e.g.
+		{"otlp": {"url": "http://collector.local.mesh:4318/v1/traces", "headers": {"Authorization": "Bearer ..."}},
+		 "file": "traces.jsonl", "sample_ratio": 0.25}
+		Raises ValueError for bad settings."""
+		with open(filename, 'r') as f:
+			config = json.load(f)
+		exporters = []
+		otlp = config.pop("otlp", None)
+		if otlp is not None:
+			otlp = {} if otlp is True else otlp
+			if not isinstance(otlp, dict):
+				raise ValueError(f"{filename}: otlp must be true or an object with url and headers")
+			exporters.append(OtlpExporter(otlp.get("url", OTLP_URL_DEFAULT), otlp.get("headers"), enable_debug))
+		traces_file = config.pop("file", None)
+		if traces_file is not None:
+			exporters.append(FileExporter(traces_file))
+		if not exporters:
+			raise ValueError(f"{filename}: give otlp, a file, or both")
+		try:
+			return cls(exporters, enable_debug=enable_debug, **config)
+		except TypeError as e:
+			raise ValueError(f"{filename}: {e}")
+
+	def start_span(self, name, parent=None, start=None, attributes=None, kind=KIND_INTERNAL):
+		"""A new span under parent, or a new trace if there is none.  Children of an unsampled trace
+		aren't recorded either."""
+		if parent is None:
+			if random.random() >= self.sample_ratio:
+				return NO_SPAN
+			return Span(self, name, f"{random.getrandbits(128):032x}", None, start, attributes, kind)
+		if not parent.recording:
+			return NO_SPAN
+		return Span(self, name, parent.trace_id, parent.span_id, start, attributes, kind)
+
+	def finished(self, span):
+		with self.condition:
+			if len(self.spans) == self.spans.maxlen:
+				self.dropped += 1
+			self.spans.append(span)
+			if len(self.spans) >= self.batch_size:
+				self.condition.notify_all()
+
+	def _take_batch(self):
+		with self.condition:
+			return [self.spans.popleft() for _ in range(min(self.batch_size, len(self.spans)))]
+
+	def _export_loop(self):
+		while True:
+			with self.condition:
+				self.condition.wait_for(lambda: self.closed or len(self.spans) >= self.batch_size, self.flush_seconds)
+				closed = self.closed
+			self.flush()
+			if closed:
+				return
+
+	def flush(self):
+		"""Export every span finished so far."""
+		batch = self._take_batch()
+		while batch:
+			delivered = False
+			for exporter in self.exporters:
+				try:
+					exporter.export(batch, self.service_name)
+					delivered = True
+					if exporter in self.failing:
+						self.failing.discard(exporter)
+						self.logger.info(f"Exporting traces to {exporter} again")
+				except Exception as e:
+					if exporter not in self.failing:
+						self.failing.add(exporter)
+						self.logger.error(f"Error exporting traces to {exporter}; spans are dropped until it recovers: {e}")
+			if delivered:
+				self.exported += len(batch)
+				self._log_debug(f"Exported {len(batch)} spans")
+			else:
+				self.dropped += len(batch)
+			batch = self._take_batch()
+
+	def stats(self):
+		with self.condition:
+			return {"exported": self.exported, "dropped": self.dropped, "queued": len(self.spans)}
+
+	def close(self):
+		"""Export what is left and stop the export thread."""
+		with self.condition:
+			self.closed = True
+			self.condition.notify_all()
+		self.thread.join()
+
+
+def configure(tracer):
+	"""Send spans to tracer from now on; None turns tracing off."""
+	global _tracer
+	_tracer = tracer
+
+
+def _stack():
+	if not hasattr(_context, "stack"):
+		_context.stack = []
+	return _context.stack
+
+
+def current_span():
+	"""The innermost span active on this thread, or None."""
+	stack = _stack()
+	return stack[-1] if stack else None
+
+
+def start_span(name, parent=None, start=None, kind=KIND_INTERNAL, **attributes):
+	"""Begin a span, under parent or else the span active on this thread.  The caller ends it."""
+	if _tracer is None:
+		return NO_SPAN
+	return _tracer.start_span(name, parent if parent is not None else current_span(), start, attributes, kind)
+
+
+@contextlib.contextmanager
+def activate(span):
+	"""Make span the parent of the spans begun on this thread for the duration, without ending it.
+	This is how a message's trace follows it from the connection to a pipeline worker."""
+	stack = _stack()
+	stack.append(span)
+	try:
+		yield span
+	finally:
+		stack.pop()
+
+
+@contextlib.contextmanager
+def span(name, parent=None, **attributes):
+	"""Time the enclosed block as a span under the active one.  An exception marks it as failed."""
+	child = start_span(name, parent, **attributes)
+	with activate(child):
+		try:
+			yield child
+		except BaseException as e:
+			child.set_error(f"{e.__class__.__name__}: {e}")
+			raise
+		finally:
+			child.end()
+
+
+def record(name, parent, start, end, **attributes):
+	"""Add a span for a stage timed already, such as the wait in the ingest queue."""
+	if _tracer is None or parent is None:
+		return
+	_tracer.start_span(name, parent, start, attributes).end(end)
+
+
+def read_spans(filename):
+	"""The spans (dicts) in a traces file.  Raises ValueError for a line that isn't a span."""
+	spans = []
+	with open(filename, 'r', encoding='utf-8') as f:
+		for number, line in enumerate(f, 1):
+			if not line.strip():
+				continue
+			try:
+				span = json.loads(line)
+				span["duration_ms"] = float(span["duration_ms"])
+			except (ValueError, KeyError, TypeError) as e:
+				raise ValueError(f"{filename} line {number}: not a span: {e}")
+			spans.append(span)
+	return spans
+
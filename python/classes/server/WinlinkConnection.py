@@ -13,7 +13,13 @@ import logging
 import queue
 import re 
 import socket
-from classes.WinlinkMailMessage import WinlinkMailMessage
+from classes.store.WinlinkMailMessage import WinlinkMailMessage
+from classes.b2f.B2Message import transfer_length
+from classes.server.IngestPipeline import IngestPipeline, ACCEPT
+from classes.b2f.WinlinkTime import utc_now
+from classes.server import Tracing
+from classes.b2f import OutboundMessage
+from classes.b2f.P2PSession import P2P_SID, ACCEPTED_ANSWERS, p2p_prompt
 import traceback
 
 START = "START"
@@ -28,12 +34,15 @@ MAILBOX_FOLDER_NAME = "mailbox"
 
 
 class WinlinkConnection:
-	def __init__(self, connection, address, timeout, enable_debug=False):
+	def __init__(self, connection, address, timeout, enable_debug=False, source_path=None, alerts=None, events=None, pipeline=None, station=None, outbox=None):
 		"""Initialize the connection handler and encapsulate socket handling."""
 		self.connection = connection
 		self.address = address
 		self.timeout = timeout  # Unified timeout value for all operations
 		self.enable_debug = enable_debug
+		self.source_path = source_path  # Label for how traffic reaches this listener, e.g. "mesh"
+		# Where received messages go to be decoded and saved; without one they are processed here
+		self.pipeline = pipeline or IngestPipeline(alerts, events, workers=0, enable_debug=enable_debug)
 		self.client_callsign = None
 		self.client_password = None  
 		self.author = None  
@@ -44,6 +53,13 @@ class WinlinkConnection:
 		self.forward_login_callsign = None  
 		self.pickup_callsigns = []  
 		self.message_queue = queue.Queue()  
+		# With a callsign and outbox the server answers as a station in a P2P session: it sends the caller
+		# the messages addressed to it.  Without them it acts as a CMS, which only receives.
+		self.station = station
+		self.outbox = outbox
+		self.offered = []  # Outbox entries proposed to the caller, waiting for its FS
+		self.awaiting = []  # Outbox entries sent, waiting for the caller to take its turn
+		self.proposed = set()  # MIDs proposed this session, so what the caller defers isn't offered again
 		
 		# Set up logging
 		self.logger = logging.getLogger(__name__)
@@ -170,11 +186,15 @@ class WinlinkConnection:
 		"""Handle successful login."""
 		self._log_debug("LOGIN_SUCCESS state")
 		
-		# Send '[AREDN_BRIDGE-1.0-B2F$]' followed by a carriage return
-		self.send_data("[AREDN_BRIDGE-1.0-B2F$]\r")
-		
-		# Send 'CMS>' followed by a carriage return
-		self.send_data("CMS>\r")
+		if self._is_p2p():
+			self.send_data(f"{P2P_SID}\r")
+			self.send_data(f"{p2p_prompt(self.client_callsign.upper(), self.station)}\r")
+		else:
+			# Send '[AREDN_BRIDGE-1.0-B2F$]' followed by a carriage return
+			self.send_data("[AREDN_BRIDGE-1.0-B2F$]\r")
+
+			# Send 'CMS>' followed by a carriage return
+			self.send_data("CMS>\r")
 		
 		self.next_state = CLIENT_REQUEST  # Transition to CLIENT_REQUEST after login success
 
@@ -183,6 +203,10 @@ class WinlinkConnection:
 		self._log_debug("CLIENT_REQUEST state")
 		request = self.wait_for_input("")  # Wait for client's request and strip trailing carriage return
 
+		if request and self.awaiting:
+			# The caller taking its turn means the messages sent to it arrived
+			self.outbox.mark_sent(self.awaiting)
+			self.awaiting = []
 		if request:
 			if request.startswith("FC"):  
 				self._handle_message_proposal(request)  
@@ -200,6 +224,10 @@ class WinlinkConnection:
 				self._handle_end_of_proposals(request)  
 			elif request.startswith("FF"):  
 				self._handle_no_messages(request)  
+			elif request.startswith("FS") and self.offered:
+				self._handle_proposal_answers(request)
+			elif request.startswith("FQ"):
+				self.next_state = CLOSE_CONNECTION  # The caller is done
 			else:
 				self.next_state = CLOSE_CONNECTION  # Close connection if request type is unrecognized
 		else:
@@ -257,13 +285,24 @@ class WinlinkConnection:
 			compressed_size = int(parts[4])
 
 			# Create a new Message instance with the extracted data
-			new_message = WinlinkMailMessage(message_type, message_id, uncompressed_size, compressed_size, enable_debug=self.enable_debug)
+			new_message = WinlinkMailMessage(message_type, message_id, uncompressed_size, compressed_size, enable_debug=self.enable_debug, source=self._source())
 			self.message_queue.put(new_message)
 			self._log_debug(f"Message added to queue: {new_message.message_id} (Type: {new_message.message_type})")
 		
 		else:
 			self._log_debug("Invalid message proposal format")
 
+	def _source(self):
+		"""Describe this connection as the source of the messages it delivers."""
+		return {
+			"kind": "p2p" if self._is_p2p() else "telnet",
+			"gateway": self.client_callsign,
+			"address": f"{self.address[0]}:{self.address[1]}" if self.address else None,
+			"sid": "-".join(p for p in (self.author, self.version, self.feature_list) if p),
+			"path": self.source_path,
+			"received": utc_now().isoformat(timespec="seconds"),  # By this server's clock; see ClockSkew
+		}
+
 	def _handle_end_of_proposals(self, message):
 		"""Handle 'F>' case"""
 		self._log_debug(f"End of proposals")
@@ -271,22 +310,40 @@ class WinlinkConnection:
 		try:
 			pending_messages = len(self.message_queue.queue)
 			if pending_messages > 0:
-				c = 'Y'
-				# Tell the client we are ready to accept all the pending messages
-				self.send_data(f"FS {c * pending_messages}\r")  
-				raw_message_data = self._wait_for_messages()  # One big binary blob for all messages
-				next_index = 0  # Start index for processing the received data
-
-				while not self.message_queue.empty():
-					message = self.message_queue.get()  # Get the first message in the queue
+				# Accept what the pipeline has room for; the client keeps deferred messages for later
+				answers = self.pipeline.answers(pending_messages)
+				self.send_data(f"FS {answers}\r")
+				accepted = [self.message_queue.get() for _ in range(pending_messages)]
+				accepted = [message for message, answer in zip(accepted, answers) if answer == ACCEPT]
+				receive_started = Tracing.now()
+				raw_message_data = self._wait_for_messages() if accepted else b""  # One big binary blob for all messages
+				receive_ended = Tracing.now()
+				transfer_bytes = len(raw_message_data)
+
+				lost = []
+				for message in accepted:
 					self._log_debug(f"Processing message ID: {message.message_id}")
-					message.capture(raw_message_data)  # Record the raw data
-					next_index = message.parse()  # Parse the message at the beginning of raw_message_data and figure out where the next one starts
-					message.save_message_to_files()
-					raw_message_data = raw_message_data[next_index:]  # Remove the processed data from the buffer
-					
-				# Send "FF" followed by a carriage return after receiving the messages
-				self.send_data("FF\r")
+					size = transfer_length(raw_message_data)  # Where the next message starts
+					message.capture(raw_message_data[:size])  # Record the raw data
+					# Each message's trace starts when the transfer carrying it began to arrive
+					message.span = Tracing.start_span("message", start=receive_started, kind=Tracing.KIND_SERVER, mid=message.message_id,
+						bytes=size, gateway=message.source.get("gateway") if message.source else None, source_path=self.source_path)
+					Tracing.record("receive", message.span, receive_started, receive_ended, transfer_bytes=transfer_bytes, messages=len(accepted))
+					if not self.pipeline.submit(message, size):
+						lost.append(message.message_id)
+					raw_message_data = raw_message_data[size:]  # Remove the processed data from the buffer
+
+				if lost:
+					# Without FF the client keeps the messages and sends them again next session
+					self.logger.error(f"Ingest queue full: closing the session without FF, so {', '.join(lost)} will be sent again")
+					self._close_connection()
+					self.next_state = CLOSE_CONNECTION
+					return
+
+				# Send "FF" followed by a carriage return after receiving the messages, or in a P2P
+				# session, propose what is waiting for the caller
+				if not self._offer_messages():
+					self.send_data("FF\r")
 			
 		except Exception as e:
 			self._log_debug(f"Error handling end of proposal: {e}")
@@ -296,10 +353,47 @@ class WinlinkConnection:
 		"""Handle the 'FF' request indicating no messages to process."""
 		self._log_debug(f"No message condition: {message}")
 		
+		if self._offer_messages():
+			return
 		# Send "FQ" followed by a carriage return
 		self.send_data("FQ\r")
 		self._log_debug("Sent 'FQ' indicating no messages")
 
+	def _is_p2p(self):
+		return self.station is not None and self.outbox is not None
+
+	def _offer_messages(self):
+		"""In a P2P session, propose the outbox messages addressed to the caller.  Returns True if there
+		were any, so it is the caller's turn to answer."""
+		if not self._is_p2p() or not self.client_callsign:
+			return False
+		self.offered = [entry for entry in self.outbox.for_station(self.client_callsign) if entry.mid not in self.proposed]
+		if not self.offered:
+			return False
+		self.proposed.update(entry.mid for entry in self.offered)
+		proposals = "".join(f"{entry.proposal}\r" for entry in self.offered)
+		self.send_data(f"{proposals}F> {OutboundMessage.proposal_checksum(proposals)}\r")
+		return True
+
+	def _handle_proposal_answers(self, message):
+		"""Handle 'FS' from the caller, answering our proposals: send the transfers it accepted."""
+		self._log_debug(f"Proposal answers: {message}")
+		answers = message[2:].strip().upper().replace(" ", "")
+		offered, self.offered = self.offered, []
+		if len(answers) != len(offered):
+			self.logger.error(f"{self.client_callsign} answered <{message}> to {len(offered)} proposals")
+			self.next_state = CLOSE_CONNECTION
+			return
+		accepted = [entry for entry, answer in zip(offered, answers) if answer in ACCEPTED_ANSWERS]
+		try:
+			if accepted:
+				self.connection.sendall(b"".join(entry.frame for entry in accepted))
+				self._log_debug(f"Sent {len(accepted)} messages to {self.client_callsign}")
+			self.awaiting = accepted
+		except Exception as e:
+			self.logger.error(f"Error sending messages to {self.client_callsign}: {e}")
+			self.next_state = CLOSE_CONNECTION
+
 	def _wait_for_messages(self):
 		"""Wait for all data from the client -- may include multiple messages"""
 		# received_data = b""
@@ -326,4 +420,4 @@ class WinlinkConnection:
 	def _close_connection(self):
 		if self.connection:
 			self.logger.info(f"Closing connection to {self.address}")
-			self.connection.close
+			self.connection.close()
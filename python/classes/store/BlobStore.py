@@ -0,0 +1,280 @@
+#!/usr/bin/env python
+'''Where raw messages and attachments are kept: the mailbox folder itself, or an S3-compatible object store'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import datetime
+import hashlib
+import hmac
+import json
+import logging
+import os
+import urllib.error
+import urllib.parse
+import urllib.request
+
+STORAGE_FILE_NAME = "storage.json"  # Blob store settings; attachments stay in the mailbox folder if the file is absent
+REQUEST_TIMEOUT_SECONDS = 60
+EMPTY_PAYLOAD_HASH = hashlib.sha256(b"").hexdigest()
+
+_store = None  # The BlobStore messages are saved to; None keeps them in each message's mailbox folder
+_store_raw = False  # Also keep each raw B2 transfer as <message>.b2f
+
+
+class BlobStore:
+	"""Somewhere to keep blobs by key, a path relative to the mailbox folder such as
+	20251004101500-AB12CD-photo.jpg or bulletins/....  Subclasses implement put(), get(), exists(), and delete()."""
+
+	local = False  # True if blobs are files beside the rest of the message
+
+	def __init__(self, name, enable_debug=False):
+		self.name = name
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def put(self, key, data, content_type="application/octet-stream"):
+		"""Store data under key, replacing what was there.  Returns where it went (a path or URL).
+		Raises an exception if it couldn't be stored."""
+		raise NotImplementedError
+
+	def get(self, key):
+		"""The blob stored under key.  Raises KeyError if there is none."""
+		raise NotImplementedError
+
+	def exists(self, key):
+		raise NotImplementedError
+
+	def delete(self, key):
+		"""Remove the blob under key, if there is one."""
+		raise NotImplementedError
+
+	def location(self, key):
+		"""Where the blob under key is kept, as put() reports it."""
+		raise NotImplementedError
+
+	def key_for(self, location):
+		"""The key of the blob at location, or None if it isn't in this store."""
+		raise NotImplementedError
+
+
+class FileBlobStore(BlobStore):
+	"""Blobs as files in a folder, as the mailbox has always kept attachments."""
+
+	local = True
+
+	def __init__(self, folder, name="files", enable_debug=False):
+		super().__init__(name, enable_debug)
+		self.folder = folder
+
+	def _path(self, key):
+		path = os.path.normpath(os.path.join(self.folder, key))
+		if os.path.relpath(path, self.folder).startswith(os.pardir):
+			raise ValueError(f"Blob key {key} is outside {self.folder}")
+		return path
+
+	def put(self, key, data, content_type="application/octet-stream"):
+		path = self._path(key)
+		os.makedirs(os.path.dirname(path) or ".", exist_ok=True)
+		with open(path, 'wb') as f:
+			f.write(data)
+		return path
+
+	def get(self, key):
+		try:
+			with open(self._path(key), 'rb') as f:
+				return f.read()
+		except FileNotFoundError:
+			raise KeyError(key)
+
+	def exists(self, key):
+		return os.path.exists(self._path(key))
+
+	def delete(self, key):
+		if self.exists(key):
+			os.remove(self._path(key))
+
+	def location(self, key):
+		return self._path(key)
+
+	def key_for(self, location):
+		relative = os.path.relpath(location, self.folder)
+		return None if relative.startswith(os.pardir) else relative
+
+
+def _hmac(key, text):
+	return hmac.new(key, text.encode("utf-8"), hashlib.sha256).digest()
+
+
+def sign_v4(method, host, path, query, headers, payload_hash, access_key, secret_key, region, when, service="s3"):
+	"""The Authorization header for an AWS Signature Version 4 request.  path is already URI-encoded;
+	headers are the ones to sign besides host, and must include x-amz-date and x-amz-content-sha256."""
+	amz_date = when.strftime("%Y%m%dT%H%M%SZ")
+	date_stamp = amz_date[:8]
+	signed = {"host": host}
+	signed.update({name.lower(): " ".join(str(value).split()) for name, value in headers.items()})
+	names = sorted(signed)
+	canonical_query = "&".join(f"{urllib.parse.quote(k, safe='-_.~')}={urllib.parse.quote(v, safe='-_.~')}" for k, v in sorted(query.items()))
+	canonical_request = "\n".join([
+		method, path, canonical_query,
+		"".join(f"{name}:{signed[name]}\n" for name in names),
+		";".join(names), payload_hash,
+	])
+	scope = f"{date_stamp}/{region}/{service}/aws4_request"
+	string_to_sign = "\n".join(["AWS4-HMAC-SHA256", amz_date, scope, hashlib.sha256(canonical_request.encode("utf-8")).hexdigest()])
+	key = _hmac(f"AWS4{secret_key}".encode("utf-8"), date_stamp)
+	for part in (region, service, "aws4_request"):
+		key = _hmac(key, part)
+	signature = hmac.new(key, string_to_sign.encode("utf-8"), hashlib.sha256).hexdigest()
+	return f"AWS4-HMAC-SHA256 Credential={access_key}/{scope}, SignedHeaders={';'.join(names)}, Signature={signature}"
+
+
+class S3BlobStore(BlobStore):
+	"""Blobs as objects in an S3 bucket, on AWS or a compatible server such as MinIO.  Objects are
+	addressed path-style (endpoint/bucket/prefix+key), which every S3-compatible server accepts."""
+
+	def __init__(self, name, endpoint, bucket, access_key, secret_key, region="us-east-1", prefix="", enable_debug=False):
+		super().__init__(name, enable_debug)
+		parsed = urllib.parse.urlsplit(endpoint)
+		if parsed.scheme not in ("http", "https") or not parsed.netloc:
+			raise ValueError(f"Blob store {name}: endpoint must be an http:// or https:// URL, not {endpoint}")
+		if not bucket or not access_key or not secret_key:
+			raise ValueError(f"Blob store {name}: bucket, access_key, and secret_key are required")
+		self.endpoint = f"{parsed.scheme}://{parsed.netloc}"
+		self.host = parsed.netloc
+		self.bucket = bucket
+		self.access_key = access_key
+		self.secret_key = secret_key
+		self.region = region
+		self.prefix = prefix
+
+	def _path(self, key):
+		return "/" + urllib.parse.quote(f"{self.bucket}/{self.prefix}{key.replace(os.sep, '/')}", safe="/-_.~")
+
+	def _request(self, method, key, data=b"", content_type=None):
+		"""(status, body) of a signed request for the object under key.  Raises OSError if the server
+		can't be reached or answers with an error other than 404."""
+		path = self._path(key)
+		payload_hash = hashlib.sha256(data).hexdigest() if data else EMPTY_PAYLOAD_HASH
+		now = datetime.datetime.now(datetime.timezone.utc)
+		headers = {"x-amz-date": now.strftime("%Y%m%dT%H%M%SZ"), "x-amz-content-sha256": payload_hash}
+		headers["Authorization"] = sign_v4(method, self.host, path, {}, headers, payload_hash, self.access_key, self.secret_key, self.region, now)
+		if content_type is not None:
+			headers["Content-Type"] = content_type
+		request = urllib.request.Request(self.endpoint + path, data=data if method == "PUT" else None, headers=headers, method=method)
+		try:
+			with urllib.request.urlopen(request, timeout=REQUEST_TIMEOUT_SECONDS) as response:
+				return response.status, response.read()
+		except urllib.error.HTTPError as e:
+			if e.code == 404:
+				return 404, b""
+			detail = e.read().decode("utf-8", errors="replace")[:200]
+			raise OSError(f"Blob store {self.name}: {method} {self.location(key)} failed with HTTP {e.code}: {detail}")
+		except (urllib.error.URLError, OSError) as e:
+			raise OSError(f"Blob store {self.name}: {method} {self.location(key)} failed: {getattr(e, 'reason', e)}")
+
+	def put(self, key, data, content_type="application/octet-stream"):
+		self._request("PUT", key, bytes(data), content_type)
+		self._log_debug(f"Stored {len(data)} bytes at {self.location(key)}")
+		return self.location(key)
+
+	def get(self, key):
+		status, body = self._request("GET", key)
+		if status == 404:
+			raise KeyError(key)
+		return body
+
+	def exists(self, key):
+		return self._request("HEAD", key)[0] != 404
+
+	def delete(self, key):
+		self._request("DELETE", key)
+
+	def location(self, key):
+		return f"s3://{self.bucket}/{self.prefix}{key.replace(os.sep, '/')}"
+
+	def key_for(self, location):
+		base = f"s3://{self.bucket}/{self.prefix}"
+		return location[len(base):] if location.startswith(base) else None
+
+
+BLOB_STORE_TYPES = {"files": FileBlobStore, "s3": S3BlobStore}
+
+
+def create_blob_store(config, enable_debug=False):
+	"""Build a blob store from its settings, e.g. {"type": "s3", "endpoint": "http://minio.local.mesh:9000",
+	"bucket": "esv", "access_key": "...", "secret_key": "..."} or {"type": "files", "folder": "/data/esv-blobs"}."""
+	config = dict(config)
+	kind = config.pop("type", None)
+	if kind not in BLOB_STORE_TYPES:
+		raise ValueError(f"Unknown blob store type {kind}; expected one of {', '.join(BLOB_STORE_TYPES)}")
+	name = config.pop("name", kind)
+	try:
+		if kind == "files":
+			return FileBlobStore(config.pop("folder"), name, enable_debug=enable_debug, **config)
+		return S3BlobStore(name, enable_debug=enable_debug, **config)
+	except (TypeError, KeyError) as e:
+		raise ValueError(f"Blob store {name}: {e}")
+
+
+def load_storage(filename, enable_debug=False):
+	"""Read the storage settings: {"store": {...}, "raw": true}.  Returns (store, keep raw transfers);
+	store is None if the file only names a shared search index (see SearchIndex.load_index).
+	Raises ValueError for bad settings."""
+	with open(filename, 'r') as f:
+		try:
+			config = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(config, dict) or not isinstance(config.get("store", {}), dict) or not ("store" in config or "index" in config):
+		raise ValueError(f"{filename}: expected {{\"store\": {{\"type\": ...}}}}")
+	unknown = set(config) - {"store", "raw", "index"}
+	if unknown:
+		raise ValueError(f"Unknown settings in {filename}: {', '.join(sorted(unknown))}")
+	if "store" not in config:
+		return None, bool(config.get("raw", False))
+	try:
+		return create_blob_store(config["store"], enable_debug), bool(config.get("raw", False))
+	except ValueError as e:
+		raise ValueError(f"{filename}: {e}")
+
+
+def set_store(store, raw=False):
+	"""Save raw messages and attachments to store from now on; None keeps them in the mailbox folder."""
+	global _store, _store_raw
+	_store = store
+	_store_raw = raw
+
+
+def current_store(folder):
+	"""The store for messages saved in folder."""
+	return _store if _store is not None else FileBlobStore(folder)
+
+
+def store_raw():
+	return _store_raw
+
+
+def fetch(location):
+	"""The blob at location, as a message's blobs file records it, from the configured store.
+	Raises KeyError if it isn't there and ValueError if the location is in some other store."""
+	key = _store.key_for(location) if _store is not None else None
+	if key is None:
+		raise ValueError(f"{location} is not in the configured blob store")
+	return _store.get(key)
@@ -0,0 +1,104 @@
+#!/usr/bin/env python
+'''Remembers the content of messages already received so re-sent copies can be flagged'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import os
+import threading
+
+CONTENT_INDEX_FILE_NAME = "content-index.jsonl"
+LEGACY_CONTENT_INDEX_FILE_NAME = "content-index.json"  # The whole index as one JSON object, read if present
+
+# One lock and in-memory copy per index file, shared by every connection thread that uses it
+_indexes = {}
+_indexes_guard = threading.Lock()
+
+
+class _Entries:
+	"""The hashes read so far from an index file, and how far into it they were read."""
+
+	def __init__(self, legacy_path):
+		self.lock = threading.Lock()
+		self.mids = {}
+		self.offset = 0
+		self.torn = False  # The file ended in a partial line, e.g. after a crash mid-write
+		if os.path.exists(legacy_path):
+			with open(legacy_path, 'r') as f:
+				self.mids.update(json.load(f))
+
+
+def _entries_for(path, legacy_path):
+	with _indexes_guard:
+		key = os.path.abspath(path)
+		if key not in _indexes:
+			_indexes[key] = _Entries(legacy_path)
+		return _indexes[key]
+
+
+class ContentIndex:
+	"""Maps content hashes to the MID of the first message seen with that content.  The index is a file
+	of one {"hash", "mid"} line per hash, appended to as messages arrive, so recording a message costs
+	the same however many came before it."""
+
+	def __init__(self, folder):
+		"""Use the index stored in folder, creating it on first use."""
+		self.path = os.path.join(folder, CONTENT_INDEX_FILE_NAME)
+		self.entries = _entries_for(self.path, os.path.join(folder, LEGACY_CONTENT_INDEX_FILE_NAME))
+
+	def _refresh(self):
+		"""Read the lines appended since the last look, by this process or another."""
+		if not os.path.exists(self.path):
+			return
+		with open(self.path, 'rb') as f:
+			f.seek(self.entries.offset)
+			data = f.read()
+		end = data.rfind(b"\n") + 1
+		for line in data[:end].splitlines():
+			try:
+				entry = json.loads(line)
+			except ValueError:
+				continue  # A line torn by a crash and terminated by the next write
+			if isinstance(entry, dict) and isinstance(entry.get("hash"), str) and entry.get("mid"):
+				self.entries.mids.setdefault(entry["hash"], entry["mid"])
+		self.entries.offset += end
+		self.entries.torn = end < len(data)
+
+	def check_and_add(self, content_hash, mid):
+		"""Return the MID already recorded for content_hash, or record mid and return None."""
+		with self.entries.lock:
+			self._refresh()
+			original = self.entries.mids.get(content_hash)
+			if original is not None and original != mid:
+				return original
+			if original is None:
+				line = json.dumps({"hash": content_hash, "mid": mid}) + "\n"
+				with open(self.path, 'a', newline='') as f:
+					f.write(("\n" if self.entries.torn else "") + line)
+				self.entries.mids[content_hash] = mid
+				self._refresh()  # Step over the line just written
+			return None
+
+
+def flag_duplicates(messages):
+	"""Flag each message whose content matches an earlier one, by date, with a different MID among
+	messages, setting duplicate_of and adding a warning.  This catches re-sends among captures read
+	together, which never passed through a mailbox's index.  Messages with attachments that weren't
+	read (kept in a blob store) can't be compared and are passed over.  Returns the messages flagged."""
+	first = {}
+	flagged = []
+	for message in sorted(messages, key=lambda m: m.date):
+		if message.duplicate_of is not None or not message.headers or any(a.data is None for a in message.attachments):
+			continue
+		mid = message.mid or message.message_id
+		original = first.setdefault(message.content_hash(), mid)
+		if original != mid:
+			message.duplicate_of = original
+			message.warnings.append(f"Probable duplicate of {original}")
+			flagged.append(message)
+	return flagged
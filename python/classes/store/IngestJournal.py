@@ -0,0 +1,174 @@
+#!/usr/bin/env python
+'''Append-only journal of raw received transfers, written before they are parsed and replayed after a crash'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import logging
+import os
+import struct
+import threading
+import zlib
+from classes.b2f.WinlinkTime import utc_now
+
+# Each record: magic, kind, sequence number, payload length, payload, then a CRC-32 of all of it.
+# A MESSAGE payload is a JSON line describing the proposal, then the raw transfer; a DONE payload is a
+# JSON object saying how the message with that sequence number ended.
+RECORD_MAGIC = b"ESVJ"
+RECORD_HEADER = struct.Struct("<4sBII")
+RECORD_CRC = struct.Struct("<I")
+MESSAGE = 1
+DONE = 2
+SAVED = "saved"
+QUARANTINED = "quarantined"
+COMPACT_BYTES = 1024 * 1024  # Start the file afresh once nothing is pending and it is this big
+
+
+class IngestJournal:
+	"""The journal file.  append() makes a transfer durable before the client is told it arrived;
+	complete() records that it was dealt with.  Whatever was appended but not completed when the server
+	stopped is returned by pending() at the next start."""
+
+	def __init__(self, filename, sync=True, enable_debug=False):
+		self.filename = filename
+		self.sync = sync  # fsync each record; without it a power cut can still lose the last few
+		self.enable_debug = enable_debug
+		self.lock = threading.Lock()
+		self.open_entries = set()  # Sequence numbers appended and not yet completed
+		self.recovered = {}  # Sequence number -> (description, raw transfer) left open by the last run
+		self.offsets = {}  # Sequence number -> file offset of each open entry's record, for read()
+		self.next_sequence = 1
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+		folder = os.path.dirname(filename)
+		if folder and not os.path.exists(folder):
+			os.makedirs(folder)
+		self._load()
+		self.file = open(filename, 'ab')
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def _load(self):
+		"""Read the existing journal, keeping the entries never completed.  A record cut short by a crash
+		ends the journal, and is cut off so new records follow good ones."""
+		if not os.path.exists(self.filename):
+			return
+		with open(self.filename, 'rb') as f:
+			data = f.read()
+		offset = 0
+		while offset < len(data):
+			record = self._read_record(data, offset)
+			if record is None:
+				self.logger.warning(f"Journal {self.filename}: discarding {len(data) - offset} damaged bytes at offset {offset}")
+				with open(self.filename, 'r+b') as f:
+					f.truncate(offset)
+				break
+			kind, sequence, payload, next_offset = record
+			self.next_sequence = max(self.next_sequence, sequence + 1)
+			if kind == MESSAGE:
+				description, _, raw = payload.partition(b"\n")
+				self.recovered[sequence] = (json.loads(description), raw)
+				self.offsets[sequence] = offset
+			elif kind == DONE:
+				self.recovered.pop(sequence, None)
+				self.offsets.pop(sequence, None)
+			offset = next_offset
+		self.open_entries = set(self.recovered)
+		self._log_debug(f"Journal {self.filename}: {len(self.recovered)} entries pending")
+
+	@staticmethod
+	def _read_record(data, offset):
+		"""(kind, sequence, payload, next offset) for the record at offset, or None if it is damaged."""
+		end = offset + RECORD_HEADER.size
+		if end > len(data):
+			return None
+		magic, kind, sequence, length = RECORD_HEADER.unpack_from(data, offset)
+		if magic != RECORD_MAGIC or end + length + RECORD_CRC.size > len(data):
+			return None
+		(crc,) = RECORD_CRC.unpack_from(data, end + length)
+		if crc != zlib.crc32(data[offset:end + length]):
+			return None
+		try:
+			if kind == MESSAGE:
+				json.loads(data[end:end + length].partition(b"\n")[0])
+		except ValueError:
+			return None
+		return kind, sequence, data[end:end + length], end + length + RECORD_CRC.size
+
+	def _write(self, kind, sequence, payload):
+		record = RECORD_HEADER.pack(RECORD_MAGIC, kind, sequence, len(payload)) + payload
+		self.file.write(record + RECORD_CRC.pack(zlib.crc32(record)))
+		self.file.flush()
+		if self.sync:
+			os.fsync(self.file.fileno())
+
+	def append(self, message, raw):
+		"""Record a received transfer (a WinlinkMailMessage's proposal and its raw bytes).  Returns its sequence number."""
+		description = {
+			"mid": message.message_id,
+			"type": message.message_type,
+			"size": message.uncompressed_size,
+			"compressed_size": message.compressed_size,
+			"source": message.source,
+			"received": utc_now().isoformat(timespec="seconds"),
+		}
+		with self.lock:
+			sequence = self.next_sequence
+			self.next_sequence += 1
+			self.offsets[sequence] = self.file.tell()
+			self._write(MESSAGE, sequence, json.dumps(description, default=str).encode("utf-8") + b"\n" + bytes(raw))
+			self.open_entries.add(sequence)
+		return sequence
+
+	def read(self, sequence):
+		"""(description, raw transfer) of an open entry, read back from the file, or None if it isn't open."""
+		with self.lock:
+			if sequence not in self.open_entries:
+				return None
+			with open(self.filename, 'rb') as f:
+				f.seek(self.offsets[sequence])
+				header = f.read(RECORD_HEADER.size)
+				length = RECORD_HEADER.unpack(header)[3] if len(header) == RECORD_HEADER.size else 0
+				data = header + f.read(length + RECORD_CRC.size)
+		record = self._read_record(data, 0)
+		if record is None:
+			raise OSError(f"Journal {self.filename}: entry {sequence} is damaged")
+		description, _, raw = record[2].partition(b"\n")
+		return json.loads(description), raw
+
+	def complete(self, sequence, status=SAVED):
+		"""Record that an entry was saved or quarantined, so it isn't replayed."""
+		with self.lock:
+			if sequence not in self.open_entries:
+				return
+			self._write(DONE, sequence, json.dumps({"status": status}).encode("utf-8"))
+			self.open_entries.discard(sequence)
+			self.recovered.pop(sequence, None)
+			self.offsets.pop(sequence, None)
+			if not self.open_entries and self.file.tell() >= COMPACT_BYTES:
+				self.file.truncate(0)
+				self.file.seek(0)
+				self._log_debug(f"Journal {self.filename}: compacted")
+
+	def pending(self):
+		"""[(sequence, description, raw transfer)] for the entries the last run never completed, oldest first."""
+		with self.lock:
+			return [(sequence, description, raw) for sequence, (description, raw) in sorted(self.recovered.items())]
+
+	def close(self):
+		with self.lock:
+			self.file.close()
@@ -0,0 +1,84 @@
+#!/usr/bin/env python
+'''ICS operational periods and bucketing of messages into them'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+from classes.b2f.WinlinkTime import parse_timestamp
+from classes.store.WinlinkMailMessage import safe_filename
+
+UNASSIGNED = "Unassigned"  # Bucket for messages outside every period; no period may take its name
+
+
+class OperationalPeriod:
+	"""A named span of time, start inclusive and end exclusive."""
+
+	def __init__(self, name, start, end):
+		if end <= start:
+			raise ValueError(f"Operational period {name} ends before it starts")
+		self.name = name
+		self.start = start  # Aware UTC datetime
+		self.end = end
+
+	def contains(self, when):
+		return self.start <= when < self.end
+
+	def as_dict(self):
+		return {"name": self.name, "start": self.start.isoformat(), "end": self.end.isoformat()}
+
+
+def file_key(name):
+	"""What a period name is compared by: the file it is written to, ignoring case, as on Windows."""
+	return safe_filename(name).casefold()
+
+
+def load_periods(filename):
+	"""Read periods from a JSON file of the form
+	{"timezone": "America/Los_Angeles", "periods": [{"name": "OP 1", "start": "2025-10-04 08:00", "end": "2025-10-04 20:00"}, ...]}
+	The timezone, if given, applies to times without one.  Raises ValueError for bad entries, and for
+	names used twice or named UNASSIGNED, since each name is a bucket, a layer, and a file.  Names are
+	compared as the files they become, so "OP 1" and "op 1 " or "OP:1" and "OP/1" are the same name."""
+	with open(filename, 'r') as f:
+		config = json.load(f)
+	zone = config.get("timezone")
+	periods = []
+	for entry in config.get("periods", []):
+		if not isinstance(entry, dict):
+			raise ValueError(f"Operational period must be an object with a name, start, and end: {entry}")
+		name = entry.get("name")
+		if name is not None and not isinstance(name, str):
+			raise ValueError(f"Operational period name must be text: {entry}")
+		start = parse_timestamp(entry.get("start"), zone)
+		end = parse_timestamp(entry.get("end"), zone)
+		if not name or start is None or end is None:
+			raise ValueError(f"Operational period needs a name, start, and end: {entry}")
+		if file_key(name) == file_key(UNASSIGNED):
+			raise ValueError(f"Operational period can't be named {name}; that name is kept for messages outside every period")
+		if any(file_key(p.name) == file_key(name) for p in periods):
+			raise ValueError(f"Operational period {name} is named twice")
+		periods.append(OperationalPeriod(name, start, end))
+	periods.sort(key=lambda p: p.start)
+	return periods
+
+
+def period_for(periods, when):
+	"""The first period containing when, or None."""
+	for period in periods:
+		if period.contains(when):
+			return period
+	return None
+
+
+def bucket(periods, messages):
+	"""Group messages by period name, in period order, with UNASSIGNED last.  Empty periods are kept."""
+	buckets = {period.name: [] for period in periods}
+	buckets[UNASSIGNED] = []
+	for message in messages:
+		period = period_for(periods, message.date)
+		buckets[period.name if period is not None else UNASSIGNED].append(message)
+	return buckets
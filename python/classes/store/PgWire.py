@@ -0,0 +1,285 @@
+#!/usr/bin/env python
+'''A small PostgreSQL client speaking the version 3 wire protocol, for stores kept in a shared database'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import base64
+import datetime
+import hashlib
+import hmac
+import secrets
+import socket
+import ssl
+import struct
+
+DEFAULT_PORT = 5432
+CONNECT_TIMEOUT_SECONDS = 15
+PROTOCOL_VERSION = 196608  # 3.0
+SSL_REQUEST_CODE = 80877103
+SSL_MODES = ("disable", "prefer", "require", "verify-full")  # As libpq's sslmode
+
+# Authentication request codes
+AUTH_OK = 0
+AUTH_CLEARTEXT = 3
+AUTH_MD5 = 5
+AUTH_SASL = 10
+AUTH_SASL_CONTINUE = 11
+AUTH_SASL_FINAL = 12
+SCRAM_MECHANISM = "SCRAM-SHA-256"
+
+# Result column types converted from their text form; the rest are returned as str
+INTEGER_TYPES = (20, 21, 23, 26)  # int8, int2, int4, oid
+FLOAT_TYPES = (700, 701, 1700)  # float4, float8, numeric
+BOOLEAN_TYPE = 16
+TIMESTAMP_TYPES = (1114, 1184)  # timestamp, timestamptz
+
+
+class Error(Exception):
+	"""The server refused a statement or the connection.  sqlstate is its five-character error code."""
+
+	def __init__(self, message, sqlstate=None):
+		super().__init__(message)
+		self.sqlstate = sqlstate
+
+
+class Result:
+	"""The rows and completion tag of a statement."""
+
+	def __init__(self, columns, rows, command):
+		self.columns = columns
+		self.rows = rows
+		self.command = command  # e.g. "INSERT 0 1"
+
+	@property
+	def rowcount(self):
+		count = self.command.rsplit(" ", 1)[-1] if self.command else ""
+		return int(count) if count.isdigit() else 0
+
+
+def _cstring(text):
+	return text.encode("utf-8") + b"\0"
+
+
+def _text_parameter(value):
+	"""A parameter in PostgreSQL's text format, or None for NULL."""
+	if value is None:
+		return None
+	if isinstance(value, bool):
+		return b"true" if value else b"false"
+	if isinstance(value, datetime.datetime):
+		return value.isoformat().encode("ascii")
+	return str(value).encode("utf-8")
+
+
+def _column_value(type_oid, data):
+	if data is None:
+		return None
+	text = data.decode("utf-8")
+	if type_oid in INTEGER_TYPES:
+		return int(text)
+	if type_oid in FLOAT_TYPES:
+		return float(text)
+	if type_oid == BOOLEAN_TYPE:
+		return text == "t"
+	if type_oid in TIMESTAMP_TYPES:
+		return datetime.datetime.fromisoformat(text)
+	return text
+
+
+def scram_client_proof(password, salt, iterations, auth_message):
+	"""The client proof and expected server signature of SCRAM-SHA-256 (RFC 5802, 7677)."""
+	salted = hashlib.pbkdf2_hmac("sha256", password.encode("utf-8"), salt, iterations)
+	client_key = hmac.new(salted, b"Client Key", hashlib.sha256).digest()
+	stored_key = hashlib.sha256(client_key).digest()
+	signature = hmac.new(stored_key, auth_message, hashlib.sha256).digest()
+	proof = bytes(a ^ b for a, b in zip(client_key, signature))
+	server_key = hmac.new(salted, b"Server Key", hashlib.sha256).digest()
+	return proof, hmac.new(server_key, auth_message, hashlib.sha256).digest()
+
+
+class Connection:
+	"""One connection to a PostgreSQL server.  Statements run one at a time, each with its parameters
+	sent apart from the SQL ($1, $2, ...), so values are never quoted into it.  Not for sharing
+	between threads."""
+
+	def __init__(self, host, user, password=None, database=None, port=DEFAULT_PORT, sslmode="prefer", timeout=CONNECT_TIMEOUT_SECONDS):
+		"""Connect and log in.  Raises OSError if the server can't be reached and Error if it refuses."""
+		if sslmode not in SSL_MODES:
+			raise ValueError(f"Unknown sslmode {sslmode}; expected one of {', '.join(SSL_MODES)}")
+		self.user = user
+		self.password = password
+		self.socket = socket.create_connection((host, port), timeout=timeout)
+		try:
+			if sslmode != "disable":
+				self._start_tls(host, sslmode)
+			self._startup(user, database or user)
+		except BaseException:
+			self.socket.close()
+			raise
+
+	def __enter__(self):
+		return self
+
+	def __exit__(self, *exception):
+		self.close()
+
+	def _start_tls(self, host, sslmode):
+		self.socket.sendall(struct.pack("!II", 8, SSL_REQUEST_CODE))
+		answer = self._receive_exactly(1)
+		if answer != b"S":
+			if sslmode == "prefer":
+				return
+			raise Error(f"The server at {host} doesn't accept TLS connections")
+		context = ssl.create_default_context()
+		if sslmode != "verify-full":
+			# As libpq: encrypted, but the certificate isn't checked
+			context.check_hostname = False
+			context.verify_mode = ssl.CERT_NONE
+		self.socket = context.wrap_socket(self.socket, server_hostname=host)
+
+	def _send(self, kind, payload=b""):
+		self.socket.sendall(kind + struct.pack("!I", len(payload) + 4) + payload)
+
+	def _receive_exactly(self, count):
+		data = bytearray()
+		while len(data) < count:
+			chunk = self.socket.recv(count - len(data))
+			if not chunk:
+				raise ConnectionError("The PostgreSQL server closed the connection")
+			data += chunk
+		return bytes(data)
+
+	def _receive(self):
+		"""The next message from the server as (type, payload).  Notices are passed over."""
+		while True:
+			header = self._receive_exactly(5)
+			kind, length = header[:1], struct.unpack("!I", header[1:])[0]
+			payload = self._receive_exactly(length - 4)
+			if kind != b"N":
+				return kind, payload
+
+	@staticmethod
+	def _error(payload):
+		fields = {}
+		for field in payload.split(b"\0"):
+			if field:
+				fields[field[:1]] = field[1:].decode("utf-8", errors="replace")
+		return Error(f"{fields.get(b'S', 'ERROR')}: {fields.get(b'M', 'unknown error')}", fields.get(b"C"))
+
+	def _startup(self, user, database):
+		parameters = b"".join(_cstring(name) + _cstring(value) for name, value in [("user", user), ("database", database), ("application_name", "esv-forms-to-map"), ("client_encoding", "UTF8")])
+		payload = struct.pack("!I", PROTOCOL_VERSION) + parameters + b"\0"
+		self.socket.sendall(struct.pack("!I", len(payload) + 4) + payload)
+		scram = None
+		while True:
+			kind, payload = self._receive()
+			if kind == b"E":
+				raise self._error(payload)
+			if kind == b"Z":
+				return
+			if kind != b"R":
+				continue  # ParameterStatus, BackendKeyData
+			code = struct.unpack("!I", payload[:4])[0]
+			if code == AUTH_OK:
+				continue
+			if self.password is None:
+				raise Error(f"The server wants a password for {user}")
+			if code == AUTH_CLEARTEXT:
+				self._send(b"p", _cstring(self.password))
+			elif code == AUTH_MD5:
+				inner = hashlib.md5((self.password + user).encode("utf-8")).hexdigest()
+				self._send(b"p", _cstring("md5" + hashlib.md5(inner.encode("ascii") + payload[4:8]).hexdigest()))
+			elif code == AUTH_SASL:
+				mechanisms = [m.decode("ascii") for m in payload[4:].split(b"\0") if m]
+				if SCRAM_MECHANISM not in mechanisms:
+					raise Error(f"No supported authentication mechanism among {', '.join(mechanisms)}")
+				scram = {"nonce": base64.b64encode(secrets.token_bytes(18)).decode("ascii")}
+				scram["first"] = f"n=,r={scram['nonce']}".encode("ascii")
+				initial = b"n,," + scram["first"]
+				self._send(b"p", _cstring(SCRAM_MECHANISM) + struct.pack("!I", len(initial)) + initial)
+			elif code == AUTH_SASL_CONTINUE and scram is not None:
+				self._send(b"p", self._scram_final(scram, payload[4:]))
+			elif code == AUTH_SASL_FINAL and scram is not None:
+				attributes = dict(item.split("=", 1) for item in payload[4:].decode("ascii").split(",") if "=" in item)
+				if not hmac.compare_digest(base64.b64decode(attributes.get("v", "")), scram["signature"]):
+					raise Error("The server's SCRAM signature is wrong")
+			else:
+				raise Error(f"Unsupported authentication request {code}")
+
+	def _scram_final(self, scram, server_first):
+		attributes = dict(item.split("=", 1) for item in server_first.decode("ascii").split(",") if "=" in item)
+		nonce = attributes.get("r", "")
+		if not nonce.startswith(scram["nonce"]) or "s" not in attributes or "i" not in attributes:
+			raise Error("Malformed SCRAM challenge from the server")
+		without_proof = f"c=biws,r={nonce}".encode("ascii")
+		auth_message = scram["first"] + b"," + server_first + b"," + without_proof
+		proof, scram["signature"] = scram_client_proof(self.password, base64.b64decode(attributes["s"]), int(attributes["i"]), auth_message)
+		return without_proof + b",p=" + base64.b64encode(proof)
+
+	def _results(self):
+		"""Read to ReadyForQuery, returning the Result of each statement.  Raises the first error."""
+		results = []
+		columns, types, rows = [], [], []
+		error = None
+		while True:
+			kind, payload = self._receive()
+			if kind == b"T":
+				count = struct.unpack("!H", payload[:2])[0]
+				columns, types, rows = [], [], []
+				index = 2
+				for _ in range(count):
+					end = payload.index(b"\0", index)
+					columns.append(payload[index:end].decode("utf-8"))
+					types.append(struct.unpack("!I", payload[end + 7:end + 11])[0])
+					index = end + 19
+			elif kind == b"D":
+				count = struct.unpack("!H", payload[:2])[0]
+				row, index = [], 2
+				for column in range(count):
+					length = struct.unpack("!i", payload[index:index + 4])[0]
+					index += 4
+					data = None if length < 0 else payload[index:index + length]
+					index += max(length, 0)
+					row.append(_column_value(types[column] if column < len(types) else 0, data))
+				rows.append(tuple(row))
+			elif kind in (b"C", b"I"):
+				results.append(Result(columns, rows, payload.rstrip(b"\0").decode("utf-8") if kind == b"C" else ""))
+				columns, types, rows = [], [], []
+			elif kind == b"E":
+				error = error or self._error(payload)
+			elif kind == b"Z":
+				if error is not None:
+					raise error
+				return results
+
+	def execute(self, sql, parameters=()):
+		"""Run one statement with its $n parameters and return its Result."""
+		values = [_text_parameter(value) for value in parameters]
+		bind = b"\0\0" + struct.pack("!HH", 0, len(values))
+		for value in values:
+			bind += struct.pack("!i", -1) if value is None else struct.pack("!i", len(value)) + value
+		bind += struct.pack("!H", 0)
+		self._send(b"P", b"\0" + _cstring(sql) + struct.pack("!H", 0))
+		self._send(b"B", bind)
+		self._send(b"D", b"P\0")
+		self._send(b"E", b"\0" + struct.pack("!I", 0))
+		self._send(b"S")
+		results = self._results()
+		return results[-1] if results else Result([], [], "")
+
+	def script(self, sql):
+		"""Run statements with no parameters, separated by semicolons, and return their Results."""
+		self._send(b"Q", _cstring(sql))
+		return self._results()
+
+	def close(self):
+		try:
+			self._send(b"X")
+		except OSError:
+			pass
+		self.socket.close()
@@ -0,0 +1,87 @@
+#!/usr/bin/env python
+'''Keeps messages that could not be decoded apart from the mailbox, with what went wrong'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import os
+from classes.b2f.WinlinkTime import utc_now
+
+QUARANTINE_FOLDER_NAME = "failed"  # Inside the mailbox folder; esvmap skips it when reading the mailbox
+RAW_FILE_SUFFIX = ".b2f"  # The transfer as received, readable by esvmap inspect
+DECODED_FILE_SUFFIX = "-decoded.txt"  # The decompressed message, when decompression got that far
+DIAGNOSTICS_FILE_SUFFIX = "-diagnostics.json"
+
+
+class Quarantine:
+	"""The failed area of a mailbox.  Each entry is a raw transfer, the decoded message if there is
+	one, and a diagnostics file naming the stage that failed, the error, and its traceback."""
+
+	def __init__(self, mailbox_folder):
+		self.folder = os.path.join(mailbox_folder, QUARANTINE_FOLDER_NAME)
+
+	def add(self, name, raw, stage, error, trace=None, decoded=None, details=None):
+		"""Quarantine a message under name (e.g. "20250301093000-MID").  details are added to the
+		diagnostics, e.g. the message's source and proposal.  Returns the diagnostics file name."""
+		if not os.path.exists(self.folder):
+			os.makedirs(self.folder)
+		base = os.path.join(self.folder, name)
+		diagnostics = {"name": name, "quarantined": utc_now().isoformat(timespec="seconds"), "stage": stage, "error": error, "traceback": trace}
+		diagnostics.update(details or {})
+		with open(f"{base}{RAW_FILE_SUFFIX}", 'wb') as f:
+			f.write(bytes(raw or b""))
+		if decoded:
+			with open(f"{base}{DECODED_FILE_SUFFIX}", 'wb') as f:
+				f.write(bytes(decoded))
+		with open(f"{base}{DIAGNOSTICS_FILE_SUFFIX}", 'w') as f:
+			json.dump(diagnostics, f, indent=4, default=str)
+		return f"{base}{DIAGNOSTICS_FILE_SUFFIX}"
+
+	def _base(self, name):
+		return os.path.join(self.folder, name)
+
+	def find(self, key):
+		"""The diagnostics of the entry named key, or of the only one for MID key; None if there is none."""
+		matches = [e for e in self.entries() if key in (e.get("name"), e.get("mid"))]
+		if len(matches) > 1:
+			raise ValueError(f"{len(matches)} quarantined messages have MID {key}; give the name instead ({', '.join(e['name'] for e in matches)})")
+		return matches[0] if matches else None
+
+	def raw(self, name):
+		with open(f"{self._base(name)}{RAW_FILE_SUFFIX}", 'rb') as f:
+			return f.read()
+
+	def decoded(self, name):
+		"""The decompressed message, or None if decompression never worked."""
+		filename = f"{self._base(name)}{DECODED_FILE_SUFFIX}"
+		if not os.path.exists(filename):
+			return None
+		with open(filename, 'rb') as f:
+			return f.read()
+
+	def update(self, diagnostics):
+		"""Rewrite an entry's diagnostics, e.g. after another failed attempt."""
+		with open(f"{self._base(diagnostics['name'])}{DIAGNOSTICS_FILE_SUFFIX}", 'w') as f:
+			json.dump(diagnostics, f, indent=4, default=str)
+
+	def remove(self, name):
+		"""Delete an entry's files, once it has been saved to the mailbox or given up on."""
+		for suffix in (RAW_FILE_SUFFIX, DECODED_FILE_SUFFIX, DIAGNOSTICS_FILE_SUFFIX):
+			if os.path.exists(f"{self._base(name)}{suffix}"):
+				os.remove(f"{self._base(name)}{suffix}")
+
+	def entries(self):
+		"""The diagnostics of every quarantined message, oldest first."""
+		if not os.path.isdir(self.folder):
+			return []
+		entries = []
+		for file_name in sorted(os.listdir(self.folder)):
+			if file_name.endswith(DIAGNOSTICS_FILE_SUFFIX):
+				with open(os.path.join(self.folder, file_name), 'r') as f:
+					entries.append(json.load(f))
+		return entries
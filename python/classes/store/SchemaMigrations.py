@@ -0,0 +1,117 @@
+#!/usr/bin/env python
+'''Versioned schema migrations for the SQLite stores, applied when a store is opened'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import logging
+import os
+import sqlite3
+import threading
+
+BACKUP_SUFFIX = ".v{version}.bak"  # Copy of a database taken before it is migrated from that version
+
+# Threads of one process migrate one at a time; other processes are kept out by BEGIN IMMEDIATE
+_migrate_lock = threading.Lock()
+
+
+class SchemaError(sqlite3.DatabaseError):
+	"""The database was written by a newer version of this software, or a migration failed."""
+
+
+def schema_version(connection):
+	"""The version a database has reached, kept in its user_version header field.  Databases made
+	before versioning are at 0."""
+	return connection.execute("PRAGMA user_version").fetchone()[0]
+
+
+def backup(connection, path):
+	"""Copy the database to path, consistently even while other connections write to it."""
+	destination = sqlite3.connect(path)
+	try:
+		connection.backup(destination)
+	finally:
+		destination.close()
+
+
+class SchemaMigrations:
+	"""A store's schema history: a list of (version, description, [SQL statement]) in version order.
+	The first migration must also accept a database made before versioning, as it is (e.g. with
+	CREATE ... IF NOT EXISTS), so those are adopted rather than rebuilt."""
+
+	def __init__(self, migrations, enable_debug=False):
+		self.migrations = migrations
+		self.enable_debug = enable_debug
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	@property
+	def latest(self):
+		return self.migrations[-1][0] if self.migrations else 0
+
+	def pending(self, connection):
+		"""[(version, description)] of the migrations the database still needs.  Raises SchemaError if
+		it is newer than these migrations know about."""
+		current = schema_version(connection)
+		if current > self.latest:
+			raise SchemaError(f"schema version {current} is newer than this software supports ({self.latest}); upgrade before using it")
+		return [(version, description) for version, description, _ in self.migrations if version > current]
+
+	def migrate(self, connection, path=None):
+		"""Apply the migrations the database at path (open as connection) hasn't had, each in its own
+		transaction, and return the versions applied.  A database with tables in it is backed up first,
+		so an upgrade never costs the history it holds.  Safe with other connections doing the same."""
+		if not self.pending(connection):
+			return []
+		with _migrate_lock:
+			return self._migrate(connection, path)
+
+	def _migrate(self, connection, path):
+		current = schema_version(connection)
+		if current >= self.latest:
+			return []
+		if path is not None and connection.execute("SELECT count(*) FROM sqlite_master WHERE type = 'table'").fetchone()[0]:
+			backup_path = f"{path}{BACKUP_SUFFIX.format(version=current)}"
+			if not os.path.exists(backup_path):
+				backup(connection, backup_path)
+				self.logger.info(f"Backed up {path} to {backup_path} before migrating it")
+		applied = []
+		isolation_level = connection.isolation_level
+		connection.isolation_level = None  # Transactions are begun and ended here, so they cover the DDL too
+		try:
+			for version, description, statements in self.migrations:
+				if version <= current:
+					continue
+				connection.execute("BEGIN IMMEDIATE")
+				try:
+					if schema_version(connection) >= version:
+						# Another connection got there first
+						connection.execute("ROLLBACK")
+						continue
+					for statement in statements:
+						connection.execute(statement)
+					connection.execute(f"PRAGMA user_version = {int(version)}")
+					connection.execute("COMMIT")
+				except sqlite3.Error as e:
+					connection.execute("ROLLBACK")
+					raise SchemaError(f"Migration {version} ({description}) of {path or 'the database'} failed: {e}")
+				applied.append(version)
+				self.logger.info(f"Migrated {path or 'the database'} to schema version {version}: {description}")
+		finally:
+			connection.isolation_level = isolation_level
+		return applied
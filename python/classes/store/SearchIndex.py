@@ -0,0 +1,338 @@
+#!/usr/bin/env python
+'''Full-text index of stored messages for keyword search: SQLite in the mailbox folder, or PostgreSQL with PostGIS'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import json
+import os
+import socket
+import sqlite3
+from classes.store import PgWire
+from classes.forms import Redaction
+from classes.export.MapExport import has_position
+from classes.store.SchemaMigrations import SchemaMigrations, SchemaError, schema_version
+
+SEARCH_INDEX_FILE_NAME = "search-index.sqlite"
+BUSY_TIMEOUT_SECONDS = 10  # Connection threads may be writing while a search runs
+SNIPPET_TOKENS = 12
+
+_index = None  # The PostgisIndex messages are indexed in; None keeps an index in each mailbox folder
+
+# Each version of the schema, oldest first.  Indexes made before versioning are at version 1 already.
+MIGRATIONS = [
+	(1, "full-text table of messages", [
+		"""CREATE VIRTUAL TABLE IF NOT EXISTS messages USING fts5(
+			key UNINDEXED, mid UNINDEXED, date UNINDEXED,
+			sender, recipient, subject, body, fields,
+			tokenize = 'porter unicode61'
+		)""",
+	]),
+	# FTS5 tables can't gain columns, so the table is copied into a new one
+	(2, "form type column, for searches such as form_type:ICS213*", [
+		"""CREATE VIRTUAL TABLE messages_v2 USING fts5(
+			key UNINDEXED, mid UNINDEXED, date UNINDEXED,
+			sender, recipient, subject, body, fields, form_type,
+			tokenize = 'porter unicode61'
+		)""",
+		"INSERT INTO messages_v2 (key, mid, date, sender, recipient, subject, body, fields, form_type) "
+		"SELECT key, mid, date, sender, recipient, subject, body, fields, '' FROM messages",
+		"DROP TABLE messages",
+		"ALTER TABLE messages_v2 RENAME TO messages",
+	]),
+]
+
+
+class SearchIndex:
+	"""An SQLite FTS5 index over the subject, body, and form fields of each message, kept in folder."""
+
+	def __init__(self, folder):
+		"""Use the index stored in folder, creating it on first use."""
+		self.path = os.path.join(folder, SEARCH_INDEX_FILE_NAME)
+
+	def _connect(self):
+		connection = sqlite3.connect(self.path, timeout=BUSY_TIMEOUT_SECONDS)
+		try:
+			SchemaMigrations(MIGRATIONS).migrate(connection, self.path)
+		except sqlite3.Error:
+			connection.close()
+			raise
+		return connection
+
+	def exists(self):
+		"""True if the index has been created."""
+		return os.path.exists(self.path)
+
+	def schema(self):
+		"""The index's schema version and the [(version, description)] migrations it still needs, without
+		applying them or creating the index."""
+		if not os.path.exists(self.path):
+			return 0, [(version, description) for version, description, _ in MIGRATIONS]
+		connection = sqlite3.connect(self.path, timeout=BUSY_TIMEOUT_SECONDS)
+		try:
+			return schema_version(connection), SchemaMigrations(MIGRATIONS).pending(connection)
+		finally:
+			connection.close()
+
+	def migrate(self):
+		"""Bring the index up to date now rather than at its next use.  Returns the versions applied."""
+		connection = sqlite3.connect(self.path, timeout=BUSY_TIMEOUT_SECONDS)
+		try:
+			return SchemaMigrations(MIGRATIONS).migrate(connection, self.path)
+		finally:
+			connection.close()
+
+	def add(self, key, message):
+		"""Index a message (a B2Message) under key, replacing whatever was indexed under it before.  What
+		is indexed is redacted as exports are, so snippets can't show what the map leaves out."""
+		form_type = message.form.form_type if message.form is not None else ""
+		fields = ""
+		if message.form is not None:
+			fields = " ".join(str(v) for v in Redaction.scrub_fields(form_type, message.form.variables).values() if v)
+		with self._connect() as connection:
+			connection.execute("DELETE FROM messages WHERE key = ?", (key,))
+			connection.execute(
+				"INSERT INTO messages (key, mid, date, sender, recipient, subject, body, fields, form_type) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				(key, message.mid, message.date.isoformat(), message.sender, message.recipient,
+					Redaction.scrub_text(message.subject), Redaction.scrub_text(message.body), fields, form_type),
+			)
+		connection.close()
+
+	def keys(self):
+		"""Every key in the index."""
+		with self._connect() as connection:
+			keys = {row[0] for row in connection.execute("SELECT key FROM messages")}
+		connection.close()
+		return keys
+
+	def clear(self):
+		with self._connect() as connection:
+			connection.execute("DELETE FROM messages")
+		connection.close()
+
+	def search(self, query, limit=50, bbox=None):
+		"""Best matches first for an FTS5 query such as: generator, "road closed", or subject:shelter.
+		Raises ValueError if the query is malformed.  Positions aren't indexed here, so bbox needs a
+		PostgisIndex."""
+		if bbox is not None:
+			raise ValueError("Searching by area needs a PostGIS index; see \"index\" in storage.json")
+		try:
+			with self._connect() as connection:
+				rows = connection.execute(
+					"SELECT key, mid, date, sender, recipient, subject, form_type, "
+					f"snippet(messages, -1, '[', ']', '...', {SNIPPET_TOKENS}), bm25(messages) "
+					"FROM messages WHERE messages MATCH ? ORDER BY bm25(messages) LIMIT ?",
+					(query, limit),
+				).fetchall()
+			connection.close()
+		except sqlite3.OperationalError as e:
+			raise ValueError(f"Bad search <{query}>: {e}")
+		names = ["key", "mid", "date", "sender", "recipient", "subject", "form_type", "snippet", "rank"]
+		return [dict(zip(names, row)) for row in rows]
+
+
+STORE_NAME = "messages"  # This store's row in esv_schema
+SCHEMA_LOCK = 4553560  # pg_advisory_xact_lock key held while migrating, so servers migrate one at a time
+TEXT_SEARCH_CONFIGURATION = "english"
+
+# Each version of the PostGIS schema, oldest first.  Each runs in one transaction.
+POSTGIS_MIGRATIONS = [
+	(1, "messages with a search document and a point geometry", [
+		"CREATE EXTENSION IF NOT EXISTS postgis",
+		f"""CREATE TABLE IF NOT EXISTS esv_messages (
+			node text NOT NULL, key text NOT NULL, mid text, date timestamptz,
+			sender text, recipient text, subject text, body text, fields text, form_type text,
+			position geometry(Point, 4326),
+			document tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('{TEXT_SEARCH_CONFIGURATION}', coalesce(subject, '')), 'A') ||
+				to_tsvector('{TEXT_SEARCH_CONFIGURATION}', concat_ws(' ', sender, recipient, body, fields, form_type))
+			) STORED,
+			PRIMARY KEY (node, key)
+		)""",
+		"CREATE INDEX IF NOT EXISTS esv_messages_document ON esv_messages USING gin (document)",
+		"CREATE INDEX IF NOT EXISTS esv_messages_position ON esv_messages USING gist (position)",
+	]),
+]
+
+SCHEMA_TABLE = "CREATE TABLE IF NOT EXISTS esv_schema (store text PRIMARY KEY, version integer NOT NULL)"
+RESULT_COLUMNS = ["key", "mid", "date", "sender", "recipient", "subject", "form_type", "snippet", "rank", "node", "latitude", "longitude"]
+
+
+class PostgisIndex:
+	"""The messages of one or more mailboxes in a PostgreSQL table, with a full-text document and a
+	PostGIS point for each, so several servers can share an index and query it by area.  Offers what
+	SearchIndex does; rows are kept per node, the server that saved them, and keys are as SearchIndex's."""
+
+	def __init__(self, host, database, user, password=None, port=PgWire.DEFAULT_PORT, sslmode="prefer", node=None):
+		self.settings = {"host": host, "port": port, "database": database, "user": user, "password": password, "sslmode": sslmode}
+		self.node = node or socket.gethostname()
+		self.path = f"postgresql://{user}@{host}:{port}/{database}"  # Where errors say the index is
+
+	def _connect(self, migrate=True):
+		connection = PgWire.Connection(**self.settings)
+		try:
+			if migrate:
+				self._migrate(connection)
+		except BaseException:
+			connection.close()
+			raise
+		return connection
+
+	def _version(self, connection):
+		if connection.execute("SELECT to_regclass('esv_schema')").rows[0][0] is None:
+			return 0
+		rows = connection.execute("SELECT version FROM esv_schema WHERE store = $1", [STORE_NAME]).rows
+		return rows[0][0] if rows else 0
+
+	def _pending(self, version):
+		latest = POSTGIS_MIGRATIONS[-1][0]
+		if version > latest:
+			raise SchemaError(f"schema version {version} of {self.path} is newer than this software supports ({latest}); upgrade before using it")
+		return [(v, description) for v, description, _ in POSTGIS_MIGRATIONS if v > version]
+
+	def _migrate(self, connection):
+		if not self._pending(self._version(connection)):
+			return []
+		applied = []
+		for version, description, statements in POSTGIS_MIGRATIONS:
+			connection.execute("BEGIN")
+			try:
+				connection.execute("SELECT pg_advisory_xact_lock($1)", [SCHEMA_LOCK])
+				connection.execute(SCHEMA_TABLE)
+				if self._version(connection) >= version:
+					connection.execute("ROLLBACK")
+					continue  # Another server got there first
+				for statement in statements:
+					connection.execute(statement)
+				connection.execute(
+					"INSERT INTO esv_schema (store, version) VALUES ($1, $2) ON CONFLICT (store) DO UPDATE SET version = $2",
+					[STORE_NAME, version],
+				)
+				connection.execute("COMMIT")
+			except PgWire.Error as e:
+				connection.execute("ROLLBACK")
+				raise SchemaError(f"Migration {version} ({description}) of {self.path} failed: {e}")
+			applied.append(version)
+		return applied
+
+	def exists(self):
+		"""True if the index has been created."""
+		with self._connect(migrate=False) as connection:
+			return connection.execute("SELECT to_regclass('esv_messages')").rows[0][0] is not None
+
+	def schema(self):
+		"""The index's schema version and the [(version, description)] migrations it still needs, without
+		applying them or creating the index."""
+		with self._connect(migrate=False) as connection:
+			version = self._version(connection)
+		return version, self._pending(version)
+
+	def migrate(self):
+		"""Bring the index up to date now rather than at its next use.  Returns the versions applied."""
+		with self._connect(migrate=False) as connection:
+			return self._migrate(connection)
+
+	def add(self, key, message):
+		"""Index a message (a B2Message) under key, replacing whatever was indexed under it before, with
+		its position if it reported one.  What is indexed is redacted as in SearchIndex."""
+		form_type = message.form.form_type if message.form is not None else ""
+		fields = ""
+		if message.form is not None:
+			fields = " ".join(str(v) for v in Redaction.scrub_fields(form_type, message.form.variables).values() if v)
+		latitude = longitude = None
+		if has_position(message):
+			latitude, longitude = message.position["latitude"], message.position["longitude"]
+		with self._connect() as connection:
+			connection.execute(
+				"INSERT INTO esv_messages (node, key, mid, date, sender, recipient, subject, body, fields, form_type, position) "
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, "
+				"CASE WHEN $11::float8 IS NULL THEN NULL ELSE ST_SetSRID(ST_MakePoint($11::float8, $12::float8), 4326) END) "
+				"ON CONFLICT (node, key) DO UPDATE SET mid = EXCLUDED.mid, date = EXCLUDED.date, sender = EXCLUDED.sender, "
+				"recipient = EXCLUDED.recipient, subject = EXCLUDED.subject, body = EXCLUDED.body, fields = EXCLUDED.fields, "
+				"form_type = EXCLUDED.form_type, position = EXCLUDED.position",
+				[self.node, key, message.mid, message.date, message.sender, message.recipient,
+					Redaction.scrub_text(message.subject), Redaction.scrub_text(message.body), fields, form_type, longitude, latitude],
+			)
+
+	def keys(self):
+		"""Every key this node has indexed."""
+		with self._connect() as connection:
+			return {row[0] for row in connection.execute("SELECT key FROM esv_messages WHERE node = $1", [self.node]).rows}
+
+	def clear(self):
+		"""Forget what this node has indexed; other nodes' messages are kept."""
+		with self._connect() as connection:
+			connection.execute("DELETE FROM esv_messages WHERE node = $1", [self.node])
+
+	def search(self, query, limit=50, bbox=None):
+		"""Best matches first, from every node, for a web search query such as: generator, "road closed",
+		or generator -diesel.  bbox (west, south, east, north) keeps those reported inside it.  Rank is
+		negated, so lower is better, as in SearchIndex.  Raises ValueError if the server refuses it."""
+		conditions = ["document @@ websearch_to_tsquery($1, $2)"]
+		parameters = [TEXT_SEARCH_CONFIGURATION, query, limit]
+		if bbox is not None:
+			conditions.append("position && ST_MakeEnvelope($4, $5, $6, $7, 4326)")
+			parameters += list(bbox)
+		sql = (
+			"SELECT key, mid, date, sender, recipient, subject, form_type, "
+			f"ts_headline($1, concat_ws(' ', subject, body, fields), websearch_to_tsquery($1, $2), 'StartSel=[, StopSel=], MaxWords={SNIPPET_TOKENS}, MinWords=1'), "
+			"-ts_rank(document, websearch_to_tsquery($1, $2)) AS rank, node, ST_Y(position), ST_X(position) "
+			f"FROM esv_messages WHERE {' AND '.join(conditions)} ORDER BY rank LIMIT $3"
+		)
+		with self._connect() as connection:
+			try:
+				rows = connection.execute(sql, parameters).rows
+			except PgWire.Error as e:
+				raise ValueError(f"Bad search <{query}>: {e}")
+		results = [dict(zip(RESULT_COLUMNS, row)) for row in rows]
+		for result in results:
+			result["date"] = result["date"].isoformat() if result["date"] is not None else ""
+		return results
+
+
+def create_index(config):
+	"""Build a shared index from its settings, e.g. {"type": "postgis", "host": "db.local.mesh",
+	"database": "esv", "user": "esv", "password": "...", "node": "node1"}.  Raises ValueError for bad settings."""
+	config = dict(config)
+	kind = config.pop("type", None)
+	if kind != "postgis":
+		raise ValueError(f"Unknown index type {kind}; expected postgis")
+	if config.get("sslmode", "prefer") not in PgWire.SSL_MODES:
+		raise ValueError(f"Unknown sslmode {config['sslmode']}; expected one of {', '.join(PgWire.SSL_MODES)}")
+	try:
+		return PostgisIndex(**config)
+	except TypeError as e:
+		raise ValueError(f"Index: {e}")
+
+
+def load_index(filename):
+	"""The shared index named under "index" in the storage settings, or None if there is none.
+	Raises ValueError for bad settings."""
+	with open(filename, 'r') as f:
+		try:
+			config = json.load(f)
+		except ValueError as e:
+			raise ValueError(f"{filename}: {e}")
+	if not isinstance(config, dict) or config.get("index") is None:
+		return None
+	if not isinstance(config["index"], dict):
+		raise ValueError(f"{filename}: expected \"index\": {{\"type\": \"postgis\", ...}}")
+	try:
+		return create_index(config["index"])
+	except ValueError as e:
+		raise ValueError(f"{filename}: {e}")
+
+
+def set_index(index):
+	"""Index messages in index from now on; None keeps an index in each mailbox folder."""
+	global _index
+	_index = index
+
+
+def open_index(folder):
+	"""The search index for messages saved in folder."""
+	return _index if _index is not None else SearchIndex(folder)
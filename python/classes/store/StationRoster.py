@@ -0,0 +1,153 @@
+#!/usr/bin/env python
+'''Who each station is: the operator, agency, capabilities, and phone net control keeps on its roster'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import csv
+import json
+import logging
+import os
+import threading
+from classes.forms import Redaction
+from classes.b2f.StationIdentity import parse_address
+
+ROSTER_FILE_NAME = "roster.csv"  # In the working directory; a .json file may be named instead
+# Roster columns and the feature properties they become.  Other columns are ignored.
+FIELDS = {"name": "operator", "agency": "agency", "capabilities": "capabilities", "phone": "phone"}
+CAPABILITY_SEPARATORS = (";", "|")  # Within the capabilities column; commas are left alone for CSV's sake
+
+_roster = None  # The Roster joined onto exported features; None leaves features as they are
+
+
+def _capabilities(value):
+	if isinstance(value, list):
+		return [str(v).strip() for v in value if str(v).strip()]
+	value = str(value or "")
+	for separator in CAPABILITY_SEPARATORS[1:]:
+		value = value.replace(separator, CAPABILITY_SEPARATORS[0])
+	return [v.strip() for v in value.split(CAPABILITY_SEPARATORS[0]) if v.strip()]
+
+
+def _entry(filename, callsign, row):
+	callsign, ssid = parse_address(callsign)
+	if not callsign:
+		raise ValueError(f"{filename}: a roster entry has no callsign")
+	entry = {}
+	for column, value in row.items():
+		column = str(column or "").strip().lower()
+		if column in FIELDS and value not in (None, "", []):
+			entry[FIELDS[column]] = _capabilities(value) if column == "capabilities" else str(value).strip()
+	return (callsign, ssid), entry
+
+
+def load_roster(filename):
+	"""{(callsign, SSID or None): {"operator", "agency", "capabilities", "phone"}} from a CSV file with a
+	callsign column, or a JSON file of {callsign: {"name", "agency", "capabilities", "phone"}}.  Raises
+	ValueError if the file is malformed."""
+	entries = {}
+	if filename.lower().endswith(".json"):
+		with open(filename, 'r', encoding='utf-8') as f:
+			try:
+				config = json.load(f)
+			except ValueError as e:
+				raise ValueError(f"{filename}: {e}")
+		if not isinstance(config, dict) or not all(isinstance(row, dict) for row in config.values()):
+			raise ValueError(f"{filename}: expected {{callsign: {{\"name\": ..., \"agency\": ...}}}}")
+		rows = config.items()
+	else:
+		with open(filename, 'r', encoding='utf-8-sig', newline='') as f:
+			reader = csv.DictReader(f)
+			columns = {str(c or "").strip().lower(): c for c in reader.fieldnames or []}
+			if "callsign" not in columns:
+				raise ValueError(f"{filename}: expected a callsign column")
+			rows = [(row[columns["callsign"]], row) for row in reader if any(v for v in row.values() if isinstance(v, str) and v.strip())]
+	for callsign, row in rows:
+		key, entry = _entry(filename, callsign, row)
+		if key in entries:
+			raise ValueError(f"{filename}: {key[0]}{'-' + key[1] if key[1] else ''} is listed twice")
+		entries[key] = entry
+	return entries
+
+
+class Roster:
+	"""A roster file, read again whenever it changes, so a long-running export picks up operators
+	added during the net."""
+
+	def __init__(self, filename, enable_debug=False):
+		self.filename = filename
+		self.enable_debug = enable_debug
+		self.lock = threading.Lock()
+		self.modified = None
+		self.stations = {}
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def load(self):
+		"""Read the file now.  Raises OSError or ValueError, keeping the stations already read."""
+		modified = os.path.getmtime(self.filename)
+		stations = load_roster(self.filename)
+		with self.lock:
+			self.stations, self.modified = stations, modified
+		self._log_debug(f"Read {len(stations)} stations from {self.filename}")
+		return stations
+
+	def _refresh(self):
+		"""Read the file again if it changed.  A file that can't be read is logged once and the stations
+		read before are kept."""
+		try:
+			modified = os.path.getmtime(self.filename)
+		except OSError:
+			return
+		if modified == self.modified:
+			return
+		try:
+			self.load()
+		except (OSError, ValueError) as e:
+			self.modified = modified
+			self.logger.error(f"Keeping the roster read before: {e}")
+
+	def lookup(self, address):
+		"""The roster entry for the station at address: its own SSID's entry if it has one, else its
+		callsign's.  None if the roster doesn't list it."""
+		callsign, ssid = parse_address(address)
+		self._refresh()
+		with self.lock:
+			return self.stations.get((callsign, ssid)) or self.stations.get((callsign, None))
+
+
+def set_roster(roster):
+	"""Join roster's entries onto exported features from now on; None leaves them out."""
+	global _roster
+	_roster = roster
+
+
+def current_roster():
+	return _roster
+
+
+def properties(address):
+	"""The feature properties the roster gives the station at address, empty if there is no roster or
+	it doesn't list the station.  Phone numbers are masked like any other, unless redaction is off."""
+	entry = _roster.lookup(address) if _roster is not None and address else None
+	if not entry:
+		return {}
+	joined = dict(entry)
+	if "phone" in joined:
+		joined["phone"] = Redaction.scrub_text(joined["phone"])
+	return joined
@@ -0,0 +1,319 @@
+#!/usr/bin/env python
+'''Simple Winlink Server that accepts incoming messages'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import os
+import datetime
+import json
+import logging
+import re
+import traceback
+from classes.b2f.B2Message import B2Message 
+from classes.store.ContentIndex import ContentIndex
+from classes.store.SearchIndex import open_index
+from classes.store.Quarantine import Quarantine, QUARANTINE_FOLDER_NAME
+from classes.b2f.PositionAttachment import PositionAttachment
+from classes.forms.WinlinkForm import WinlinkForm
+from classes.store import BlobStore
+from classes.server import Tracing
+from classes.b2f.WinlinkTime import utc_now
+
+MAILBOX_FOLDER_NAME = "mailbox"
+HEADERS_FILE_SUFFIX = "-headers.txt"
+SOURCE_FILE_SUFFIX = "-source.json"
+BLOBS_FILE_SUFFIX = "-blobs.json"  # Where attachments kept in a blob store went
+BULLETINS_FOLDER_NAME = "bulletins"  # Under the mailbox folder; catalog responses and bulletins go here
+
+# Characters that can't appear in a file name on at least one supported platform
+UNSAFE_FILENAME_CHARACTERS = re.compile(r'[<>:"/\\|?*\x00-\x1f]')
+# Device names Windows reserves, with any extension: CON.txt opens the console, not a file
+RESERVED_FILENAME = re.compile(r"^(?:CON|PRN|AUX|NUL|COM[0-9]|LPT[0-9]) *(?:\.|$)", re.IGNORECASE)
+
+
+def safe_filename(name):
+	"""Replace characters that are not allowed in Windows or POSIX file names."""
+	name = UNSAFE_FILENAME_CHARACTERS.sub("_", str(name)).strip(" .") or "_"
+	return f"_{name}" if RESERVED_FILENAME.match(name) else name
+
+
+class WinlinkMailMessage:
+	"""Class to represent a message with its metadata."""
+	
+	def __init__(self, message_type=None, message_id=None, uncompressed_size=None, compressed_size=None, enable_debug=False, folder=MAILBOX_FOLDER_NAME, source=None):
+		"""Initialize the message with the necessary instance variables."""
+		self.time_created = datetime.datetime.now()
+		self.enable_debug = enable_debug
+		self.message_type = message_type  # Type of the message (e.g., proposal, etc.)
+		self.message_id = message_id  # Unique identifier for the message
+		self.uncompressed_size = uncompressed_size  # Uncompressed size of the message
+		self.compressed_size = compressed_size  # Compressed size of the message
+		self.b2 = None
+		self.saved_files = []  # Files written by save_message_to_files()
+		self.journal_sequence = None  # Entry in the IngestJournal, once the raw transfer is recorded there
+		self.span = None  # Root of the message's trace through the pipeline, if tracing is on
+		self.queued_at = None  # Tracing.now() when it joined the ingest queue
+		self.blobs = {}  # File name -> location, for what went to a blob store rather than the mailbox folder
+		# Provenance, e.g. {"kind": "telnet", "gateway": "W6EI-10", "address": "10.1.2.3:51234",
+		# "sid": "WL2K-5.0-B2FWIHJM$", "path": "mesh"} or {"kind": "file", "file": "...", "path": "HF"}
+		self.source = source
+		self.folder = folder
+
+		if not os.path.exists(folder):
+			os.makedirs(folder)
+
+		julian_date = self.time_created.strftime("%Y%m%d%H%M%S")
+		self.filename = os.path.join(folder, safe_filename(f"{julian_date}-{self.message_id}"))
+
+		# Set up logging
+		self.logger = logging.getLogger(__name__)
+		self._setup_logging()
+
+	def _setup_logging(self):
+		"""Set up logging configuration."""
+		log_level = logging.DEBUG if self.enable_debug else logging.INFO
+		logging.basicConfig(level=log_level,
+							format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+		
+	def _log_debug(self, message):
+		"""Log debug messages if debugging is enabled."""
+		if self.enable_debug:
+			self.logger.debug(message)
+
+	def capture(self, raw_data) -> int:
+		"""Capture the raw data and decode it."""
+		# Record the raw data
+		self.b2 = B2Message(self.message_id, raw_data, self.uncompressed_size, self.compressed_size, enable_debug=self.enable_debug)
+		self.b2.source = self.source
+
+	# Returns the index of the next unprocessed byte in raw_data
+	def parse(self) -> int:
+		return self.b2.parse()  # Returns the index of the next unprocessed byte in raw_data
+		# self._log_debug(f"B2 subject: {self.b2.subject}")
+		# self._save_raw_data_to_file()
+
+	def decode(self):
+		"""Parse the captured transfer, catching whatever the parsers raise.  Returns (stage, error,
+		traceback) if the message could not be fully decoded, else None."""
+		try:
+			self.parse()
+			return self.b2.problem()
+		except Exception as e:
+			return "framing", f"{e.__class__.__name__}: {e}", traceback.format_exc()
+
+	def check_for_duplicate(self):
+		"""Flag the message if a message with different MID but the same content was already saved here."""
+		if self.b2 is None or self.b2.decompressed_data is None:
+			return None
+		try:
+			original = ContentIndex(self.folder).check_and_add(self.b2.content_hash(), self.b2.mid or self.message_id)
+		except (OSError, ValueError) as e:
+			self.logger.error(f"Error reading content index: {e}")
+			return None
+		if original is not None:
+			self.b2.duplicate_of = original
+			self.b2.warnings.append(f"Probable duplicate of {original}")
+			self.logger.warning(f"Message {self.message_id}: probable duplicate of {original}")
+		return original
+
+	def _route_bulletin(self):
+		"""Save bulletins and catalog responses apart from station traffic."""
+		if self.b2 is not None and self.b2.is_bulletin():
+			bulletins_folder = os.path.join(self.folder, BULLETINS_FOLDER_NAME)
+			if not os.path.exists(bulletins_folder):
+				os.makedirs(bulletins_folder)
+			self.filename = os.path.join(bulletins_folder, os.path.basename(self.filename))
+			self._log_debug(f"Routing message {self.message_id} to {bulletins_folder}")
+
+	def save_message_to_files(self):
+		"""Save the raw data and the decoded data to files."""
+		self._route_bulletin()
+		self.check_for_duplicate()
+		try:
+			self._save_headers_to_file()
+			self._save_source_to_file()
+			self._save_body_to_file()
+			self._save_attachments_to_files()
+			if BlobStore.store_raw():
+				self._save_raw_data_to_file()
+		except Exception as e:
+			self._log_debug(f"Error saving message to file: {e}")
+		self._index_message()
+
+	def quarantine(self, stage, error, trace=None):
+		"""Put the message in the mailbox's failed area instead of saving it.  Returns the diagnostics file."""
+		b2 = self.b2
+		details = {
+			"mid": self.message_id,
+			"type": self.message_type,
+			"size": self.uncompressed_size,
+			"compressed_size": self.compressed_size,
+			"received": self.time_created.isoformat(timespec="seconds"),
+			"source": self.source,
+			"subject": b2.subject if b2 is not None else None,
+			"sender": b2.sender if b2 is not None else None,
+			"warnings": list(b2.warnings) if b2 is not None else [],
+			"transforms": list(b2.transforms) if b2 is not None else [],
+		}
+		diagnostics = Quarantine(self.folder).add(os.path.basename(self.filename), b2.raw_data if b2 is not None else b"", stage, error, trace,
+			b2.decompressed_data if b2 is not None else None, details)
+		self.saved_files.append(diagnostics)
+		self.logger.error(f"Message {self.message_id} quarantined ({stage}: {error}); see {diagnostics}")
+		return diagnostics
+
+	def _index_message(self):
+		"""Add the saved message to the mailbox's full-text index, keyed by its headers file."""
+		if self.b2 is None or self.b2.headers is None:
+			return
+		key = os.path.relpath(f"{self.filename}{HEADERS_FILE_SUFFIX}", self.folder)
+		with Tracing.span("index") as span:
+			try:
+				open_index(self.folder).add(key, self.b2)
+				self._log_debug(f"Indexed {key}")
+			except Exception as e:
+				self.logger.error(f"Error indexing message {self.message_id}: {e}")
+				span.set_error(e)
+
+	def _blob_key(self, filename):
+		return os.path.relpath(filename, self.folder)
+
+	def _save_blob(self, filename, data, content_type="application/octet-stream"):
+		"""Put data in the blob store under the key for filename.  Returns where it went: filename itself
+		if the store is the mailbox folder, or if the store failed and the file was kept here instead."""
+		store = BlobStore.current_store(self.folder)
+		if not store.local:
+			try:
+				location = store.put(self._blob_key(filename), data, content_type)
+				self.blobs[os.path.basename(filename)] = location
+				return location
+			except Exception as e:
+				self.logger.error(f"Error saving {os.path.basename(filename)} to blob store {store.name}; keeping it in {self.folder}: {e}")
+		with open(filename, 'wb') as f:
+			f.write(data)
+		return filename
+
+	def _save_raw_data_to_file(self):
+		"""Save the raw data to a .b2f file."""
+		try:
+			raw_filename = f"{self.filename}.b2f"
+			raw_location = self._save_blob(raw_filename, self.b2.raw_data)
+			self.saved_files.append(raw_location)
+			self._log_debug(f"Raw data saved to {raw_location}")
+		except Exception as e:
+			self._log_debug(f"Error saving raw data: {e}")
+
+	def _save_headers_to_file(self):
+		"""Save the headers to a .txt file."""
+		if self.b2.headers is not None:
+			try:
+				headers_filename = f"{self.filename}{HEADERS_FILE_SUFFIX}"
+				with open(headers_filename, 'w', newline='') as f:  # Keep the \r\n line endings as received
+					f.write(self.b2.headers)
+				self.saved_files.append(headers_filename)
+				self._log_debug(f"Headers saved to {headers_filename}")
+			except Exception as e:
+				self._log_debug(f"Error saving headers: {e}")
+		else:
+			self._log_debug(f"Error saving headers: None")
+
+	def _save_source_to_file(self):
+		"""Save the provenance to a .json file next to the headers."""
+		if self.source is not None:
+			try:
+				source_filename = f"{self.filename}{SOURCE_FILE_SUFFIX}"
+				source = self.source
+				if self.b2.duplicate_of is not None:
+					source = dict(source, duplicate_of=self.b2.duplicate_of)  # So exports read from the mailbox leave it out too
+				with open(source_filename, 'w') as f:
+					json.dump(source, f, indent=4)
+				self.saved_files.append(source_filename)
+				self._log_debug(f"Source saved to {source_filename}")
+			except Exception as e:
+				self._log_debug(f"Error saving source: {e}")
+
+	def _save_body_to_file(self):
+		"""Save the body to a .txt file."""
+		if self.b2.body is not None:
+			try:
+				body_filename = f"{self.filename}-body.txt"
+				with open(body_filename, 'w', newline='') as f:
+					f.write(self.b2.body)
+				self.saved_files.append(body_filename)
+				self._log_debug(f"Body saved to {body_filename}")
+			except Exception as e:
+				self._log_debug(f"Error saving body: {e}")
+		else:
+			self._log_debug(f"Error saving body: None")
+
+	def _save_attachments_to_files(self):
+		"""Save any binary attachments to separate files, or to the blob store.  Forms and position
+		attachments always stay in the mailbox folder, where the map exports read them."""
+		try:
+			for attachment in self.b2.attachments:
+				if attachment.data is None:
+					self._log_debug(f"Attachment {attachment.filename} has no data")
+					continue
+				attachment_filename = f"{self.filename}-{safe_filename(attachment.filename)}"
+				if WinlinkForm.is_form_attachment(attachment.filename) or PositionAttachment.is_position_attachment(attachment.filename):
+					with open(attachment_filename, 'wb') as f:
+						f.write(attachment.data)
+					attachment_location = attachment_filename
+				else:
+					attachment_location = self._save_blob(attachment_filename, attachment.data)
+				self.saved_files.append(attachment_location)
+				self._log_debug(f"Attachment saved to {attachment_location}")
+		except Exception as e:
+			self._log_debug(f"Error saving attachments: {e}")
+		self._save_blobs_manifest()
+
+	def _save_blobs_manifest(self):
+		"""Record where the blobs kept outside the mailbox folder went, next to the headers."""
+		if not self.blobs:
+			return
+		try:
+			blobs_filename = f"{self.filename}{BLOBS_FILE_SUFFIX}"
+			with open(blobs_filename, 'w') as f:
+				json.dump({"store": BlobStore.current_store(self.folder).name, "blobs": self.blobs}, f, indent=4)
+			self.saved_files.append(blobs_filename)
+		except Exception as e:
+			self._log_debug(f"Error saving blob locations: {e}")
+
+
+def mailbox_mids(folder):
+	"""MID -> headers file for every message saved in a mailbox folder, leaving out quarantined ones."""
+	mids = {}
+	for path, subfolders, names in os.walk(folder):
+		subfolders[:] = sorted(s for s in subfolders if s != QUARANTINE_FOLDER_NAME)
+		for name in sorted(names):
+			if not name.lower().endswith(HEADERS_FILE_SUFFIX):
+				continue
+			filename = os.path.join(path, name)
+			with open(filename, 'r', newline='', errors='replace') as f:
+				for line in f:
+					if line.startswith("Mid: "):
+						mids[line[5:].strip()] = filename
+						break
+	return mids
+
+
+def reprocess_quarantined(quarantine, entry, folder=MAILBOX_FOLDER_NAME, enable_debug=False):
+	"""Decode a quarantined message again with the parsers as they are now.  If it works the message is
+	saved to the mailbox folder and leaves the quarantine; if not, the new failure is added to its diagnostics.
+	Returns the saved files, or None."""
+	message = WinlinkMailMessage(entry.get("type"), entry.get("mid"), entry.get("size"), entry.get("compressed_size"),
+		enable_debug=enable_debug, folder=folder, source=entry.get("source"))
+	message.capture(quarantine.raw(entry["name"]))
+	problem = message.decode()
+	if problem is None:
+		message.save_message_to_files()
+		quarantine.remove(entry["name"])
+		return message.saved_files
+	entry.setdefault("attempts", []).append({"at": utc_now().isoformat(timespec="seconds"), "stage": problem[0], "error": problem[1]})
+	entry["stage"], entry["error"], entry["traceback"] = problem
+	quarantine.update(entry)
+	return None
@@ -0,0 +1,2327 @@
+#!/usr/bin/env python
+'''Command line tools for working with captured B2 messages'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import argparse
+import collections
+import csv
+import datetime
+import hashlib
+import json
+import logging
+import os
+import sqlite3
+import sys
+import time
+from classes.b2f.B2Message import B2Message, transfer_length
+from classes.store.Quarantine import Quarantine, QUARANTINE_FOLDER_NAME
+from classes.store.WinlinkMailMessage import WinlinkMailMessage, HEADERS_FILE_SUFFIX, SOURCE_FILE_SUFFIX, BLOBS_FILE_SUFFIX, MAILBOX_FOLDER_NAME, safe_filename, reprocess_quarantined, mailbox_mids
+from classes.store.SearchIndex import open_index, load_index, set_index
+from classes.forms.FormViewer import FormViewer
+from classes.forms.WinlinkForm import find_position_variables
+from classes.geo import Geo
+from classes.geo.Elevation import ElevationModel
+from classes.geo import Elevation
+from classes.geo.Declination import MagneticModel
+from classes.b2f import WinlinkPrecedence
+from classes.export import MapExport
+from classes.store import OperationalPeriods
+from classes.export import PeriodReport
+from classes.export.StaticMap import StaticMap, check_zoom, parse_bbox, parse_size
+from classes.server.ExportScheduler import ExportScheduler, EXPORT_FORMATS
+from classes.server.Publisher import HttpPublisher
+from classes.forms import ExpressCsv
+from classes.b2f import OutboundMessage
+from classes.b2f.ExerciseTraffic import ExerciseTraffic
+from classes.server.IngestBenchmark import IngestBenchmark, prepare_messages, percentile, traced_stages
+from classes.b2f.WinlinkTime import check_timezone, parse_timestamp, utc_now
+from classes.export.MapExport import has_position
+from classes.geo import Units
+from classes.forms import ResourceRequest
+from classes.forms import ShelterStatus
+from classes.forms import Welfare
+from classes.b2f import DeliveryReceipts
+from classes.forms import Redaction
+from classes.b2f import MessageThreads
+from classes.b2f import ClockSkew
+from classes.store import ContentIndex
+from classes.b2f import StationIdentity
+from classes.store import StationRoster
+from classes.geo import GridDensity
+from classes.geo import PointDensity
+from classes.export import Translation
+from classes.server import Tracing
+from classes.store import PgWire
+from classes.store import BlobStore
+from classes.export import ExportBundle
+from classes.forms import TemplateVersions
+from classes.forms import TemplatePack
+from classes.geo import Annotations
+from classes.export import PrintLayout
+from classes.geo import WeatherHazards
+from classes.export import CommandDocs
+from classes.server import SelfTest
+from classes.server.AlertEngine import ALERTS_FILE_NAME
+from classes.server.EventPublisher import EVENTS_FILE_NAME
+from classes.b2f.P2PSession import P2PClient, SessionError
+from classes.b2f.Outbox import Outbox, OUTBOX_FOLDER_NAME
+from classes.forms.MappingConfig import MappingConfig, MAPPINGS, MAPPINGS_FILE_NAME, STYLES_FILE_NAME, load_config
+
+HEXDUMP_BYTES_DEFAULT = 256
+HEXDUMP_WIDTH = 16
+
+CAPTURE_FILE_EXTENSION = ".b2f"
+MANIFEST_FILE_NAME = "manifest.json"
+
+RINGS_KM_DEFAULT = "5,10,25,50,100"
+
+SEARCH_LIMIT_DEFAULT = 50
+MAP_SIZE_DEFAULT = "800x600"
+FEATURES_FILE_NAME = "features.ndjson"  # Appended to a features --url that ends in /
+GENERATE_COUNT_DEFAULT = 100
+GENERATE_BBOX_DEFAULT = "-122.20,37.38,-122.08,37.46"  # Palo Alto
+GENERATE_HOURS_DEFAULT = 4.0
+GENERATE_RECIPIENT_DEFAULT = "EOC"
+BENCH_PORT_DEFAULT = 8772  # main.py's listener
+BENCH_RATE_DEFAULT = 5.0
+BENCH_DURATION_DEFAULT = 30.0
+BENCH_CONCURRENCY_DEFAULT = 8
+BENCH_SETTLE_SECONDS = 5  # Stop waiting for saves after this long without one
+BENCH_POLL_SECONDS = 0.5
+BODY_FILE_SUFFIX = "-body.txt"  # As saved by WinlinkMailMessage
+
+BAUD_DEFAULT = 1200
+BITS_PER_BYTE_DEFAULT = 10  # 8 data bits plus start and stop bits on an async link
+
+# Exit codes.  When more than one failure class applies the highest code is returned.
+EXIT_OK = 0  # Everything processed cleanly
+EXIT_WARNINGS = 1  # Processed, but at least one message has validation warnings
+EXIT_USAGE = 2  # Bad command line (argparse uses the same code)
+EXIT_DECODE_ERROR = 3  # B2 framing was fine but a message could not be decompressed
+EXIT_PARSE_ERROR = 4  # A file contains malformed B2 data
+EXIT_IO_ERROR = 5  # A file could not be read or written
+
+
+def hexdump(data, limit):
+	"""Format the first limit bytes of data as offset, hex, and ASCII columns."""
+	lines = []
+	data = bytes(data[:limit])
+	for offset in range(0, len(data), HEXDUMP_WIDTH):
+		chunk = data[offset:offset + HEXDUMP_WIDTH]
+		hex_part = " ".join(f"{b:02x}" for b in chunk)
+		ascii_part = "".join(chr(b) if 0x20 <= b < 0x7F else "." for b in chunk)
+		lines.append(f"{offset:08x}  {hex_part:<{HEXDUMP_WIDTH * 3 - 1}}  |{ascii_part}|")
+	return "\n".join(lines)
+
+
+def read_messages(filename, enable_debug=False):
+	"""Parse every B2 message in a capture file.  Returns the messages and the errors, joined, if any.
+	A message that fails to parse is skipped when its framing shows where the next one starts."""
+	with open(filename, 'rb') as f:
+		raw_data = f.read()
+
+	messages = []
+	errors = []
+	index = 1
+	while len(raw_data) > 0:
+		message = B2Message(f"{index}", raw_data, None, None, enable_debug=enable_debug)
+		try:
+			next_index = message.parse()
+		except Exception as e:
+			errors.append(f"Message {index}: {e}")
+			try:
+				next_index = transfer_length(raw_data)
+			except ValueError:
+				break
+		else:
+			messages.append(message)
+			if message.parse_error is not None:
+				errors.append(f"Message {index}: parser failure in {message.parse_error['stage']}: {message.parse_error['error']}")
+		raw_data = raw_data[next_index:]
+		index += 1
+	return messages, "; ".join(errors) or None
+
+
+def capture_files(paths, suffixes=(CAPTURE_FILE_EXTENSION,)):
+	"""Expand directories into the files they contain ending in one of suffixes, in sorted order."""
+	for path in paths:
+		if os.path.isdir(path):
+			for folder, subfolders, names in os.walk(path):
+				# The failed area holds messages that could not be decoded; keep them out of results
+				subfolders[:] = sorted(s for s in subfolders if s != QUARANTINE_FOLDER_NAME)
+				for name in sorted(names):
+					if name.lower().endswith(suffixes):
+						yield os.path.join(folder, name)
+		else:
+			yield path
+
+
+def file_source(filename, path=None):
+	"""Provenance for messages read from a capture file."""
+	return {"kind": "file", "file": filename, "path": path}
+
+
+def read_headers_file(filename, enable_debug=False):
+	"""Rebuild a message, its body, form, and provenance if they were saved, from the headers file saved in a mailbox folder."""
+	with open(filename, 'r', newline='') as f:
+		headers = f.read()
+	message_id = os.path.basename(filename)[:-len(HEADERS_FILE_SUFFIX)]
+	message = B2Message(message_id, b"", None, None, enable_debug=enable_debug)
+	message.headers = headers
+	message.parse_headers(headers)
+	prefix = filename[:-len(HEADERS_FILE_SUFFIX)]
+	if os.path.exists(f"{prefix}{BODY_FILE_SUFFIX}"):
+		with open(f"{prefix}{BODY_FILE_SUFFIX}", 'r', newline='') as f:
+			message.body = f.read()
+	blobs = {}
+	if os.path.exists(prefix + BLOBS_FILE_SUFFIX):
+		with open(prefix + BLOBS_FILE_SUFFIX, 'r') as f:
+			blobs = json.load(f).get("blobs", {})
+	for attachment in message.attachments:
+		attachment_filename = f"{prefix}-{safe_filename(attachment.filename)}"
+		if os.path.exists(attachment_filename):
+			with open(attachment_filename, 'rb') as f:
+				attachment.data = f.read()
+		else:
+			# Attachments in a blob store aren't fetched; nothing here reads more than where they are
+			attachment.location = blobs.get(os.path.basename(attachment_filename))
+	if not message.is_bulletin():
+		message.extract_form()
+	source_filename = prefix + SOURCE_FILE_SUFFIX
+	if os.path.exists(source_filename):
+		with open(source_filename, 'r') as f:
+			message.source = json.load(f)
+		message.duplicate_of = message.source.get("duplicate_of")
+	return message
+
+
+def matches_source(message, path=None, gateway=None):
+	"""True if the message's provenance matches the given path and gateway (None matches anything)."""
+	source = message.source or {}
+	if path is not None and source.get("path") != path:
+		return False
+	if gateway is not None and (source.get("gateway") or "").upper() != gateway.upper():
+		return False
+	return True
+
+
+def summary_entry(message):
+	"""What Report.summary counts for a message: whether it decoded, its position, form type, and warnings."""
+	return {
+		"mid": message.mid,
+		"decoded": bool(message.headers),  # Decoded here, or by the server that saved it
+		"position": message.position if has_position(message) else None,
+		"form_type": message.form.form_type if message.form is not None else None,
+		"warnings": message.warnings,  # Not a copy, so warnings added once all files are read are counted
+	}
+
+
+def iter_messages(paths, report, enable_debug=False, source_path=None, gateway=None):
+	"""The messages in each capture file and mailbox headers file, one list per file as it is read,
+	recording files and errors in report.  Only messages whose provenance matches source_path and
+	gateway are returned.  Replies aren't linked and clocks aren't corrected; see load_messages()."""
+	for filename in capture_files(paths, (CAPTURE_FILE_EXTENSION, HEADERS_FILE_SUFFIX)):
+		if filename.endswith(CAPTURE_FILE_EXTENSION) and os.path.exists(filename[:-len(CAPTURE_FILE_EXTENSION)] + HEADERS_FILE_SUFFIX):
+			continue  # The raw transfer kept with a saved message; its headers file stands for it
+		entry = {"file": filename, "messages": [], "error": None}
+		report.files.append(entry)
+		try:
+			if filename.endswith(HEADERS_FILE_SUFFIX):
+				found, error = [read_headers_file(filename, enable_debug)], None
+			else:
+				found, error = read_messages(filename, enable_debug=enable_debug)
+				for message in found:
+					message.source = file_source(filename)
+		except (OSError, ValueError) as e:
+			entry["error"] = str(e)
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR)
+			continue
+		found = [m for m in found if matches_source(m, source_path, gateway)]
+		for message in found:
+			entry["messages"].append(dict(summary_entry(message), source=message.source))
+		if error is not None:
+			entry["error"] = error
+			report.error(f"{filename}: {error}")
+			report.fail(EXIT_PARSE_ERROR)
+		yield found
+
+
+def load_messages(paths, report, enable_debug=False, source_path=None, gateway=None):
+	"""Read messages from capture files and mailbox headers files, recording files and errors in report.
+	Only messages whose provenance matches source_path and gateway are returned, with replies linked
+	to the messages they answer and re-sent copies flagged as duplicates."""
+	messages = []
+	for found in iter_messages(paths, report, enable_debug, source_path, gateway):
+		messages.extend(found)
+	# Dates are corrected before anything is put in date order
+	ClockSkew.apply(messages)
+	ContentIndex.flag_duplicates(messages)
+	MessageThreads.link(messages)
+	return messages
+
+
+def sha256_file(filename):
+	"""SHA-256 of a file's contents as a hex string."""
+	digest = hashlib.sha256()
+	with open(filename, 'rb') as f:
+		for chunk in iter(lambda: f.read(65536), b""):
+			digest.update(chunk)
+	return digest.hexdigest()
+
+
+class Report:
+	"""Collects the results of a command for text or JSON output and picks the exit code."""
+
+	def __init__(self, command, args, reads_messages=True):
+		self.command = command
+		self.quiet = args.quiet
+		self.json = args.json
+		self.reads_messages = reads_messages  # False leaves the summary, which would be all zeros, out of the JSON
+		self.files = []
+		self.results = {}  # Command-specific results added to the JSON document
+		self.exit_code = EXIT_OK
+
+	def fail(self, exit_code):
+		"""Record a failure class; the most severe one wins."""
+		self.exit_code = max(self.exit_code, exit_code)
+
+	def say(self, text=""):
+		"""Print human-readable output unless --quiet or --json is in effect."""
+		if not self.quiet and not self.json:
+			print(text)
+
+	def error(self, text):
+		"""Report an error on stderr (even with --quiet; stdout stays clean for --json)."""
+		print(f"Error: {text}", file=sys.stderr)
+
+	def summary(self):
+		"""Counts across every file in the report."""
+		messages = [m for f in self.files for m in f["messages"]]
+		return {
+			"files_processed": len(self.files),
+			"messages": len(messages),
+			"decoded": sum(1 for m in messages if m.get("decoded")),
+			"positions": sum(1 for m in messages if m.get("position")),
+			"forms": sum(1 for m in messages if m.get("form_type")),
+			"warnings": sum(len(m.get("warnings", [])) for m in messages),
+			"errors": sum(1 for f in self.files if f["error"] is not None),
+		}
+
+	def finish(self):
+		"""Emit the JSON document if requested and return the exit code."""
+		if self.json and not self.quiet:
+			document = {"command": self.command, "exit_code": self.exit_code}
+			if self.reads_messages:
+				document["summary"] = self.summary()
+			document["files"] = self.files
+			document.update(self.results)
+			print(json.dumps(document, indent=4, default=str))
+		return self.exit_code
+
+
+def message_details(message, dump_bytes):
+	"""Collect the B2 structure of a parsed message."""
+	position = message.position
+	decoded = message.decompressed_data or b""
+	return {
+		"message_id": message.message_id,
+		"subject": message.subject,
+		"header_length": message.header_length,
+		"offset": message.offset,
+		"blocks": message.block_count,
+		"compressed_size": message.compressed_size,
+		"declared_decompressed_size": message.declared_decompressed_size,
+		"compression_ratio": message.compressed_size / message.decompressed_size if message.decompressed_size else 0.0,
+		"crc16": message.declared_crc,
+		"checksum": message.transmitted_checksum,
+		"decoded": bool(decoded),
+		"decoded_size": len(decoded),
+		"decompression_error": message.decompression_error,
+		"parse_error": message.parse_error,
+		"position": position if has_position(message) else None,
+		"positions": [{"role": role, "latitude": lat, "longitude": lon} for role, lat, lon in message.positions()],
+		"form_type": message.form.form_type if message.form is not None else None,
+		"template_version": TemplateVersions.declared_version(message.form) if message.form is not None else None,
+		"warnings": message.warnings,
+		"transforms": message.transforms,
+		"dump": bytes((decoded or message.compressed_data)[:dump_bytes]).hex(),
+	}
+
+
+def inspect_command(args):
+	"""Print the B2 structure of each message in one or more capture files."""
+	report = Report("inspect", args)
+	for filename in args.files:
+		try:
+			messages, error = read_messages(filename, enable_debug=args.debug)
+		except OSError as e:
+			report.files.append({"file": filename, "messages": [], "error": str(e)})
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR)
+			continue
+
+		details = [message_details(m, args.bytes) for m in messages]
+		report.files.append({"file": filename, "messages": details, "error": error})
+		if len(args.files) > 1:
+			report.say(f"File {filename}")
+		for message, detail in zip(messages, details):
+			report.say(f"Message {detail['message_id']}")
+			report.say(f"  Subject:             {detail['subject']}")
+			report.say(f"  Header length:       {detail['header_length']}")
+			report.say(f"  Offset:              {detail['offset']}")
+			report.say(f"  Blocks:              {detail['blocks']}")
+			report.say(f"  Compressed size:     {detail['compressed_size']}")
+			report.say(f"  Decompressed size:   {detail['declared_decompressed_size']} (declared)")
+			report.say(f"  Compression ratio:   {detail['compression_ratio']:.3f}")
+			report.say(f"  CRC-16:              0x{detail['crc16']:04X}")
+			report.say(f"  Checksum:            0x{detail['checksum']:02X}")
+			if detail["decoded"]:
+				report.say(f"  Decoded size:        {detail['decoded_size']}")
+				report.say(f"  First {min(args.bytes, detail['decoded_size'])} decoded bytes:")
+				report.say(hexdump(message.decompressed_data, args.bytes))
+			else:
+				report.say(f"  Decoding failed:     {detail['decompression_error']}")
+				report.say(f"  First {min(args.bytes, len(message.compressed_data))} compressed bytes:")
+				report.say(hexdump(message.compressed_data, args.bytes))
+				report.fail(EXIT_DECODE_ERROR)
+			if detail["transforms"]:
+				report.say(f"  Transforms applied:  {', '.join(detail['transforms'])}")
+			for warning in detail["warnings"]:
+				report.say(f"  Warning:             {warning}")
+			if detail["warnings"]:
+				report.fail(EXIT_WARNINGS)
+		if error is not None:
+			report.error(f"{filename}: {error}")
+			report.fail(EXIT_PARSE_ERROR)
+	return report.finish()
+
+
+def extract_command(args):
+	"""Decode capture files into headers, bodies, and attachments, and write a manifest of the run."""
+	report = Report("extract", args)
+	for filename in capture_files(args.paths):
+		entry = {"file": filename, "sha256": None, "messages": [], "error": None}
+		report.files.append(entry)
+		try:
+			entry["sha256"] = sha256_file(filename)
+			messages, error = read_messages(filename, enable_debug=args.debug)
+		except OSError as e:
+			entry["error"] = str(e)
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR)
+			continue
+
+		stem = os.path.splitext(os.path.basename(filename))[0]
+		for message in messages:
+			mid = message.mid or f"{stem}-{message.message_id}"
+			source = file_source(filename, args.path)
+			mail = WinlinkMailMessage(message_id=mid, enable_debug=args.debug, folder=args.output, source=source)
+			mail.b2 = message
+			message.source = source
+			if message.decompressed_data and message.decompression_error is None:
+				mail.save_message_to_files()
+				status = "decoded"
+			else:
+				report.fail(EXIT_DECODE_ERROR)
+				status = "failed"
+			if message.warnings:
+				report.fail(EXIT_WARNINGS)
+			entry["messages"].append({
+				"mid": message.mid,
+				"status": status,
+				"decoded": status == "decoded",
+				"decompression_error": message.decompression_error,
+				"position": message.position if has_position(message) else None,
+				"form_type": message.form.form_type if message.form is not None else None,
+				"bulletin": message.is_bulletin(),
+				"duplicate_of": message.duplicate_of,
+				"source": source,
+				"warnings": message.warnings,
+				"transforms": message.transforms,
+				"artifacts": mail.saved_files,
+			})
+			report.say(f"{filename}: {mid} {status}, {len(mail.saved_files)} files written")
+		if error is not None:
+			entry["error"] = error
+			report.error(f"{filename}: {error}")
+			report.fail(EXIT_PARSE_ERROR)
+
+	manifest_filename = args.manifest or os.path.join(args.output, MANIFEST_FILE_NAME)
+	try:
+		os.makedirs(os.path.dirname(manifest_filename) or ".", exist_ok=True)
+		with open(manifest_filename, 'w') as f:
+			json.dump({"summary": report.summary(), "files": report.files}, f, indent=4, default=str)
+		report.say(f"Manifest written to {manifest_filename}")
+	except OSError as e:
+		report.error(f"{manifest_filename}: {e}")
+		report.fail(EXIT_IO_ERROR)
+	return report.finish()
+
+
+def import_command(args):
+	"""Merge Winlink Express CSV exports into a mailbox, adding placeholders for messages we don't have."""
+	report = Report("import", args)
+	if args.timezone is not None:
+		try:
+			check_timezone(args.timezone)
+		except ValueError as e:
+			report.error(f"Bad --timezone: {e}")
+			report.fail(EXIT_USAGE)
+			return report.finish()
+	try:
+		known = mailbox_mids(args.mailbox) if os.path.isdir(args.mailbox) else {}
+	except OSError as e:
+		report.error(f"{args.mailbox}: {e}")
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	for filename in args.csv:
+		entry = {"file": filename, "messages": [], "error": None}
+		report.files.append(entry)
+		try:
+			rows = ExpressCsv.read_index(filename, args.timezone)
+		except (OSError, ValueError) as e:
+			entry["error"] = str(e)
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR if isinstance(e, OSError) else EXIT_PARSE_ERROR)
+			continue
+		merged = added = skipped = 0
+		for row in rows:
+			express = {k: v for k, v in row.items() if k not in ("mid", "date") and v}
+			if row["mid"] in known:
+				# Keep what the message itself says; add what only Winlink Express knows (folder, size, ...)
+				source_filename = known[row["mid"]][:-len(HEADERS_FILE_SUFFIX)] + SOURCE_FILE_SUFFIX
+				source = {}
+				try:
+					if os.path.exists(source_filename):
+						with open(source_filename, 'r') as f:
+							source = json.load(f)
+					source["express"] = express
+					with open(source_filename, 'w') as f:
+						json.dump(source, f, indent=4)
+				except (OSError, ValueError) as e:
+					report.error(f"{source_filename}: {e}")
+					report.fail(EXIT_IO_ERROR)
+					continue
+				merged += 1
+				entry["messages"].append({"mid": row["mid"], "status": "merged", "artifacts": [source_filename]})
+			elif row["date"] is None:
+				# A placeholder would be dated now, and sort and map as if it had just arrived
+				skipped += 1
+				entry["messages"].append({"mid": row["mid"], "status": "skipped", "warnings": ["No date in the CSV; no placeholder added"]})
+				report.fail(EXIT_WARNINGS)
+			else:
+				source = {"kind": "csv", "file": filename, "path": args.path, "placeholder": True, "express": express}
+				message = ExpressCsv.placeholder_message(row, args.debug)
+				message.source = source
+				mail = WinlinkMailMessage(message_id=row["mid"], enable_debug=args.debug, folder=args.mailbox, source=source)
+				mail.b2 = message
+				mail.save_message_to_files()
+				known[row["mid"]] = f"{mail.filename}{HEADERS_FILE_SUFFIX}"
+				added += 1
+				entry["messages"].append({"mid": row["mid"], "status": "placeholder", "artifacts": mail.saved_files})
+		report.say(f"{filename}: {len(rows)} messages, {merged} merged, {added} placeholders added"
+			+ (f", {skipped} without a date skipped" if skipped else ""))
+	return report.finish()
+
+
+def quarantine_command(args):
+	"""List, show, reprocess, or discard the messages in a mailbox's failed area."""
+	report = Report("quarantine", args, reads_messages=False)
+	quarantine = Quarantine(args.mailbox)
+	try:
+		if args.names:
+			entries = []
+			for key in args.names:
+				entry = quarantine.find(key)
+				if entry is None:
+					raise ValueError(f"No quarantined message {key} in {quarantine.folder}")
+				entries.append(entry)
+		elif args.action in ("list", "reprocess"):
+			entries = quarantine.entries()
+		else:
+			raise ValueError(f"quarantine {args.action} needs the names or MIDs of the messages")
+	except (OSError, ValueError) as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	if args.action == "list":
+		for entry in entries:
+			tries = len(entry.get("attempts", []))
+			report.say(f"{entry['name']:36} {entry.get('stage', ''):24} {str(entry.get('error'))[:60]}" + (f"  (retried {tries}x)" if tries else ""))
+		report.say(f"{len(entries)} quarantined messages in {quarantine.folder}")
+		report.results = {"quarantined": entries}
+	elif args.action == "show":
+		for entry in entries:
+			report.say(json.dumps({k: v for k, v in entry.items() if k != "traceback"}, indent=4, default=str))
+			if entry.get("traceback"):
+				report.say(entry["traceback"].rstrip())
+			decoded = quarantine.decoded(entry["name"])
+			if decoded is not None and not args.raw:
+				report.say(f"--- Decoded message, {len(decoded)} bytes ---")
+				report.say(decoded.decode("utf-8", errors="replace").replace("\r\n", "\n"))
+			else:
+				raw = quarantine.raw(entry["name"])
+				report.say(f"--- Raw transfer, {len(raw)} bytes ---")
+				report.say(hexdump(raw, args.bytes))
+			entry["decoded"] = decoded.decode("utf-8", errors="replace") if decoded is not None else None
+		report.results = {"quarantined": entries}
+	elif args.action == "reprocess":
+		results = []
+		for entry in entries:
+			try:
+				saved = reprocess_quarantined(quarantine, entry, args.mailbox, args.debug)
+			except OSError as e:
+				report.error(f"{entry['name']}: {e}")
+				report.fail(EXIT_IO_ERROR)
+				continue
+			results.append({"name": entry["name"], "mid": entry.get("mid"), "saved": saved is not None, "artifacts": saved or [], "error": None if saved is not None else entry["error"]})
+			report.say(f"{entry['name']}: " + ("saved to the mailbox" if saved is not None else f"still failing in {entry['stage']}: {entry['error']}"))
+		recovered = sum(1 for r in results if r["saved"])
+		report.say(f"Recovered {recovered} of {len(results)} quarantined messages")
+		if recovered < len(results):
+			report.fail(EXIT_DECODE_ERROR)
+		report.results = {"reprocessed": results}
+	elif args.action == "discard":
+		for entry in entries:
+			quarantine.remove(entry["name"])
+			report.say(f"Discarded {entry['name']}")
+		report.results = {"discarded": [entry["name"] for entry in entries]}
+	return report.finish()
+
+
+def checkin_command(args):
+	"""Write a Winlink Check-In form message as a compressed B2 transfer, or as plain message text."""
+	report = Report("checkin", args, reads_messages=False)
+	lat = lon = None
+	if args.position:
+		try:
+			lat, lon = Geo.parse_lat_lon(args.position)
+		except ValueError as e:
+			report.error(f"Bad --position: {e}")
+			report.fail(EXIT_USAGE)
+			return report.finish()
+
+	callsign = args.callsign.upper()
+	when = OutboundMessage.utc_now()
+	mid = OutboundMessage.generate_mid()
+	form = OutboundMessage.check_in_form(callsign, lat, lon, args.comments, args.location, args.setting, args.organization, when)
+	body = OutboundMessage.check_in_body(callsign, lat, lon, args.comments, args.location, args.setting, when)
+	text = OutboundMessage.message_text(mid, callsign, [t.upper() for t in args.to], OutboundMessage.CHECK_IN_SUBJECT, body,
+		[form], when, (lat, lon) if lat is not None else None)
+	if args.plain:
+		data, compressed_size = text, None
+	else:
+		data, compressed = OutboundMessage.transfer(OutboundMessage.CHECK_IN_SUBJECT, text)
+		compressed_size = len(compressed)
+	output = args.output or f"{mid}{CAPTURE_FILE_EXTENSION}"
+	try:
+		with open(output, 'wb') as f:
+			f.write(data)
+	except OSError as e:
+		report.error(f"{output}: {e}")
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	proposal = OutboundMessage.proposal(mid, len(text), compressed_size) if compressed_size is not None else None
+	report.results = {"checkin": {"mid": mid, "file": output, "size": len(text), "compressed_size": compressed_size, "proposal": proposal}}
+	report.say(f"Wrote {output}: {mid}, {len(text)} bytes" + (f", {compressed_size} compressed ({proposal})" if proposal else ""))
+	return report.finish()
+
+
+def generate_command(args):
+	"""Write synthetic exercise traffic as compressed capture files."""
+	report = Report("generate", args, reads_messages=False)
+	try:
+		bbox = parse_bbox(args.bbox)
+		end = parse_timestamp(args.end) if args.end else utc_now()
+		if end is None:
+			raise ValueError(f"Unrecognized --end <{args.end}>")
+		start = end - datetime.timedelta(hours=args.hours)
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	os.makedirs(args.output, exist_ok=True)
+	traffic = ExerciseTraffic(bbox, start, end, [r.upper() for r in args.to or [GENERATE_RECIPIENT_DEFAULT]], args.seed)
+	written = []
+	for mid, subject, text in traffic.messages(args.count):
+		data, compressed = OutboundMessage.transfer(subject, text)
+		filename = os.path.join(args.output, f"{mid}{CAPTURE_FILE_EXTENSION}")
+		try:
+			with open(filename, 'wb') as f:
+				f.write(data)
+		except OSError as e:
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR)
+			break
+		written.append({"mid": mid, "file": filename, "size": len(text), "compressed_size": len(compressed)})
+	report.results = {"generated": written}
+	report.say(f"Wrote {len(written)} messages to {args.output}, {start:%Y-%m-%d %H:%M} to {end:%Y-%m-%d %H:%M} UTC")
+	return report.finish()
+
+
+def bench_command(args):
+	"""Drive a running server with exercise traffic at a set rate and report throughput and time to acknowledge."""
+	report = Report("bench", args, reads_messages=False)
+	host, _, port = args.server.rpartition(":") if ":" in args.server else (args.server, "", str(BENCH_PORT_DEFAULT))
+	try:
+		port = int(port)
+		bbox = parse_bbox(args.bbox)
+		if args.rate <= 0 or args.duration <= 0 or args.batch < 1 or args.concurrency < 1:
+			raise ValueError("--rate and --duration must be positive, --batch and --concurrency at least 1")
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	count = max(1, round(args.rate * args.duration))
+	end = utc_now()
+	traffic = ExerciseTraffic(bbox, end - datetime.timedelta(hours=GENERATE_HOURS_DEFAULT), end, [GENERATE_RECIPIENT_DEFAULT], args.seed)
+	report.say(f"Preparing {count} messages...")
+	messages = prepare_messages(traffic, count)
+	report.say(f"Sending {args.rate:g} messages/s to {host}:{port} for {args.duration:g} s, {args.batch} per session, up to {args.concurrency} sessions at once")
+	benchmark = IngestBenchmark(host, port, args.rate, args.batch, args.concurrency, args.callsign.upper(), enable_debug=args.debug)
+	results = benchmark.run(messages)
+	if args.mailbox:
+		# Only messages that decoded have a headers file, so this counts what the server parsed.  The
+		# server saves after FF, from its queue, so wait until the count stops growing.
+		accepted, saved, last_change = set(benchmark.accepted_mids), 0, time.monotonic()
+		while saved < len(accepted) and time.monotonic() - last_change < BENCH_SETTLE_SECONDS:
+			time.sleep(BENCH_POLL_SECONDS)
+			count_now = len(accepted & set(mailbox_mids(args.mailbox)))
+			if count_now > saved:
+				saved, last_change = count_now, time.monotonic()
+		results["messages_saved"] = saved
+	if args.traces:
+		# The server exports spans in batches, so wait out a flush before giving up on the rest
+		accepted, traced, last_change = benchmark.accepted_mids, 0, time.monotonic()
+		while traced < len(accepted) and time.monotonic() - last_change < BENCH_SETTLE_SECONDS + Tracing.FLUSH_SECONDS_DEFAULT:
+			time.sleep(BENCH_POLL_SECONDS)
+			try:
+				count_now = traced_stages(Tracing.read_spans(args.traces), accepted)[1]
+			except (OSError, ValueError):
+				continue  # Not written yet, or a line still being written
+			if count_now > traced:
+				traced, last_change = count_now, time.monotonic()
+		try:
+			results["stages"], results["messages_traced"] = traced_stages(Tracing.read_spans(args.traces), accepted)
+		except (OSError, ValueError) as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR if isinstance(e, OSError) else EXIT_PARSE_ERROR)
+
+	acknowledgement = results["acknowledgement"]
+	report.say(f"Accepted {results['messages_accepted']} of {count} messages at {results['achieved_rate']:g}/s ({'sustained' if results['sustained'] else 'NOT sustained'})")
+	if results["messages_deferred"]:
+		report.say(f"Deferred by the server: {results['messages_deferred']}")
+	if acknowledgement["p50_ms"] is not None:
+		report.say(f"Acknowledgement, end of transfer to FF: p50 {acknowledgement['p50_ms']} ms, p90 {acknowledgement['p90_ms']} ms, "
+			f"p99 {acknowledgement['p99_ms']} ms, max {acknowledgement['max_ms']} ms")
+	report.say(f"Schedule lag: p99 {results['schedule_lag']['p99_ms']} ms")
+	if "messages_saved" in results:
+		report.say(f"Saved in {args.mailbox}: {results['messages_saved']}")
+	if "stages" in results:
+		report.say(f"Traced in {args.traces}: {results['messages_traced']}")
+		for stage, summary in results["stages"].items():
+			if summary["p50_ms"] is not None:
+				report.say(f"Server {stage}: p50 {summary['p50_ms']} ms, p99 {summary['p99_ms']} ms")
+	for error, times in results["errors"].items():
+		report.error(f"{times} sessions: {error}")
+	if args.output:
+		try:
+			with open(args.output, 'w') as f:
+				json.dump(results, f, indent=4)
+		except OSError as e:
+			report.error(f"{args.output}: {e}")
+			report.fail(EXIT_IO_ERROR)
+	report.results = {"benchmark": results}
+	if not results["messages_accepted"]:
+		report.fail(EXIT_IO_ERROR)
+	elif not results["sustained"] or results.get("messages_saved", results["messages_accepted"]) < results["messages_accepted"]:
+		report.fail(EXIT_WARNINGS)
+	return report.finish()
+
+
+def connect_command(args):
+	"""Exchange traffic with another station in a P2P session: send it the outbox messages addressed to it
+	and save what it sends back."""
+	report = Report("connect", args, reads_messages=False)
+	host, _, port = args.station.rpartition(":") if ":" in args.station else (args.station, "", str(BENCH_PORT_DEFAULT))
+	try:
+		port = int(port)
+	except ValueError:
+		report.error(f"Bad port in {args.station}")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	client = P2PClient(host, port, args.callsign, args.password, Outbox(args.outbox, enable_debug=args.debug), args.mailbox, args.peer,
+		args.path, enable_debug=args.debug)
+	peer = None
+	try:
+		peer = client.exchange()
+	except (OSError, SessionError) as e:
+		report.error(f"Session with {host}:{port} failed: {e}")
+		report.fail(EXIT_IO_ERROR)
+
+	received = [{
+		"mid": m.message_id,
+		"sender": m.b2.sender if m.b2 is not None else None,
+		"subject": m.b2.subject if m.b2 is not None else None,
+		"saved": any(f.endswith(HEADERS_FILE_SUFFIX) for f in m.saved_files),
+	} for m in client.received]
+	report.results = {"session": {"peer": peer or args.peer, "sid": client.peer_sid, "sent": client.sent, "deferred": client.deferred, "received": received, "refused": client.refused}}
+	for mid in client.sent:
+		report.say(f"Sent {mid}")
+	for mid in client.deferred:
+		report.say(f"Not taken by {peer or 'the peer'}, still in {args.outbox}: {mid}")
+	for entry in received:
+		report.say(f"Received {entry['mid']} from {entry['sender']}: {entry['subject']}" + ("" if entry["saved"] else f" (quarantined in {args.mailbox})"))
+	for mid in client.refused:
+		report.say(f"Already in {args.mailbox}, refused: {mid}")
+	report.say()
+	report.say(f"Session with {peer or host}: {len(client.sent)} sent, {len(received)} received")
+	if any(not entry["saved"] for entry in received):
+		report.fail(EXIT_WARNINGS)
+	return report.finish()
+
+
+def station_positions(messages):
+	"""[(Station, its most recent message with a position)] for each station, as finely as --stations tells them apart."""
+	latest = {}
+	for message in messages:
+		station = StationIdentity.identify(message)
+		if not has_position(message) or station is None:
+			continue
+		if station not in latest or message.date > latest[station][1].date:
+			latest[station] = (station, message)
+	return [latest[station] for station in sorted(latest, key=lambda s: s.key)]
+
+
+def map_command(args):
+	"""Render the located messages as a PNG map over local tiles."""
+	report = Report("map", args)
+	try:
+		width, height = parse_size(args.size)
+		bbox = parse_bbox(args.bbox) if args.bbox else None
+		if args.zoom is not None:
+			check_zoom(args.zoom)
+	except ValueError as e:
+		report.error(f"Bad --size, --bbox, or --zoom: {e}")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	messages = load_messages(args.paths, report, args.debug, args.source_path, args.gateway)
+	features = WeatherHazards.features(messages) + [f for m in MapExport.ordered(messages) for f in MapExport.message_features(m)] + Annotations.features()
+	raster = StaticMap(args.tiles, enable_debug=args.debug).render(features, width, height, bbox, args.zoom)
+	try:
+		with open(args.output, 'wb') as f:
+			f.write(raster.to_png())
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	report.results = {"map": {"file": args.output, "width": width, "height": height, "features": len(features)}}
+	report.say(f"Wrote {args.output}: {width}x{height}, {len(features)} features")
+	return report.finish()
+
+
+def grid_command(args):
+	"""Count the located messages in each Maidenhead grid square and write them as a polygon layer."""
+	report = Report("grid", args)
+	messages = load_messages(args.paths, report, args.debug, args.source_path, args.gateway)
+	features = GridDensity.grid_features(messages, args.precision)
+	try:
+		MapExport.write_geojson(args.output, MapExport.feature_collection(features, Translation.tr("Grid density")))
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	rows = [{k: v for k, v in f["properties"].items() if k in ("locator", "reports", "stations", "first_date", "last_date")} for f in features]
+	report.results = {"grid": {"file": args.output, "precision": args.precision, "squares": rows}}
+	for row in rows[:args.top]:
+		report.say(f"{row['locator']:<9} {row['reports']:>6} reports  {row['stations']:>5} stations  {row['first_date'][:16]} - {row['last_date'][:16]}")
+	if len(rows) > args.top:
+		report.say(f"... and {len(rows) - args.top} more")
+	report.say()
+	report.say(f"Wrote {args.output}: {len(rows)} squares, {sum(r['reports'] for r in rows)} reports")
+	return report.finish()
+
+
+def density_command(args):
+	"""Write heatmap points and precomputed marker clusters for browser maps of many reports."""
+	report = Report("density", args)
+	if not args.heat and not args.clusters:
+		report.error("Give --heat, --clusters, or both")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	try:
+		zooms = PointDensity.parse_zooms(args.zooms)
+		if args.radius <= 0:
+			raise ValueError("the radius must be greater than 0")
+	except ValueError as e:
+		report.error(f"Bad --zooms or --radius: {e}")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	messages = load_messages(args.paths, report, args.debug, args.source_path, args.gateway)
+	report.results = {"density": {}}
+	try:
+		if args.heat:
+			heat = PointDensity.heat_points(messages, args.weight)
+			with open(args.heat, 'w') as f:
+				json.dump(heat, f)
+			report.results["density"]["heat"] = {"file": args.heat, "points": len(heat["points"]), "max": heat["max"]}
+			report.say(f"Wrote {args.heat}: {len(heat['points'])} heat points, max {heat['max']}")
+		if args.clusters:
+			features = PointDensity.cluster_features(messages, zooms, args.radius)
+			MapExport.write_geojson(args.clusters, MapExport.feature_collection(features, Translation.tr("Clusters")))
+			counts = {zoom: sum(1 for f in features if f["properties"]["zoom"] == zoom) for zoom in zooms}
+			report.results["density"]["clusters"] = {"file": args.clusters, "radius": args.radius, "zooms": counts}
+			report.say(f"Wrote {args.clusters}: {len(features)} clusters over zooms {zooms.start}-{zooms.stop - 1}")
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+	return report.finish()
+
+
+def features_command(args):
+	"""Write each located message's features as a line of GeoJSON as soon as its file is read."""
+	report = Report("features", args)
+	output = args.output or (None if args.url else "-")
+	if output == "-" and args.json:
+		report.error("--json can't be used while the features go to stdout")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	if output == "-":
+		report.quiet = True  # stdout carries the features
+	counts = {"messages": 0, "features": 0}
+
+	def lines(sink):
+		if args.sorted:
+			# Everything is read first, so replies are linked and clocks corrected as in the other exports
+			batches = [MapExport.ordered(load_messages(args.paths, report, args.debug, args.source_path, args.gateway))]
+		else:
+			batches = iter_messages(args.paths, report, args.debug, args.source_path, args.gateway)
+		bulletins = []
+		for found in batches:
+			bulletins.extend(m for m in found if m.is_bulletin())
+			for message in found:
+				counts["messages"] += 1
+				for feature in MapExport.message_features(message):
+					counts["features"] += 1
+					line = (json.dumps(feature) + "\n").encode("utf-8")
+					if sink is not None:
+						sink.write(line)
+						sink.flush()
+					yield line
+		# The hazards from weather bulletins, and net control's notes, lines, and areas, follow the traffic
+		for feature in WeatherHazards.features(bulletins) + Annotations.features():
+			counts["features"] += 1
+			line = (json.dumps(feature) + "\n").encode("utf-8")
+			if sink is not None:
+				sink.write(line)
+				sink.flush()
+			yield line
+
+	try:
+		if output == "-":
+			sink = sys.stdout.buffer
+		elif output is not None:
+			sink = open(output, 'wb')
+		else:
+			sink = None
+		try:
+			if args.url:
+				publisher = HttpPublisher("features", args.url, args.method, enable_debug=args.debug)
+				publisher.stream(os.path.basename(output) if output not in (None, "-") else FEATURES_FILE_NAME, lines(sink), EXPORT_FORMATS["ndjson"])
+			else:
+				for _ in lines(sink):
+					pass
+		finally:
+			if sink is not None and sink is not sys.stdout.buffer:
+				sink.close()
+	except BrokenPipeError:
+		# The reader stopped early, as head does; point stdout at nothing so exiting doesn't complain
+		os.dup2(os.open(os.devnull, os.O_WRONLY), sys.stdout.fileno())
+	except (OSError, ValueError) as e:
+		report.error(f"{args.url or output}: {e}")
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	report.results = {"features": dict(counts, file=output, url=args.url)}
+	report.say(f"Wrote {counts['features']} features from {counts['messages']} messages to {', '.join(d for d in (output, args.url) if d)}")
+	return report.finish()
+
+
+def bundle_command(args):
+	"""Write one ZIP holding everything for a time range: map layers, a message log, the ICS-309, and attachments."""
+	report = Report("bundle", args)
+	try:
+		start = parse_timestamp(args.start) if args.start else None
+		end = parse_timestamp(args.end) if args.end else None
+		if (args.start and start is None) or (args.end and end is None):
+			raise ValueError(f"Unrecognized time <{args.start if args.start and start is None else args.end}>")
+		if start is not None and end is not None and end <= start:
+			raise ValueError("--end must be after --start")
+		if args.output == "-" and args.json:
+			raise ValueError("--json can't be used while the bundle goes to stdout")
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	if args.output == "-":
+		report.quiet = True  # stdout carries the ZIP
+
+	messages = ExportBundle.select(load_messages(args.paths, report, args.debug, args.source_path, args.gateway), start, end)
+	static_map = StaticMap(args.tiles, enable_debug=args.debug) if args.tiles else None
+	try:
+		manifest = ExportBundle.write_bundle(sys.stdout.buffer if args.output == "-" else args.output, messages, args.name, start, end,
+			args.incident, args.operator, args.net, static_map)
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	report.results = {"bundle": dict(manifest, file=args.output)}
+	for entry in manifest["withheld"]:
+		report.say(f"Left out {entry['mid']} {entry['file']}: {entry['reason']}")
+	if manifest["withheld"]:
+		report.say()
+	report.say(f"Wrote {args.output}: {manifest['messages']} messages, {manifest['features']} features, {len(manifest['attachments'])} attachments ({manifest['start'][:16]} to {manifest['end'][:16]})")
+	return report.finish()
+
+
+def print_command(args):
+	"""Lay the located messages and annotations out on one page for printing: title, legend, scale bar, and title block."""
+	report = Report("print", args)
+	try:
+		bbox = parse_bbox(args.bbox) if args.bbox else None
+		if args.zoom is not None:
+			check_zoom(args.zoom)
+		start = parse_timestamp(args.start) if args.start else None
+		end = parse_timestamp(args.end) if args.end else None
+		if (args.start and start is None) or (args.end and end is None):
+			raise ValueError(f"Unrecognized time <{args.start if args.start and start is None else args.end}>")
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	messages = ExportBundle.select(load_messages(args.paths, report, args.debug, args.source_path, args.gateway), start, end)
+	features = WeatherHazards.features(messages, end) + [f for m in messages for f in MapExport.message_features(m)] + Annotations.features()
+	title = args.title or Translation.tr("Situation map")
+	subtitle = None
+	if messages:
+		subtitle = Translation.tr("{start} to {end}", start=PrintLayout.local_time(start or messages[0].date), end=PrintLayout.local_time(end or messages[-1].date))
+	pdf = PrintLayout.print_map(features, title, args.paper, args.orientation, subtitle, args.incident, args.operator,
+		StaticMap(args.tiles, enable_debug=args.debug), bbox, args.zoom)
+	try:
+		pdf.save(args.output)
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	report.results = {"print": {"file": args.output, "paper": args.paper, "orientation": args.orientation, "messages": len(messages), "features": len(features)}}
+	report.say(f"Wrote {args.output}: {args.paper} {args.orientation}, {len(messages)} messages, {len(features)} features")
+	return report.finish()
+
+
+def schedule_command(args):
+	"""Run the export jobs in a config file as they come due."""
+	report = Report("schedule", args)
+
+	def load(paths, source_path, gateway):
+		return load_messages(paths, Report("schedule", args), args.debug, source_path, gateway)
+
+	try:
+		scheduler = ExportScheduler.from_file(args.config, load, args.paths or [MAILBOX_FOLDER_NAME], args.incremental, enable_debug=args.debug)
+	except (OSError, ValueError) as e:
+		report.error(f"{args.config}: {e}")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	if args.once:
+		results = scheduler.run_jobs(scheduler.jobs)
+		for name, delivered in results.items():
+			report.say(f"{name}: {'done' if delivered else 'FAILED'}")
+			if not delivered:
+				report.fail(EXIT_IO_ERROR)
+		report.results = {"jobs": results}
+		return report.finish()
+
+	report.say(f"Running {len(scheduler.jobs)} export jobs from {args.config}; interrupt to stop")
+	# Jobs read the messages afresh each time, so edits to the mappings and styles show in the next export
+	args.mapping_config.watch()
+	try:
+		scheduler.run_forever()
+	except KeyboardInterrupt:
+		pass
+	finally:
+		args.mapping_config.stop()
+	return report.finish()
+
+
+def clock_command(args):
+	"""How far each station's clock appears to be off, judged by when the server received its messages."""
+	report = Report("clock", args)
+	messages = load_messages(args.paths, report, args.debug, args.source_path, args.gateway)
+	skews = ClockSkew.station_offsets(messages)
+	stations = {}
+	for message in messages:
+		station = (message.sender or "").upper().split("-")[0]
+		if not station:
+			continue
+		row = stations.setdefault(station, {"station": station, "messages": 0, "received": 0, "offset_seconds": None, "status": "ok", "delays": []})
+		row["messages"] += 1
+		received = ClockSkew.received_time(message)
+		if received is not None:
+			row["received"] += 1
+			row["delays"].append((received - ClockSkew.sent_time(message)).total_seconds())
+	for station, row in stations.items():
+		delays = row.pop("delays")
+		# Receive time minus the date sent, by the station's clock; negative when its clock is ahead
+		row["delay_seconds"] = {"min": min(delays), "max": max(delays)} if delays else None
+		if station in skews:
+			row["offset_seconds"] = skews[station].total_seconds()
+			row["status"] = ClockSkew.describe(skews[station])
+		elif not delays:
+			row["status"] = "no receive times"
+
+	rows = sorted(stations.values(), key=lambda r: r["station"])
+	report.results = {"stations": rows, "threshold_seconds": ClockSkew.SKEW_THRESHOLD.total_seconds()}
+	report.say(f"{'Station':<10} {'Messages':>8} {'Received':>8}  Clock")
+	for row in rows:
+		report.say(f"{row['station']:<10} {row['messages']:>8} {row['received']:>8}  {row['status']}")
+	report.say()
+	report.say(f"Stations with clocks off: {len(skews)}")
+	if skews:
+		report.fail(EXIT_WARNINGS)
+	return report.finish()
+
+
+def report_command(args):
+	"""Distance and bearing from a reference point to each station, with a ring/sector coverage summary."""
+	report = Report("report", args)
+	try:
+		reference_lat, reference_lon = Geo.parse_lat_lon(args.reference)
+		rings = sorted(float(r) for r in args.rings.split(","))
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	declination = args.declination
+	if args.wmm:
+		try:
+			declination = MagneticModel(args.wmm, enable_debug=args.debug).declination(reference_lat, reference_lon)
+		except (OSError, ValueError) as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR)
+			return report.finish()
+
+	dem = Elevation.current_model()  # From --dem
+	stations = []
+	for station, message in station_positions(load_messages(args.paths, report, args.debug, args.source_path, args.gateway)):
+		source = message.source or {}
+		lat, lon = message.position["latitude"], message.position["longitude"]
+		distance = Geo.distance_km(reference_lat, reference_lon, lat, lon)
+		bearing = Geo.bearing_degrees(reference_lat, reference_lon, lat, lon)
+		ring = next((r for r in rings if distance <= r), None)
+		stations.append({
+			"station": station.label,
+			"callsign": message.sender,
+			"ssid": station.ssid,
+			"mid": message.mid,
+			"date": message.date.isoformat(),
+			"latitude": lat,
+			"longitude": lon,
+			"distance_km": round(distance, 3),
+			"distance_mi": round(distance / Geo.KM_PER_MILE, 3),
+			"bearing_deg": round(bearing, 1),
+			"sector": Geo.sector_name(bearing),
+			"ring_km": ring,
+			"source_path": source.get("path"),
+			"gateway": source.get("gateway"),
+			"precedence": message.precedence,
+			"severity": message.severity,
+			"urgency": message.urgency(),
+		})
+		if declination is not None:
+			stations[-1]["bearing_mag_deg"] = round(Geo.magnetic_bearing(bearing, declination), 1)
+		if dem is not None:
+			elevation = dem.elevation(lat, lon)
+			stations[-1]["elevation_m"] = round(elevation, 1) if elevation is not None else None
+
+	ring_counts = {r: sum(1 for s in stations if s["ring_km"] == r) for r in rings}
+	beyond = sum(1 for s in stations if s["ring_km"] is None)
+	sector_counts = {name: sum(1 for s in stations if s["sector"] == name) for name in Geo.SECTOR_NAMES_8}
+
+	try:
+		if args.csv:
+			with open(args.csv, 'w', newline='') as f:
+				fieldnames = ["station", "callsign", "ssid", "mid", "date", "latitude", "longitude", "distance_km", "distance_mi", "bearing_deg", "sector", "ring_km", "source_path", "gateway", "precedence", "severity", "urgency"]
+				if declination is not None:
+					fieldnames.append("bearing_mag_deg")
+				if dem is not None:
+					fieldnames.append("elevation_m")
+				writer = csv.DictWriter(f, fieldnames=fieldnames)
+				writer.writeheader()
+				writer.writerows(stations)
+		if args.geojson:
+			features = [{
+				"type": "Feature",
+				"geometry": {"type": "Point", "coordinates": [reference_lon, reference_lat]},
+				"properties": {"role": "reference"},
+			}]
+			for r in rings:
+				features.append({
+					"type": "Feature",
+					"geometry": {"type": "Polygon", "coordinates": [Geo.circle(reference_lat, reference_lon, r)]},
+					"properties": {"role": "ring", "radius_km": r, "stations": ring_counts[r]},
+				})
+			for s in stations:
+				properties = {"role": "station"}
+				properties.update({k: v for k, v in s.items() if k not in ("latitude", "longitude")})
+				properties.update(WinlinkPrecedence.symbology(s["urgency"]))
+				features.append({
+					"type": "Feature",
+					"geometry": {"type": "Point", "coordinates": [s["longitude"], s["latitude"]]},
+					"properties": properties,
+				})
+			with open(args.geojson, 'w') as f:
+				json.dump({"type": "FeatureCollection", "features": features}, f, indent=4)
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	report.results = {
+		"reference": {"latitude": reference_lat, "longitude": reference_lon},
+		"declination_deg": round(declination, 2) if declination is not None else None,
+		"stations": stations,
+		"rings": [{"radius_km": r, "stations": ring_counts[r]} for r in rings],
+		"beyond_rings": beyond,
+		"sectors": sector_counts,
+	}
+	for s in stations:
+		magnetic = f" {s['bearing_mag_deg']:6.1f}°M" if "bearing_mag_deg" in s else ""
+		elevation = f" {s['elevation_m']:7.1f} m" if s.get("elevation_m") is not None else ""
+		report.say(f"{s['station']:<20} {s['distance_km']:9.2f} km {s['distance_mi']:9.2f} mi {s['bearing_deg']:6.1f}°T{magnetic} {s['sector']:<3}{elevation}")
+	report.say()
+	if declination is not None:
+		report.say(f"Declination at the reference point: {abs(declination):.1f}° {'E' if declination >= 0 else 'W'}")
+	report.say(f"Stations: {len(stations)}")
+	previous = 0.0
+	for r in rings:
+		report.say(f"  {previous:g}-{r:g} km: {ring_counts[r]}")
+		previous = r
+	report.say(f"  beyond {previous:g} km: {beyond}")
+	report.say("  " + "  ".join(f"{name}: {count}" for name, count in sector_counts.items()))
+	return report.finish()
+
+
+def stats_command(args):
+	"""Per-message and aggregate compression statistics, with an airtime estimate at a given baud rate."""
+	report = Report("stats", args)
+	seconds_per_byte = args.bits_per_byte / args.baud
+	rows = []
+	for filename in capture_files(args.paths):
+		try:
+			messages, error = read_messages(filename, enable_debug=args.debug)
+		except OSError as e:
+			report.files.append({"file": filename, "messages": [], "error": str(e)})
+			report.error(f"{filename}: {e}")
+			report.fail(EXIT_IO_ERROR)
+			continue
+		entries = []
+		for message in messages:
+			stats = message.compression_stats()
+			stats.update(summary_entry(message))
+			stats["subject"] = Redaction.scrub_text(message.subject)
+			stats["airtime_seconds"] = round(stats["transfer_size"] * seconds_per_byte, 2)
+			stats["airtime_saved_seconds"] = round(stats["bytes_saved"] * seconds_per_byte, 2)
+			entries.append(stats)
+			rows.append((filename, stats))
+		report.files.append({"file": filename, "messages": entries, "error": error})
+		if error is not None:
+			report.error(f"{filename}: {error}")
+			report.fail(EXIT_PARSE_ERROR)
+
+	original = sum(s["original_size"] for _, s in rows)
+	compressed = sum(s["compressed_size"] for _, s in rows)
+	transferred = sum(s["transfer_size"] for _, s in rows)
+	totals = {
+		"messages": len(rows),
+		"original_size": original,
+		"compressed_size": compressed,
+		"transfer_size": transferred,
+		"ratio": compressed / original if original else 0.0,
+		"bytes_saved": original - transferred,
+		"baud": args.baud,
+		"bits_per_byte": args.bits_per_byte,
+		"airtime_seconds": round(transferred * seconds_per_byte, 2),
+		"uncompressed_airtime_seconds": round(original * seconds_per_byte, 2),
+		"airtime_saved_seconds": round((original - transferred) * seconds_per_byte, 2),
+	}
+	report.results = {"compression": totals}
+
+	for filename, s in rows:
+		report.say(f"{os.path.basename(filename)} {s['subject'][:24]:<24} {s['original_size']:>9} -> {s['transfer_size']:>9} bytes  {s['ratio']:6.3f}  {s['airtime_seconds']:8.1f} s")
+	report.say()
+	report.say(f"Messages:          {totals['messages']}")
+	report.say(f"Original bytes:    {totals['original_size']}")
+	report.say(f"Compressed bytes:  {totals['compressed_size']}")
+	report.say(f"Bytes on the wire: {totals['transfer_size']} (with B2 framing)")
+	report.say(f"Ratio:             {totals['ratio']:.3f}")
+	report.say(f"Airtime at {args.baud} baud: {totals['airtime_seconds']:.1f} s, saving {totals['airtime_saved_seconds']:.1f} s of {totals['uncompressed_airtime_seconds']:.1f} s")
+	return report.finish()
+
+
+def period_title(name):
+	"""A period's name as shown in its products; the bucket outside every period is named in the products' language."""
+	return Translation.tr(name) if name == OperationalPeriods.UNASSIGNED else name
+
+
+def periods_command(args):
+	"""Bucket positioned messages by operational period and export one layer per period."""
+	report = Report("periods", args)
+	try:
+		periods = OperationalPeriods.load_periods(args.config)
+	except (OSError, ValueError) as e:
+		report.error(f"{args.config}: {e}")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+
+	messages = load_messages(args.paths, report, args.debug)
+	buckets = OperationalPeriods.bucket(periods, messages)
+	layers = [(name, [f for m in MapExport.ordered(bucket) for f in MapExport.message_features(m)]) for name, bucket in buckets.items()]
+
+	try:
+		if args.geojson_dir:
+			os.makedirs(args.geojson_dir, exist_ok=True)
+			for name, features in layers:
+				filename = os.path.join(args.geojson_dir, f"{safe_filename(name)}.geojson")
+				MapExport.write_geojson(filename, MapExport.feature_collection(features, period_title(name)))
+		if args.kml:
+			MapExport.write_kml(args.kml, Translation.tr("Operational periods"), [(period_title(name), features) for name, features in layers if features])
+		if args.pdf_dir:
+			os.makedirs(args.pdf_dir, exist_ok=True)
+			spans = {p.name: p for p in periods}
+			static_map = StaticMap(args.tiles, enable_debug=args.debug) if args.tiles else None
+			for name, bucket in buckets.items():
+				if bucket or name in spans:
+					filename = os.path.join(args.pdf_dir, f"{safe_filename(name)}.pdf")
+					PeriodReport.write_period_report(filename, period_title(name), spans.get(name), bucket, args.incident, args.operator, args.net, static_map)
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	spans = {p.name: p for p in periods}
+	report.results = {"periods": [
+		dict(spans[name].as_dict() if name in spans else {"name": name}, features=len(features)) for name, features in layers
+	]}
+	for name, features in layers:
+		span = f"{spans[name].start.isoformat()} to {spans[name].end.isoformat()}" if name in spans else "outside every period"
+		report.say(f"{name:<20} {len(features):>6} features  ({span})")
+	return report.finish()
+
+
+def scrubbed_row(record):
+	"""record.as_dict() with the redaction applied to the form fields it carries, each judged by the
+	template variable it was read from, as on the map; dropped fields become None."""
+	row = record.as_dict()
+	names = {name: record.sources.get(name) or name for name in record.fields}
+	fields = Redaction.scrub_fields(record.message.form.form_type, {names[name]: value for name, value in record.fields.items()})
+	row.update({name: fields.get(names[name]) for name in record.fields})
+	if "subject" in row:
+		row["subject"] = Redaction.scrub_text(row["subject"])
+	return row
+
+
+def render_command(args):
+	"""Fill in each form's HTML viewer with the values received, as the sender saw the form."""
+	report = Report("render", args)
+	viewer = FormViewer(args.templates, enable_debug=args.debug)
+	rendered = []
+	missing = set()
+	try:
+		os.makedirs(args.output, exist_ok=True)
+		for message in load_messages(args.paths, report, args.debug):
+			if message.form is None:
+				continue
+			page = viewer.render(message, Redaction.scrub_fields(message.form.form_type, message.form.variables))
+			if page is None:
+				missing.add(message.form.form_type)
+				continue
+			filename = os.path.join(args.output, f"{safe_filename(message.mid or message.message_id)}.html")
+			with open(filename, 'w', encoding='utf-8') as f:
+				f.write(page)
+			rendered.append({"mid": message.mid, "form_type": message.form.form_type, "file": filename})
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	report.results = {"rendered": rendered, "no_viewer": sorted(missing)}
+	for entry in rendered:
+		report.say(f"{entry['mid'] or '':<12} {entry['form_type']:<24} {entry['file']}")
+	if missing:
+		report.say(f"No viewer in {args.templates} for: {', '.join(sorted(missing))}")
+	return report.finish()
+
+
+def search_command(args):
+	"""Keyword search over the messages saved in a mailbox, indexing any that aren't indexed yet."""
+	report = Report("search", args, reads_messages=False)
+	index = open_index(args.mailbox)
+	try:
+		bbox = parse_bbox(args.bbox) if args.bbox else None
+		if args.rebuild:
+			index.clear()
+		indexed = index.keys()
+		added = 0
+		for filename in capture_files([args.mailbox], (HEADERS_FILE_SUFFIX,)):
+			key = os.path.relpath(filename, args.mailbox)
+			if key not in indexed:
+				index.add(key, read_headers_file(filename, args.debug))
+				added += 1
+		results = index.search(args.query, args.limit, bbox=bbox)
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	except (OSError, sqlite3.Error, PgWire.Error) as e:
+		report.error(f"{index.path}: {e}")
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	for result in results:
+		result["subject"] = Redaction.scrub_text(result["subject"])
+		result["snippet"] = Redaction.scrub_text(result["snippet"])
+	report.results = {"query": args.query, "newly_indexed": added, "results": results}
+	for result in results:
+		report.say(f"{result['date'][:16]} {result['mid'] or '':<12} {result['sender'] or '':<10} {result['subject']}")
+		report.say(f"    {' '.join(result['snippet'].split())}")
+	report.say()
+	report.say(f"Matches: {len(results)}")
+	return report.finish()
+
+
+def stage_summary(spans):
+	"""{span name: count, errors, and p50, p95, max, and total milliseconds}, busiest stage first."""
+	durations = {}
+	errors = collections.Counter()
+	for span in spans:
+		durations.setdefault(span["name"], []).append(span["duration_ms"])
+		if span.get("status") == "error":
+			errors[span["name"]] += 1
+	summary = {
+		name: {"count": len(values), "errors": errors[name], "p50_ms": percentile(values, 50), "p95_ms": percentile(values, 95),
+			"max_ms": max(values), "total_ms": round(sum(values), 3)}
+		for name, values in durations.items()
+	}
+	return dict(sorted(summary.items(), key=lambda item: -item[1]["total_ms"]))
+
+
+def slowest_traces(spans, count):
+	"""The count slowest traces, each {root span, stages: {name: milliseconds}}, slowest first."""
+	stages = {}
+	roots = []
+	for span in spans:
+		if span.get("parent_id") is None:
+			roots.append(span)
+		else:
+			trace = stages.setdefault(span["trace_id"], {})
+			trace[span["name"]] = trace.get(span["name"], 0.0) + span["duration_ms"]
+	roots.sort(key=lambda span: -span["duration_ms"])
+	return [dict(root, stages=stages.get(root["trace_id"], {})) for root in roots[:count]]
+
+
+def traces_command(args):
+	"""Time spent in each pipeline stage, and the slowest messages, from a traces file the server wrote."""
+	report = Report("traces", args, reads_messages=False)
+	try:
+		spans = Tracing.read_spans(args.file)
+	except (OSError, ValueError) as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR if isinstance(e, OSError) else EXIT_PARSE_ERROR)
+		return report.finish()
+	if args.since:
+		since = parse_timestamp(args.since)
+		if since is None:
+			report.error(f"Bad --since time: {args.since}")
+			report.fail(EXIT_USAGE)
+			return report.finish()
+		spans = [s for s in spans if s["start"] >= since.timestamp() * 1e9]
+
+	summary = stage_summary(spans)
+	slowest = slowest_traces(spans, args.slowest)
+	report.results = {"stages": summary, "slowest": slowest}
+	report.say(f"{'Stage':<24} {'Count':>7} {'Errors':>6} {'p50 ms':>9} {'p95 ms':>9} {'Max ms':>9} {'Total s':>9}")
+	for name, stage in summary.items():
+		report.say(f"{name:<24} {stage['count']:>7} {stage['errors']:>6} {stage['p50_ms']:>9.1f} {stage['p95_ms']:>9.1f} {stage['max_ms']:>9.1f} {stage['total_ms'] / 1000:>9.2f}")
+	if slowest:
+		report.say()
+		report.say("Slowest messages:")
+	for trace in slowest:
+		stages = ", ".join(f"{name} {ms:.0f}" for name, ms in sorted(trace["stages"].items(), key=lambda item: -item[1]))
+		report.say(f"  {trace['attributes'].get('mid') or trace['trace_id']:<14} {trace['duration_ms']:>9.1f} ms  {trace['status']:<5}  {stages}")
+	if any(stage["errors"] for stage in summary.values()):
+		report.fail(EXIT_WARNINGS)
+	return report.finish()
+
+
+def migrate_command(args):
+	"""Show the schema version of a mailbox's stores and bring them up to date."""
+	report = Report("migrate", args, reads_messages=False)
+	index = open_index(args.mailbox)
+	try:
+		exists = index.exists()
+		version, pending = index.schema()
+		applied = [] if args.check or not exists else index.migrate()
+	except (OSError, sqlite3.Error, PgWire.Error) as e:
+		report.error(f"{index.path}: {e}")
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	if not exists:
+		report.say(f"{index.path}: not created yet; it starts at schema version {pending[-1][0]}")
+		pending = []
+	elif applied:
+		report.say(f"{index.path}: migrated from schema version {version} to {applied[-1]}")
+	else:
+		report.say(f"{index.path}: schema version {version}" + (f", {len(pending)} migrations pending" if pending else ", up to date"))
+	for pending_version, description in pending:
+		report.say(f"    {pending_version}: {description}")
+	if args.check and pending:
+		report.fail(EXIT_WARNINGS)
+	report.results = {"stores": [{"path": index.path, "version": applied[-1] if applied else version, "pending": [v for v, _ in pending if v not in applied], "applied": applied}]}
+	return report.finish()
+
+
+def requests_command(args):
+	"""List ICS-213RR resource requests as open or filled, with an optional map layer."""
+	report = Report("requests", args)
+	requests = ResourceRequest.track_requests(load_messages(args.paths, report, args.debug))
+	if args.open:
+		requests = [r for r in requests if r.status == ResourceRequest.OPEN]
+	rows = [scrubbed_row(r) for r in requests]
+
+	try:
+		if args.csv:
+			with open(args.csv, 'w', newline='') as f:
+				fieldnames = ["mid", "date", "sender", "recipient", "subject"] + list(ResourceRequest.REQUEST_FIELDS) + ["items", "status", "filled_by", "filled_date"]
+				writer = csv.DictWriter(f, fieldnames=fieldnames)
+				writer.writeheader()
+				for row in rows:
+					writer.writerow(dict(row, items="; ".join(" ".join(item.values()) for item in row["items"])))
+		if args.geojson:
+			features = []
+			for request in requests:
+				for feature in MapExport.message_features(request.message):
+					feature["properties"].update(status=request.status, filled_by=request.as_dict()["filled_by"])
+					feature["properties"].update(ResourceRequest.STATUS_SYMBOLOGY[request.status])
+					features.append(feature)
+			MapExport.write_geojson(args.geojson, MapExport.feature_collection(features, Translation.tr("Resource requests")))
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	report.results = {
+		"requests": rows,
+		"open": sum(1 for r in requests if r.status == ResourceRequest.OPEN),
+		"filled": sum(1 for r in requests if r.status == ResourceRequest.FILLED),
+	}
+	for row in rows:
+		filled = f" by {row['filled_by']}" if row["filled_by"] else ""
+		report.say(f"{row['date'][:16]} {row['mid'] or '':<12} {row['sender'] or '':<10} {row['status']:<6}{filled:<16} {row['subject']}")
+		for item in row["items"]:
+			report.say(f"    {' '.join(item.values())}")
+	report.say()
+	report.say(f"Open: {report.results['open']}  Filled: {report.results['filled']}")
+	return report.finish()
+
+
+def shelters_command(args):
+	"""Latest status of each shelter, with a map layer styled by how full it is."""
+	report = Report("shelters", args)
+	shelters = ShelterStatus.latest_shelters(load_messages(args.paths, report, args.debug))
+	rows = [scrubbed_row(s) for s in shelters]
+
+	try:
+		if args.csv:
+			with open(args.csv, 'w', newline='') as f:
+				fieldnames = ["shelter", "mid", "date", "sender"] + [n for n in ShelterStatus.SHELTER_FIELDS if n != "shelter"] + list(ShelterStatus.NUMBER_FIELDS) + ["occupancy_pct", "available"]
+				writer = csv.DictWriter(f, fieldnames=fieldnames)
+				writer.writeheader()
+				writer.writerows(rows)
+		if args.geojson:
+			features = []
+			for shelter, row in zip(shelters, rows):
+				for feature in MapExport.message_features(shelter.message):
+					feature["properties"].update(row)
+					feature["properties"].update(shelter.symbology())
+					features.append(feature)
+			MapExport.write_geojson(args.geojson, MapExport.feature_collection(features, Translation.tr("Shelters")))
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+
+	report.results = {"shelters": rows}
+	for row in rows:
+		occupancy = f"{row['occupancy'] if row['occupancy'] is not None else '?':>5} / {row['capacity'] if row['capacity'] is not None else '?':<5}"
+		percent = f"{row['occupancy_pct']:5.1f}%" if row["occupancy_pct"] is not None else "     ?"
+		report.say(f"{row['shelter'] or row['sender']:<30} {occupancy} {percent}  {row['status'] or '':<10} {row['date'][:16]}")
+	report.say()
+	report.say(f"Shelters: {len(rows)}  Occupants: {sum(r['occupancy'] or 0 for r in rows)}  Capacity: {sum(r['capacity'] or 0 for r in rows)}")
+	return report.finish()
+
+
+def welfare_command(args):
+	"""List health and welfare inquiries, with personal details redacted unless --no-redaction is given."""
+	report = Report("welfare", args)
+	inquiries = [Welfare.WelfareInquiry(m) for m in sorted(load_messages(args.paths, report, args.debug), key=lambda m: m.date) if Welfare.is_welfare_inquiry(m)]
+	rows = [scrubbed_row(i) for i in inquiries]
+
+	if args.csv:
+		try:
+			with open(args.csv, 'w', newline='') as f:
+				writer = csv.DictWriter(f, fieldnames=["mid", "date", "sender", "recipient", "form_type"] + list(Welfare.WELFARE_FIELDS))
+				writer.writeheader()
+				writer.writerows(rows)
+		except OSError as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR)
+
+	report.results = {"inquiries": rows}
+	for row in rows:
+		report.say(f"{row['date'][:16]} {row['mid'] or '':<12} {row['sender'] or '':<10} {row['subject_name'] or '':<24} {row['status'] or ''}")
+	report.say()
+	report.say(f"Inquiries: {len(rows)}")
+	return report.finish()
+
+
+def receipts_command(args):
+	"""List each message as unconfirmed, delivered, or read, from the receipts that quote its MID."""
+	report = Report("receipts", args)
+	deliveries, unmatched = DeliveryReceipts.track_deliveries(load_messages(args.paths, report, args.debug))
+	if args.unconfirmed:
+		deliveries = [d for d in deliveries if d.status == DeliveryReceipts.UNCONFIRMED]
+	rows = [dict(d.as_dict(), subject=Redaction.scrub_text(d.message.subject)) for d in deliveries]
+
+	if args.csv:
+		try:
+			with open(args.csv, 'w', newline='') as f:
+				writer = csv.DictWriter(f, fieldnames=["mid", "date", "sender", "recipient", "subject", "status", "receipts"])
+				writer.writeheader()
+				for row in rows:
+					writer.writerow(dict(row, receipts="; ".join(f"{r['kind']} {r['mid'] or ''} {r['sender'] or ''} {r['date']}" for r in row["receipts"])))
+		except OSError as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR)
+
+	report.results = {
+		"messages": rows,
+		"unmatched": [{"mid": m.mid, "date": m.date.isoformat(), "sender": m.sender, "subject": Redaction.scrub_text(m.subject)} for m in unmatched],
+	}
+	for status in DeliveryReceipts.STATUS_ORDER:
+		report.results[status] = sum(1 for row in rows if row["status"] == status)
+	for row in rows:
+		by = f" by {row['receipts'][-1]['sender']}" if row["receipts"] else ""
+		report.say(f"{row['date'][:16]} {row['mid'] or '':<12} {row['recipient'] or '':<10} {row['status']:<11}{by:<14} {row['subject'] or ''}")
+	if unmatched:
+		report.say()
+		report.say("Receipts that name no message here:")
+		for message in unmatched:
+			report.say(f"    {message.date.isoformat()[:16]} {message.mid or '':<12} {message.sender or '':<10} {Redaction.scrub_text(message.subject)}")
+	report.say()
+	report.say("  ".join(f"{status.capitalize()}: {report.results[status]}" for status in DeliveryReceipts.STATUS_ORDER))
+	return report.finish()
+
+
+def templates_command(args):
+	"""Show which template versions of each form type were received, against the versions the mappings were written for."""
+	report = Report("templates", args)
+	rows = TemplateVersions.matrix(load_messages(args.paths, report, args.debug))
+
+	if args.csv:
+		try:
+			with open(args.csv, 'w', newline='') as f:
+				writer = csv.DictWriter(f, fieldnames=["form_type", "version", "status", "supported", "messages", "senders", "first", "last"])
+				writer.writeheader()
+				writer.writerows(dict(row, supported=" ".join(row["supported"]), senders=" ".join(row["senders"])) for row in rows)
+		except OSError as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR)
+
+	report.results = {"templates": rows}
+	for row in rows:
+		supported = f" (mappings: {', '.join(row['supported'])})" if row["supported"] else ""
+		report.say(f"{row['form_type'] or '':<28} {row['version'] or '-':<10} {row['status']:<10} {row['messages']:>5} messages, last {row['last'][:16]}{supported}")
+	flagged = [row for row in rows if row["status"] in TemplateVersions.WARNING_STATUSES]
+	report.say()
+	report.say(f"Form types: {len({row['form_type'] for row in rows})}, versions needing review: {len(flagged)}")
+	if flagged:
+		report.fail(EXIT_WARNINGS)
+	return report.finish()
+
+
+def mappings_command(args):
+	"""Draft the form mappings from the Winlink Standard Templates ZIP, and write or merge them into mappings.json."""
+	report = Report("mappings", args, reads_messages=False)
+	output = args.output or args.mappings or MAPPINGS_FILE_NAME
+	if output == "-":
+		report.quiet = True  # stdout carries the mappings
+	try:
+		forms = TemplatePack.read_pack(args.pack)
+		existing = {}
+		if output != "-" and not args.replace and os.path.exists(output):
+			load_config(output, MAPPINGS)  # Refuses a file the server wouldn't load
+			with open(output, 'r') as f:
+				existing = json.load(f)
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+	drafted = TemplatePack.draft_mappings(forms)
+	mappings = TemplatePack.merge_mappings(existing, drafted)
+
+	report.results = {
+		"forms": [dict(form, positions=[p["role"] for p in find_position_variables(form["fields"])]) for form in forms],
+		"settings": {setting: sorted(entries) for setting, entries in drafted.items()},
+		"file": None if output == "-" else output,
+	}
+	for form in report.results["forms"]:
+		roles = f", positions: {' '.join(form['positions'])}" if form["positions"] else ""
+		report.say(f"{form['form_type']:<32} {form['version'] or '-':<12} {len(form['fields']):>4} fields, {len(form['required'])} required{roles}")
+	report.say()
+	report.say(f"Forms: {len(forms)}; " + ", ".join(f"{setting}: {len(entries)}" for setting, entries in drafted.items()))
+	write_text_output(output, json.dumps(mappings, indent=4) + "\n", report)
+	return report.finish()
+
+
+def hazards_command(args):
+	"""List the NWS watches, warnings, and advisories in force from weather bulletins, and write them as a polygon overlay."""
+	report = Report("hazards", args)
+	at = None
+	if args.at:
+		at = parse_timestamp(args.at)
+		if at is None:
+			report.error(f"Unrecognized time <{args.at}>")
+			report.fail(EXIT_USAGE)
+			return report.finish()
+	hazards = WeatherHazards.active_hazards(load_messages(args.paths, report, args.debug), at)
+	features = WeatherHazards.hazard_features(hazards)
+	if args.output:
+		try:
+			MapExport.write_geojson(args.output, MapExport.feature_collection(features, Translation.tr("Hazards")))
+		except OSError as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR)
+
+	rows = [{k: v.isoformat() if isinstance(v, datetime.datetime) else v for k, v in h.items()} for h in hazards]
+	missing = WeatherHazards.unmapped(hazards)
+	report.results = {"hazards": rows, "unmapped_zones": missing, "features": len(features), "file": args.output}
+	for row in rows:
+		until = f"until {row['ends'][:16]}" if row["ends"] else "until further notice"
+		report.say(f"{row['zone']:<7} {row['event']:<32} {row['office'] or '':<5} {until:<28} {row['headline'] or ''}")
+	report.say()
+	report.say(f"Hazards in force: {len(rows)} in {len({row['zone'] for row in rows})} zones, {len({(row['office'], row['event'], row['number']) for row in rows})} events")
+	if missing:
+		zones = f"the zone file ({WeatherHazards.zone_count()} zones)" if WeatherHazards.zone_count() else "a zone file (see --zones)"
+		report.say(f"Not drawn, not in {zones}: {', '.join(missing)}")
+	if args.output:
+		report.say(f"Wrote {args.output}: {len(features)} polygons")
+	return report.finish()
+
+
+def zones_command(args):
+	"""Build the zone file for the hazards overlay from NWS zone and county GeoJSON, downloaded or fetched."""
+	report = Report("zones", args, reads_messages=False)
+	if not args.files and not args.fetch:
+		report.error("Give GeoJSON files of NWS zones and counties, or --fetch STATE")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	if args.tolerance < 0:
+		report.error("--tolerance can't be negative")
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	collections = []
+	for filename in args.files:
+		try:
+			collections.append(WeatherHazards.read_collection(filename))
+		except (OSError, ValueError) as e:
+			report.error(str(e))
+			report.fail(EXIT_IO_ERROR if isinstance(e, OSError) else EXIT_PARSE_ERROR)
+	for state in args.fetch or []:
+		for kind in WeatherHazards.NWS_ZONE_TYPES:
+			report.say(f"Fetching the {kind} zones of {state.upper()}...")
+			try:
+				collections.append(WeatherHazards.fetch_zones(state, kind))
+			except (OSError, ValueError) as e:
+				report.error(f"{state.upper()} {kind} zones: {e}")
+				report.fail(EXIT_IO_ERROR)
+
+	zone_file = WeatherHazards.build_zone_file(collections, args.state, args.tolerance)
+	features = zone_file["features"]
+	points = sum(len(ring) for f in features for polygon in f["geometry"]["coordinates"] for ring in polygon)
+	report.results = {"file": args.output, "zones": len(features), "points": points}
+	if not features:
+		report.error("No zones or counties with a UGC code and a polygon; the zone file was not written")
+		report.fail(EXIT_WARNINGS)
+		return report.finish()
+	try:
+		with open(args.output, 'w') as f:
+			json.dump(zone_file, f, separators=(",", ":"))
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+	counties = sum(1 for f in features if f["properties"]["ugc"][2] == "C")
+	report.say(f"Wrote {args.output}: {len(features) - counties} forecast zones and {counties} counties, {points} points")
+	return report.finish()
+
+
+def annotation_row(entry):
+	"""An annotation as listed, with its measurements."""
+	return dict(entry, **Annotations.measure(entry["kind"], [tuple(p) for p in entry["points"]]))
+
+
+def annotate_command(args):
+	"""List, add, or remove the notes, lines, and areas drawn on the map, or measure points without saving them."""
+	report = Report("annotate", args, reads_messages=False)
+	store = Annotations.current_store()
+	try:
+		points = [Geo.parse_lat_lon(p) for p in args.items] if args.action in ("note", "line", "area", "measure") else []
+		if args.action == "list":
+			rows = [annotation_row(entry) for entry in store.entries()]
+		elif args.action == "remove":
+			rows = []
+			for annotation_id in args.items:
+				removed = store.remove(annotation_id)
+				if removed is None:
+					report.error(f"No annotation {annotation_id} in {store.filename}")
+					report.fail(EXIT_USAGE)
+				else:
+					rows.append(annotation_row(removed))
+		elif args.action == "measure":
+			if len(points) < 2:
+				raise ValueError("measure needs at least two points")
+			rows = [dict(kind="measure", points=[list(p) for p in points], **Annotations.measure(Annotations.LINE, points),
+				**(Annotations.measure(Annotations.AREA, points) if len(points) >= 3 else {}))]
+		else:
+			rows = [annotation_row(store.add(args.action, points, args.label, args.note, args.author))]
+	except ValueError as e:
+		report.error(str(e))
+		report.fail(EXIT_USAGE)
+		return report.finish()
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return report.finish()
+
+	report.results = {"annotations": rows, "file": store.filename}
+	for row in rows:
+		measures = []
+		if "length_km" in row:
+			measures.append(f"{row['length_km']:.2f} km ({row['length_mi']:.2f} mi) long")
+		if "area_km2" in row:
+			measures.append(f"{row['area_km2']:.2f} km² ({row['area_mi2']:.2f} mi²), {row['perimeter_km']:.2f} km around")
+		label = " ".join(f"{row[k]}" for k in ("label", "note") if row.get(k))
+		report.say(f"{row.get('id', ''):<5} {row['kind']:<7} {len(row['points']):>3} points  {'; '.join(measures):<32} {label}")
+	if args.action == "remove":
+		report.say(f"Removed {len(rows)} from {store.filename}")
+	elif args.action in ("note", "line", "area"):
+		report.say(f"Saved to {store.filename}")
+	return report.finish()
+
+
+def write_text_output(output, text, report):
+	"""Write text to the output file, or to stdout for "-", recording any error in report."""
+	if output == "-":
+		sys.stdout.write(text)
+		return
+	try:
+		with open(output, 'w', encoding='utf-8') as f:
+			f.write(text)
+	except OSError as e:
+		report.error(str(e))
+		report.fail(EXIT_IO_ERROR)
+		return
+	report.say(f"Wrote {output}")
+
+
+def completion_command(args):
+	"""A bash, zsh, or fish completion script, built from the command line definitions."""
+	report = Report("completion", args, reads_messages=False)
+	if args.output == "-":
+		report.quiet = True  # stdout carries the script
+	tree = CommandDocs.command_tree(args.parser)
+	write_text_output(args.output, CommandDocs.completion(tree, args.shell, [tree.name, f"{tree.name}.py"]), report)
+	report.results = {"shell": args.shell, "file": None if args.output == "-" else args.output}
+	return report.finish()
+
+
+def manpage_command(args):
+	"""A man page for every command and option, from the same definitions as --help."""
+	report = Report("manpage", args, reads_messages=False)
+	if args.output == "-":
+		report.quiet = True  # stdout carries the page
+	sections = [
+		("Exit status", [
+			(EXIT_OK, "Everything processed cleanly."),
+			(EXIT_WARNINGS, "Processed, but some messages have validation warnings."),
+			(EXIT_USAGE, "Bad command line."),
+			(EXIT_DECODE_ERROR, "A message could not be decompressed."),
+			(EXIT_PARSE_ERROR, "A file contains malformed B2 data."),
+			(EXIT_IO_ERROR, "A file could not be read or written; when several apply, the highest code is returned."),
+		]),
+		("Files", [
+			(MAPPINGS_FILE_NAME, "Form field mappings, read from the working directory; see --mappings."),
+			(STYLES_FILE_NAME, "Map marker styles; see --styles."),
+			(Translation.TRANSLATIONS_FILE_NAME, "Further languages; see --translations."),
+			(BlobStore.STORAGE_FILE_NAME, "The blob store for attachments and raw messages; see --storage."),
+			(StationRoster.ROSTER_FILE_NAME, "Who each station is; see --roster."),
+			(WeatherHazards.ZONES_FILE_NAME, "NWS zone and county shapes for the hazards overlay; see --zones."),
+			(os.path.join(MAILBOX_FOLDER_NAME, Annotations.ANNOTATIONS_FILE_NAME), "Notes, lines, and areas drawn on the map; see --annotations."),
+			(EVENTS_FILE_NAME, "The server's event publishers, dry-run by selftest; see --events."),
+			(ALERTS_FILE_NAME, "The server's alert rules and notifiers, dry-run by selftest; see --alerts."),
+		]),
+	]
+	page = CommandDocs.man_page(CommandDocs.command_tree(args.parser), sections=sections)
+	write_text_output(args.output, page, report)
+	report.results = {"file": None if args.output == "-" else args.output}
+	return report.finish()
+
+
+def selftest_exit_code(stage):
+	"""The exit code a failed self-test stage stands for."""
+	if stage == "decompress":
+		return EXIT_DECODE_ERROR
+	if stage in ("encode", "parse", "geocode"):
+		return EXIT_PARSE_ERROR
+	if stage.startswith("config "):
+		return EXIT_USAGE
+	return EXIT_IO_ERROR
+
+
+def selftest_command(args):
+	"""Run a sample Check-In through decompression, parsing, geocoding, and every exporter, and try
+	the configured publishers, event brokers, and notifiers without sending to them."""
+	report = Report("selftest", args, reads_messages=False)
+	events = args.events or (EVENTS_FILE_NAME if os.path.exists(EVENTS_FILE_NAME) else None)
+	alerts = args.alerts or (ALERTS_FILE_NAME if os.path.exists(ALERTS_FILE_NAME) else None)
+	results = SelfTest.SelfTest(args.config, events, alerts, enable_debug=args.debug).run()
+	for result in results:
+		report.say(f"{result['status'].upper():<4}  {result['stage']}: {result['detail']}")
+		if result["status"] == SelfTest.FAIL:
+			report.fail(selftest_exit_code(result["stage"]))
+	passed = sum(1 for result in results if result["status"] == SelfTest.PASS)
+	report.say(f"All {passed} stages passed" if passed == len(results) else f"{passed} of {len(results)} stages passed")
+	report.results = {"stages": results}
+	return report.finish()
+
+
+def main(argv=None):
+	parser = argparse.ArgumentParser(prog="esvmap", description=__doc__)
+	parser.add_argument("--debug", action="store_true", help="enable debug logging")
+	output = parser.add_mutually_exclusive_group()
+	output.add_argument("-q", "--quiet", action="store_true", help="print nothing but errors; rely on the exit code")
+	output.add_argument("--json", action="store_true", help="print a single JSON document with the results on stdout")
+	parser.add_argument("--units", choices=sorted(Units.PRESETS), help="render measurements in form fields in these units")
+	parser.add_argument("--stations", choices=StationIdentity.MODES, default=StationIdentity.PATH,
+		help="tell stations apart by callsign, SSID, and path (default), by callsign and SSID, or by callsign alone")
+	parser.add_argument("--clock", choices=ClockSkew.MODES, default=ClockSkew.FLAG, help="flag (default), correct, or ignore stations whose clocks disagree with the receive times")
+	redaction = parser.add_mutually_exclusive_group()
+	redaction.add_argument("--redaction", metavar="FILE", help="JSON file of redaction settings (default: redact personal details in welfare forms)")
+	redaction.add_argument("--no-redaction", action="store_true", help="export personal details as received")
+	parser.add_argument("--language", default=Translation.ENGLISH, metavar="CODE",
+		help=f"language of popups, layer names, and reports: {', '.join(Translation.languages())}, or one from the translations file (default: %(default)s)")
+	parser.add_argument("--translations", metavar="FILE", help=f"JSON file of further translations (default: {Translation.TRANSLATIONS_FILE_NAME}, if there is one)")
+	parser.add_argument("--storage", metavar="FILE", help=f"JSON file naming the blob store for attachments and raw messages (default: {BlobStore.STORAGE_FILE_NAME}, if there is one)")
+	parser.add_argument("--annotations", metavar="FILE",
+		help=f"JSON file of the notes, lines, and areas drawn on the map, included in exports (default: {os.path.join(MAILBOX_FOLDER_NAME, Annotations.ANNOTATIONS_FILE_NAME)})")
+	parser.add_argument("--roster", metavar="FILE",
+		help=f"CSV or JSON file of each station's operator, agency, capabilities, and phone, added to exported features (default: {StationRoster.ROSTER_FILE_NAME}, if there is one)")
+	parser.add_argument("--dem", metavar="FOLDER", help="folder of SRTM .hgt tiles; adds each feature's ground elevation, elevation_m, to exports")
+	parser.add_argument("--zones", metavar="FILE", help=f"GeoJSON of NWS forecast zones and counties for the hazards overlay (default: {WeatherHazards.ZONES_FILE_NAME}, if there is one)")
+	parser.add_argument("--mappings", metavar="FILE", help=f"JSON file of form field mappings (default: {MAPPINGS_FILE_NAME}, if there is one)")
+	parser.add_argument("--styles", metavar="FILE", help=f"JSON file of map marker styles (default: {STYLES_FILE_NAME}, if there is one)")
+	subparsers = parser.add_subparsers(dest="command", required=True)
+
+	inspect_parser = subparsers.add_parser("inspect", help="print the B2 structure of capture files")
+	inspect_parser.add_argument("files", nargs="+", metavar="file", help=".b2f file as received from the client")
+	inspect_parser.add_argument("-n", "--bytes", type=int, default=HEXDUMP_BYTES_DEFAULT, help="number of bytes to dump")
+	inspect_parser.set_defaults(handler=inspect_command)
+
+	extract_parser = subparsers.add_parser("extract", help="decode capture files or directories and write a manifest")
+	extract_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, or a directory searched for them")
+	extract_parser.add_argument("-o", "--output", default="extracted", help="folder for the decoded files (default: %(default)s)")
+	extract_parser.add_argument("-m", "--manifest", help=f"where to write the manifest (default: OUTPUT/{MANIFEST_FILE_NAME})")
+	extract_parser.add_argument("--path", help="label for how these captures arrived (e.g., HF, VHF, mesh), recorded as their source")
+	extract_parser.set_defaults(handler=extract_command)
+
+	stats_parser = subparsers.add_parser("stats", help="compression statistics and airtime estimates")
+	stats_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, or a directory searched for them")
+	stats_parser.add_argument("--baud", type=float, default=BAUD_DEFAULT, help="channel rate for airtime estimates (default: %(default)s)")
+	stats_parser.add_argument("--bits-per-byte", type=float, default=BITS_PER_BYTE_DEFAULT, help="bits sent per byte, including framing (default: %(default)s)")
+	stats_parser.set_defaults(handler=stats_command)
+
+	periods_parser = subparsers.add_parser("periods", help="export one layer per ICS operational period")
+	periods_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	periods_parser.add_argument("-c", "--config", required=True, help="JSON file listing the operational periods")
+	periods_parser.add_argument("--geojson-dir", metavar="FOLDER", help="write one GeoJSON FeatureCollection per period here")
+	periods_parser.add_argument("--kml", metavar="FILE", help="write a KML file with one folder per period")
+	periods_parser.add_argument("--pdf-dir", metavar="FOLDER", help="write one PDF per period: a map, the reports received, and an ICS-309 log")
+	periods_parser.add_argument("--incident", help="incident name for the PDF headings")
+	periods_parser.add_argument("--operator", help="radio operator name and call sign for the ICS-309")
+	periods_parser.add_argument("--net", help="radio net name or tactical call for the ICS-309")
+	periods_parser.add_argument("--tiles", metavar="FOLDER", help="local {z}/{x}/{y}.png tiles to draw the PDF maps over")
+	periods_parser.set_defaults(handler=periods_command)
+
+	render_parser = subparsers.add_parser("render", help="fill in each form's HTML viewer with the values received")
+	render_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	render_parser.add_argument("-t", "--templates", required=True, metavar="FOLDER", help="Winlink Express form templates folder with the *_Viewer.html files")
+	render_parser.add_argument("-o", "--output", default="rendered", help="folder for the filled-in pages (default: %(default)s)")
+	render_parser.set_defaults(handler=render_command)
+
+	search_parser = subparsers.add_parser("search", help="keyword search over the messages saved in a mailbox")
+	search_parser.add_argument("query", help='FTS5 query, e.g. generator, "road closed", or subject:shelter (web search syntax with a PostGIS index)')
+	search_parser.add_argument("-m", "--mailbox", default=MAILBOX_FOLDER_NAME, help="mailbox folder (default: %(default)s)")
+	search_parser.add_argument("-n", "--limit", type=int, default=SEARCH_LIMIT_DEFAULT, help="most results to show (default: %(default)s)")
+	search_parser.add_argument("--rebuild", action="store_true", help="re-index every message instead of only new ones")
+	search_parser.add_argument("--bbox", metavar="W,S,E,N", help="only messages reported inside the box (needs a PostGIS index)")
+	search_parser.set_defaults(handler=search_command)
+
+	traces_parser = subparsers.add_parser("traces", help="time spent in each pipeline stage, from a traces file the server wrote")
+	traces_parser.add_argument("file", help="traces file (JSON lines), as named by \"file\" in tracing.json")
+	traces_parser.add_argument("--slowest", type=int, default=10, metavar="N", help="slowest messages to list (default: %(default)s)")
+	traces_parser.add_argument("--since", metavar="TIME", help="only spans that began at or after this time, UTC unless a zone is given")
+	traces_parser.set_defaults(handler=traces_command)
+
+	migrate_parser = subparsers.add_parser("migrate", help="upgrade the schema of a mailbox's stores, keeping their contents")
+	migrate_parser.add_argument("-m", "--mailbox", default=MAILBOX_FOLDER_NAME, help="mailbox folder (default: %(default)s)")
+	migrate_parser.add_argument("--check", action="store_true", help="only report the migrations needed; exit 1 if there are any")
+	migrate_parser.set_defaults(handler=migrate_command)
+
+	requests_parser = subparsers.add_parser("requests", help="ICS-213RR resource requests and whether they have been filled")
+	requests_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	requests_parser.add_argument("--open", action="store_true", help="only requests no reply has filled")
+	requests_parser.add_argument("--csv", metavar="FILE", help="write the table as CSV")
+	requests_parser.add_argument("--geojson", metavar="FILE", help="write the requests with positions as a GeoJSON layer styled by status")
+	requests_parser.set_defaults(handler=requests_command)
+
+	shelters_parser = subparsers.add_parser("shelters", help="latest status of each shelter from shelter status forms")
+	shelters_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	shelters_parser.add_argument("--csv", metavar="FILE", help="write the table as CSV")
+	shelters_parser.add_argument("--geojson", metavar="FILE", help="write a shelter layer styled by occupancy")
+	shelters_parser.set_defaults(handler=shelters_command)
+
+	welfare_parser = subparsers.add_parser("welfare", help="health and welfare inquiries")
+	welfare_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	welfare_parser.add_argument("--csv", metavar="FILE", help="write the table as CSV")
+	welfare_parser.set_defaults(handler=welfare_command)
+
+	receipts_parser = subparsers.add_parser("receipts", help="delivery and read receipts, and which messages they confirm")
+	receipts_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	receipts_parser.add_argument("--unconfirmed", action="store_true", help="only messages no receipt has confirmed")
+	receipts_parser.add_argument("--csv", metavar="FILE", help="write the table as CSV")
+	receipts_parser.set_defaults(handler=receipts_command)
+
+	templates_parser = subparsers.add_parser("templates", help="form template versions received, against those the mappings cover")
+	templates_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	templates_parser.add_argument("--csv", metavar="FILE", help="write the matrix as CSV")
+	templates_parser.set_defaults(handler=templates_command)
+
+	mappings_parser = subparsers.add_parser("mappings", help="draft mappings.json entries from the Winlink Standard Templates ZIP")
+	mappings_parser.add_argument("pack", metavar="ZIP", help="the Standard Templates ZIP, as Winlink Express downloads it")
+	mappings_parser.add_argument("-o", "--output", metavar="FILE", help=f"mappings file to merge into, or - for stdout (default: --mappings, or {MAPPINGS_FILE_NAME})")
+	mappings_parser.add_argument("--replace", action="store_true", help="write only what the templates give, dropping the file's other settings")
+	mappings_parser.set_defaults(handler=mappings_command)
+
+	hazards_parser = subparsers.add_parser("hazards", help="NWS watches, warnings, and advisories in force, from weather bulletins")
+	hazards_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	hazards_parser.add_argument("-o", "--output", metavar="FILE", help="write the hazards as a GeoJSON polygon layer")
+	hazards_parser.add_argument("--at", metavar="TIME", help="the hazards in force at this time, UTC unless a zone is given (default: now)")
+	hazards_parser.set_defaults(handler=hazards_command)
+
+	zones_parser = subparsers.add_parser("zones", help="build the NWS zone and county shapes the hazards overlay draws")
+	zones_parser.add_argument("files", nargs="*", metavar="file", help="GeoJSON of NWS forecast zones or counties, from api.weather.gov or the NWS shapefiles")
+	zones_parser.add_argument("--fetch", action="append", metavar="STATE", help="fetch a state's forecast zones and counties from api.weather.gov (repeatable)")
+	zones_parser.add_argument("--state", action="append", metavar="STATE", help="keep only the zones in this state (repeatable)")
+	zones_parser.add_argument("--tolerance", type=float, default=WeatherHazards.SIMPLIFY_TOLERANCE_DEFAULT, help="simplify outlines to within this many degrees, 0 to keep every point (default: %(default)s)")
+	zones_parser.add_argument("-o", "--output", default=WeatherHazards.ZONES_FILE_NAME, metavar="FILE", help="the zone file to write (default: %(default)s)")
+	zones_parser.set_defaults(handler=zones_command)
+
+	annotate_parser = subparsers.add_parser("annotate", help="notes, lines, and areas net control draws on the map; exports include them")
+	annotate_parser.add_argument("action", choices=["list", "note", "line", "area", "remove", "measure"],
+		help="note, line, area: save LAT,LON points; remove: delete ids; measure: length and area of points, not saved")
+	annotate_parser.add_argument("items", nargs="*", metavar="LAT,LON or ID", help="points in decimal degrees, or annotation ids to remove")
+	annotate_parser.add_argument("--label", help="short name shown on the map (e.g., Team 3 sector)")
+	annotate_parser.add_argument("--note", help="longer text for the popup")
+	annotate_parser.add_argument("--author", help="who drew it (e.g., net control's call sign)")
+	annotate_parser.set_defaults(handler=annotate_command)
+
+	map_parser = subparsers.add_parser("map", help="render located messages as a PNG map over local tiles")
+	map_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	map_parser.add_argument("-o", "--output", default="map.png", help="PNG file to write (default: %(default)s)")
+	map_parser.add_argument("--tiles", metavar="FOLDER", help="local tiles laid out as {z}/{x}/{y}.png; without them the map is a plain grid")
+	map_parser.add_argument("--bbox", metavar="W,S,E,N", help="area to show (default: around the features)")
+	map_parser.add_argument("--zoom", type=int, help="tile zoom level (default: the highest that fits)")
+	map_parser.add_argument("--size", default=MAP_SIZE_DEFAULT, metavar="WxH", help="image size in pixels (default: %(default)s)")
+	map_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	map_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	map_parser.set_defaults(handler=map_command)
+
+	grid_parser = subparsers.add_parser("grid", help="report counts per Maidenhead grid square as a GeoJSON polygon layer")
+	grid_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	grid_parser.add_argument("-o", "--output", default="grid.geojson", help="GeoJSON file to write (default: %(default)s)")
+	grid_parser.add_argument("--precision", type=int, choices=GridDensity.PRECISIONS, default=GridDensity.PRECISION_DEFAULT,
+		help="locator characters per square: 2 (field), 4 (square), 6 (subsquare), or 8 (default: %(default)s)")
+	grid_parser.add_argument("--top", type=int, default=20, metavar="N", help="squares to list, busiest first (default: %(default)s)")
+	grid_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	grid_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	grid_parser.set_defaults(handler=grid_command)
+
+	density_parser = subparsers.add_parser("density", help="heatmap points and precomputed marker clusters for maps of many reports")
+	density_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	density_parser.add_argument("--heat", metavar="FILE", help="write [latitude, longitude, intensity] points for Leaflet.heat as JSON")
+	density_parser.add_argument("--weight", choices=PointDensity.WEIGHTS, default=PointDensity.COUNT, help="what each report adds to the heat (default: %(default)s)")
+	density_parser.add_argument("--clusters", metavar="FILE", help="write a GeoJSON layer of marker clusters for each zoom level")
+	density_parser.add_argument("--zooms", default=PointDensity.ZOOMS_DEFAULT, metavar="MIN-MAX", help="zoom levels to cluster for (default: %(default)s)")
+	density_parser.add_argument("--radius", type=int, default=PointDensity.CLUSTER_RADIUS_DEFAULT, metavar="PX", help="cluster cell size in pixels (default: %(default)s)")
+	density_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	density_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	density_parser.set_defaults(handler=density_command)
+
+	features_parser = subparsers.add_parser("features", help="stream located messages as newline-delimited GeoJSON features")
+	features_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	features_parser.add_argument("-o", "--output", metavar="FILE", help="file to write, or - for stdout (default: stdout, unless --url is given)")
+	features_parser.add_argument("--url", help="also stream the features to this URL as a chunked HTTP request")
+	features_parser.add_argument("--method", default="POST", choices=["POST", "PUT"], help="HTTP method for --url (default: %(default)s)")
+	features_parser.add_argument("--sorted", action="store_true", help="read everything first, then write in date order with replies linked and clocks corrected")
+	features_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	features_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	features_parser.set_defaults(handler=features_command)
+
+	bundle_parser = subparsers.add_parser("bundle", help="write a ZIP of the GeoJSON, KMZ, CSV log, ICS-309, and attachments for a time range")
+	bundle_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	bundle_parser.add_argument("-o", "--output", default="exercise.zip", help="ZIP file to write, or - for stdout (default: %(default)s)")
+	bundle_parser.add_argument("--start", metavar="TIME", help="only messages dated at or after this time, UTC unless a zone is given")
+	bundle_parser.add_argument("--end", metavar="TIME", help="only messages dated before this time, UTC unless a zone is given")
+	bundle_parser.add_argument("--name", default=ExportBundle.BUNDLE_NAME_DEFAULT, help="name for the map layers and the ICS-309 (default: %(default)s)")
+	bundle_parser.add_argument("--incident", help="incident name for the ICS-309")
+	bundle_parser.add_argument("--operator", help="radio operator name and call sign for the ICS-309")
+	bundle_parser.add_argument("--net", help="radio net name or tactical call for the ICS-309")
+	bundle_parser.add_argument("--tiles", metavar="FOLDER", help="local {z}/{x}/{y}.png tiles to draw the ICS-309 map over")
+	bundle_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	bundle_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	bundle_parser.set_defaults(handler=bundle_command)
+
+	print_parser = subparsers.add_parser("print", help="lay out a one-page PDF map for printing, with legend, scale bar, and title block")
+	print_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	print_parser.add_argument("-o", "--output", default="map.pdf", help="PDF to write (default: %(default)s)")
+	print_parser.add_argument("--paper", default=PrintLayout.PAPER_DEFAULT, choices=list(PrintLayout.PAPERS), help="paper size; letter is ANSI A (default: %(default)s)")
+	print_parser.add_argument("--orientation", default=PrintLayout.LANDSCAPE, choices=PrintLayout.ORIENTATIONS, help="(default: %(default)s)")
+	print_parser.add_argument("--title", help="title across the top and in the title block (default: Situation map)")
+	print_parser.add_argument("--incident", help="incident name for the title block")
+	print_parser.add_argument("--operator", help="who prepared the map, e.g. name and call sign")
+	print_parser.add_argument("--start", metavar="TIME", help="only messages dated at or after this time, UTC unless a zone is given")
+	print_parser.add_argument("--end", metavar="TIME", help="only messages dated before this time, UTC unless a zone is given")
+	print_parser.add_argument("--tiles", metavar="FOLDER", help="local {z}/{x}/{y}.png tiles to draw the map over; without them it is a plain grid")
+	print_parser.add_argument("--bbox", metavar="W,S,E,N", help="area to show (default: around the features)")
+	print_parser.add_argument("--zoom", type=int, help="tile zoom level (default: the highest that fits)")
+	print_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	print_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	print_parser.set_defaults(handler=print_command)
+
+	import_parser = subparsers.add_parser("import", help="merge Winlink Express CSV exports into a mailbox")
+	import_parser.add_argument("csv", nargs="+", metavar="file", help="CSV written by Winlink Express's Generate CSV")
+	import_parser.add_argument("-m", "--mailbox", default=MAILBOX_FOLDER_NAME, help="mailbox folder (default: %(default)s)")
+	import_parser.add_argument("--timezone", help="zone of the CSV's dates if they don't say (default: UTC)")
+	import_parser.add_argument("--path", help="how these messages arrived, recorded in the placeholders (e.g., HF, VHF)")
+	import_parser.set_defaults(handler=import_command)
+
+	quarantine_parser = subparsers.add_parser("quarantine", help="review and reprocess messages the server could not decode")
+	quarantine_parser.add_argument("action", nargs="?", choices=["list", "show", "reprocess", "discard"], default="list", help="what to do (default: %(default)s)")
+	quarantine_parser.add_argument("names", nargs="*", metavar="name", help="quarantined messages, by name or MID (reprocess: default all)")
+	quarantine_parser.add_argument("-m", "--mailbox", default=MAILBOX_FOLDER_NAME, help="mailbox folder (default: %(default)s)")
+	quarantine_parser.add_argument("--raw", action="store_true", help="show: dump the raw transfer even when there is a decoded message")
+	quarantine_parser.add_argument("--bytes", type=int, default=HEXDUMP_BYTES_DEFAULT, help="show: raw bytes to dump (default: %(default)s)")
+	quarantine_parser.set_defaults(handler=quarantine_command)
+
+	checkin_parser = subparsers.add_parser("checkin", help="write a Winlink Check-In form message ready to send")
+	checkin_parser.add_argument("--callsign", required=True, help="sending station")
+	checkin_parser.add_argument("--to", required=True, action="append", metavar="ADDRESS", help="recipient; repeat for more than one")
+	checkin_parser.add_argument("--position", metavar="LAT,LON", help="station position in decimal degrees")
+	checkin_parser.add_argument("--location", default="", help="location description")
+	checkin_parser.add_argument("--comments", default="", help="comments for net control")
+	checkin_parser.add_argument("--setting", default=OutboundMessage.SETTINGS[0], choices=OutboundMessage.SETTINGS, help="(default: %(default)s)")
+	checkin_parser.add_argument("--organization", default="", help="served agency or net")
+	checkin_parser.add_argument("-o", "--output", metavar="FILE", help="file to write (default: <MID>.b2f)")
+	checkin_parser.add_argument("--plain", action="store_true", help="write the uncompressed message, as Pat keeps it in its outbox")
+	checkin_parser.set_defaults(handler=checkin_command)
+
+	generate_parser = subparsers.add_parser("generate", help="synthesize exercise traffic for testing and training")
+	generate_parser.add_argument("-o", "--output", default="exercise", help="folder for the capture files (default: %(default)s)")
+	generate_parser.add_argument("-n", "--count", type=int, default=GENERATE_COUNT_DEFAULT, help="messages to write (default: %(default)s)")
+	generate_parser.add_argument("--bbox", default=GENERATE_BBOX_DEFAULT, metavar="W,S,E,N", help="area the stations are in (default: %(default)s)")
+	generate_parser.add_argument("--hours", type=float, default=GENERATE_HOURS_DEFAULT, help="length of the exercise (default: %(default)s)")
+	generate_parser.add_argument("--end", metavar="TIME", help="when the exercise ends, UTC unless a zone is given (default: now)")
+	generate_parser.add_argument("--to", action="append", default=None, metavar="ADDRESS", help=f"recipient; repeat to spread traffic over several (default: {GENERATE_RECIPIENT_DEFAULT})")
+	generate_parser.add_argument("--seed", type=int, help="random seed, to generate the same traffic again")
+	generate_parser.set_defaults(handler=generate_command)
+
+	connect_parser = subparsers.add_parser("connect", help="exchange messages directly with another station in a P2P session")
+	connect_parser.add_argument("station", metavar="HOST[:PORT]", help=f"station to call (port {BENCH_PORT_DEFAULT} if not given)")
+	connect_parser.add_argument("--callsign", required=True, help="your callsign")
+	connect_parser.add_argument("--password", help="password, if the station asks for one (default: the callsign)")
+	connect_parser.add_argument("--peer", metavar="CALLSIGN", help="send the messages addressed to this callsign (default: the station named in its prompt)")
+	connect_parser.add_argument("--outbox", default=OUTBOX_FOLDER_NAME, metavar="FOLDER", help=".b2f transfers to send, moved to sent/ once taken (default: %(default)s)")
+	connect_parser.add_argument("--mailbox", default=MAILBOX_FOLDER_NAME, metavar="FOLDER", help="where to save what the station sends (default: %(default)s)")
+	connect_parser.add_argument("--path", help="label for how the session is carried (e.g., mesh), recorded as the source of what is received")
+	connect_parser.set_defaults(handler=connect_command)
+
+	bench_parser = subparsers.add_parser("bench", help="measure a running server's ingestion rate and time to acknowledge")
+	bench_parser.add_argument("server", metavar="HOST[:PORT]", help=f"server to send to (port {BENCH_PORT_DEFAULT} if not given)")
+	bench_parser.add_argument("--rate", type=float, default=BENCH_RATE_DEFAULT, help="messages per second to offer (default: %(default)s)")
+	bench_parser.add_argument("--duration", type=float, default=BENCH_DURATION_DEFAULT, help="seconds to keep it up (default: %(default)s)")
+	bench_parser.add_argument("--batch", type=int, default=1, help="messages proposed per session (default: %(default)s)")
+	bench_parser.add_argument("--concurrency", type=int, default=BENCH_CONCURRENCY_DEFAULT, help="most sessions open at once (default: %(default)s)")
+	bench_parser.add_argument("--callsign", default="BENCH", help="callsign to log in with (default: %(default)s)")
+	bench_parser.add_argument("--bbox", default=GENERATE_BBOX_DEFAULT, metavar="W,S,E,N", help="area of the generated traffic (default: %(default)s)")
+	bench_parser.add_argument("--seed", type=int, help="random seed for the generated traffic")
+	bench_parser.add_argument("--mailbox", metavar="FOLDER", help="the server's mailbox folder, to count the messages it saved")
+	bench_parser.add_argument("--traces", metavar="FILE", help="the server's traces file, to time its parse and store stages")
+	bench_parser.add_argument("-o", "--output", metavar="FILE", help="write the results to a JSON file")
+	bench_parser.set_defaults(handler=bench_command)
+
+	schedule_parser = subparsers.add_parser("schedule", help="run export jobs from a config file on their schedules")
+	schedule_parser.add_argument("paths", nargs="*", metavar="path", help="default folders or files for jobs that don't name their own (default: the mailbox folder)")
+	schedule_parser.add_argument("-c", "--config", required=True, metavar="FILE", help="JSON file of export jobs")
+	schedule_parser.add_argument("--once", action="store_true", help="run every job once now and exit")
+	schedule_parser.add_argument("--incremental", action="store_true", help="geojson and ndjson jobs deliver only the features that changed since their last run")
+	schedule_parser.set_defaults(handler=schedule_command)
+
+	report_parser = subparsers.add_parser("report", help="distance, bearing, and coverage from a reference point")
+	report_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	report_parser.add_argument("-r", "--reference", required=True, metavar="LAT,LON", help="reference point (e.g., the EOC) in decimal degrees")
+	report_parser.add_argument("--rings", default=RINGS_KM_DEFAULT, metavar="KM,...", help="ring radii in km (default: %(default)s)")
+	report_parser.add_argument("--csv", metavar="FILE", help="write the per-station table as CSV")
+	report_parser.add_argument("--geojson", metavar="FILE", help="write the reference point and rings as GeoJSON")
+	# Also a global option; SUPPRESS keeps one given before the command from being reset here
+	report_parser.add_argument("--dem", metavar="FOLDER", default=argparse.SUPPRESS, help="folder of SRTM .hgt tiles; adds each station's elevation")
+	magnetic = report_parser.add_mutually_exclusive_group()
+	magnetic.add_argument("--wmm", metavar="FILE", help="World Magnetic Model WMM.COF file; adds magnetic bearings using today's declination at the reference point")
+	magnetic.add_argument("--declination", type=float, metavar="DEG", help="adds magnetic bearings using this declination (east positive)")
+	report_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	report_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	report_parser.set_defaults(handler=report_command)
+
+	clock_parser = subparsers.add_parser("clock", help="stations whose clocks are off, judged by when their messages were received")
+	clock_parser.add_argument("paths", nargs="+", metavar="path", help=".b2f file, mailbox headers file, or a folder searched for them")
+	clock_parser.add_argument("--source-path", metavar="PATH", help="only messages that arrived by this path (e.g., HF, VHF, mesh)")
+	clock_parser.add_argument("--gateway", metavar="CALLSIGN", help="only messages delivered by this gateway callsign")
+	clock_parser.set_defaults(handler=clock_command)
+
+	completion_parser = subparsers.add_parser("completion", help="print a shell completion script for esvmap")
+	completion_parser.add_argument("shell", choices=CommandDocs.SHELLS, help="the shell to complete in")
+	completion_parser.add_argument("-o", "--output", default="-", help="file to write (default: stdout)")
+	completion_parser.set_defaults(handler=completion_command)
+
+	manpage_parser = subparsers.add_parser("manpage", help="print a man page for esvmap, covering every command")
+	manpage_parser.add_argument("-o", "--output", default="-", help="file to write, e.g. esvmap.1 (default: stdout)")
+	manpage_parser.set_defaults(handler=manpage_command)
+
+	selftest_parser = subparsers.add_parser("selftest", help="run a sample message through the whole pipeline offline, before a node goes on the air")
+	selftest_parser.add_argument("-c", "--config", metavar="FILE", help="JSON file of export jobs, as for schedule, to build and dry-run")
+	selftest_parser.add_argument("--events", metavar="FILE", help=f"JSON file of event publishers to dry-run (default: {EVENTS_FILE_NAME}, if there is one)")
+	selftest_parser.add_argument("--alerts", metavar="FILE", help=f"JSON file of alert rules and notifiers to dry-run (default: {ALERTS_FILE_NAME}, if there is one)")
+	selftest_parser.set_defaults(handler=selftest_command)
+
+	args = parser.parse_args(argv)
+	args.parser = parser  # completion and manpage are built from the command tree
+
+	# Logging goes to stderr.  Configure it before any class does so these settings win.
+	if args.debug:
+		log_level = logging.DEBUG
+	elif args.quiet or args.json:
+		log_level = logging.CRITICAL
+	else:
+		log_level = logging.WARNING
+	logging.basicConfig(level=log_level, format="%(asctime)s - %(name)s - %(levelname)s - %(message)s")
+	Units.set_units(args.units)
+	ClockSkew.set_mode(args.clock)
+	StationIdentity.set_mode(args.stations)
+	if args.no_redaction:
+		Redaction.set_redaction(None)
+	elif args.redaction:
+		try:
+			Redaction.set_redaction(Redaction.load_redaction(args.redaction))
+		except (OSError, ValueError) as e:
+			print(f"Error: {args.redaction}: {e}", file=sys.stderr)
+			return EXIT_USAGE
+	translations = args.translations or (Translation.TRANSLATIONS_FILE_NAME if os.path.exists(Translation.TRANSLATIONS_FILE_NAME) else None)
+	try:
+		if translations:
+			Translation.load_translations(translations)
+		Translation.set_language(args.language)
+	except (OSError, ValueError) as e:
+		print(f"Error: {e}", file=sys.stderr)
+		return EXIT_USAGE
+	storage = args.storage or (BlobStore.STORAGE_FILE_NAME if os.path.exists(BlobStore.STORAGE_FILE_NAME) else None)
+	if storage:
+		try:
+			BlobStore.set_store(*BlobStore.load_storage(storage, enable_debug=args.debug))
+			set_index(load_index(storage))
+		except (OSError, ValueError) as e:
+			print(f"Error: {e}", file=sys.stderr)
+			return EXIT_USAGE
+	roster = args.roster or (StationRoster.ROSTER_FILE_NAME if os.path.exists(StationRoster.ROSTER_FILE_NAME) else None)
+	if roster:
+		try:
+			StationRoster.set_roster(StationRoster.Roster(roster, enable_debug=args.debug))
+			StationRoster.current_roster().load()
+		except (OSError, ValueError) as e:
+			print(f"Error: {e}", file=sys.stderr)
+			return EXIT_USAGE
+	if args.dem and not os.path.isdir(args.dem):
+		print(f"Error: {args.dem}: no such folder", file=sys.stderr)
+		return EXIT_USAGE
+	Elevation.set_model(ElevationModel(args.dem, enable_debug=args.debug) if args.dem else None)
+	zones = args.zones or (WeatherHazards.ZONES_FILE_NAME if os.path.exists(WeatherHazards.ZONES_FILE_NAME) else None)
+	if zones:
+		try:
+			WeatherHazards.set_zones(WeatherHazards.load_zones(zones))
+		except (OSError, ValueError) as e:
+			print(f"Error: {e}", file=sys.stderr)
+			return EXIT_USAGE
+	Annotations.set_store(Annotations.AnnotationStore(args.annotations or os.path.join(MAILBOX_FOLDER_NAME, Annotations.ANNOTATIONS_FILE_NAME), enable_debug=args.debug))
+	for filename in (args.mappings, args.styles):
+		if filename and not os.path.exists(filename):
+			print(f"Error: {filename}: no such file", file=sys.stderr)
+			return EXIT_USAGE
+	args.mapping_config = MappingConfig(args.mappings or MAPPINGS_FILE_NAME, args.styles or STYLES_FILE_NAME, enable_debug=args.debug)
+	try:
+		args.mapping_config.load()
+	except (OSError, ValueError) as e:
+		print(f"Error: {e}", file=sys.stderr)
+		return EXIT_USAGE
+
+	return args.handler(args)
+
+
+if __name__ == "__main__":
+	sys.exit(main())
@@ -8,21 +8,118 @@ __maintainer__ = __author__
 __email__ = "bob@rail.com"
 __status__ = "Experimental"
 
+import os
 import socket
 import threading
-from classes.WinlinkConnection import WinlinkConnection
+from classes.server.WinlinkConnection import WinlinkConnection
+from classes.server.AlertEngine import AlertEngine, ALERTS_FILE_NAME
+from classes.server.EventPublisher import load_event_publishers, EVENTS_FILE_NAME
+from classes.server.IngestPipeline import IngestPipeline
+from classes.store.IngestJournal import IngestJournal
+from classes.forms.MappingConfig import MappingConfig, MAPPINGS_FILE_NAME, STYLES_FILE_NAME
+from classes.store.Quarantine import Quarantine
+from classes.b2f.P2PSession import load_station, P2P_FILE_NAME
+from classes.server.Tracing import Tracer
+from classes.server import Tracing
+from classes.store import BlobStore
+from classes.store import SearchIndex
+from classes.store import StationRoster
+from classes.store.WinlinkMailMessage import MAILBOX_FOLDER_NAME, reprocess_quarantined
 
 LISTEN_IP = "0.0.0.0"
 LISTEN_PORT = 8772
 SIMULTANEOUS_CONNECTION_MAX = 5
 CONNECTION_READ_TIMEOUT_SECONDS = 1
+SOURCE_PATH = "mesh"  # Recorded with each message received by this listener
+INGEST_FILE_NAME = "ingest.json"  # Queue limits and overflow policy; the defaults apply if the file is absent
+SHUTDOWN_DRAIN_SECONDS = 30  # How long to let queued messages finish on shutdown
+JOURNAL_FILE_NAME = "ingest.journal"  # Raw transfers not yet saved; replayed at startup after a crash
+TRACING_FILE_NAME = "tracing.json"  # Where to send pipeline traces; tracing is off if the file is absent
 
 
 class WinlinkServer:
-	def __init__(self, host=LISTEN_IP, port=LISTEN_PORT):
+	def __init__(self, host=LISTEN_IP, port=LISTEN_PORT, source_path=SOURCE_PATH):
 		"""Initialize the server with default host and port."""
 		self.host = host
 		self.port = port
+		self.source_path = source_path
+		self.tracer = None
+		if os.path.exists(TRACING_FILE_NAME):
+			try:
+				self.tracer = Tracer.from_file(TRACING_FILE_NAME)
+				Tracing.configure(self.tracer)
+				print(f"Tracing the pipeline to {', '.join(str(e) for e in self.tracer.exporters)}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {TRACING_FILE_NAME} - {e}")
+		if os.path.exists(BlobStore.STORAGE_FILE_NAME):
+			try:
+				store, raw = BlobStore.load_storage(BlobStore.STORAGE_FILE_NAME)
+				BlobStore.set_store(store, raw)
+				if store is not None:
+					print(f"Saving attachments{' and raw messages' if raw else ''} to blob store {store.name}")
+				index = SearchIndex.load_index(BlobStore.STORAGE_FILE_NAME)
+				SearchIndex.set_index(index)
+				if index is not None:
+					print(f"Indexing messages in {index.path} as node {index.node}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {BlobStore.STORAGE_FILE_NAME} - {e}")
+		if os.path.exists(StationRoster.ROSTER_FILE_NAME):
+			try:
+				roster = StationRoster.Roster(StationRoster.ROSTER_FILE_NAME)
+				print(f"Loaded {len(roster.load())} stations from {StationRoster.ROSTER_FILE_NAME}")
+				StationRoster.set_roster(roster)
+			except (OSError, ValueError) as e:
+				print(f"Error loading {StationRoster.ROSTER_FILE_NAME} - {e}")
+		self.station = self.outbox = None
+		if os.path.exists(P2P_FILE_NAME):
+			try:
+				self.station, self.outbox = load_station(P2P_FILE_NAME)
+				print(f"Answering P2P sessions as {self.station}, with messages from {self.outbox.folder}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {P2P_FILE_NAME} - {e}")
+		self.alerts = None
+		if os.path.exists(ALERTS_FILE_NAME):
+			try:
+				self.alerts = AlertEngine.from_file(ALERTS_FILE_NAME)
+				print(f"Loaded {len(self.alerts.rules)} alert rules from {ALERTS_FILE_NAME}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {ALERTS_FILE_NAME} - {e}")
+		self.events = []
+		if os.path.exists(EVENTS_FILE_NAME):
+			try:
+				self.events = load_event_publishers(EVENTS_FILE_NAME)
+				print(f"Loaded {len(self.events)} event publishers from {EVENTS_FILE_NAME}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {EVENTS_FILE_NAME} - {e}")
+		self.journal = IngestJournal(JOURNAL_FILE_NAME)
+		self.pipeline = None
+		if os.path.exists(INGEST_FILE_NAME):
+			try:
+				self.pipeline = IngestPipeline.from_file(INGEST_FILE_NAME, self.alerts, self.events, self.journal)
+				print(f"Loaded ingest settings from {INGEST_FILE_NAME}")
+			except (OSError, ValueError) as e:
+				print(f"Error loading {INGEST_FILE_NAME} - {e}")
+		if self.pipeline is None:
+			self.pipeline = IngestPipeline(self.alerts, self.events, journal=self.journal)
+		queue = self.pipeline.queue
+		print(f"Ingest queue holds {queue.capacity} messages or {queue.max_bytes} bytes; when full: {queue.policy}")
+		# Form mappings and styles are watched and reloaded while the server runs
+		self.mappings = MappingConfig(on_change=self.mappings_changed)
+		try:
+			for filename in self.mappings.load():
+				print(f"Loaded form mappings and styles from {filename}")
+		except (OSError, ValueError) as e:
+			print(f"Error loading {MAPPINGS_FILE_NAME} or {STYLES_FILE_NAME} - {e}")
+
+	def mappings_changed(self, mappings):
+		"""After the mappings change, optionally try the quarantined messages again with them."""
+		if not self.mappings.reprocess_failed:
+			return
+		quarantine = Quarantine(MAILBOX_FOLDER_NAME)
+		entries = quarantine.entries()
+		recovered = sum(1 for entry in entries if reprocess_quarantined(quarantine, entry) is not None)
+		if entries:
+			print(f"Recovered {recovered} of {len(entries)} quarantined messages with the new mappings")
 
 	def start_server(self):
 		"""Main listening loop that accepts new connections."""
@@ -33,6 +130,10 @@ class WinlinkServer:
 			print(f"Error binding to {self.host}:{self.port} - {e}")
 			return
 		server_socket.listen(SIMULTANEOUS_CONNECTION_MAX)  
+		replayed = self.pipeline.replay()
+		if replayed:
+			print(f"Replayed {replayed} messages left unsaved in {JOURNAL_FILE_NAME}")
+		self.mappings.watch()
 		print(f"Server is listening on {self.host}:{self.port}")
 
 		try:
@@ -42,13 +143,20 @@ class WinlinkServer:
 				print(f"Connection established with {address}")
 
 				# Fork a new thread to handle the connection
-				handler = WinlinkConnection(connection, address, timeout=CONNECTION_READ_TIMEOUT_SECONDS, enable_debug=True)
+				handler = WinlinkConnection(connection, address, timeout=CONNECTION_READ_TIMEOUT_SECONDS, enable_debug=True, source_path=self.source_path, pipeline=self.pipeline,
+					station=self.station, outbox=self.outbox)
 				threading.Thread(target=handler.handle_connection).start()
 		
 		except KeyboardInterrupt:
 			print("Winlink Server interrupted, shutting down...")
 		finally:
 			server_socket.close()
+			self.mappings.stop()
+			self.pipeline.close(SHUTDOWN_DRAIN_SECONDS)
+			print(f"Ingest pipeline: {self.pipeline.stats()}")
+			if self.tracer is not None:
+				self.tracer.close()
+				print(f"Tracing: {self.tracer.stats()}")
 
 if __name__ == "__main__":
 	server = WinlinkServer()
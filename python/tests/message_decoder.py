@@ -17,15 +17,15 @@ sys.path.insert(0, src_path)
 
 import datetime
 import logging
-from classes.B2Message import B2Message 
+from classes.b2f.B2Message import B2Message 
 
 def extract(filename, uncompressed_len, compressed_len):
-	with open(f'{this_path}/testdata/{filename}.b2f', 'rb') as f:
+	with open(os.path.join(this_path, 'testdata', f'{filename}.b2f'), 'rb') as f:
 		raw_data = f.read()
 	z, uncompressed = B2Message(filename, raw_data, uncompressed_len, compressed_len, True)
 
 	# <DEBUGGING>
-	with open(f'{this_path}/testdata/{filename}.Z', 'wb') as f:
+	with open(os.path.join(this_path, 'testdata', f'{filename}.Z'), 'wb') as f:
 		f.write(z)  # Do not skip over the CRC-16 and the 4 byte length field
 	# </DEBUGGING>
 
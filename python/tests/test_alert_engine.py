@@ -0,0 +1,191 @@
+#!/usr/bin/env python
+'''Alert rules, and the redacted alerts sent to ntfy, Pushover, and webhooks'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import json
+import tempfile
+import unittest
+import urllib.parse
+from unittest import mock
+from classes.server.AlertEngine import AlertEngine, AlertRule, Geofence, INSIDE, OUTSIDE
+from classes.geo import Geo
+from classes.b2f.B2Message import B2Message
+from classes.server.Notifier import NtfyNotifier, PushoverNotifier, WebhookNotifier
+from classes.b2f import OutboundMessage
+from classes.forms import Redaction
+
+PHONE = "650-555-1234"
+
+# A 1 degree square with a hole in its middle, and a second square to the east
+SQUARE = [[-123.0, 37.0], [-122.0, 37.0], [-122.0, 38.0], [-123.0, 38.0], [-123.0, 37.0]]
+HOLE = [[-122.6, 37.4], [-122.4, 37.4], [-122.4, 37.6], [-122.6, 37.6], [-122.6, 37.4]]
+EAST = [[-121.0, 37.0], [-120.0, 37.0], [-120.0, 38.0], [-121.0, 38.0], [-121.0, 37.0]]
+
+
+def decoded(subject, sender="N0CALL", mid="AAAAAAAAAAAA", location=(37.5, -122.25), form=None):
+	"""A message decoded from its text; form is (form type, variables) for a form attachment."""
+	files = [OutboundMessage.form_attachment(form[0], sender, form[1])] if form else []
+	text = OutboundMessage.message_text(mid, sender, ["EOC"], subject, "Body", files=files, location=location)
+	message = B2Message(mid, b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+class AlertRuleTest(unittest.TestCase):
+
+	def test_each_condition_must_hold(self):
+		message = decoded("//WL2K P/ Generator out", sender="N0CALL-7", form=("ICS213", {"message": "Out"}))
+		self.assertTrue(AlertRule("all", []).matches(message))
+		self.assertTrue(AlertRule("urgent", [], min_urgency=1).matches(message))
+		self.assertFalse(AlertRule("urgent", [], min_urgency=2).matches(message))
+		self.assertTrue(AlertRule("form", [], form_type="ICS213").matches(message))
+		self.assertFalse(AlertRule("form", [], form_type="Winlink_Check_In").matches(message))
+		self.assertTrue(AlertRule("station", [], sender="n0call").matches(message))  # Any SSID
+		self.assertFalse(AlertRule("station", [], sender="N1CALL").matches(message))
+		self.assertTrue(AlertRule("subject", [], subject=r"generator\s+out").matches(message))
+		self.assertFalse(AlertRule("subject", [], subject="shelter").matches(message))
+		self.assertFalse(AlertRule("both", [], min_urgency=1, subject="shelter").matches(message))
+
+	def test_a_bad_when_is_refused(self):
+		with self.assertRaises(ValueError):
+			AlertRule("rule", [], when="near")
+
+
+class GeofenceTest(unittest.TestCase):
+
+	def test_point_in_polygon(self):
+		polygon = {"type": "Polygon", "coordinates": [SQUARE, HOLE]}
+		self.assertTrue(Geo.point_in_geometry(37.2, -122.8, polygon))
+		self.assertFalse(Geo.point_in_geometry(37.5, -122.5, polygon))  # In the hole
+		self.assertFalse(Geo.point_in_geometry(38.5, -122.5, polygon))
+		self.assertFalse(Geo.point_in_geometry(37.5, -121.5, polygon))
+
+	def test_point_in_multipolygon(self):
+		multipolygon = {"type": "MultiPolygon", "coordinates": [[SQUARE], [EAST]]}
+		self.assertTrue(Geo.point_in_geometry(37.5, -122.5, multipolygon))
+		self.assertTrue(Geo.point_in_geometry(37.5, -120.5, multipolygon))
+		self.assertFalse(Geo.point_in_geometry(37.5, -121.5, multipolygon))  # Between them
+		self.assertFalse(Geo.point_in_geometry(37.5, -122.5, {"type": "Point", "coordinates": [-122.5, 37.5]}))
+
+	def test_only_polygons_are_geofences(self):
+		with self.assertRaises(ValueError):
+			Geofence("line", {"type": "LineString", "coordinates": SQUARE})
+
+	def test_inside_and_outside(self):
+		zone = Geofence("West", {"type": "Polygon", "coordinates": [SQUARE]})
+		inside, outside = decoded("Here", location=(37.5, -122.5)), decoded("Here", location=(37.5, -121.5))
+		self.assertTrue(AlertRule("in", [], geofence=zone, when=INSIDE).matches(inside))
+		self.assertFalse(AlertRule("in", [], geofence=zone, when=INSIDE).matches(outside))
+		self.assertTrue(AlertRule("out", [], geofence=zone, when=OUTSIDE).matches(outside))
+		self.assertFalse(AlertRule("out", [], geofence=zone, when=OUTSIDE).matches(inside))
+
+	def test_the_role_filter(self):
+		zone = Geofence("West", {"type": "Polygon", "coordinates": [SQUARE]})
+		# The station reports from inside the zone; the incident in its form is outside it
+		message = decoded("Road closed", location=(37.5, -122.5), form=("Damage_Assessment", {"incident_lat": "37.5", "incident_lon": "-121.5"}))
+		self.assertTrue(AlertRule("any", [], geofence=zone).matches(message))
+		self.assertTrue(AlertRule("reporter", [], geofence=zone, role="reporter").matches(message))
+		self.assertFalse(AlertRule("incident", [], geofence=zone, role="incident").matches(message))
+		self.assertTrue(AlertRule("incident", [], geofence=zone, when=OUTSIDE, role="incident").matches(message))
+		self.assertFalse(AlertRule("any", [], geofence=zone, when=OUTSIDE).matches(message))  # The reporter is inside
+		self.assertFalse(AlertRule("shelter", [], geofence=zone, role="shelter").matches(message))  # No such position
+
+	def test_a_message_with_no_position_never_matches_a_geofence(self):
+		zone = Geofence("West", {"type": "Polygon", "coordinates": [SQUARE]})
+		message = decoded("Somewhere", location=None)
+		self.assertEqual(message.positions(), [])
+		self.assertFalse(AlertRule("in", [], geofence=zone).matches(message))
+		self.assertFalse(AlertRule("out", [], geofence=zone, when=OUTSIDE).matches(message))
+		self.assertTrue(AlertRule("no zone", []).matches(message))
+
+	def test_zones_are_loaded_with_the_rules(self):
+		with tempfile.TemporaryDirectory() as folder:
+			with open(os.path.join(folder, "zones.geojson"), 'w') as f:
+				json.dump({"type": "FeatureCollection", "features": [
+					{"type": "Feature", "properties": {"name": "West"}, "geometry": {"type": "Polygon", "coordinates": [SQUARE]}},
+					{"type": "Feature", "properties": {}, "geometry": {"type": "MultiPolygon", "coordinates": [[EAST]]}},
+				]}, f)
+			filename = os.path.join(folder, "alerts.json")
+			with open(filename, 'w') as f:
+				json.dump({"notifiers": {"eoc": {"type": "ntfy", "url": "http://ntfy.local.mesh", "topic": "esv"}}, "geofences": "zones.geojson",
+					"rules": [{"name": "east", "geofence": "zone 2", "notify": ["eoc"]}]}, f)
+			engine = AlertEngine.from_file(filename)
+			self.assertEqual(engine.rules[0].geofence.name, "zone 2")
+			self.assertTrue(engine.rules[0].matches(decoded("Here", location=(37.5, -120.5))))
+			with open(filename, 'w') as f:
+				json.dump({"geofences": "zones.geojson", "rules": [{"name": "bad", "geofence": "North"}]}, f)
+			with self.assertRaises(ValueError):
+				AlertEngine.from_file(filename)
+
+
+class RedactedAlertTest(unittest.TestCase):
+
+	def setUp(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		self.ntfy = NtfyNotifier("phones", "http://ntfy.local.mesh/", "esv alerts", token="t0ken")
+		self.pushover = PushoverNotifier("pushover", "app", "user")
+		self.webhook = WebhookNotifier("hook", "http://hooks.local.mesh/esv")
+		self.posts = {}
+		for notifier in (self.ntfy, self.pushover, self.webhook):
+			notifier._post = mock.Mock(side_effect=lambda url, data, headers, name=notifier.name: self.posts.setdefault(name, (url, data, headers)))
+		self.engine = AlertEngine({"phones": self.ntfy, "pushover": self.pushover, "hook": self.webhook}, [AlertRule("all", ["phones", "pushover", "hook"])])
+		self.message = decoded(f"//WL2K O/ Call Jane at {PHONE}", form=("Welfare_Inquiry", {
+			"subject_name": "Jane Doe", "subject_latitude": "37.6", "subject_longitude": "-122.3"}))
+
+	def tearDown(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+
+	def test_ntfy_request(self):
+		self.assertEqual(self.engine.evaluate(self.message), ["all"])
+		url, data, headers = self.posts["phones"]
+		self.assertEqual(url, "http://ntfy.local.mesh/esv%20alerts")
+		self.assertEqual(headers["Title"], f"Immediate from N0CALL: //WL2K O/ Call Jane at {Redaction.REDACTED}")
+		self.assertEqual(headers["Priority"], "urgent")
+		self.assertEqual(headers["Authorization"], "Bearer t0ken")
+		self.assertTrue(data.decode("utf-8").startswith("N0CALL to EOC at 37.50000, -122.25000, "))
+		self.assertTrue(data.decode("utf-8").endswith(" (Welfare_Inquiry)"))
+
+	def test_pushover_request(self):
+		self.engine.evaluate(self.message)
+		url, data, headers = self.posts["pushover"]
+		form = dict(urllib.parse.parse_qsl(data.decode("utf-8")))
+		self.assertEqual((form["token"], form["user"], form["priority"]), ("app", "user", "1"))
+		self.assertNotIn(PHONE, form["title"])
+		self.assertIn(Redaction.REDACTED, form["title"])
+		self.assertEqual(headers["Content-Type"], "application/x-www-form-urlencoded")
+
+	def test_webhook_details_leave_out_redacted_positions(self):
+		self.assertIn(("subject", 37.6, -122.3), self.message.positions())
+		self.engine.evaluate(self.message)
+		body = json.loads(self.posts["hook"][1])
+		self.assertNotIn(PHONE, json.dumps(body))
+		self.assertEqual(body["details"]["positions"], [["reporter", 37.5, -122.25]])
+		Redaction.set_redaction(None)
+		self.posts.clear()
+		self.engine.evaluate(self.message)
+		body = json.loads(self.posts["hook"][1])
+		self.assertIn(PHONE, body["details"]["subject"])
+		self.assertIn(["subject", 37.6, -122.3], body["details"]["positions"])
+
+	def test_a_probable_duplicate_raises_no_alert(self):
+		self.message.duplicate_of = "BBBBBBBBBBBB"
+		self.assertEqual(self.engine.evaluate(self.message), [])
+		self.assertEqual(self.posts, {})
+
+
+if __name__ == '__main__':
+	unittest.main()
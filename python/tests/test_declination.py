@@ -0,0 +1,90 @@
+#!/usr/bin/env python
+'''Magnetic declination from World Magnetic Model coefficients, checked against fields known exactly'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import math
+import tempfile
+import unittest
+from datetime import datetime, timezone
+from classes.geo import Geo
+from classes.geo.Declination import MagneticModel, WGS84_A, WMM_REFERENCE_RADIUS, decimal_year
+
+EPOCH = datetime(2020, 1, 1, tzinfo=timezone.utc)
+# At the equator, at sea level, the geodetic and geocentric frames agree and r is the equatorial radius
+EQUATOR_RATIO = (WMM_REFERENCE_RADIUS / WGS84_A) ** 3
+
+
+class DeclinationTest(unittest.TestCase):
+	"""Models of a dipole or two, in the .COF layout, whose field can be worked out by hand."""
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def model(self, *terms):
+		filename = os.path.join(self.folder.name, "WMM.COF")
+		with open(filename, 'w') as f:
+			f.write("    2020.0            WMM-TEST        01/01/2020\n")
+			for term in terms:
+				f.write(" ".join(f"{v:>10}" for v in term) + "\n")
+			f.write("9" * 48 + "\n")
+		return MagneticModel(filename)
+
+	def test_an_axial_dipole_points_at_true_north(self):
+		model = self.model((1, 0, -30000.0, 0.0, 0.0, 0.0))
+		north, east, down = model.field(0.0, 0.0, when=EPOCH)
+		self.assertAlmostEqual(north, 30000.0 * EQUATOR_RATIO, places=6)
+		self.assertAlmostEqual(east, 0.0, places=6)
+		self.assertAlmostEqual(down, 0.0, places=6)
+		for latitude, longitude in [(37.4, -122.1), (-33.9, 151.2), (64.8, -147.7)]:
+			self.assertAlmostEqual(model.declination(latitude, longitude, when=EPOCH), 0.0, places=9)
+
+	def test_a_tilted_dipole_at_the_equator(self):
+		# g10 pulls north and h11 pulls west at longitude 0, so the declination is atan2(-h11, -g10)
+		model = self.model((1, 0, -30000.0, 0.0, 0.0, 0.0), (1, 1, 0.0, 5000.0, 0.0, 0.0))
+		north, east, _ = model.field(0.0, 0.0, when=EPOCH)
+		self.assertAlmostEqual(north, 30000.0 * EQUATOR_RATIO, places=6)
+		self.assertAlmostEqual(east, -5000.0 * EQUATOR_RATIO, places=6)
+		self.assertAlmostEqual(model.declination(0.0, 0.0, when=EPOCH), math.degrees(math.atan2(-5000.0, 30000.0)), places=9)
+		self.assertAlmostEqual(model.declination(0.0, 0.0, when=EPOCH), -9.462322208, places=6)
+		# A quarter turn east, h11 points along the meridian instead and the declination is gone
+		self.assertAlmostEqual(model.declination(0.0, 90.0, when=EPOCH), 0.0, places=9)
+
+	def test_secular_variation_moves_the_coefficients(self):
+		model = self.model((1, 0, -30000.0, 0.0, 100.0, 0.0), (1, 1, 0.0, 5000.0, 0.0, -500.0))
+		when = datetime(2022, 1, 1, tzinfo=timezone.utc)
+		north, east, _ = model.field(0.0, 0.0, when=when)
+		self.assertAlmostEqual(north, 29800.0 * EQUATOR_RATIO, places=6)
+		self.assertAlmostEqual(east, -4000.0 * EQUATOR_RATIO, places=6)
+
+	def test_decimal_year(self):
+		self.assertEqual(decimal_year(EPOCH), 2020.0)
+		self.assertAlmostEqual(decimal_year(datetime(2021, 7, 2, 12, tzinfo=timezone.utc)), 2021.5, places=9)
+
+	def test_a_file_without_coefficients_is_refused(self):
+		with self.assertRaises(ValueError):
+			self.model()
+
+	def test_magnetic_bearing(self):
+		self.assertAlmostEqual(Geo.magnetic_bearing(90.0, 13.0), 77.0)
+		self.assertAlmostEqual(Geo.magnetic_bearing(5.0, 13.0), 352.0)
+		self.assertAlmostEqual(Geo.magnetic_bearing(350.0, -15.0), 5.0)
+
+
+if __name__ == '__main__':
+	unittest.main()
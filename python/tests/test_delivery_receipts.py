@@ -0,0 +1,67 @@
+#!/usr/bin/env python
+'''Delivery and read receipts, matched to the messages they confirm by MID'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import unittest
+from classes.b2f import DeliveryReceipts
+
+
+class Message:
+
+	def __init__(self, mid, sender, subject, body="", minute=0):
+		self.mid = mid
+		self.sender = sender
+		self.recipient = "EOC"
+		self.subject = subject
+		self.body = body
+		self.form = None
+		self.date = datetime.datetime(2025, 10, 4, 12, minute, tzinfo=datetime.timezone.utc)
+
+
+class ReceiptTest(unittest.TestCase):
+
+	def test_receipt_subjects(self):
+		for subject, kind in [("Read receipt: Shelter count", DeliveryReceipts.READ), ("Message read", DeliveryReceipts.READ),
+				("Delivery confirmation", DeliveryReceipts.DELIVERED), ("//WL2K P/ ACK: Cots", DeliveryReceipts.DELIVERED),
+				("Message Delivered AAAAAAAAAAAA", DeliveryReceipts.DELIVERED), ("Shelter count", None), ("Re: Cots", None),
+				("Acknowledged staffing plan", None), ("Delivery of cots", None)]:
+			self.assertEqual(DeliveryReceipts.receipt_kind(Message("ZZZZZZZZZZZZ", "W1AW", subject)), kind, subject)
+
+	def test_receipts_confirm_the_quoted_mid(self):
+		first = Message("AAAAAAAAAAAA", "N0CALL", "Shelter count")
+		second = Message("BBBBBBBBBBBB", "N0CALL", "Cots", minute=1)
+		third = Message("CCCCCCCCCCCC", "N0CALL", "Water", minute=2)
+		delivered = Message("DDDDDDDDDDDD", "W1AW-10", "Delivery confirmation", body="Your message AAAAAAAAAAAA was delivered", minute=3)
+		read = Message("EEEEEEEEEEEE", "W1AW", "Read receipt: Shelter count", body="MID: AAAAAAAAAAAA", minute=4)
+		ack = Message("FFFFFFFFFFFF", "W1AW", "ACK BBBBBBBBBBBB", minute=5)
+		stray = Message("GGGGGGGGGGGG", "W1AW", "Delivery confirmation", body="MID: 999999999999", minute=6)
+		deliveries, unmatched = DeliveryReceipts.track_deliveries([stray, ack, read, delivered, third, second, first])
+		self.assertEqual([d.message.mid for d in deliveries], ["AAAAAAAAAAAA", "BBBBBBBBBBBB", "CCCCCCCCCCCC"])
+		self.assertEqual([d.status for d in deliveries], [DeliveryReceipts.READ, DeliveryReceipts.DELIVERED, DeliveryReceipts.UNCONFIRMED])
+		self.assertEqual([r["mid"] for r in deliveries[0].as_dict()["receipts"]], ["DDDDDDDDDDDD", "EEEEEEEEEEEE"])
+		self.assertEqual(unmatched, [stray])
+
+	def test_a_station_does_not_confirm_its_own_message(self):
+		sent = Message("AAAAAAAAAAAA", "N0CALL", "Shelter count")
+		echo = Message("BBBBBBBBBBBB", "N0CALL-7", "Delivery confirmation", body="AAAAAAAAAAAA", minute=1)
+		deliveries, unmatched = DeliveryReceipts.track_deliveries([sent, echo])
+		self.assertEqual(deliveries[0].status, DeliveryReceipts.UNCONFIRMED)
+		self.assertEqual(unmatched, [echo])
+
+
+if __name__ == '__main__':
+	unittest.main()
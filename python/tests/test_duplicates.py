@@ -0,0 +1,127 @@
+#!/usr/bin/env python
+'''Re-sent messages flagged by the mailbox's content index and among captures read together'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import argparse
+import json
+import tempfile
+import unittest
+from classes.b2f.B2Message import B2Message
+from classes.store import ContentIndex
+from classes.store.WinlinkMailMessage import WinlinkMailMessage
+import esvmap
+
+
+def decoded(mid, date, body="Water over the road at Elm and 3rd\r\n"):
+	"""A message decoded from its text, without a compressed transfer."""
+	data = body.encode("ascii")
+	text = f"Mid: {mid}\r\nDate: {date}\r\nFrom: N0CALL\r\nTo: EOC\r\nSubject: Road closed\r\nBody: {len(data)}\r\n\r\n".encode("ascii") + data + b"\r\n"
+	message = B2Message(mid, b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+def duplicate_warnings(message):
+	return [w for w in message.warnings if w.startswith("Probable duplicate")]
+
+
+class ContentIndexTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		ContentIndex._indexes.clear()  # Each test starts from what is on disk
+
+	def tearDown(self):
+		self.folder.cleanup()
+		ContentIndex._indexes.clear()
+
+	def test_first_mid_is_kept(self):
+		index = ContentIndex.ContentIndex(self.folder.name)
+		self.assertIsNone(index.check_and_add("h1", "AAAAAAAAAAAA"))
+		self.assertIsNone(index.check_and_add("h1", "AAAAAAAAAAAA"))  # The same message seen again
+		self.assertEqual(index.check_and_add("h1", "BBBBBBBBBBBB"), "AAAAAAAAAAAA")
+		self.assertEqual(ContentIndex.ContentIndex(self.folder.name).check_and_add("h1", "CCCCCCCCCCCC"), "AAAAAAAAAAAA")
+
+	def test_each_hash_is_appended_once(self):
+		index = ContentIndex.ContentIndex(self.folder.name)
+		for n in range(50):
+			index.check_and_add(f"h{n}", f"MID{n:09d}")
+			index.check_and_add(f"h{n}", f"MID{n:09d}")
+		with open(index.path, 'r') as f:
+			lines = f.read().splitlines()
+		self.assertEqual(len(lines), 50)
+		self.assertEqual(json.loads(lines[-1]), {"hash": "h49", "mid": "MID000000049"})
+
+	def test_lines_written_by_another_process_are_read(self):
+		index = ContentIndex.ContentIndex(self.folder.name)
+		index.check_and_add("h1", "AAAAAAAAAAAA")
+		with open(index.path, 'a') as f:
+			f.write(json.dumps({"hash": "h2", "mid": "BBBBBBBBBBBB"}) + "\n")
+		self.assertEqual(index.check_and_add("h2", "CCCCCCCCCCCC"), "BBBBBBBBBBBB")
+
+	def test_a_torn_line_is_passed_over(self):
+		index = ContentIndex.ContentIndex(self.folder.name)
+		with open(index.path, 'w') as f:
+			f.write(json.dumps({"hash": "h1", "mid": "AAAAAAAAAAAA"}) + '\n{"hash": "h2", "mi')
+		self.assertIsNone(index.check_and_add("h3", "CCCCCCCCCCCC"))
+		ContentIndex._indexes.clear()
+		reread = ContentIndex.ContentIndex(self.folder.name)
+		self.assertEqual(reread.check_and_add("h1", "XXXXXXXXXXXX"), "AAAAAAAAAAAA")
+		self.assertEqual(reread.check_and_add("h3", "XXXXXXXXXXXX"), "CCCCCCCCCCCC")
+
+	def test_an_index_from_before_is_read(self):
+		with open(os.path.join(self.folder.name, ContentIndex.LEGACY_CONTENT_INDEX_FILE_NAME), 'w') as f:
+			json.dump({"h1": "AAAAAAAAAAAA"}, f)
+		self.assertEqual(ContentIndex.ContentIndex(self.folder.name).check_and_add("h1", "BBBBBBBBBBBB"), "AAAAAAAAAAAA")
+
+
+class FlagDuplicatesTest(unittest.TestCase):
+
+	def test_later_copies_are_flagged(self):
+		resent = decoded("BBBBBBBBBBBB", "2025/10/04 12:30")
+		first = decoded("AAAAAAAAAAAA", "2025/10/04 12:00")
+		other = decoded("CCCCCCCCCCCC", "2025/10/04 12:10", body="All clear\r\n")
+		again = decoded("AAAAAAAAAAAA", "2025/10/04 12:00")  # The same transfer in a second capture
+		self.assertEqual(ContentIndex.flag_duplicates([resent, first, other, again]), [resent])
+		self.assertEqual(resent.duplicate_of, "AAAAAAAAAAAA")
+		self.assertEqual(duplicate_warnings(resent), ["Probable duplicate of AAAAAAAAAAAA"])
+		for message in (first, other, again):
+			self.assertIsNone(message.duplicate_of)
+
+	def test_messages_already_flagged_are_left_alone(self):
+		first = decoded("AAAAAAAAAAAA", "2025/10/04 12:00")
+		resent = decoded("BBBBBBBBBBBB", "2025/10/04 12:30")
+		resent.duplicate_of = "AAAAAAAAAAAA"
+		self.assertEqual(ContentIndex.flag_duplicates([first, resent]), [])
+		self.assertEqual(duplicate_warnings(resent), [])
+
+	def test_a_load_of_separate_mailboxes_flags_re_sends(self):
+		with tempfile.TemporaryDirectory() as folder:
+			for mid, date, station in [("AAAAAAAAAAAA", "2025/10/04 12:00", "north"), ("BBBBBBBBBBBB", "2025/10/04 12:30", "south")]:
+				mail = WinlinkMailMessage("EM", mid, 100, 50, folder=os.path.join(folder, station))
+				mail.b2 = decoded(mid, date)
+				mail.save_message_to_files()
+				self.assertIsNone(mail.b2.duplicate_of)  # Each mailbox has seen it once
+			report = esvmap.Report("map", argparse.Namespace(quiet=True, json=False))
+			messages = esvmap.load_messages([folder], report)
+		flagged = {m.mid: m.duplicate_of for m in messages}
+		self.assertEqual(flagged, {"AAAAAAAAAAAA": None, "BBBBBBBBBBBB": "AAAAAAAAAAAA"})
+		self.assertEqual(sum(len([w for w in m["warnings"] if w.startswith("Probable duplicate")]) for f in report.files for m in f["messages"]), 1)
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,83 @@
+#!/usr/bin/env python
+'''Ground elevation of exported features from SRTM tiles, given with --dem or per export job'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import json
+import struct
+import tempfile
+import unittest
+from classes.b2f.B2Message import B2Message
+from classes.geo import Elevation
+from classes.export import MapExport
+from classes.b2f import OutboundMessage
+from classes.server.ExportScheduler import ExportJob
+
+SIZE = 1201  # A 3" tile
+
+
+def located(latitude, longitude):
+	text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Check in", "Here", location=(latitude, longitude))
+	message = B2Message("AAAAAAAAAAAA", b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+class ElevationTest(unittest.TestCase):
+
+	@classmethod
+	def setUpClass(cls):
+		cls.folder = tempfile.TemporaryDirectory()
+		with open(os.path.join(cls.folder.name, "N37W123.hgt"), 'wb') as f:
+			f.write(struct.pack(">h", 152) * (SIZE * SIZE))
+		cls.model = Elevation.ElevationModel(cls.folder.name)
+
+	@classmethod
+	def tearDownClass(cls):
+		cls.folder.cleanup()
+
+	def test_features_have_elevations_only_with_a_model(self):
+		message = located(37.5, -122.5)
+		[feature] = MapExport.message_features(message)
+		self.assertNotIn("elevation_m", feature["properties"])
+		with Elevation.using(self.model):
+			[feature] = MapExport.message_features(message)
+			self.assertEqual(feature["properties"]["elevation_m"], 152.0)
+			self.assertEqual(MapExport.message_properties(message)["elevation_m"], 152.0)
+			[outside] = MapExport.message_features(located(40.5, -122.5))  # No tile there
+			self.assertIsNone(outside["properties"]["elevation_m"])
+		self.assertIsNone(Elevation.current_model())
+
+	def test_the_global_model_and_a_job_s_own(self):
+		Elevation.set_model(self.model)
+		try:
+			[feature] = MapExport.message_features(located(37.5, -122.5))
+			self.assertEqual(feature["properties"]["elevation_m"], 152.0)
+		finally:
+			Elevation.set_model(None)
+		job = ExportJob("elevations", "geojson", every="1m", output=os.path.join(self.folder.name, "out.geojson"), dem=self.folder.name)
+		plain = ExportJob("plain", "geojson", every="1m", output=os.path.join(self.folder.name, "plain.geojson"))
+		shared = {}
+		[feature] = json.loads(job.build([located(37.5, -122.5)], shared))["features"]
+		self.assertEqual(feature["properties"]["elevation_m"], 152.0)
+		[feature] = json.loads(plain.build([located(37.5, -122.5)], shared))["features"]  # Not the first job's features
+		self.assertNotIn("elevation_m", feature["properties"])
+		with self.assertRaises(ValueError):
+			ExportJob("missing", "geojson", every="1m", output="x.geojson", dem=os.path.join(self.folder.name, "nowhere"))
+
+
+if __name__ == '__main__':
+	unittest.main()
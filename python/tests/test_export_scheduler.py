@@ -0,0 +1,115 @@
+#!/usr/bin/env python
+'''Cron schedules and the delivery of scheduled export jobs'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import tempfile
+import unittest
+from classes.server.ExportScheduler import CronSchedule, ExportJob, ExportScheduler, _cron_field
+
+
+class CronTest(unittest.TestCase):
+
+	def test_fields(self):
+		self.assertEqual(_cron_field("*/15", 0, 59), {0, 15, 30, 45})
+		self.assertEqual(_cron_field("5", 0, 59), {5})
+		self.assertEqual(_cron_field("10-14/2", 0, 59), {10, 12, 14})
+		self.assertEqual(_cron_field("1,3,5", 0, 6), {1, 3, 5})
+
+	def test_a_start_with_a_step_runs_to_the_end_of_the_range(self):
+		self.assertEqual(_cron_field("5/15", 0, 59), {5, 20, 35, 50})
+		self.assertEqual(_cron_field("2/3", 1, 12), {2, 5, 8, 11})
+
+	def test_bad_fields_are_refused(self):
+		for text in ["60", "5-1", "*/0", "x", "70/5"]:
+			with self.assertRaises(ValueError):
+				_cron_field(text, 0, 59)
+		with self.assertRaises(ValueError):
+			CronSchedule("0 * * *")
+
+	def test_day_and_weekday_match_either_when_both_are_restricted(self):
+		schedule = CronSchedule("0 12 1 * 1")  # Noon on the 1st, and noon every Monday
+		self.assertTrue(schedule.matches(datetime.datetime(2025, 10, 1, 12, 0)))  # A Wednesday
+		self.assertTrue(schedule.matches(datetime.datetime(2025, 10, 6, 12, 0)))  # A Monday
+		self.assertFalse(schedule.matches(datetime.datetime(2025, 10, 7, 12, 0)))
+		self.assertFalse(schedule.matches(datetime.datetime(2025, 10, 6, 13, 0)))
+
+	def test_day_and_weekday_must_both_match_when_one_is_a_wildcard(self):
+		weekdays = CronSchedule("30 * * * 1-5")
+		self.assertTrue(weekdays.matches(datetime.datetime(2025, 10, 3, 9, 30)))  # A Friday
+		self.assertFalse(weekdays.matches(datetime.datetime(2025, 10, 4, 9, 30)))  # A Saturday
+		every_other_day = CronSchedule("0 0 */2 * *")
+		self.assertTrue(every_other_day.matches(datetime.datetime(2025, 10, 3, 0, 0)))
+		self.assertFalse(every_other_day.matches(datetime.datetime(2025, 10, 4, 0, 0)))
+		sundays = CronSchedule("0 6 */1 * 0")  # */1 is still a wildcard, so only Sundays
+		self.assertTrue(sundays.matches(datetime.datetime(2025, 10, 5, 6, 0)))
+		self.assertFalse(sundays.matches(datetime.datetime(2025, 10, 6, 6, 0)))
+
+
+class Publisher:
+	"""Records what it is sent, and fails while failing is set."""
+
+	def __init__(self):
+		self.failing = False
+		self.sent = []
+
+	def publish(self, file_name, data, content_type):
+		if self.failing:
+			return False
+		self.sent.append(data)
+		return True
+
+
+class IncrementalDeliveryTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		self.output = os.path.join(self.folder.name, "log.ndjson")
+		self.features = []
+		self.publisher = Publisher()
+		self.job = ExportJob("log", "ndjson", every="1m", output=self.output, incremental=True)
+		self.job._features = lambda messages, shared: self.features
+		self.job.publishers.append(self.publisher)
+		self.scheduler = ExportScheduler([self.job], lambda paths, source_path, gateway: [])
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def feature(self, feature_id, value):
+		return {"type": "Feature", "id": feature_id, "geometry": None, "properties": {"value": value}}
+
+	def lines(self):
+		with open(self.output, 'r') as f:
+			return f.read().splitlines()
+
+	def test_a_failed_publisher_does_not_append_twice(self):
+		self.features = [self.feature("a", 1)]
+		self.publisher.failing = True
+		self.assertFalse(self.scheduler.run_job(self.job))
+		self.assertFalse(os.path.exists(self.output))
+		self.publisher.failing = False
+		self.assertTrue(self.scheduler.run_job(self.job))
+		self.assertEqual(len(self.lines()), 1)
+		self.assertTrue(self.scheduler.run_job(self.job))  # Nothing changed
+		self.assertEqual(len(self.lines()), 1)
+		self.features = [self.feature("a", 2)]
+		self.assertTrue(self.scheduler.run_job(self.job))
+		self.assertEqual(len(self.lines()), 2)
+		self.assertEqual(len(self.publisher.sent), 2)
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,79 @@
+#!/usr/bin/env python
+'''Locations read from form variables, by form type or by variable name'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import unittest
+from classes.b2f import OutboundMessage
+from classes.export import MapExport
+from classes.b2f.B2Message import B2Message
+from classes.forms.WinlinkForm import WinlinkForm
+
+
+def form(form_type, variables):
+	name, data = OutboundMessage.form_attachment(form_type, "N0CALL", variables)
+	parsed = WinlinkForm(name, data)
+	parsed.parse()
+	return parsed
+
+
+class FormPositionTest(unittest.TestCase):
+
+	def test_listed_forms_read_only_their_own_variables(self):
+		parsed = form("ICS213_Initial", {"latitude": "37.4", "longitude": "-122.1", "site_lat": "38.0", "site_lon": "-121.0"})
+		self.assertEqual(parsed.positions(), [("form", 37.4, -122.1)])
+
+	def test_other_forms_are_searched_by_variable_name(self):
+		parsed = form("Damage_Assessment", {"site_lat": "38.0", "site_lon": "-121.0", "maplat": "37.4", "maplon": "-122.1"})
+		self.assertEqual(parsed.positions(), [("form", 37.4, -122.1), ("site", 38.0, -121.0)])
+
+	def test_words_that_end_like_an_axis_are_not_positions(self):
+		parsed = form("Damage_Assessment", {"salon": "4", "flat": "12", "relation": "son", "colon": "1", "sitelat": "38.0", "sitelon": "-121.0"})
+		self.assertEqual(parsed.positions(), [])
+		for name in ["salon", "flat", "relation", "sitelat"]:
+			self.assertFalse(WinlinkForm.is_position_variable("Damage_Assessment", name), name)
+		self.assertTrue(WinlinkForm.is_position_variable("Damage_Assessment", "incident_site_latitude"))
+		self.assertTrue(WinlinkForm.is_position_variable("ICS213_Initial", "longitude"))
+
+
+def message(form_type, variables, location):
+	"""A message with its X-Location at location and a form of form_type."""
+	text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Check in", "Here",
+		files=[OutboundMessage.form_attachment(form_type, "N0CALL", variables)], location=location)
+	b2 = B2Message("AAAAAAAAAAAA", b"", None, None)
+	b2.decompressed_data = text
+	b2._extract_message_parts()
+	return b2
+
+
+class ReporterPositionTest(unittest.TestCase):
+
+	def test_a_form_repeating_the_reporter_s_position_adds_no_feature(self):
+		for form_type in ["Winlink_Check_In", "ICS213_Initial"]:
+			b2 = message(form_type, {"latitude": "37.40001", "longitude": "-122.10002"}, (37.4, -122.1))  # Rounded by the form
+			self.assertEqual(b2.positions(), [("reporter", 37.4, -122.1)], form_type)
+			self.assertEqual([f["properties"]["role"] for f in MapExport.message_features(b2)], ["reporter"])
+
+	def test_a_form_position_elsewhere_is_kept(self):
+		b2 = message("Winlink_Check_In", {"latitude": "37.41", "longitude": "-122.1"}, (37.4, -122.1))  # About 1 km north
+		self.assertEqual(b2.positions(), [("reporter", 37.4, -122.1), ("form", 37.41, -122.1)])
+
+	def test_a_form_position_is_kept_without_an_x_location(self):
+		b2 = message("Winlink_Check_In", {"latitude": "37.4", "longitude": "-122.1"}, None)
+		self.assertEqual(b2.positions(), [("form", 37.4, -122.1)])
+
+
+if __name__ == '__main__':
+	unittest.main()
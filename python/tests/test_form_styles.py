@@ -0,0 +1,133 @@
+#!/usr/bin/env python
+'''Styles chosen by form type and field values, and how GeoJSON and KML carry them'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import json
+import tempfile
+import unittest
+from classes.forms import FormStyles
+from classes.export import MapExport
+from classes.forms import MappingConfig
+from classes.b2f import OutboundMessage
+from classes.forms import Redaction
+from classes.b2f.B2Message import B2Message
+
+DAMAGE_STYLE = {
+	"marker-symbol": "danger", "layer": "Damage", "z_order": 1,
+	"when": [{"fields": {"power": "OUT"}, "marker-color": "#ff0000"}, {"fields": {"power": "out", "water": "out"}, "marker-size": "large"}],
+}
+
+
+class Form:
+
+	def __init__(self, form_type, variables):
+		self.form_type = form_type
+		self.variables = variables
+
+
+def message(form_type, variables):
+	"""A decoded message carrying a form, placed by its X-Location."""
+	name, data = OutboundMessage.form_attachment(form_type, "N0CALL", variables)
+	text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Report", "See form", files=[(name, data)], location=(37.4, -122.1))
+	decoded = B2Message("AAAAAAAAAAAA", b"", None, None)
+	decoded.decompressed_data = text
+	decoded._extract_message_parts()
+	return decoded
+
+
+def feature(mid, **properties):
+	return {"type": "Feature", "id": mid, "geometry": {"type": "Point", "coordinates": [-122.0, 37.0]}, "properties": dict(properties, mid=mid)}
+
+
+class FormStyleTest(unittest.TestCase):
+
+	def setUp(self):
+		FormStyles.FORM_STYLES = {"Damage_Assessment": DAMAGE_STYLE}
+
+	def tearDown(self):
+		FormStyles.FORM_STYLES = {}
+
+	def test_rules_apply_in_order_where_every_field_matches(self):
+		self.assertEqual(FormStyles.form_style(Form("Damage_Assessment", {"power": "On"})), {"marker-symbol": "danger", "layer": "Damage", "z_order": 1})
+		style = FormStyles.form_style(Form("Damage_Assessment", {"power": " out ", "water": "OUT"}))
+		self.assertEqual((style["marker-color"], style["marker-size"]), ("#ff0000", "large"))
+		self.assertEqual(FormStyles.form_style(Form("ICS213", {"power": "OUT"})), {})
+		self.assertEqual(FormStyles.form_style(None), {})
+
+	def test_styles_file(self):
+		with tempfile.TemporaryDirectory() as folder:
+			filename = os.path.join(folder, "styles.json")
+			with open(filename, 'w') as f:
+				json.dump({"forms": {"Damage_Assessment": DAMAGE_STYLE}}, f)
+			self.assertEqual(MappingConfig.load_config(filename, MappingConfig.STYLES)["forms"]["Damage_Assessment"], DAMAGE_STYLE)
+			for bad in [{"Damage_Assessment": "red"}, {"Damage_Assessment": {"when": [{"marker-color": "#ff0000"}]}}, {"Damage_Assessment": {"z_order": "top"}}]:
+				with open(filename, 'w') as f:
+					json.dump({"forms": bad}, f)
+				with self.assertRaises(ValueError):
+					MappingConfig.load_config(filename, MappingConfig.STYLES)
+
+	def test_higher_z_order_is_drawn_later(self):
+		features = [feature("A", z_order=2), feature("B"), feature("C", z_order=1), feature("D")]
+		self.assertEqual([f["id"] for f in MapExport.feature_collection(features)["features"]], ["B", "D", "C", "A"])
+
+	def test_kml_styles_and_layer_folders(self):
+		features = [feature("A", callsign="N0CALL", **{"marker-color": "#ff8000", "icon": "http://example.org/x.png", "layer": "Damage"}), feature("B", callsign="W1AW")]
+		kml = MapExport.kml_document("Test", [("All", features)])
+		self.assertIn("<Style><IconStyle><color>ff0080ff</color><Icon><href>http://example.org/x.png</href></Icon></IconStyle></Style>", kml)
+		self.assertLess(kml.index("<name>W1AW</name>"), kml.index("<name>Damage</name>"))
+		self.assertLess(kml.index("<name>Damage</name>"), kml.index("<name>N0CALL</name>"))
+		self.assertNotIn("x.png</td>", kml)
+
+
+class PopupTest(unittest.TestCase):
+
+	def setUp(self):
+		FormStyles.FORM_STYLES = {
+			"Damage_Assessment": {"popup": "$callsign at $address: $damage $notes", "when": [{"fields": {"damage": "Roof"}, "popup": "Roof down at ${address}"}]},
+			"Welfare_Inquiry": {"popup": "Asking after $subject_name"},
+		}
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+
+	def tearDown(self):
+		FormStyles.FORM_STYLES = {}
+
+	def test_the_template_fills_in_form_fields_and_message_properties(self):
+		properties = MapExport.message_features(message("Damage_Assessment", {"address": "12 Elm St", "damage": "Tree"}))[0]["properties"]
+		self.assertEqual(properties["description"], "N0CALL at 12 Elm St: Tree ")  # An empty field leaves nothing
+		self.assertNotIn("popup", properties)
+		properties = MapExport.message_features(message("Damage_Assessment", {"address": "12 Elm St", "damage": "roof"}))[0]["properties"]
+		self.assertEqual(properties["description"], "Roof down at 12 Elm St")
+		self.assertNotIn("description", MapExport.message_features(message("ICS213", {"message": "Hi"}))[0]["properties"])
+
+	def test_the_template_sees_only_redacted_fields(self):
+		properties = MapExport.message_features(message("Welfare_Inquiry", {"subject_name": "Jane Doe"}))[0]["properties"]
+		self.assertEqual(properties["description"], f"Asking after {Redaction.REDACTED}")
+
+	def test_kml_shows_the_popup_as_text(self):
+		features = [feature("A", callsign="N0CALL", description="<b>Elm</b>\nAll clear"), feature("B", callsign="W1AW", subject="Hello")]
+		kml = MapExport.kml_document("Test", [("All", features)])
+		self.assertIn("<description><![CDATA[&lt;b&gt;Elm&lt;/b&gt;<br/>All clear]]></description>", kml)
+		self.assertIn("<td>Hello</td>", kml)
+
+	def test_a_bad_template_is_refused(self):
+		for bad in [{"popup": "cost $5"}, {"popup": ["$a"]}, {"when": [{"fields": {"a": "b"}, "popup": "${a"}]}]:
+			with self.assertRaises(ValueError):
+				FormStyles.check_style("Damage_Assessment", bad)
+		FormStyles.check_style("Damage_Assessment", {"popup": "cost $$5 at $address"})
+
+
+if __name__ == '__main__':
+	unittest.main()
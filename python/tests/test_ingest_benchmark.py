@@ -0,0 +1,60 @@
+#!/usr/bin/env python
+'''Server stage times read from its traces for the messages a benchmark sent'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import json
+import tempfile
+import unittest
+from classes.server import IngestBenchmark
+from classes.server import Tracing
+
+
+def trace(number, mid, parse_ms, store_ms):
+	"""The spans of one message's trace, as the server's file exporter writes them."""
+	trace_id = f"{number:032x}"
+	spans = [{"trace_id": trace_id, "span_id": f"{number:08x}0001", "parent_id": None, "name": "message", "duration_ms": parse_ms + store_ms + 5, "attributes": {"mid": mid}}]
+	for index, (name, ms) in enumerate([("parse", parse_ms), ("store", store_ms), ("alerts", 1.0)], 2):
+		spans.append({"trace_id": trace_id, "span_id": f"{number:08x}{index:04x}", "parent_id": f"{number:08x}0001", "name": name, "duration_ms": ms, "attributes": {}})
+	return spans
+
+
+class TracedStagesTest(unittest.TestCase):
+
+	def test_only_the_benchmark_messages_are_timed(self):
+		spans = []
+		for number in range(1, 101):
+			spans += trace(number, f"BENCH{number:07d}", float(number), 2.0 * number)
+		spans += trace(500, "OTHERTRAFFIC", 9999.0, 9999.0)
+		stages, traced = IngestBenchmark.traced_stages(spans, [f"BENCH{n:07d}" for n in range(1, 102)])
+		self.assertEqual(traced, 100)  # BENCH0000101 was never traced
+		self.assertEqual(list(stages), ["parse", "store"])
+		self.assertEqual((stages["parse"]["p50_ms"], stages["parse"]["p99_ms"]), (50.0, 99.0))
+		self.assertEqual((stages["store"]["p50_ms"], stages["store"]["p99_ms"]), (100.0, 198.0))
+
+	def test_spans_read_from_a_traces_file(self):
+		with tempfile.TemporaryDirectory() as folder:
+			filename = os.path.join(folder, "traces.jsonl")
+			with open(filename, 'w') as f:
+				for span in trace(1, "BENCH0000001", 12.5, 30.0):
+					f.write(json.dumps(span) + "\n")
+			stages, traced = IngestBenchmark.traced_stages(Tracing.read_spans(filename), ["BENCH0000001"])
+		self.assertEqual(traced, 1)
+		self.assertEqual(stages["parse"]["p99_ms"], 12.5)
+		self.assertIsNone(IngestBenchmark.traced_stages([], ["BENCH0000001"])[0]["store"]["p50_ms"])
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,197 @@
+#!/usr/bin/env python
+'''Overflow policies of the ingest queue, and the journal that keeps what it drops'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import tempfile
+import threading
+import time
+import unittest
+from classes.store.IngestJournal import IngestJournal, SAVED
+from classes.server.IngestPipeline import BoundedQueue, IngestPipeline, BLOCK, DROP, OLDEST
+from classes.store.WinlinkMailMessage import WinlinkMailMessage
+
+
+class BoundedQueueTest(unittest.TestCase):
+
+	def fill(self, queue, count, size):
+		for i in range(count):
+			self.assertTrue(queue.put(i, size))
+
+	def test_drop_refuses_the_new_item(self):
+		queue = BoundedQueue(capacity=2, policy=DROP)
+		self.fill(queue, 2, 10)
+		self.assertFalse(queue.put("new", 10))
+		self.assertEqual(queue.take_dropped(), ["new"])
+		self.assertEqual([queue.get(0), queue.get(0)], [0, 1])
+
+	def test_oldest_makes_room_for_the_new_item(self):
+		queue = BoundedQueue(capacity=3, max_bytes=100, policy=OLDEST)
+		self.fill(queue, 3, 30)
+		self.assertTrue(queue.put("new", 30))
+		self.assertEqual(queue.take_dropped(), [0])
+		self.assertTrue(queue.put("big", 60))
+		self.assertEqual(queue.take_dropped(), [1, 2])
+
+	def test_oldest_refuses_an_oversize_item_without_emptying_the_queue(self):
+		queue = BoundedQueue(capacity=5, max_bytes=100, policy=OLDEST)
+		self.fill(queue, 3, 30)
+		self.assertFalse(queue.put("huge", 500))
+		self.assertEqual(queue.take_dropped(), ["huge"])
+		self.assertEqual(len(queue), 3)
+
+	def test_oversize_item_enters_an_empty_queue(self):
+		for policy in (BLOCK, DROP, OLDEST):
+			queue = BoundedQueue(capacity=5, max_bytes=100, policy=policy)
+			self.assertTrue(queue.put("huge", 500))
+			self.assertEqual(queue.take_dropped(), [])
+
+	def test_block_drops_after_the_timeout(self):
+		queue = BoundedQueue(capacity=1, policy=BLOCK, block_timeout=0.05)
+		self.fill(queue, 1, 10)
+		self.assertFalse(queue.put("late", 10))
+		self.assertEqual(queue.take_dropped(), ["late"])
+		self.assertGreater(queue.blocked_seconds, 0.0)
+
+	def test_byte_limit(self):
+		queue = BoundedQueue(capacity=10, max_bytes=50, policy=DROP)
+		self.fill(queue, 2, 25)
+		self.assertFalse(queue.put("over", 1))
+		queue.get(0)
+		self.assertTrue(queue.put("fits", 25))
+
+
+class IngestJournalTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		self.filename = os.path.join(self.folder.name, "ingest.journal")
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def message(self, mid):
+		return WinlinkMailMessage("EM", mid, 100, 50, folder=self.folder.name)
+
+	def test_open_entries_are_replayed_after_a_restart(self):
+		journal = IngestJournal(self.filename, sync=False)
+		first = journal.append(self.message("AAAAAAAAAAAA"), b"first")
+		second = journal.append(self.message("BBBBBBBBBBBB"), b"second")
+		journal.complete(first, SAVED)
+		journal.close()
+		journal = IngestJournal(self.filename, sync=False)
+		self.assertEqual([(s, d["mid"], raw) for s, d, raw in journal.pending()], [(second, "BBBBBBBBBBBB", b"second")])
+		third = journal.append(self.message("CCCCCCCCCCCC"), b"third")
+		self.assertGreater(third, second)
+		journal.close()
+
+	def test_read_back_an_open_entry(self):
+		journal = IngestJournal(self.filename, sync=False)
+		sequence = journal.append(self.message("AAAAAAAAAAAA"), b"\x01\x02raw\n")
+		description, raw = journal.read(sequence)
+		self.assertEqual((description["mid"], raw), ("AAAAAAAAAAAA", b"\x01\x02raw\n"))
+		journal.complete(sequence, SAVED)
+		self.assertIsNone(journal.read(sequence))
+		journal.close()
+
+	def test_a_torn_record_is_cut_off(self):
+		journal = IngestJournal(self.filename, sync=False)
+		journal.append(self.message("AAAAAAAAAAAA"), b"kept")
+		journal.close()
+		size = os.path.getsize(self.filename)
+		with open(self.filename, 'ab') as f:
+			f.write(b"ESVJ\x01torn")
+		journal = IngestJournal(self.filename, sync=False)
+		self.assertEqual([raw for _, _, raw in journal.pending()], [b"kept"])
+		self.assertEqual(os.path.getsize(self.filename), size)
+		journal.close()
+
+
+class RecordingPipeline(IngestPipeline):
+	"""Records what it would process instead of decoding it."""
+
+	def __init__(self, *arguments, **options):
+		self.seen = []
+		self.processing = threading.Event()
+		self.proceed = threading.Event()
+		super().__init__(*arguments, **options)
+
+	def process(self, message):
+		self.processing.set()
+		self.proceed.wait(5)
+		self.seen.append((message.message_id, message.b2.raw_data))
+		self._complete(message, SAVED)
+
+
+class SpillTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		self.journal = IngestJournal(os.path.join(self.folder.name, "ingest.journal"), sync=False)
+
+	def tearDown(self):
+		self.journal.close()
+		self.folder.cleanup()
+
+	def submit(self, pipeline, mid):
+		message = WinlinkMailMessage("EM", mid, 100, 50, folder=self.folder.name)
+		message.capture(mid.encode("ascii"))
+		return pipeline.submit(message, 10)
+
+	def test_dropped_messages_wait_in_the_journal(self):
+		pipeline = RecordingPipeline(capacity=1, policy=DROP, journal=self.journal)
+		self.assertTrue(self.submit(pipeline, "AAAAAAAAAAAA"))
+		self.assertTrue(pipeline.processing.wait(5))  # The worker holds the first, so the queue is empty
+		self.assertTrue(self.submit(pipeline, "BBBBBBBBBBBB"))
+		self.assertTrue(self.submit(pipeline, "CCCCCCCCCCCC"))  # Doesn't fit, but is kept
+		self.assertEqual(pipeline.stats()["spilled"], 1)
+		pipeline.proceed.set()
+		for _ in range(50):
+			if len(pipeline.seen) == 3:
+				break
+			time.sleep(0.1)
+		self.assertEqual([mid for mid, _ in pipeline.seen], ["AAAAAAAAAAAA", "BBBBBBBBBBBB", "CCCCCCCCCCCC"])
+		self.assertEqual(pipeline.seen[2][1], b"CCCCCCCCCCCC")
+		self.assertEqual(pipeline.stats()["dropped"], 0)
+		pipeline.close(5)
+
+	def test_spilled_messages_survive_a_stop(self):
+		pipeline = RecordingPipeline(capacity=1, policy=OLDEST, journal=self.journal)
+		self.submit(pipeline, "AAAAAAAAAAAA")
+		self.assertTrue(pipeline.processing.wait(5))
+		self.submit(pipeline, "BBBBBBBBBBBB")
+		self.submit(pipeline, "CCCCCCCCCCCC")  # Pushes out B
+		pipeline.queue.close()
+		pipeline.proceed.set()
+		for worker in pipeline.workers:
+			worker.join(5)
+		self.journal.close()
+		journal = IngestJournal(self.journal.filename, sync=False)
+		self.assertEqual([description["mid"] for _, description, _ in journal.pending()], ["BBBBBBBBBBBB"])
+		journal.close()
+
+	def test_without_a_journal_a_dropped_transfer_is_reported(self):
+		pipeline = RecordingPipeline(capacity=1, policy=DROP)
+		self.assertTrue(self.submit(pipeline, "AAAAAAAAAAAA"))
+		self.assertTrue(pipeline.processing.wait(5))
+		self.assertTrue(self.submit(pipeline, "BBBBBBBBBBBB"))
+		self.assertFalse(self.submit(pipeline, "CCCCCCCCCCCC"))
+		self.assertEqual(pipeline.stats()["dropped"], 1)
+		pipeline.proceed.set()
+		pipeline.close(5)
+
+
+if __name__ == '__main__':
+	unittest.main()
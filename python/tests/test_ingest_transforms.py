@@ -0,0 +1,42 @@
+#!/usr/bin/env python
+'''Checking and applying the transforms in mappings.json'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import unittest
+from classes.b2f import IngestTransforms
+
+
+class CheckTransformsTest(unittest.TestCase):
+
+	def replace(self, find, replace):
+		return IngestTransforms.check_transforms([{"type": "replace", "find": find, "replace": replace, "regex": True}])
+
+	def test_group_references_are_applied(self):
+		transforms = self.replace(r"<(gps)_lat>", r"<\1_latitude>")
+		self.assertEqual(IngestTransforms.apply(b"<gps_lat>", IngestTransforms.BODY, transforms=transforms), (b"<gps_latitude>", ["replace"]))
+
+	def test_a_replacement_naming_a_missing_group_is_refused(self):
+		for replace in [r"\2", r"\g<name>"]:
+			with self.assertRaises(ValueError):
+				self.replace(r"(a)", replace)
+
+	def test_a_bad_regex_is_refused(self):
+		with self.assertRaises(ValueError):
+			self.replace(r"(a", "b")
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,138 @@
+#!/usr/bin/env python
+'''LZHUF compression against a transfer captured from Winlink Express'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import io
+import stat
+import struct
+import tempfile
+import unittest
+from unittest import mock
+from classes.lzhuf import Lzhuf
+from classes.b2f import OutboundMessage
+from classes.b2f.B2Message import B2Message, BUILT_IN_DECOMPRESSOR, STX, EOT, decompressor
+
+CAPTURE_FILE = os.path.join(this_path, "testdata", "MQ2TOYZRMM2D.b2f")
+
+
+def compressed_image(transfer):
+	"""The compressed image carried in the STX blocks of a transfer with no resume offset."""
+	index = 2 + transfer[1]
+	image = bytearray()
+	while transfer[index] == STX:
+		image += transfer[index + 2:index + 2 + transfer[index + 1]]
+		index += 2 + transfer[index + 1]
+	assert transfer[index] == EOT
+	return bytes(image)
+
+
+class LzhufTest(unittest.TestCase):
+
+	@classmethod
+	def setUpClass(cls):
+		with open(CAPTURE_FILE, 'rb') as f:
+			cls.image = compressed_image(f.read())
+		cls.text = Lzhuf.decompress(cls.image)
+
+	def test_capture_decodes_to_its_declared_length(self):
+		crc, length = struct.unpack("<HI", self.image[:6])
+		self.assertEqual(crc, Lzhuf.crc16(self.image[2:]))
+		self.assertEqual(len(self.text), length)
+		self.assertTrue(self.text.startswith(b"MID: MQ2TOYZRMM2D\r\n"))
+
+	def test_round_trip(self):
+		image = Lzhuf.compress(self.text)
+		crc, length = struct.unpack("<HI", image[:6])
+		self.assertEqual(crc, Lzhuf.crc16(image[2:]))
+		self.assertEqual(length, len(self.text))
+		self.assertEqual(Lzhuf.decompress(image), self.text)
+
+	def test_round_trip_of_short_and_repetitive_text(self):
+		for text in [b"", b"A", b" " * 100, b"abcabcabc" * 300, bytes(range(256)) * 4]:
+			self.assertEqual(Lzhuf.decompress(Lzhuf.compress(text)), text)
+
+	def test_a_transfer_carries_the_compressed_image(self):
+		text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Test", "Hello")
+		frame, compressed = OutboundMessage.transfer("Test", text)
+		self.assertEqual(compressed_image(frame), compressed)
+		self.assertEqual(Lzhuf.decompress(compressed), text)
+
+	def test_damaged_images_are_refused(self):
+		damaged = bytearray(self.image)
+		damaged[100] ^= 0xFF
+		with self.assertRaises(ValueError):
+			Lzhuf.decompress(bytes(damaged))
+		truncated = self.image[:1000]
+		with self.assertRaises(ValueError):
+			Lzhuf.decompress(struct.pack("<H", Lzhuf.crc16(truncated[2:])) + truncated[2:])
+
+	def test_reading_a_stream(self):
+		reader = Lzhuf.LzhufReader(io.BytesIO(self.image))
+		self.assertEqual(reader.length, len(self.text))
+		self.assertEqual(reader.read(10), self.text[:10])
+		self.assertEqual(reader.read(), self.text[10:])
+		self.assertEqual(reader.read(), b"")
+
+	def test_stream_round_trip_in_chunks(self):
+		text = bytes(range(256)) * 600  # Longer than a chunk
+		compressed, decompressed = io.BytesIO(), io.BytesIO()
+		self.assertEqual(Lzhuf.compress_stream(io.BytesIO(text), compressed), len(compressed.getvalue()))
+		compressed.seek(0)
+		self.assertEqual(Lzhuf.decompress_stream(compressed, decompressed), len(text))
+		self.assertEqual(decompressed.getvalue(), text)
+		self.assertEqual(Lzhuf.decompress_stream(io.BytesIO(Lzhuf.compress(b"")), io.BytesIO()), 0)
+
+	def test_damaged_streams_are_refused(self):
+		damaged = bytearray(self.image)
+		damaged[-1] ^= 0x01  # Padding after the last code: it decodes, but the CRC is wrong
+		with self.assertRaises(ValueError):
+			Lzhuf.decompress_stream(io.BytesIO(bytes(damaged)), io.BytesIO())
+		with self.assertRaises(ValueError):
+			Lzhuf.decompress_stream(io.BytesIO(self.image[:1000]), io.BytesIO())
+		with self.assertRaises(ValueError):
+			Lzhuf.LzhufReader(io.BytesIO(self.image[:4]))
+
+
+	def test_messages_decompress_without_the_external_decompressor(self):
+		text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Test", "Hello")
+		frame, compressed = OutboundMessage.transfer("Test", text)
+		with mock.patch("classes.b2f.B2Message.shutil.which", return_value=None):
+			self.assertEqual(decompressor(), BUILT_IN_DECOMPRESSOR)
+			message = B2Message("AAAAAAAAAAAA", frame, None, None)
+			message.parse()
+		self.assertIsNone(message.decompression_error)
+		self.assertEqual(message.decompressed_data, text)
+		self.assertEqual(message.subject, "Test")
+
+
+	@unittest.skipIf(sys.platform == "win32", "The stand-in decompressor is a shell script")
+	def test_a_failing_decompressor_is_reported(self):
+		text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], "Test", "Hello")
+		frame, _ = OutboundMessage.transfer("Test", text)
+		with tempfile.TemporaryDirectory() as folder:
+			program = os.path.join(folder, "decompress_lzhuf")
+			with open(program, 'w') as f:
+				f.write("#!/bin/sh\necho 'bad Huffman table' >&2\nexit 3\n")
+			os.chmod(program, stat.S_IRWXU)
+			with mock.patch("classes.b2f.B2Message.shutil.which", return_value=program):
+				message = B2Message("AAAAAAAAAAAA", frame, None, None)
+				message.parse()
+		self.assertEqual(message.decompression_error, "decompress_lzhuf exited with status 3: bad Huffman table")
+		self.assertIsNone(message.decompressed_data)
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,74 @@
+#!/usr/bin/env python
+'''Operational periods read from a file, and messages bucketed into them'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import json
+import tempfile
+import unittest
+from classes.store import OperationalPeriods
+
+
+class Message:
+
+	def __init__(self, hour):
+		self.date = datetime.datetime(2025, 10, 4, hour, 0, tzinfo=datetime.timezone.utc)
+
+
+class OperationalPeriodsTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def load(self, *names):
+		filename = os.path.join(self.folder.name, "periods.json")
+		periods = [{"name": name, "start": f"2025-10-04 {8 + 4 * i:02d}:00", "end": f"2025-10-04 {12 + 4 * i:02d}:00"} for i, name in enumerate(names)]
+		with open(filename, 'w') as f:
+			json.dump({"periods": periods}, f)
+		return OperationalPeriods.load_periods(filename)
+
+	def test_bucket(self):
+		buckets = OperationalPeriods.bucket(self.load("OP 1", "OP 2"), [Message(9), Message(13), Message(20), Message(6)])
+		self.assertEqual([(name, len(messages)) for name, messages in buckets.items()], [("OP 1", 1), ("OP 2", 1), (OperationalPeriods.UNASSIGNED, 2)])
+
+	def test_the_unassigned_name_is_refused(self):
+		for name in ["Unassigned", " unassigned "]:
+			with self.assertRaises(ValueError):
+				self.load("OP 1", name)
+
+	def test_a_name_used_twice_is_refused(self):
+		with self.assertRaises(ValueError):
+			self.load("OP 1", "op 1")
+
+	def test_names_that_make_the_same_file_are_refused(self):
+		for first, second in [("OP:1", "OP/1"), ("OP 1", "op 1."), ("Night?", "night*")]:
+			with self.assertRaises(ValueError, msg=(first, second)):
+				self.load(first, second)
+		with self.assertRaises(ValueError):
+			self.load("OP 1", "Unassigned.")
+		self.assertEqual([p.name for p in self.load("OP 1", "OP 10")], ["OP 1", "OP 10"])
+
+	def test_a_name_that_is_not_text_is_refused(self):
+		for name in [7, ["OP 1"], {"n": 1}]:
+			with self.assertRaises(ValueError, msg=name):
+				self.load("OP 1", name)
+
+
+if __name__ == '__main__':
+	unittest.main()
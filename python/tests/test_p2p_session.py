@@ -0,0 +1,121 @@
+#!/usr/bin/env python
+'''A peer-to-peer session over a socket pair, refusing messages the mailbox already has'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import socket
+import tempfile
+import threading
+import unittest
+from unittest import mock
+from classes.b2f import OutboundMessage
+from classes.b2f.Outbox import Outbox
+from classes.b2f.P2PSession import P2PClient, read_line, p2p_prompt
+from classes.store.WinlinkMailMessage import HEADERS_FILE_SUFFIX, mailbox_mids
+
+KNOWN_MID = "KNOWNMESSAGE"
+NEW_MID = "NEWMESSAGE01"
+
+
+def offered(mid):
+	"""(proposal, transfer) for a message from the peer."""
+	text = OutboundMessage.message_text(mid, "W6EI", ["N0CALL"], f"Message {mid}", "Hello", location=(37.4275, -122.1697))
+	frame, compressed = OutboundMessage.transfer(f"Message {mid}", text)
+	return OutboundMessage.proposal(mid, len(text), len(compressed)), frame
+
+
+class Peer:
+	"""The answering station's end of the session, playing a script and recording what it is sent."""
+
+	def __init__(self, connection, offers):
+		self.connection = connection
+		self.stream = connection.makefile('rb')
+		self.offers = offers  # (proposal, frame)
+		self.lines = []
+		self.error = None
+
+	def expect(self, prefix):
+		line = read_line(self.stream)
+		self.lines.append(line)
+		if not line.startswith(prefix):
+			raise AssertionError(f"expected {prefix}, got <{line}>")
+		return line
+
+	def run(self):
+		try:
+			self.connection.sendall(f"[WL2K-5.0-B2FWIHJM$]\r{p2p_prompt('N0CALL', 'W6EI')}\r".encode())
+			self.expect("[")  # The caller's SID
+			self.expect("FF")  # Nothing for us in its outbox
+			proposals = "".join(f"{proposal}\r" for proposal, _ in self.offers)
+			self.connection.sendall(f"{proposals}F> {OutboundMessage.proposal_checksum(proposals)}\r".encode())
+			answers = self.expect("FS")[2:].strip()
+			self.connection.sendall(b"".join(frame for (_, frame), answer in zip(self.offers, answers) if answer == "+"))
+			self.expect("FF")  # Its turn again, still with nothing to send
+			self.connection.sendall(b"FQ\r")
+		except Exception as e:
+			self.error = e
+		finally:
+			self.stream.close()
+			self.connection.close()
+
+
+class P2PSessionTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		self.mailbox = os.path.join(self.folder.name, "mailbox")
+		os.makedirs(self.mailbox)
+		with open(os.path.join(self.mailbox, f"20251004-{KNOWN_MID}{HEADERS_FILE_SUFFIX}"), 'w', newline='') as f:
+			f.write(f"Mid: {KNOWN_MID}\r\nSubject: Saved before\r\n")
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def exchange(self, offers):
+		ours, theirs = socket.socketpair()
+		peer = Peer(theirs, offers)
+		thread = threading.Thread(target=peer.run, daemon=True)
+		thread.start()
+		client = P2PClient("localhost", 8772, "N0CALL", outbox=Outbox(os.path.join(self.folder.name, "outbox")), folder=self.mailbox, timeout=10)
+		with mock.patch("classes.b2f.P2PSession.socket.create_connection", return_value=ours):
+			station = client.exchange()
+		thread.join(10)
+		self.assertIsNone(peer.error)
+		return client, peer, station
+
+	def test_messages_already_in_the_mailbox_are_refused(self):
+		client, peer, station = self.exchange([offered(KNOWN_MID), offered(NEW_MID)])
+		self.assertEqual(station, "W6EI")
+		self.assertIn("FS -+", peer.lines)
+		self.assertEqual(client.refused, [KNOWN_MID])
+		self.assertEqual([m.message_id for m in client.received], [NEW_MID])
+		self.assertEqual(set(mailbox_mids(self.mailbox)), {KNOWN_MID, NEW_MID})
+
+	def test_a_message_proposed_twice_is_taken_once(self):
+		client, peer, _ = self.exchange([offered(NEW_MID), offered(NEW_MID)])
+		self.assertIn("FS +-", peer.lines)
+		self.assertEqual(client.refused, [NEW_MID])
+		self.assertEqual([m.message_id for m in client.received], [NEW_MID])
+
+	def test_quarantined_messages_are_not_counted_as_saved(self):
+		failed = os.path.join(self.mailbox, "failed")
+		os.makedirs(failed)
+		with open(os.path.join(failed, f"20251004-{NEW_MID}{HEADERS_FILE_SUFFIX}"), 'w', newline='') as f:
+			f.write(f"Mid: {NEW_MID}\r\n")
+		self.assertEqual(set(mailbox_mids(self.mailbox)), {KNOWN_MID})
+
+
+if __name__ == '__main__':
+	unittest.main()
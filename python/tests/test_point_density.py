@@ -0,0 +1,76 @@
+#!/usr/bin/env python
+'''Heatmap points and precomputed marker clusters'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import unittest
+from unittest import mock
+from classes.geo import PointDensity
+
+
+class Message:
+	"""A report whose feature is given, since message_features is tested with the exports."""
+
+	def __init__(self, mid, latitude, longitude, urgency=0, minute=0):
+		self.date = datetime.datetime(2025, 10, 4, 12, minute, tzinfo=datetime.timezone.utc)
+		self.feature = {"type": "Feature", "id": mid, "geometry": {"type": "Point", "coordinates": [longitude, latitude]}, "properties": {"urgency": urgency}}
+
+
+def report_feature(message):
+	return message.feature
+
+
+@mock.patch("classes.geo.PointDensity.report_feature", report_feature)
+class PointDensityTest(unittest.TestCase):
+
+	def setUp(self):
+		self.messages = [
+			Message("a", 37.400001, -122.100001, minute=0),
+			Message("b", 37.400002, -122.100002, urgency=3, minute=1),  # Within a metre of a
+			Message("c", 37.41, -122.09, minute=2),
+			Message("d", 40.0, -105.0, urgency=1, minute=3),
+		]
+
+	def test_heat_points_add_up_reports_at_the_same_place(self):
+		heat = PointDensity.heat_points(self.messages)
+		self.assertEqual(heat, {"max": 2, "points": [[37.4, -122.1, 2], [37.41, -122.09, 1], [40.0, -105.0, 1]]})
+		heat = PointDensity.heat_points(self.messages, PointDensity.URGENCY)
+		self.assertEqual(heat["points"][0], [37.4, -122.1, 5])
+		self.assertEqual(heat["max"], 5)
+		with self.assertRaises(ValueError):
+			PointDensity.heat_points(self.messages, "loudness")
+
+	def test_clusters_split_as_the_zoom_increases(self):
+		features = PointDensity.cluster_features(self.messages, range(4, 17))
+		by_zoom = {}
+		for feature in features:
+			by_zoom.setdefault(feature["properties"]["zoom"], []).append(feature["properties"])
+		self.assertEqual(sorted(p["point_count"] for p in by_zoom[4]), [1, 3])
+		self.assertEqual(max(p["urgency"] for p in by_zoom[4]), 3)
+		self.assertEqual(sorted(p["point_count"] for p in by_zoom[16]), [1, 1, 2])
+		self.assertEqual(sorted(p["feature_id"] for p in by_zoom[16] if p["point_count"] == 1), ["c", "d"])
+		self.assertTrue(all(sum(p["point_count"] for p in clusters) == 4 for clusters in by_zoom.values()))
+
+	def test_zoom_ranges(self):
+		self.assertEqual(PointDensity.parse_zooms("4-16"), range(4, 17))
+		self.assertEqual(PointDensity.parse_zooms("10"), range(10, 11))
+		for text in ["9-3", "0-20", "x", ""]:
+			with self.assertRaises(ValueError):
+				PointDensity.parse_zooms(text)
+
+
+if __name__ == '__main__':
+	unittest.main()
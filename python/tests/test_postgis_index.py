@@ -0,0 +1,300 @@
+#!/usr/bin/env python
+'''The PostgreSQL client and the PostGIS search index, against a stand-in server on a local socket'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import base64
+import datetime
+import json
+import socket
+import struct
+import tempfile
+import threading
+import unittest
+from classes.store import BlobStore
+from classes.store import PgWire
+from classes.store import SearchIndex
+from classes.b2f.B2Message import B2Message
+from classes.b2f import OutboundMessage
+
+PASSWORD = "s3cret"
+SALT = b"0123456789abcdef"
+ITERATIONS = 4096
+TEXT, INT4, FLOAT8, TIMESTAMPTZ = 25, 23, 701, 1184
+
+
+def message(kind, payload=b""):
+	return kind + struct.pack("!I", len(payload) + 4) + payload
+
+
+class FakeServer:
+	"""Enough of a PostgreSQL server to log in with SCRAM-SHA-256 and answer extended queries.  Each
+	statement is passed with its parameters to respond(), which returns (columns, rows) or raises
+	PgWire.Error to send an ErrorResponse."""
+
+	def __init__(self, respond):
+		self.respond = respond
+		self.statements = []
+		self.listener = socket.socket()
+		self.listener.bind(("127.0.0.1", 0))
+		self.listener.listen()
+		self.port = self.listener.getsockname()[1]
+		self.thread = threading.Thread(target=self._serve, daemon=True)
+		self.thread.start()
+
+	def close(self):
+		self.listener.close()
+
+	def _serve(self):
+		while True:
+			try:
+				connection, _ = self.listener.accept()
+			except OSError:
+				return
+			with connection:
+				try:
+					self._session(connection)
+				except (OSError, ValueError):
+					pass
+
+	@staticmethod
+	def _read(connection, count):
+		data = b""
+		while len(data) < count:
+			chunk = connection.recv(count - len(data))
+			if not chunk:
+				raise OSError("closed")
+			data += chunk
+		return data
+
+	def _message(self, connection):
+		kind = self._read(connection, 1)
+		length = struct.unpack("!I", self._read(connection, 4))[0]
+		return kind, self._read(connection, length - 4)
+
+	def _session(self, connection):
+		length = struct.unpack("!I", self._read(connection, 4))[0]
+		payload = self._read(connection, length - 4)
+		if struct.unpack("!I", payload[:4])[0] == PgWire.SSL_REQUEST_CODE:
+			connection.sendall(b"N")
+			length = struct.unpack("!I", self._read(connection, 4))[0]
+			payload = self._read(connection, length - 4)
+		connection.sendall(message(b"R", struct.pack("!I", PgWire.AUTH_SASL) + b"SCRAM-SHA-256\0\0"))
+		_, payload = self._message(connection)
+		client_first = payload[payload.index(b"\0") + 5:]
+		bare = client_first[3:]
+		nonce = dict(item.split(b"=", 1) for item in bare.split(b","))[b"r"] + b"server"
+		server_first = b"r=" + nonce + b",s=" + base64.b64encode(SALT) + b",i=" + str(ITERATIONS).encode()
+		connection.sendall(message(b"R", struct.pack("!I", PgWire.AUTH_SASL_CONTINUE) + server_first))
+		_, client_final = self._message(connection)
+		without_proof, proof = client_final.rsplit(b",p=", 1)
+		expected, signature = PgWire.scram_client_proof(PASSWORD, SALT, ITERATIONS, bare + b"," + server_first + b"," + without_proof)
+		if base64.b64decode(proof) != expected:
+			connection.sendall(message(b"E", b"SFATAL\0C28P01\0Mpassword authentication failed\0\0"))
+			return
+		connection.sendall(message(b"R", struct.pack("!I", PgWire.AUTH_SASL_FINAL) + b"v=" + base64.b64encode(signature)))
+		connection.sendall(message(b"R", struct.pack("!I", PgWire.AUTH_OK)) + message(b"S", b"server_version\x0016\0") + message(b"Z", b"I"))
+		sql, parameters = None, []
+		while True:
+			kind, payload = self._message(connection)
+			if kind == b"X":
+				return
+			if kind == b"P":
+				sql = payload[1:payload.index(b"\0", 1)].decode()
+			elif kind == b"B":
+				count = struct.unpack("!H", payload[4:6])[0]
+				index, parameters = 6, []
+				for _ in range(count):
+					size = struct.unpack("!i", payload[index:index + 4])[0]
+					index += 4
+					parameters.append(None if size < 0 else payload[index:index + size].decode())
+					index += max(size, 0)
+			elif kind == b"S":
+				connection.sendall(self._answer(sql, parameters))
+			elif kind == b"Q":
+				connection.sendall(message(b"C", b"SELECT 0\0") + message(b"Z", b"I"))
+
+	def _answer(self, sql, parameters):
+		self.statements.append((sql, parameters))
+		try:
+			columns, rows = self.respond(sql, parameters)
+		except PgWire.Error as e:
+			return message(b"1") + message(b"E", b"SERROR\0C" + e.sqlstate.encode() + b"\0M" + str(e).encode() + b"\0\0") + message(b"Z", b"I")
+		reply = message(b"1") + message(b"2")
+		if columns:
+			description = struct.pack("!H", len(columns))
+			for name, oid in columns:
+				description += name.encode() + b"\0" + struct.pack("!IHIhiH", 0, 0, oid, -1, -1, 0)
+			reply += message(b"T", description)
+			for row in rows:
+				data = struct.pack("!H", len(row))
+				for value in row:
+					data += struct.pack("!i", -1) if value is None else struct.pack("!i", len(str(value).encode())) + str(value).encode()
+				reply += message(b"D", data)
+		else:
+			reply += message(b"n")
+		return reply + message(b"C", f"SELECT {len(rows)}\0".encode()) + message(b"Z", b"I")
+
+
+class FakeDatabase:
+	"""What the stand-in server holds: the schema version and the rows of esv_messages."""
+
+	def __init__(self):
+		self.version = None
+		self.rows = {}
+
+	def __call__(self, sql, parameters):
+		if sql.startswith("SELECT to_regclass('esv_schema')"):
+			return [("to_regclass", TEXT)], [("esv_schema" if self.version is not None else None,)]
+		if sql.startswith("SELECT to_regclass('esv_messages')"):
+			return [("to_regclass", TEXT)], [("esv_messages" if self.version else None,)]
+		if sql.startswith("SELECT version FROM esv_schema"):
+			return [("version", INT4)], [(self.version,)] if self.version else []
+		if sql.startswith("CREATE TABLE IF NOT EXISTS esv_schema"):
+			self.version = self.version or 0
+		elif sql.startswith("INSERT INTO esv_schema"):
+			self.version = int(parameters[1])
+		elif sql.startswith("INSERT INTO esv_messages"):
+			self.rows[(parameters[0], parameters[1])] = parameters
+		elif sql.startswith("SELECT key FROM esv_messages"):
+			return [("key", TEXT)], [(key,) for node, key in self.rows if node == parameters[0]]
+		elif sql.startswith("SELECT key, mid, date"):
+			if parameters[1] == "(":
+				raise PgWire.Error("ERROR: syntax error in tsquery", "42601")
+			return [(n, t) for n, t in [("key", TEXT), ("mid", TEXT), ("date", TIMESTAMPTZ), ("sender", TEXT), ("recipient", TEXT),
+				("subject", TEXT), ("form_type", TEXT), ("ts_headline", TEXT), ("rank", FLOAT8), ("node", TEXT), ("st_y", FLOAT8), ("st_x", FLOAT8)]], [
+				(p[1], p[2], "2025-10-04 12:00:00+00", p[4], p[5], p[6], p[9], "[generator] out", -0.25, p[0], p[11], p[10]) for p in self.rows.values()]
+		return [], []
+
+
+def decoded(subject, body, location=None):
+	text = OutboundMessage.message_text("AAAAAAAAAAAA", "N0CALL", ["EOC"], subject, body, location=location)
+	b2 = B2Message("AAAAAAAAAAAA", b"", None, None)
+	b2.decompressed_data = text
+	b2._extract_message_parts()
+	return b2
+
+
+class PgWireTest(unittest.TestCase):
+
+	def setUp(self):
+		self.server = FakeServer(self.respond)
+
+	def tearDown(self):
+		self.server.close()
+
+	def respond(self, sql, parameters):
+		if sql == "SELECT $1::int4, $2, $3, now()":
+			return [("a", INT4), ("b", TEXT), ("c", TEXT), ("now", TIMESTAMPTZ)], [(int(parameters[0]) + 1, parameters[1], None, "2025-10-04 12:00:00.5+00")]
+		raise PgWire.Error("ERROR: relation \"nowhere\" does not exist", "42P01")
+
+	def connect(self, password=PASSWORD):
+		return PgWire.Connection("127.0.0.1", "esv", password, "esv", port=self.server.port)
+
+	def test_parameters_and_typed_results(self):
+		with self.connect() as connection:
+			result = connection.execute("SELECT $1::int4, $2, $3, now()", [41, "it's; DROP TABLE x", None])
+		self.assertEqual(result.columns, ["a", "b", "c", "now"])
+		self.assertEqual(result.rows, [(42, "it's; DROP TABLE x", None, datetime.datetime(2025, 10, 4, 12, 0, 0, 500000, tzinfo=datetime.timezone.utc))])
+		self.assertEqual(result.rowcount, 1)
+		self.assertEqual(self.server.statements[0][1], ["41", "it's; DROP TABLE x", None])
+
+	def test_errors_carry_their_sqlstate_and_leave_the_connection_usable(self):
+		with self.connect() as connection:
+			with self.assertRaises(PgWire.Error) as raised:
+				connection.execute("SELECT * FROM nowhere")
+			self.assertEqual(raised.exception.sqlstate, "42P01")
+			self.assertEqual(connection.execute("SELECT $1::int4, $2, $3, now()", [1, "x", None]).rows[0][0], 2)
+
+	def test_a_wrong_password_is_refused(self):
+		with self.assertRaises(PgWire.Error) as raised:
+			self.connect("wrong")
+		self.assertEqual(raised.exception.sqlstate, "28P01")
+
+
+class PostgisIndexTest(unittest.TestCase):
+
+	def setUp(self):
+		self.database = FakeDatabase()
+		self.server = FakeServer(self.database)
+		self.index = SearchIndex.PostgisIndex("127.0.0.1", "esv", "esv", PASSWORD, port=self.server.port, node="north")
+
+	def tearDown(self):
+		self.server.close()
+
+	def test_a_new_database_is_migrated_before_first_use(self):
+		self.assertFalse(self.index.exists())
+		self.assertEqual(self.index.schema(), (0, [(1, "messages with a search document and a point geometry")]))
+		self.assertEqual(self.index.keys(), set())
+		self.assertEqual(self.database.version, 1)
+		statements = [sql for sql, _ in self.server.statements]
+		self.assertIn("CREATE EXTENSION IF NOT EXISTS postgis", statements)
+		self.assertTrue(self.index.exists())
+		self.assertEqual(self.index.migrate(), [])
+
+	def test_add_keeps_the_position_and_redacts_as_sqlite_does(self):
+		self.index.add("20251004-AAAAAAAAAAAA-headers.txt", decoded("Generator out", "Need a generator", location=(37.5, -122.25)))
+		self.index.add("20251004-BBBBBBBBBBBB-headers.txt", decoded("No position", "Text"))
+		rows = {key: parameters for (node, key), parameters in self.database.rows.items()}
+		placed = rows["20251004-AAAAAAAAAAAA-headers.txt"]
+		self.assertEqual(placed[0], "north")
+		self.assertEqual(placed[6], "Generator out")
+		self.assertEqual((float(placed[10]), float(placed[11])), (-122.25, 37.5))  # Longitude first, as PostGIS takes them
+		self.assertEqual(rows["20251004-BBBBBBBBBBBB-headers.txt"][10:], [None, None])
+		self.assertEqual(self.index.keys(), set(rows))
+
+	def test_search_results_match_those_of_sqlite(self):
+		self.index.add("20251004-AAAAAAAAAAAA-headers.txt", decoded("Generator out", "Need a generator", location=(37.5, -122.25)))
+		[result] = self.index.search("generator", 10, bbox=(-123, 37, -122, 38))
+		self.assertEqual(result["date"], "2025-10-04T12:00:00+00:00")
+		self.assertEqual((result["subject"], result["snippet"], result["rank"], result["node"]), ("Generator out", "[generator] out", -0.25, "north"))
+		self.assertEqual((result["latitude"], result["longitude"]), (37.5, -122.25))
+		sql, parameters = self.server.statements[-1]
+		self.assertIn("ST_MakeEnvelope($4, $5, $6, $7, 4326)", sql)
+		self.assertEqual(parameters[3:], ["-123", "37", "-122", "38"])
+		with self.assertRaises(ValueError):
+			self.index.search("(", 10)
+
+
+class IndexSettingsTest(unittest.TestCase):
+
+	def test_storage_json_names_the_index(self):
+		with tempfile.TemporaryDirectory() as folder:
+			filename = os.path.join(folder, BlobStore.STORAGE_FILE_NAME)
+			with open(filename, 'w') as f:
+				json.dump({"index": {"type": "postgis", "host": "db.local.mesh", "database": "esv", "user": "esv", "node": "north"}}, f)
+			self.assertEqual(BlobStore.load_storage(filename), (None, False))
+			index = SearchIndex.load_index(filename)
+			self.assertEqual((index.path, index.node), ("postgresql://esv@db.local.mesh:5432/esv", "north"))
+			try:
+				SearchIndex.set_index(index)
+				self.assertIs(SearchIndex.open_index(folder), index)
+			finally:
+				SearchIndex.set_index(None)
+			self.assertIsInstance(SearchIndex.open_index(folder), SearchIndex.SearchIndex)
+			for bad in [{"type": "mysql"}, {"type": "postgis", "host": "db"}, {"type": "postgis", "host": "db", "database": "esv", "user": "esv", "sslmode": "always"}]:
+				with open(filename, 'w') as f:
+					json.dump({"index": bad}, f)
+				with self.assertRaises(ValueError):
+					SearchIndex.load_index(filename)
+
+	def test_sqlite_refuses_an_area(self):
+		with tempfile.TemporaryDirectory() as folder:
+			with self.assertRaises(ValueError):
+				SearchIndex.SearchIndex(folder).search("generator", bbox=(-123, 37, -122, 38))
+
+
+if __name__ == '__main__':
+	unittest.main()
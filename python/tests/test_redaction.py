@@ -0,0 +1,162 @@
+#!/usr/bin/env python
+'''Redaction patterns and the per-form, per-field policy'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import json
+import tempfile
+import unittest
+from classes.forms import Redaction
+from classes.forms.FormViewer import FormViewer
+from classes.forms.ResourceRequest import ResourceRequest
+from classes.forms.ShelterStatus import ShelterReport
+import esvmap
+
+
+class Form:
+
+	def __init__(self, form_type, variables):
+		self.form_type = form_type
+		self.variables = variables
+		self.parameters = {}
+
+
+class Message:
+
+	def __init__(self, form_type, variables, subject="", body=""):
+		self.form = Form(form_type, variables)
+		self.subject = subject
+		self.body = body
+		self.mid = "AAAAAAAAAAAA"
+		self.sender = "N0CALL"
+		self.recipient = "EOC"
+		self.date = datetime.datetime(2025, 10, 4, 12, 0, tzinfo=datetime.timezone.utc)
+
+
+class PatternTest(unittest.TestCase):
+
+	def setUp(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+
+	def test_phone_numbers_are_masked(self):
+		for text in ["650-555-1234", "(650) 555-1234", "(650)555-1234", "650.555.1234", "650 555 1234", "+1 650 555 1234", "1-650-555-1234"]:
+			self.assertEqual(Redaction.scrub_text(f"call {text}."), f"call {Redaction.REDACTED}.", text)
+
+	def test_numbers_that_are_not_phone_numbers_are_left_alone(self):
+		for text in ["-122.0841234", "37.4275123", "6505551234", "122.084.1234.5", "order 123-456-78901", "2025-10-04 12:00",
+				"37.123 -122.456 7890", "x650-555-1234", "MID 12345678901"]:
+			self.assertEqual(Redaction.scrub_text(text), text)
+
+	def test_email_addresses_are_masked(self):
+		self.assertEqual(Redaction.scrub_text("to jane.doe+esv@example.org now"), f"to {Redaction.REDACTED} now")
+
+	def test_nothing_is_masked_with_redaction_off(self):
+		Redaction.set_redaction(None)
+		self.assertEqual(Redaction.scrub_text("650-555-1234"), "650-555-1234")
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+
+
+class PolicyTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+
+	def tearDown(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		self.folder.cleanup()
+
+	def use(self, config):
+		filename = os.path.join(self.folder.name, "redaction.json")
+		with open(filename, 'w') as f:
+			json.dump(config, f)
+		Redaction.set_redaction(Redaction.load_redaction(filename))
+
+	def test_default_redacts_welfare_personal_fields(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		fields = Redaction.scrub_fields("Welfare_Inquiry", {"subject_name": "Jane Doe", "status": "Safe", "latitude": "37.1"})
+		self.assertEqual(fields, {"subject_name": Redaction.REDACTED, "status": "Safe"})
+
+	def test_other_forms_are_only_pattern_masked(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		fields = Redaction.scrub_fields("ICS213", {"name": "Jane Doe", "message": "call (650) 555-1234"})
+		self.assertEqual(fields, {"name": "Jane Doe", "message": f"call {Redaction.REDACTED}"})
+
+	def test_policy_actions(self):
+		self.use({"salt": "test", "policy": {"*welfare*": {"subject_name": "hash", "status": "keep", "notes": "drop", "*phone*": "redact"}}})
+		fields = Redaction.scrub_fields("Welfare_Inquiry", {"subject_name": "Jane Doe", "status": "555-123-4567", "notes": "x", "inquirer_phone": "1"})
+		self.assertEqual(fields["subject_name"], Redaction.hash_value("Jane Doe"))
+		self.assertEqual(len(fields["subject_name"]), Redaction.HASH_LENGTH)
+		self.assertEqual(fields["status"], "555-123-4567")  # keep skips pattern masking
+		self.assertNotIn("notes", fields)
+		self.assertEqual(fields["inquirer_phone"], Redaction.REDACTED)
+
+	def test_hashes_match_across_case_and_spacing_and_depend_on_the_salt(self):
+		self.use({"salt": "one", "policy": {"*": {"*": "hash"}}})
+		first = Redaction.hash_value("Jane Doe")
+		self.assertEqual(first, Redaction.hash_value("  jane doe "))
+		self.use({"salt": "two", "policy": {"*": {"*": "hash"}}})
+		self.assertNotEqual(first, Redaction.hash_value("Jane Doe"))
+
+	def test_hash_needs_a_salt(self):
+		with self.assertRaises(ValueError):
+			self.use({"policy": {"*welfare*": {"subject_name": "hash"}}})
+
+	def test_unknown_settings_are_refused(self):
+		with self.assertRaises(ValueError):
+			self.use({"policy": {"*": {"*": "shred"}}})
+		with self.assertRaises(ValueError):
+			self.use({"patterns": ["ssn"]})
+		with self.assertRaises(ValueError):
+			self.use({"colour": "red"})
+
+	def test_first_matching_form_glob_decides(self):
+		self.use({"policy": {"ARC_*": {"notes": "drop"}, "*": {"*": "redact"}}})
+		self.assertEqual(Redaction.scrub_fields("ARC_Shelter_Status", {"notes": "x", "status": "Open"}), {"status": "Open"})
+
+	def test_table_rows_apply_the_policy_by_template_variable(self):
+		self.use({"policy": {"ARC_Shelter_Status": {"shelter_manager": "drop", "shelter_phone": "drop", "*": "keep"}}})
+		message = Message("ARC_Shelter_Status", {"shelter_name": "Elm School", "shelter_manager": "Pat Smith", "shelter_phone": "650-555-1234"},
+			subject="Elm School, call 650-555-1234")
+		row = esvmap.scrubbed_row(ShelterReport(message))
+		self.assertEqual(row["shelter"], "Elm School")
+		self.assertIsNone(row["manager"])
+		self.assertIsNone(row["phone"])
+
+	def test_table_rows_scrub_the_subject(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		message = Message("ICS213RR", {"incident_name": "Creek"}, subject="Cots, call 650-555-1234")
+		self.assertEqual(esvmap.scrubbed_row(ResourceRequest(message))["subject"], f"Cots, call {Redaction.REDACTED}")
+
+
+class ViewerTest(unittest.TestCase):
+
+	def setUp(self):
+		Redaction.set_redaction(dict(Redaction.DEFAULT_REDACTION))
+		self.folder = tempfile.TemporaryDirectory()
+		with open(os.path.join(self.folder.name, "ICS213_Viewer.html"), 'w') as f:
+			f.write("<p>{var message}</p><p>{MsgSubject}</p><pre>{MsgOriginalBody}</pre>")
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def test_subject_and_body_are_scrubbed(self):
+		message = Message("ICS213", {"message": "call 650-555-1234"}, subject="Call 650-555-1234", body="Reach me at jo@example.org")
+		page = FormViewer(self.folder.name).render(message, Redaction.scrub_fields("ICS213", message.form.variables))
+		self.assertEqual(page, f"<p>call {Redaction.REDACTED}</p><p>Call {Redaction.REDACTED}</p><pre>Reach me at {Redaction.REDACTED}</pre>")
+
+
+if __name__ == '__main__':
+	unittest.main()
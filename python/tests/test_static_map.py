@@ -0,0 +1,75 @@
+#!/usr/bin/env python
+'''Map options and the PNG decoder that reads tiles'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import struct
+import tempfile
+import unittest
+import zlib
+from classes.export.PngImage import Raster
+from classes.export.StaticMap import StaticMap, check_zoom, parse_size, MAX_IMAGE_SIZE, MAX_ZOOM
+
+
+def png(width, height, data):
+	"""A PNG of RGB rows as given, whether or not there are enough of them."""
+	def chunk(kind, body):
+		return struct.pack(">I", len(body)) + kind + body + struct.pack(">I", zlib.crc32(kind + body))
+	header = struct.pack(">IIBBBBB", width, height, 8, 2, 0, 0, 0)
+	return b"\x89PNG\r\n\x1a\n" + chunk(b"IHDR", header) + chunk(b"IDAT", zlib.compress(data)) + chunk(b"IEND", b"")
+
+
+class OptionsTest(unittest.TestCase):
+
+	def test_size(self):
+		self.assertEqual(parse_size("800x600"), (800, 600))
+		self.assertEqual(parse_size("1X1"), (1, 1))
+		for text in ["0x600", "800x-1", f"{MAX_IMAGE_SIZE + 1}x10", "800", "800x600x3", "axb"]:
+			with self.assertRaises(ValueError):
+				parse_size(text)
+
+	def test_zoom(self):
+		self.assertEqual(check_zoom(0), 0)
+		self.assertEqual(check_zoom(MAX_ZOOM), MAX_ZOOM)
+		for zoom in [-1, MAX_ZOOM + 1]:
+			with self.assertRaises(ValueError):
+				check_zoom(zoom)
+
+
+class DecoderTest(unittest.TestCase):
+
+	def test_round_trip(self):
+		raster = Raster(3, 2, (10, 20, 30))
+		raster.set(1, 1, (200, 100, 50))
+		self.assertEqual(Raster.from_png(raster.to_png()).pixels, raster.pixels)
+
+	def test_truncated_images_are_refused(self):
+		whole = Raster(20, 10, (10, 20, 30)).to_png()
+		for length in [20, 40, len(whole) - 20]:
+			with self.assertRaises(ValueError):
+				Raster.from_png(whole[:length])
+		with self.assertRaises(ValueError):
+			Raster.from_png(png(20, 10, b"\x00" + b"\x01" * 60))  # A complete stream with one short row
+
+	def test_a_truncated_tile_is_skipped(self):
+		with tempfile.TemporaryDirectory() as folder:
+			os.makedirs(os.path.join(folder, "0", "0"))
+			with open(os.path.join(folder, "0", "0", "0.png"), 'wb') as f:
+				f.write(png(256, 256, b"\x00" * 100))
+			self.assertIsNone(StaticMap(folder)._tile(0, 0, 0))
+
+
+if __name__ == '__main__':
+	unittest.main()
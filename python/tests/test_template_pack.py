@@ -0,0 +1,108 @@
+#!/usr/bin/env python
+'''Field names read from a Standard Templates ZIP, and the mappings drafted and merged from them'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import contextlib
+import io
+import json
+import tempfile
+import unittest
+import zipfile
+from classes.forms import MappingConfig
+from classes.forms import TemplatePack
+import esvmap
+
+PACK = {
+	"Standard_Forms/ICS USA Forms/ICS213.txt": "Form: ICS213_Initial.html,ICS213_Viewer.html\r\nReplyTemplate: ICS213_SendReply.txt\r\nSubj: <var subjectline>\r\nMsg: <var message>\r\n",
+	"Standard_Forms/ICS USA Forms/ICS213_Initial.html": """<form method="post">
+		<input type="hidden" name="templateversion" value="ICS 213 v3.2">
+		<input type="text" name="To_Name" required><input name="fm_name" required />
+		<input name="subjectline"><textarea name="message" required></textarea>
+		<input name="incident_lat"><input name="incident_lon">
+		<select name="priority"><option>Routine</option></select>
+		<input type="submit" name="Submit" value="Send">
+	</form>""",
+	"Standard_Forms/ICS USA Forms/ICS213_Viewer.html": "<p>{var to_name}</p><p>{var approved_by}</p>",
+	"Standard_Forms/General Forms/Damage.txt": "Form: Damage_Assessment.html\r\n",
+	"Standard_Forms/General Forms/Damage_Assessment.html": '<input name="site_latitude"><input name="site_longitude"><input name="notes">',
+	"Standard_Forms/General Forms/Plain Text.txt": "Subj: Status\r\nMsg: <var body>\r\n",
+}
+
+
+class TemplatePackTest(unittest.TestCase):
+
+	def setUp(self):
+		self.folder = tempfile.TemporaryDirectory()
+		self.pack = os.path.join(self.folder.name, "Standard_Forms.zip")
+		with zipfile.ZipFile(self.pack, 'w') as pack:
+			for name, text in PACK.items():
+				pack.writestr(name, text)
+		self.output = os.path.join(self.folder.name, "mappings.json")
+
+	def tearDown(self):
+		self.folder.cleanup()
+
+	def run_command(self, *arguments):
+		with contextlib.redirect_stdout(io.StringIO()):
+			return esvmap.main(["mappings", self.pack, "-o", self.output] + list(arguments))
+
+	def test_fields_come_from_the_form_the_template_and_the_viewer(self):
+		forms = TemplatePack.read_pack(self.pack)
+		self.assertEqual([form["form_type"] for form in forms], ["Damage_Assessment", "ICS213"])  # Plain text is left out
+		ics213 = forms[1]
+		self.assertEqual(ics213["fields"], ["templateversion", "to_name", "fm_name", "subjectline", "message", "incident_lat", "incident_lon", "priority", "approved_by"])
+		self.assertEqual(ics213["required"], ["to_name", "fm_name", "message"])
+		self.assertEqual(ics213["version"], "ICS 213 v3.2")
+		self.assertEqual((ics213["template"], ics213["form"], ics213["viewer"]), ("ICS213.txt", "ICS213_Initial.html", "ICS213_Viewer.html"))
+
+	def test_drafted_mappings(self):
+		drafted = TemplatePack.draft_mappings(TemplatePack.read_pack(self.pack))
+		self.assertEqual(drafted, {
+			"required_variables": {"ICS213": ["to_name", "fm_name", "message"]},
+			"positions": {
+				"Damage_Assessment": [{"role": "site", "latitude": "site_latitude", "longitude": "site_longitude"}],
+				"ICS213": [{"role": "incident", "latitude": "incident_lat", "longitude": "incident_lon"}],
+			},
+			"template_versions": {"ICS213": ["ICS 213 v3.2"]},
+		})
+
+	def test_the_command_merges_into_an_existing_file(self):
+		with open(self.output, 'w') as f:
+			json.dump({
+				"required_variables": {"ICS213": ["message"], "County_Report": ["county"]},
+				"template_versions": {"ICS213": ["ICS 213 v3.1"]},
+				"shelter_forms": ["County_Shelter_Report"],
+			}, f)
+		self.assertEqual(self.run_command(), esvmap.EXIT_OK)
+		with open(self.output, 'r') as f:
+			mappings = json.load(f)
+		self.assertEqual(mappings["required_variables"], {"County_Report": ["county"], "ICS213": ["to_name", "fm_name", "message"]})
+		self.assertEqual(mappings["template_versions"], {"ICS213": ["ICS 213 v3.1", "ICS 213 v3.2"]})
+		self.assertEqual(mappings["shelter_forms"], ["County_Shelter_Report"])
+		MappingConfig.load_config(self.output, MappingConfig.MAPPINGS)  # The server can load it
+		self.assertEqual(self.run_command("--replace"), esvmap.EXIT_OK)
+		with open(self.output, 'r') as f:
+			self.assertNotIn("shelter_forms", json.load(f))
+
+	def test_a_file_that_is_not_a_zip_is_refused(self):
+		with open(self.pack, 'w') as f:
+			f.write("not a zip")
+		with self.assertRaises(ValueError):
+			TemplatePack.read_pack(self.pack)
+
+
+if __name__ == '__main__':
+	unittest.main()
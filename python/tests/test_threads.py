@@ -0,0 +1,68 @@
+#!/usr/bin/env python
+'''Replies linked to the messages they answer'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import unittest
+from classes.b2f.B2Message import B2Message
+from classes.b2f import MessageThreads
+from classes.b2f import OutboundMessage
+
+START = datetime.datetime(2025, 10, 4, 12, 0)
+
+
+def decoded(mid, sender, subject, minutes, body="Body"):
+	"""A message decoded from its text, sent minutes after START."""
+	text = OutboundMessage.message_text(mid, sender, ["EOC"], subject, body, when=START + datetime.timedelta(minutes=minutes))
+	message = B2Message(mid, b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+class ThreadTest(unittest.TestCase):
+
+	def test_a_reply_answers_the_latest_message_from_another_station(self):
+		first = decoded("AAAAAAAAAAAA", "N0CALL", "Water at Elm", 0)
+		second = decoded("BBBBBBBBBBBB", "N0CALL-7", "Water at Elm", 5)  # The same station
+		unrelated = decoded("CCCCCCCCCCCC", "N2CALL", "Shelter count", 6)
+		reply = decoded("DDDDDDDDDDDD", "N1CALL", "Re: //WL2K P/ Water at Elm", 10)
+		again = decoded("EEEEEEEEEEEE", "N0CALL", "RE: Water at Elm", 15)
+		threads = MessageThreads.link([again, reply, unrelated, second, first])
+		self.assertEqual(reply.in_reply_to, "BBBBBBBBBBBB")
+		self.assertEqual(again.in_reply_to, "DDDDDDDDDDDD")
+		self.assertIsNone(second.in_reply_to)  # Not marked as a reply
+		self.assertIsNone(unrelated.in_reply_to)
+		self.assertEqual(list(threads), ["BBBBBBBBBBBB"])
+		self.assertEqual([m.mid for m in threads["BBBBBBBBBBBB"]], ["BBBBBBBBBBBB", "DDDDDDDDDDDD", "EEEEEEEEEEEE"])
+
+	def test_a_quoted_mid_wins_over_the_subject(self):
+		first = decoded("AAAAAAAAAAAA", "N0CALL", "Water at Elm", 0)
+		second = decoded("BBBBBBBBBBBB", "N2CALL", "Water at Elm", 5)
+		reply = decoded("CCCCCCCCCCCC", "N1CALL", "Re: Water at Elm", 10, body="Answering AAAAAAAAAAAA")
+		MessageThreads.link([first, second, reply])
+		self.assertEqual(reply.in_reply_to, "AAAAAAAAAAAA")
+		self.assertEqual(reply.thread, "AAAAAAAAAAAA")
+
+	def test_a_reply_with_no_earlier_message_starts_its_own_thread(self):
+		reply = decoded("AAAAAAAAAAAA", "N1CALL", "Re: Water at Elm", 0)
+		original = decoded("BBBBBBBBBBBB", "N0CALL", "Water at Elm", 5)  # Received after the reply
+		self.assertEqual(MessageThreads.link([reply, original]), {})
+		self.assertIsNone(reply.in_reply_to)
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,69 @@
+#!/usr/bin/env python
+'''The shelter, welfare, and resource request tables read only the variables that mean their fields'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import datetime
+import unittest
+from classes.forms import MappingConfig
+from classes.forms import Welfare
+from classes.forms.ResourceRequest import ResourceRequest
+from classes.forms.ShelterStatus import ShelterReport
+
+
+class Form:
+
+	def __init__(self, form_type, variables):
+		self.form_type = form_type
+		self.variables = variables
+		self.parameters = {}
+
+
+class Message:
+
+	def __init__(self, form_type, variables):
+		self.form = Form(form_type, variables)
+		self.subject = ""
+		self.body = ""
+		self.mid = "AAAAAAAAAAAA"
+		self.sender = "N0CALL"
+		self.recipient = "EOC"
+		self.date = datetime.datetime(2025, 10, 4, 12, 0, tzinfo=datetime.timezone.utc)
+
+
+class TrafficTableTest(unittest.TestCase):
+
+	def tearDown(self):
+		MappingConfig.apply_config({}, MappingConfig.MAPPINGS)
+
+	def test_generic_variables_are_not_taken_for_fields(self):
+		generic = {"name": "Pat Smith", "address": "12 Elm St", "phone": "650-555-1234", "location": "EOC", "status": "Sent", "fm_name": "N0CALL", "incident": "x"}
+		shelter = ShelterReport(Message("ARC_Shelter_Status", dict(generic, shelter_name="Elm School", shelter_status="Open")))
+		self.assertEqual((shelter.fields["shelter"], shelter.fields["status"]), ("Elm School", "Open"))
+		self.assertEqual((shelter.fields["address"], shelter.fields["phone"]), (None, None))
+		welfare = Welfare.WelfareInquiry(Message("Welfare_Inquiry", dict(generic, subject_name="Jane Doe")))
+		self.assertEqual(welfare.fields["subject_name"], "Jane Doe")
+		self.assertEqual((welfare.fields["last_known_location"], welfare.fields["inquirer_name"], welfare.fields["inquirer_phone"]), (None, None, None))
+		request = ResourceRequest(Message("ICS213RR", generic))
+		self.assertEqual((request.fields["incident"], request.fields["requested_by"]), (None, None))
+
+	def test_other_names_come_from_the_mappings(self):
+		MappingConfig.apply_config({"welfare_fields": {"subject_name": ["name"]}}, MappingConfig.MAPPINGS)
+		welfare = Welfare.WelfareInquiry(Message("Welfare_Inquiry", {"name": "Jane Doe"}))
+		self.assertEqual((welfare.fields["subject_name"], welfare.sources["subject_name"]), ("Jane Doe", "name"))
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,65 @@
+#!/usr/bin/env python
+'''Measurements in form fields rendered in a chosen set of units'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import unittest
+from classes.geo import Units
+
+
+class UnitsTest(unittest.TestCase):
+
+	def tearDown(self):
+		Units.set_units(None)
+
+	def test_conversions(self):
+		Units.set_units("us")
+		self.assertEqual(Units.render("15 kts"), "17 mph")
+		self.assertEqual(Units.render("20°C"), "68°F")
+		self.assertEqual(Units.render("300 m"), "984 ft")
+		self.assertEqual(Units.render("8 km"), "5.0 mi")
+		Units.set_units("metric")
+		self.assertEqual(Units.render("1200 ft"), "366 m")
+		self.assertEqual(Units.render("3 Mi"), "4.8 km")
+
+	def test_a_capital_m_is_left_as_received(self):
+		Units.set_units("metric")
+		self.assertEqual(Units.render("5 M"), "5 M")
+		self.assertIsNone(Units.parse_measurement("5 M"))
+		self.assertEqual(Units.parse_measurement("5 m"), (5.0, Units.LENGTH, "m"))
+
+	def test_values_already_in_the_preferred_units_are_unchanged(self):
+		Units.set_units("us")
+		self.assertEqual(Units.render("15.25 mph"), "15.25 mph")
+		self.assertEqual(Units.render("3.14159 mi"), "3.14159 mi")
+		self.assertEqual(Units.render("72 F"), "72 F")
+		self.assertEqual(Units.render("1.5 miles"), "1.5 miles")
+
+	def test_the_nautical_preset_uses_nautical_miles(self):
+		Units.set_units("nautical")
+		self.assertEqual(Units.render("10 km"), "5.4 nmi")
+		self.assertEqual(Units.render("23 mi"), "20 nmi")
+		self.assertEqual(Units.render("12.5 NM"), "12.5 NM")
+		self.assertEqual(Units.parse_measurement("12 nmi"), (12.0, Units.LENGTH, "nmi"))
+		Units.set_units("metric")
+		self.assertEqual(Units.render("10 NM"), "19 km")
+		self.assertEqual(Units.render("5 M"), "5 M")  # Still ambiguous
+
+	def test_values_are_unchanged_without_a_preset(self):
+		self.assertEqual(Units.render("15 kts"), "15 kts")
+
+
+if __name__ == '__main__':
+	unittest.main()
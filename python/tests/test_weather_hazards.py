@@ -0,0 +1,132 @@
+#!/usr/bin/env python
+'''NWS hazards read from bulletins, and the zone file the overlay draws them on'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import contextlib
+import datetime
+import io
+import json
+import tempfile
+import unittest
+from classes.b2f.B2Message import B2Message
+from classes.b2f import OutboundMessage
+from classes.geo import WeatherHazards
+import esvmap
+
+UTC = datetime.timezone.utc
+ISSUED = datetime.datetime(2026, 10, 14, 12, 0, tzinfo=UTC)
+
+
+def product(ugc, vtec, headline="WINTER STORM WARNING IN EFFECT"):
+	return f"WWUS76 KMTR 141200\nWSWMTR\n\n{ugc}\n{vtec}\nSanta Cruz Mountains-\n\n...{headline}...\n\n$$\n"
+
+
+def bulletin(mid, body, when):
+	"""A bulletin decoded from its text."""
+	text = OutboundMessage.message_text(mid, "SERVICE", ["N0CALL"], "NWS Winter Storm Warning", body, when=when, message_type="Bulletin")
+	message = B2Message(mid, b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+def square(west, south, size):
+	return [[west, south], [west + size, south], [west + size, south + size], [west, south + size], [west, south]]
+
+
+class UgcTest(unittest.TestCase):
+
+	def test_ranges_and_prefixes_are_expanded(self):
+		self.assertEqual(WeatherHazards.expand_ugc("CAZ006-007>009-CAC001-141200-"), (["CAZ006", "CAZ007", "CAZ008", "CAZ009", "CAC001"], "141200"))
+		self.assertEqual(WeatherHazards.expand_ugc("CAZ506-508>\n509-NVZ002-150300-"), (["CAZ506", "CAZ508", "CAZ509", "NVZ002"], "150300"))
+		self.assertEqual(WeatherHazards.expand_ugc("007-CAZ010-"), (["CAZ010"], None))  # A number before any prefix is skipped
+
+	def test_purge_times_roll_into_the_next_month(self):
+		self.assertEqual(WeatherHazards._purge_time("151800", ISSUED), datetime.datetime(2026, 10, 15, 18, 0, tzinfo=UTC))
+		self.assertEqual(WeatherHazards._purge_time("020600", datetime.datetime(2026, 10, 31, 22, 0, tzinfo=UTC)), datetime.datetime(2026, 11, 2, 6, 0, tzinfo=UTC))
+		self.assertEqual(WeatherHazards._purge_time("010000", datetime.datetime(2026, 12, 31, 20, 0, tzinfo=UTC)), datetime.datetime(2027, 1, 1, 0, 0, tzinfo=UTC))
+		self.assertIsNone(WeatherHazards._purge_time("310000", datetime.datetime(2026, 9, 30, 12, 0, tzinfo=UTC)))  # Not in October either way
+
+
+class ActiveHazardsTest(unittest.TestCase):
+
+	def test_a_cancellation_or_expiry_ends_the_hazard_in_its_zones(self):
+		new = bulletin("WXAAAAAAAAAA", product("CAZ506-508>509-151800-", "/O.NEW.KMTR.WS.W.0004.261014T1200Z-261015T1800Z/"), ISSUED)
+		cancelled = bulletin("WXBBBBBBBBBB", product("CAZ508-151800-", "/O.CAN.KMTR.WS.W.0004.000000T0000Z-261015T1800Z/", "CANCELLED"), ISSUED + datetime.timedelta(hours=2))
+		expired = bulletin("WXCCCCCCCCCC", product("CAZ509-151800-", "/O.EXP.KMTR.WS.W.0004.000000T0000Z-261015T1800Z/", "EXPIRED"), ISSUED + datetime.timedelta(hours=3))
+		at = ISSUED + datetime.timedelta(hours=4)
+		self.assertEqual(sorted(h["zone"] for h in WeatherHazards.active_hazards([new], at)), ["CAZ506", "CAZ508", "CAZ509"])
+		hazards = WeatherHazards.active_hazards([expired, new, cancelled], at)
+		self.assertEqual([h["zone"] for h in hazards], ["CAZ506"])
+		self.assertEqual(hazards[0]["event"], "Winter Storm Warning")
+		self.assertEqual(hazards[0]["mid"], "WXAAAAAAAAAA")
+		self.assertEqual(WeatherHazards.active_hazards([new], datetime.datetime(2026, 10, 15, 19, 0, tzinfo=UTC)), [])  # Past its end
+
+	def test_a_product_for_another_event_is_not_cancelled(self):
+		new = bulletin("WXAAAAAAAAAA", product("CAZ506-151800-", "/O.NEW.KMTR.WS.W.0004.261014T1200Z-261015T1800Z/"), ISSUED)
+		other = bulletin("WXBBBBBBBBBB", product("CAZ506-151800-", "/O.CAN.KMTR.WS.W.0005.000000T0000Z-261015T1800Z/"), ISSUED + datetime.timedelta(hours=1))
+		self.assertEqual(len(WeatherHazards.active_hazards([new, other], ISSUED + datetime.timedelta(hours=2))), 1)
+
+
+class ZoneFileTest(unittest.TestCase):
+
+	def test_outlines_are_simplified(self):
+		# Points along the edges of a square, each within the tolerance of the straight edge
+		ring = [[-122.0 + i / 100, 37.0 + (0.001 if i % 2 else 0.0)] for i in range(101)] + [[-121.0, 38.0], [-122.0, 38.0], [-122.0, 37.0]]
+		simplified = WeatherHazards.simplify_ring(ring, 0.005)
+		self.assertEqual(simplified, [[-122.0, 37.0], [-121.0, 37.0], [-121.0, 38.0], [-122.0, 38.0], [-122.0, 37.0]])
+		self.assertEqual(len(WeatherHazards.simplify_ring(ring, 0)), len(ring))
+		tiny = square(-122.0, 37.0, 0.001)
+		self.assertEqual(WeatherHazards.simplify_ring(tiny, 0.005), tiny)  # Kept rather than collapsed
+
+	def test_built_from_api_and_shapefile_properties(self):
+		api = {"type": "FeatureCollection", "features": [
+			{"type": "Feature", "properties": {"id": "CAZ506", "name": "Santa Cruz Mountains"}, "geometry": {"type": "Polygon", "coordinates": [square(-122.2, 37.0, 0.3), square(-122.1, 37.1, 0.05)]}},
+			{"type": "Feature", "properties": {"id": "NVZ002", "name": "Lake Tahoe"}, "geometry": {"type": "Polygon", "coordinates": [square(-120.1, 39.0, 0.2)]}},
+			{"type": "Feature", "properties": {"id": "CAZ999"}, "geometry": None},
+		]}
+		shapefile = {"type": "FeatureCollection", "features": [
+			{"type": "Feature", "properties": {"STATE": "CA", "FIPS": "06087", "COUNTYNAME": "Santa Cruz"}, "geometry": {"type": "MultiPolygon", "coordinates": [[square(-122.3, 36.9, 0.4)], [square(-122.0, 36.8, 0.01)]]}},
+		]}
+		zone_file = WeatherHazards.build_zone_file([shapefile, api], states=["ca"])
+		self.assertEqual([f["properties"] for f in zone_file["features"]], [{"ugc": "CAC087", "name": "Santa Cruz"}, {"ugc": "CAZ506", "name": "Santa Cruz Mountains"}])
+		self.assertEqual(len(zone_file["features"][0]["geometry"]["coordinates"]), 2)
+		self.assertEqual(len(zone_file["features"][1]["geometry"]["coordinates"][0]), 1)  # The hole is left out
+		with tempfile.TemporaryDirectory() as folder:
+			filename = os.path.join(folder, WeatherHazards.ZONES_FILE_NAME)
+			with open(filename, 'w') as f:
+				json.dump(zone_file, f)
+			zones = WeatherHazards.load_zones(filename)
+		self.assertEqual(sorted(zones), ["CAC087", "CAZ506"])
+		self.assertEqual(zones["CAZ506"]["polygons"], [square(-122.2, 37.0, 0.3)])
+
+	def test_the_command_writes_a_zone_file_the_overlay_loads(self):
+		with tempfile.TemporaryDirectory() as folder:
+			source = os.path.join(folder, "zones.json")
+			with open(source, 'w') as f:
+				json.dump({"type": "FeatureCollection", "features": [{"type": "Feature", "properties": {"STATE_ZONE": "CA506", "NAME": "Santa Cruz Mountains"}, "geometry": {"type": "Polygon", "coordinates": [square(-122.2, 37.0, 0.3)]}}]}, f)
+			output = os.path.join(folder, WeatherHazards.ZONES_FILE_NAME)
+			with contextlib.redirect_stdout(io.StringIO()):
+				self.assertEqual(esvmap.main(["zones", source, "-o", output]), esvmap.EXIT_OK)
+			self.assertEqual(list(WeatherHazards.load_zones(output)), ["CAZ506"])
+			with contextlib.redirect_stdout(io.StringIO()), contextlib.redirect_stderr(io.StringIO()):
+				self.assertEqual(esvmap.main(["zones"]), esvmap.EXIT_USAGE)
+				self.assertEqual(esvmap.main(["zones", source, "--state", "NV", "-o", os.path.join(folder, "none.geojson")]), esvmap.EXIT_WARNINGS)
+			self.assertFalse(os.path.exists(os.path.join(folder, "none.geojson")))
+
+
+if __name__ == '__main__':
+	unittest.main()
@@ -0,0 +1,103 @@
+#!/usr/bin/env python
+'''File names that Windows accepts, and CRLF headers and bodies kept as received'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import tempfile
+import unittest
+from classes.b2f.B2Message import B2Message
+from classes.store.WinlinkMailMessage import WinlinkMailMessage, HEADERS_FILE_SUFFIX, safe_filename
+import esvmap
+
+BODY = "Line one\r\nLine two\r\n\r\nA bare\nnewline\r\n"
+
+
+class SafeFilenameTest(unittest.TestCase):
+
+	def test_reserved_characters_are_replaced(self):
+		self.assertEqual(safe_filename('a<b>c:d"e/f\\g|h?i*j'), "a_b_c_d_e_f_g_h_i_j")
+		self.assertEqual(safe_filename("tab\there\x01"), "tab_here_")
+		self.assertEqual(safe_filename("20251004-AB12CD-photo.jpg"), "20251004-AB12CD-photo.jpg")
+
+	def test_names_of_dots_and_spaces(self):
+		for name in ["", ".", "..", " . ", "   "]:
+			self.assertEqual(safe_filename(name), "_", repr(name))
+		self.assertEqual(safe_filename("report. "), "report")  # Windows drops the trailing dot and space anyway
+		self.assertEqual(safe_filename(" .hidden"), "hidden")
+
+	def test_device_names_are_not_used_as_file_names(self):
+		for name in ["CON", "con.txt", "Aux", "NUL .b2f", "COM1", "lpt9.jpg"]:
+			self.assertTrue(safe_filename(name).startswith("_"), name)
+		for name in ["CONSOLE", "COM10x", "icon.png", "NULL"]:
+			self.assertEqual(safe_filename(name), name)
+
+	def test_safe_names_can_be_created(self):
+		with tempfile.TemporaryDirectory() as folder:
+			for name in ['a:b', 'q?.txt', 'CON', 'aux.txt', ' . ', 'x*y|z']:
+				filename = os.path.join(folder, safe_filename(name))
+				with open(filename, 'w') as f:
+					f.write(name)
+				self.assertTrue(os.path.isfile(filename), name)
+
+	@unittest.skipUnless(sys.platform == "win32", "Windows refuses these names")
+	def test_windows_refuses_the_unsafe_names(self):
+		with tempfile.TemporaryDirectory() as folder:
+			for name in ['q?.txt', 'a<b', 'x|y']:  # a:b would be an NTFS stream, not an error
+				with self.assertRaises(OSError):
+					open(os.path.join(folder, name), 'w').close()
+
+
+def decoded(subject, body):
+	"""A message decoded from its text, without a compressed transfer.  The body is sent as given, bare
+	newlines and all, as some clients do."""
+	data = body.encode("ascii")
+	text = f"Mid: AAAAAAAAAAAA\r\nDate: 2025/10/04 12:00\r\nFrom: N0CALL\r\nTo: EOC\r\nSubject: {subject}\r\nBody: {len(data)}\r\n\r\n".encode("ascii") + data + b"\r\n"
+	message = B2Message("AAAAAAAAAAAA", b"", None, None)
+	message.decompressed_data = text
+	message._extract_message_parts()
+	return message
+
+
+class CrlfTest(unittest.TestCase):
+
+	def test_headers_split_on_crlf(self):
+		message = B2Message("AAAAAAAAAAAA", b"", None, None)
+		message.parse_headers("Mid: AAAAAAAAAAAA\r\nSubject: Water at Elm\r\nFrom: N0CALL\r\nTo: EOC\r\nBody: 12\r\nFile: 40 notes.txt\r\n")
+		self.assertEqual((message.mid, message.subject, message.sender, message.recipient), ("AAAAAAAAAAAA", "Water at Elm", "N0CALL", "EOC"))
+		self.assertEqual(message.body_length, 12)
+		self.assertEqual([(a.filename, a.size) for a in message.attachments], [("notes.txt", 40)])
+
+	def test_a_body_with_crlfs_is_cut_at_its_length(self):
+		message = decoded("Test", BODY)
+		self.assertEqual(message.body, BODY)
+
+	def test_headers_and_body_survive_a_save_and_reload(self):
+		with tempfile.TemporaryDirectory() as folder:
+			mail = WinlinkMailMessage("EM", "AAAAAAAAAAAA", 100, 50, folder=folder)
+			mail.b2 = decoded("Test", BODY)
+			mail.save_message_to_files()
+			headers_file = f"{mail.filename}{HEADERS_FILE_SUFFIX}"
+			with open(headers_file, 'rb') as f:
+				raw = f.read()
+			self.assertIn(b"\r\nSubject: Test\r\n", raw)
+			self.assertNotIn(b"\r\r\n", raw)  # Not translated a second time
+			reloaded = esvmap.read_headers_file(headers_file)
+			self.assertEqual(reloaded.headers, mail.b2.headers)
+			self.assertEqual(reloaded.body, BODY)
+			self.assertEqual(reloaded.subject, "Test")
+
+
+if __name__ == '__main__':
+	unittest.main()
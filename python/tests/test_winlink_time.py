@@ -0,0 +1,50 @@
+#!/usr/bin/env python
+'''Timestamps parsed from Winlink messages and forms, and the zone they are shown in'''
+
+__author__ = "Bob Iannucci"
+__copyright__ = "Copyright 2025, Bob Iannucci"
+__license__ = "MIT"
+__maintainer__ = __author__
+__email__ = "bob@rail.com"
+__status__ = "Experimental"
+
+import sys
+import os
+
+this_path = os.path.dirname(__file__)
+src_path = os.path.abspath(os.path.join(this_path, '../'))
+sys.path.insert(0, src_path)
+
+import unittest
+from datetime import datetime, timezone
+from unittest import mock
+from zoneinfo import ZoneInfoNotFoundError
+from classes.b2f import WinlinkTime
+
+WHEN = datetime(2025, 10, 4, 19, 30, tzinfo=timezone.utc)
+
+
+def no_zone_data(name):
+	raise ZoneInfoNotFoundError(f"No time zone found with key {name}")
+
+
+class WinlinkTimeTest(unittest.TestCase):
+
+	def test_local_time(self):
+		self.assertEqual(WinlinkTime.to_local(WHEN).isoformat(), "2025-10-04T12:30:00-07:00")
+
+	def test_utc_without_zone_data(self):
+		with mock.patch("classes.b2f.WinlinkTime.ZoneInfo", no_zone_data), self.assertLogs("classes.b2f.WinlinkTime", "WARNING") as logs:
+			self.assertEqual(WinlinkTime.to_local(WHEN), WHEN)
+			self.assertEqual(WinlinkTime.to_local(WHEN).utcoffset().total_seconds(), 0)
+			self.assertEqual(WinlinkTime.parse_timestamp("2025-10-04 12:30 LOCAL"), datetime(2025, 10, 4, 12, 30, tzinfo=timezone.utc))
+		self.assertEqual(len(logs.records), 1)
+		WinlinkTime._missing_zones.clear()
+
+	def test_unknown_zones_are_refused(self):
+		with self.assertRaises(ValueError):
+			WinlinkTime.check_timezone("Mars/Olympus_Mons")
+
+
+if __name__ == '__main__':
+	unittest.main()